@@ -0,0 +1,396 @@
+/*
+ * MarineNP Bibliography
+ * Purpose: Write citations as BibTeX, RIS or CSL-JSON for reference managers
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file formats the citations of the database, which hold a DOI, a title,
+ * an author list and a free-text reference, as reference manager records. The
+ * Crossref metadata of a citation, when it has been enriched, completes it
+ * with the journal, volume, issue, pages and year, the full author list and
+ * the untruncated title. Otherwise the publication year is taken from the
+ * free-text reference when it has one. The reference is kept as a note.
+ */
+
+package bibliography
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"marinenp/crossref"
+	"marinenp/models"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Formats
+const (
+	BibTeX  = "bibtex"
+	RIS     = "ris"
+	CSLJSON = "csl"
+)
+
+// ContentTypes are the media types of the formats, as used for DOI content
+// negotiation
+var ContentTypes = map[string]string{
+	BibTeX:  "application/x-bibtex; charset=utf-8",
+	RIS:     "application/x-research-info-systems; charset=utf-8",
+	CSLJSON: "application/vnd.citationstyles.csl+json; charset=utf-8",
+}
+
+// Extensions are the file extensions of the formats
+var Extensions = map[string]string{
+	BibTeX:  ".bib",
+	RIS:     ".ris",
+	CSLJSON: ".json",
+}
+
+// Writer writes citations in a format. Close must be called after the last
+// citation to complete the file.
+type Writer struct {
+	w      io.Writer
+	format string
+	n      int
+}
+
+// NewWriter returns a writer of citations in a format
+func NewWriter(w io.Writer, format string) (*Writer, error) {
+	if _, ok := ContentTypes[format]; !ok {
+		return nil, fmt.Errorf("unknown citation format %s", format)
+	}
+	return &Writer{w: w, format: format}, nil
+}
+
+// Count returns the number of citations written
+func (w *Writer) Count() int {
+	return w.n
+}
+
+// Write writes a citation
+func (w *Writer) Write(c *models.Citation) error {
+	r := newReference(c)
+	var err error
+	switch w.format {
+	case BibTeX:
+		err = w.writeBibTeX(r)
+	case RIS:
+		err = w.writeRIS(r)
+	case CSLJSON:
+		err = w.writeCSL(r)
+	}
+	w.n++
+	return err
+}
+
+// Close completes the file
+func (w *Writer) Close() error {
+	if w.format != CSLJSON {
+		return nil
+	}
+	end := "\n]\n"
+	if w.n == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(w.w, end)
+	return err
+}
+
+// reference is a citation with its fields parsed
+type reference struct {
+	id      int64
+	title   string
+	authors []author
+	year    string
+	journal string
+	volume  string
+	issue   string
+	pages   string
+	doi     string
+	note    string
+}
+
+// author is a name split into family and given names, or kept whole as a
+// literal when its form is not recognized
+type author struct {
+	family, given, literal string
+}
+
+// yearPattern matches a publication year in a free-text reference
+var yearPattern = regexp.MustCompile(`\b(1[89]\d\d|20\d\d)\b`)
+
+// initialsPattern matches initials written without dots, as in PubMed
+var initialsPattern = regexp.MustCompile(`^[A-Z]{1,3}$`)
+
+func newReference(c *models.Citation) reference {
+	r := reference{
+		id:    c.ID,
+		title: clean(c.Title),
+		doi:   crossref.NormalizeDOI(c.DOI),
+		note:  clean(c.CitationText),
+	}
+	r.year = yearPattern.FindString(r.note)
+	names := splitAuthors(c.Authors)
+
+	if m := c.Metadata; m != nil && m.Status == models.MetadataFound {
+		if title := clean(m.Title); title != "" && isTruncated(r.title, title) {
+			r.title = title
+		}
+		if full := splitAuthors(m.Authors); len(full) >= len(names) {
+			names = full
+		}
+		if m.Year != nil {
+			r.year = strconv.Itoa(*m.Year)
+		}
+		r.journal = clean(m.Journal)
+		r.volume = clean(m.Volume)
+		r.issue = clean(m.Issue)
+		r.pages = clean(m.Pages)
+	}
+
+	for _, name := range names {
+		r.authors = append(r.authors, parseAuthor(name))
+	}
+	return r
+}
+
+// isTruncated reports whether a title is empty or the beginning of the full
+// title, with or without an ellipsis
+func isTruncated(title, full string) bool {
+	title = strings.TrimRight(strings.TrimSuffix(strings.TrimSuffix(title, "..."), "…"), " ")
+	return len(title) <= len(full) && strings.EqualFold(full[:len(title)], title)
+}
+
+// clean collapses the whitespace of a field, including line breaks
+func clean(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// splitAuthors splits an author list on semicolons, | or " and", or on
+// commas when every part is a full name rather than "Family, Given"
+func splitAuthors(authors string) []string {
+	authors = clean(authors)
+	if authors == "" {
+		return nil
+	}
+	var parts []string
+	switch {
+	case strings.ContainsAny(authors, ";|"):
+		parts = strings.FieldsFunc(authors, func(r rune) bool { return r == ';' || r == '|' })
+	case strings.Contains(authors, ","):
+		parts = strings.Split(authors, ",")
+		for _, p := range parts {
+			if !strings.Contains(strings.TrimSpace(p), " ") {
+				parts = []string{authors}
+				break
+			}
+		}
+	default:
+		parts = []string{authors}
+	}
+
+	var names []string
+	for _, p := range parts {
+		for _, name := range strings.Split(p, " and ") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// parseAuthor splits "Family, Given" and "Family AB" names; other names,
+// such as "Given Family" or consortia, are kept whole
+func parseAuthor(name string) author {
+	if family, given, ok := strings.Cut(name, ","); ok {
+		return author{family: strings.TrimSpace(family), given: strings.TrimSpace(given)}
+	}
+	fields := strings.Fields(name)
+	if len(fields) > 1 && initialsPattern.MatchString(fields[len(fields)-1]) {
+		initials := fields[len(fields)-1]
+		dotted := make([]string, len(initials))
+		for i, r := range initials {
+			dotted[i] = string(r) + "."
+		}
+		return author{family: strings.Join(fields[:len(fields)-1], " "), given: strings.Join(dotted, " ")}
+	}
+	return author{literal: name}
+}
+
+// sortName returns the name of an author as "Family, Given", or whole
+func (a author) sortName() string {
+	if a.literal != "" {
+		return a.literal
+	}
+	if a.given == "" {
+		return a.family
+	}
+	return a.family + ", " + a.given
+}
+
+// url returns the resolver URL of the DOI
+func (r reference) url() string {
+	if r.doi == "" {
+		return ""
+	}
+	return "https://doi.org/" + r.doi
+}
+
+// key returns the citation key of a reference, from the family name of its
+// first author, its year and its id, which keeps keys unique and stable
+func (r reference) key() string {
+	name := "marinenp"
+	if len(r.authors) > 0 {
+		a := r.authors[0]
+		if a.literal != "" {
+			fields := strings.Fields(a.literal)
+			name = fields[len(fields)-1]
+		} else {
+			name = a.family
+		}
+	}
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() == 0 {
+		b.WriteString("marinenp")
+	}
+	return fmt.Sprintf("%s%s_%d", b.String(), r.year, r.id)
+}
+
+// bibtexEscaper escapes the characters with a meaning in BibTeX values
+var bibtexEscaper = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	"{", `\{`, "}", `\}`,
+	"&", `\&`, "%", `\%`, "$", `\$`, "#", `\#`, "_", `\_`,
+	"~", `\textasciitilde{}`, "^", `\textasciicircum{}`,
+)
+
+// verbatimCleaner removes the braces that would unbalance verbatim values
+var verbatimCleaner = strings.NewReplacer("{", "", "}", "")
+
+func (w *Writer) writeBibTeX(r reference) error {
+	authors := make([]string, len(r.authors))
+	for i, a := range r.authors {
+		authors[i] = bibtexEscaper.Replace(a.sortName())
+	}
+
+	var b strings.Builder
+	if w.n > 0 {
+		b.WriteByte('\n')
+	}
+	fmt.Fprintf(&b, "@article{%s", r.key())
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, ",\n  %s = {%s}", name, value)
+		}
+	}
+	field("title", bibtexEscaper.Replace(r.title))
+	field("author", strings.Join(authors, " and "))
+	field("journal", bibtexEscaper.Replace(r.journal))
+	field("year", r.year)
+	field("volume", bibtexEscaper.Replace(r.volume))
+	field("number", bibtexEscaper.Replace(r.issue))
+	field("pages", bibtexEscaper.Replace(strings.Replace(r.pages, "-", "--", 1)))
+	field("doi", verbatimCleaner.Replace(r.doi))
+	field("url", verbatimCleaner.Replace(r.url()))
+	field("note", bibtexEscaper.Replace(r.note))
+	b.WriteString("\n}\n")
+	_, err := io.WriteString(w.w, b.String())
+	return err
+}
+
+func (w *Writer) writeRIS(r reference) error {
+	var b strings.Builder
+	tag := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "%s  - %s\r\n", name, value)
+		}
+	}
+	tag("TY", "JOUR")
+	tag("ID", r.key())
+	tag("TI", r.title)
+	for _, a := range r.authors {
+		tag("AU", a.sortName())
+	}
+	tag("T2", r.journal)
+	tag("PY", r.year)
+	tag("VL", r.volume)
+	tag("IS", r.issue)
+	if start, end, ok := strings.Cut(r.pages, "-"); ok {
+		tag("SP", start)
+		tag("EP", end)
+	} else {
+		tag("SP", r.pages)
+	}
+	tag("DO", r.doi)
+	tag("UR", r.url())
+	tag("N1", r.note)
+	tag("ER", " ")
+	_, err := io.WriteString(w.w, b.String())
+	return err
+}
+
+// cslItem is a CSL-JSON item
+type cslItem struct {
+	ID      string    `json:"id"`
+	Type    string    `json:"type"`
+	Title   string    `json:"title,omitempty"`
+	Author  []cslName `json:"author,omitempty"`
+	Issued  *cslDate  `json:"issued,omitempty"`
+	Journal string    `json:"container-title,omitempty"`
+	Volume  string    `json:"volume,omitempty"`
+	Issue   string    `json:"issue,omitempty"`
+	Page    string    `json:"page,omitempty"`
+	DOI     string    `json:"DOI,omitempty"`
+	URL     string    `json:"URL,omitempty"`
+	Note    string    `json:"note,omitempty"`
+}
+
+type cslName struct {
+	Family  string `json:"family,omitempty"`
+	Given   string `json:"given,omitempty"`
+	Literal string `json:"literal,omitempty"`
+}
+
+type cslDate struct {
+	DateParts [][]int `json:"date-parts"`
+}
+
+func (w *Writer) writeCSL(r reference) error {
+	item := cslItem{
+		ID:      r.key(),
+		Type:    "article-journal",
+		Title:   r.title,
+		Journal: r.journal,
+		Volume:  r.volume,
+		Issue:   r.issue,
+		Page:    r.pages,
+		DOI:     r.doi,
+		URL:     r.url(),
+		Note:    r.note,
+	}
+	for _, a := range r.authors {
+		item.Author = append(item.Author, cslName{a.family, a.given, a.literal})
+	}
+	if r.year != "" {
+		year, _ := strconv.Atoi(r.year)
+		item.Issued = &cslDate{[][]int{{year}}}
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if w.n == 0 {
+		sep = "[\n"
+	}
+	_, err = io.WriteString(w.w, sep+string(data))
+	return err
+}
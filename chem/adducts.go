@@ -0,0 +1,92 @@
+/*
+ * MarineNP Mass Spectrometry Adducts
+ * Purpose: Ion adducts used to convert observed m/z values to neutral masses
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file lists the common electrospray adducts with the mass they add to
+ * the neutral molecule, and converts between m/z and neutral monoisotopic
+ * mass. Masses include the electron mass, as in the usual adduct tables.
+ */
+
+package chem
+
+import "math"
+
+// Monoisotopic masses used to derive adduct mass shifts
+const (
+	electronMass  = 0.000548579909
+	protonMass    = 1.007276466621
+	sodiumMass    = 22.989769282
+	potassiumMass = 38.963706485
+	ammoniumMass  = 18.034374129 // NH4, neutral
+	chlorineMass  = 34.968852682
+	waterMass     = 18.010564684
+	formateMass   = 44.997654266 // HCOO, neutral
+	acetateMass   = 59.013304330 // CH3COO, neutral
+)
+
+// Adduct describes an ion formed from Multimer neutral molecules that adds
+// Delta to their mass and carries Charge
+type Adduct struct {
+	Name     string  `json:"name"`
+	Charge   int     `json:"charge"`
+	Multimer int     `json:"multimer"`
+	Delta    float64 `json:"delta"`
+}
+
+// Adducts lists the supported adducts by ionization mode
+var Adducts = map[string][]Adduct{
+	"positive": {
+		{Name: "[M+H]+", Charge: 1, Multimer: 1, Delta: protonMass},
+		{Name: "[M+Na]+", Charge: 1, Multimer: 1, Delta: sodiumMass - electronMass},
+		{Name: "[M+K]+", Charge: 1, Multimer: 1, Delta: potassiumMass - electronMass},
+		{Name: "[M+NH4]+", Charge: 1, Multimer: 1, Delta: ammoniumMass - electronMass},
+		{Name: "[M+H-H2O]+", Charge: 1, Multimer: 1, Delta: protonMass - waterMass},
+		{Name: "[M]+", Charge: 1, Multimer: 1, Delta: -electronMass},
+		{Name: "[M+2H]2+", Charge: 2, Multimer: 1, Delta: 2 * protonMass},
+		{Name: "[2M+H]+", Charge: 1, Multimer: 2, Delta: protonMass},
+		{Name: "[2M+Na]+", Charge: 1, Multimer: 2, Delta: sodiumMass - electronMass},
+	},
+	"negative": {
+		{Name: "[M-H]-", Charge: -1, Multimer: 1, Delta: -protonMass},
+		{Name: "[M+Cl]-", Charge: -1, Multimer: 1, Delta: chlorineMass + electronMass},
+		{Name: "[M+HCOO]-", Charge: -1, Multimer: 1, Delta: formateMass + electronMass},
+		{Name: "[M+CH3COO]-", Charge: -1, Multimer: 1, Delta: acetateMass + electronMass},
+		{Name: "[M-H-H2O]-", Charge: -1, Multimer: 1, Delta: -protonMass - waterMass},
+		{Name: "[M-2H]2-", Charge: -2, Multimer: 1, Delta: -2 * protonMass},
+		{Name: "[2M-H]-", Charge: -1, Multimer: 2, Delta: -protonMass},
+	},
+}
+
+// DefaultAdducts are used when a request does not name any
+var DefaultAdducts = map[string][]string{
+	"positive": {"[M+H]+", "[M+Na]+", "[M+NH4]+"},
+	"negative": {"[M-H]-", "[M+Cl]-", "[M+HCOO]-"},
+}
+
+// LookupAdduct returns the adduct with the given name in an ionization mode
+func LookupAdduct(mode, name string) (Adduct, bool) {
+	for _, adduct := range Adducts[mode] {
+		if adduct.Name == name {
+			return adduct, true
+		}
+	}
+	return Adduct{}, false
+}
+
+// MZ returns the m/z of the adduct of a neutral molecule
+func (a Adduct) MZ(mass float64) float64 {
+	return (float64(a.Multimer)*mass + a.Delta) / math.Abs(float64(a.Charge))
+}
+
+// NeutralMass returns the neutral monoisotopic mass giving the m/z as this adduct
+func (a Adduct) NeutralMass(mz float64) float64 {
+	return (mz*math.Abs(float64(a.Charge)) - a.Delta) / float64(a.Multimer)
+}
+
+// PPM returns the error of an observed value relative to a theoretical one,
+// in parts per million
+func PPM(observed, theoretical float64) float64 {
+	return (observed - theoretical) / theoretical * 1e6
+}
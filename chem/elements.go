@@ -0,0 +1,58 @@
+/*
+ * MarineNP Element Table
+ * Purpose: Element symbols, atomic numbers and default valences
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file maps element symbols to atomic numbers and lists the default
+ * valences of the SMILES organic subset used to derive implicit hydrogens.
+ */
+
+package chem
+
+import "strings"
+
+// elementSymbols is indexed by atomic number; index 0 is the unknown atom
+var elementSymbols = strings.Fields(`*
+	H He Li Be B C N O F Ne Na Mg Al Si P S Cl Ar K Ca Sc Ti V Cr Mn Fe Co Ni
+	Cu Zn Ga Ge As Se Br Kr Rb Sr Y Zr Nb Mo Tc Ru Rh Pd Ag Cd In Sn Sb Te I
+	Xe Cs Ba La Ce Pr Nd Pm Sm Eu Gd Tb Dy Ho Er Tm Yb Lu Hf Ta W Re Os Ir Pt
+	Au Hg Tl Pb Bi Po At Rn Fr Ra Ac Th Pa U Np Pu Am Cm Bk Cf Es Fm Md No Lr
+	Rf Db Sg Bh Hs Mt Ds Rg Cn Nh Fl Mc Lv Ts Og`)
+
+// atomicNumbers maps element symbols to atomic numbers
+var atomicNumbers = map[string]int{}
+
+// defaultValences lists the allowed valences of organic-subset atoms
+var defaultValences = map[int][]int{
+	5:  {3},       // B
+	6:  {4},       // C
+	7:  {3, 5},    // N
+	8:  {2},       // O
+	15: {3, 5},    // P
+	16: {2, 4, 6}, // S
+	9:  {1},       // F
+	17: {1},       // Cl
+	35: {1},       // Br
+	53: {1},       // I
+}
+
+func init() {
+	for number, symbol := range elementSymbols {
+		atomicNumbers[symbol] = number
+	}
+}
+
+// ElementSymbol returns the symbol of an atomic number
+func ElementSymbol(number int) string {
+	if number < 0 || number >= len(elementSymbols) {
+		return "*"
+	}
+	return elementSymbols[number]
+}
+
+// AtomicNumber returns the atomic number of an element symbol
+func AtomicNumber(symbol string) (int, bool) {
+	number, ok := atomicNumbers[symbol]
+	return number, ok
+}
@@ -0,0 +1,189 @@
+/*
+ * MarineNP Screening Fingerprints
+ * Purpose: Path fingerprints used to screen substructure candidates
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file hashes every linear path of up to PathLength bonds into a fixed
+ * size bit vector. A query's paths are found in any molecule that contains
+ * the query, so a molecule whose fingerprint lacks one of the query's bits
+ * cannot match and is skipped without running the matcher.
+ */
+
+package chem
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"strings"
+)
+
+const (
+	// FingerprintVersion identifies how fingerprints are computed. It is
+	// raised whenever parsing or hashing changes the fingerprints of some
+	// molecules, so that stored fingerprints are recomputed. Version 2 keeps
+	// the hydrogens of NH atoms in rings written in Kekulé form.
+	FingerprintVersion = 2
+	// FingerprintBits is the size of a screening fingerprint
+	FingerprintBits = 2048
+	// PathLength is the longest path, in bonds, hashed into a fingerprint
+	PathLength = 6
+	// maxPaths bounds the paths hashed for one molecule. A molecule with more
+	// paths, such as a large fused ring system, gets every bit set so that it
+	// is never screened out.
+	maxPaths = 200000
+)
+
+// Fingerprint is a fixed size bit vector
+type Fingerprint []uint64
+
+// NewFingerprint returns an empty fingerprint
+func NewFingerprint() Fingerprint {
+	return make(Fingerprint, FingerprintBits/64)
+}
+
+func (f Fingerprint) set(hash uint32) {
+	bit := hash % FingerprintBits
+	f[bit/64] |= 1 << (bit % 64)
+}
+
+// Contains reports whether every bit of other is also set in f
+func (f Fingerprint) Contains(other Fingerprint) bool {
+	if len(f) != len(other) {
+		return false
+	}
+	for i, word := range other {
+		if f[i]&word != word {
+			return false
+		}
+	}
+	return true
+}
+
+// Count returns the number of set bits
+func (f Fingerprint) Count() int {
+	n := 0
+	for _, word := range f {
+		n += bits.OnesCount64(word)
+	}
+	return n
+}
+
+// Bytes encodes the fingerprint for storage
+func (f Fingerprint) Bytes() []byte {
+	data := make([]byte, len(f)*8)
+	for i, word := range f {
+		binary.LittleEndian.PutUint64(data[i*8:], word)
+	}
+	return data
+}
+
+// FingerprintFromBytes decodes a stored fingerprint
+func FingerprintFromBytes(data []byte) (Fingerprint, error) {
+	if len(data) != FingerprintBits/8 {
+		return nil, fmt.Errorf("fingerprint has %d bytes, expected %d", len(data), FingerprintBits/8)
+	}
+	f := make(Fingerprint, len(data)/8)
+	for i := range f {
+		f[i] = binary.LittleEndian.Uint64(data[i*8:])
+	}
+	return f, nil
+}
+
+// pathGraph is the labelled graph walked when hashing paths. Atoms or bonds
+// with an empty label end a path, which is how query atoms that do not have
+// a single exact symbol are left out of the screen.
+type pathGraph struct {
+	atomLabels []string
+	bondLabels []string
+	adjacency  [][]edge
+}
+
+// PathFingerprint returns the screening fingerprint of a molecule
+func PathFingerprint(m *Molecule) Fingerprint {
+	g := pathGraph{adjacency: m.adjacency}
+	for i := range m.Atoms {
+		g.atomLabels = append(g.atomLabels, m.Atoms[i].Symbol())
+	}
+	for _, b := range m.Bonds {
+		g.bondLabels = append(g.bondLabels, b.Order.Symbol())
+	}
+	f, complete := g.fingerprint()
+	if !complete {
+		for i := range f {
+			f[i] = ^uint64(0)
+		}
+	}
+	return f
+}
+
+// Screen returns the fingerprint bits every molecule containing the query has
+func (q *Query) Screen() Fingerprint {
+	g := pathGraph{adjacency: q.adjacency}
+	for _, a := range q.Atoms {
+		g.atomLabels = append(g.atomLabels, a.Label)
+	}
+	for _, b := range q.Bonds {
+		g.bondLabels = append(g.bondLabels, b.Label)
+	}
+	// A truncated query screen is still valid, only less selective
+	f, _ := g.fingerprint()
+	return f
+}
+
+// fingerprint hashes every labelled simple path starting at every atom; each
+// path is hashed in the lexically smaller of its two directions. It reports
+// false if it stopped after maxPaths paths.
+func (g pathGraph) fingerprint() (Fingerprint, bool) {
+	f := NewFingerprint()
+	visited := make([]bool, len(g.atomLabels))
+	var forward []string
+	paths := 0
+
+	var walk func(atom, depth int)
+	walk = func(atom, depth int) {
+		paths++
+		f.set(hashPath(forward))
+		if depth == PathLength || paths > maxPaths {
+			return
+		}
+		for _, e := range g.adjacency[atom] {
+			if visited[e.atom] || g.bondLabels[e.bond] == "" || g.atomLabels[e.atom] == "" {
+				continue
+			}
+			visited[e.atom] = true
+			forward = append(forward, g.bondLabels[e.bond], g.atomLabels[e.atom])
+			walk(e.atom, depth+1)
+			forward = forward[:len(forward)-2]
+			visited[e.atom] = false
+		}
+	}
+
+	for atom, label := range g.atomLabels {
+		if label == "" {
+			continue
+		}
+		visited[atom] = true
+		forward = append(forward[:0], label)
+		walk(atom, 0)
+		visited[atom] = false
+	}
+	return f, paths <= maxPaths
+}
+
+// hashPath hashes a path given as alternating atom and bond labels
+func hashPath(path []string) uint32 {
+	forward := strings.Join(path, " ")
+	reversed := make([]string, len(path))
+	for i, label := range path {
+		reversed[len(path)-1-i] = label
+	}
+	if backward := strings.Join(reversed, " "); backward < forward {
+		forward = backward
+	}
+	h := fnv.New32a()
+	h.Write([]byte(forward))
+	return h.Sum32()
+}
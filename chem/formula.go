@@ -0,0 +1,293 @@
+/*
+ * MarineNP Molecular Formulas
+ * Purpose: Parse, compare and query molecular formulas
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file parses molecular formulas into element counts so that formulas
+ * compare equal regardless of the order their elements are written in, and
+ * implements element range patterns such as "C10-20 N1-3 Br+ S0" used by
+ * formula search.
+ */
+
+package chem
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Formula maps element symbols to their counts
+type Formula map[string]int
+
+// chargeSuffix matches a trailing charge such as "+", "-2" or "++"
+var chargeSuffix = regexp.MustCompile(`[+-]+[0-9]*$`)
+
+// ParseFormula parses a molecular formula such as "C20H30O2", "C6H4(OH)2" or
+// "C2H3O2.Na". A trailing charge, e.g. "C5H12N+" or "C2H3O2-", is ignored and
+// deuterium and tritium are counted as hydrogen.
+func ParseFormula(text string) (Formula, error) {
+	text = chargeSuffix.ReplaceAllString(strings.TrimSpace(text), "")
+	if text == "" {
+		return nil, fmt.Errorf("empty formula")
+	}
+
+	f := Formula{}
+	for _, part := range strings.FieldsFunc(text, func(r rune) bool { return r == '.' || r == '·' || r == '*' }) {
+		s := &scanner{text: part}
+		multiplier := 1
+		if n := s.readNumber(); n > 0 {
+			multiplier = n
+		}
+		counts, err := parseFormulaGroup(s, 0)
+		if err != nil {
+			return nil, err
+		}
+		if !s.done() {
+			return nil, s.errorf("unexpected %q in formula", s.peek())
+		}
+		for symbol, n := range counts {
+			f[symbol] += n * multiplier
+		}
+	}
+	if len(f) == 0 {
+		return nil, fmt.Errorf("formula contains no elements")
+	}
+	return f, nil
+}
+
+// parseFormulaGroup parses elements and bracketed groups up to the end of
+// the input or a closing bracket
+func parseFormulaGroup(s *scanner, depth int) (Formula, error) {
+	if depth > 8 {
+		return nil, s.errorf("brackets nested too deeply")
+	}
+	f := Formula{}
+	for !s.done() {
+		c := s.peek()
+		switch {
+		case c == '(' || c == '[':
+			s.pos++
+			inner, err := parseFormulaGroup(s, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			closing := byte(')')
+			if c == '[' {
+				closing = ']'
+			}
+			if s.peek() != closing {
+				return nil, s.errorf("unbalanced bracket in formula")
+			}
+			s.pos++
+			count := 1
+			if n := s.readNumber(); n >= 0 {
+				count = n
+			}
+			for symbol, n := range inner {
+				f[symbol] += n * count
+			}
+
+		case c == ')' || c == ']':
+			return f, nil
+
+		case c >= 'A' && c <= 'Z':
+			symbol := string(c)
+			s.pos++
+			if !s.done() && s.peek() >= 'a' && s.peek() <= 'z' {
+				if _, ok := AtomicNumber(symbol + string(s.peek())); ok {
+					symbol += string(s.peek())
+					s.pos++
+				}
+			}
+			if symbol == "D" || symbol == "T" {
+				symbol = "H"
+			} else if _, ok := AtomicNumber(symbol); !ok {
+				return nil, s.errorf("unknown element %q", symbol)
+			}
+			count := 1
+			if n := s.readNumber(); n >= 0 {
+				count = n
+			}
+			f[symbol] += count
+
+		default:
+			return nil, s.errorf("unexpected %q in formula", c)
+		}
+	}
+	return f, nil
+}
+
+// Equal reports whether two formulas have the same element counts
+func (f Formula) Equal(other Formula) bool {
+	for symbol, n := range f {
+		if other[symbol] != n {
+			return false
+		}
+	}
+	for symbol, n := range other {
+		if f[symbol] != n {
+			return false
+		}
+	}
+	return true
+}
+
+// String writes the formula in Hill order: C, then H, then the other
+// elements alphabetically; without carbon all elements are alphabetical
+func (f Formula) String() string {
+	symbols := make([]string, 0, len(f))
+	for symbol, n := range f {
+		if n > 0 {
+			symbols = append(symbols, symbol)
+		}
+	}
+	rank := func(symbol string) int {
+		if f["C"] > 0 {
+			switch symbol {
+			case "C":
+				return 0
+			case "H":
+				return 1
+			}
+		}
+		return 2
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		if rank(symbols[i]) != rank(symbols[j]) {
+			return rank(symbols[i]) < rank(symbols[j])
+		}
+		return symbols[i] < symbols[j]
+	})
+
+	var b strings.Builder
+	for _, symbol := range symbols {
+		b.WriteString(symbol)
+		if f[symbol] > 1 {
+			b.WriteString(strconv.Itoa(f[symbol]))
+		}
+	}
+	return b.String()
+}
+
+// Formula returns the molecular formula of a molecule, including implicit
+// and bracket hydrogens
+func (m *Molecule) Formula() Formula {
+	f := Formula{}
+	for _, a := range m.Atoms {
+		if a.Element > 0 {
+			f[ElementSymbol(a.Element)]++
+		}
+		if a.HCount > 0 {
+			f["H"] += a.HCount
+		}
+	}
+	return f
+}
+
+// Halogens is the pseudo-element X of formula patterns, the sum of all
+// halogen counts
+const Halogens = "X"
+
+var halogenSymbols = []string{"F", "Cl", "Br", "I", "At"}
+
+// ElementRange constrains the count of one element; Max is -1 when unbounded
+type ElementRange struct {
+	Symbol   string
+	Min, Max int
+}
+
+// FormulaPattern is a set of element ranges that must all hold
+type FormulaPattern []ElementRange
+
+// ParseFormulaPattern parses element ranges separated by spaces or commas.
+// Each term is an element symbol, or X for any halogen, followed by
+//
+//	n     exactly n, so Br0 excludes bromine
+//	n-m   between n and m
+//	n-    at least n
+//	-m    at most m
+//	+     at least one; a bare symbol means the same
+//
+// and a leading ! also excludes the element, e.g. "C10-20 N1-3 X+ !S".
+func ParseFormulaPattern(text string) (FormulaPattern, error) {
+	s := &scanner{text: strings.TrimSpace(text)}
+	var pattern FormulaPattern
+	for {
+		for !s.done() && strings.ContainsRune(" ,;\t", rune(s.peek())) {
+			s.pos++
+		}
+		if s.done() {
+			break
+		}
+
+		negate := false
+		if s.peek() == '!' {
+			negate = true
+			s.pos++
+		}
+
+		if s.peek() < 'A' || s.peek() > 'Z' {
+			return nil, s.errorf("expected an element symbol")
+		}
+		symbol := string(s.peek())
+		s.pos++
+		if !s.done() && s.peek() >= 'a' && s.peek() <= 'z' {
+			symbol += string(s.peek())
+			s.pos++
+		}
+		if _, ok := AtomicNumber(symbol); !ok && symbol != Halogens {
+			return nil, s.errorf("unknown element %q", symbol)
+		}
+
+		r := ElementRange{Symbol: symbol, Min: 1, Max: -1}
+		switch {
+		case negate:
+			r.Min, r.Max = 0, 0
+		case s.peek() == '+':
+			s.pos++
+		case s.peek() == '-':
+			s.pos++
+			max := s.readNumber()
+			if max < 0 {
+				return nil, s.errorf("expected a maximum count")
+			}
+			r.Min, r.Max = 0, max
+		case isDigit(s.peek()):
+			r.Min = s.readNumber()
+			r.Max = r.Min
+			if s.peek() == '-' {
+				s.pos++
+				r.Max = s.readNumber()
+				if r.Max >= 0 && r.Max < r.Min {
+					return nil, s.errorf("empty range for %s", symbol)
+				}
+			}
+		}
+		pattern = append(pattern, r)
+	}
+	if len(pattern) == 0 {
+		return nil, fmt.Errorf("empty formula pattern")
+	}
+	return pattern, nil
+}
+
+// Matches reports whether the formula satisfies every range of the pattern
+func (p FormulaPattern) Matches(f Formula) bool {
+	for _, r := range p {
+		n := f[r.Symbol]
+		if r.Symbol == Halogens {
+			n = 0
+			for _, symbol := range halogenSymbols {
+				n += f[symbol]
+			}
+		}
+		if n < r.Min || (r.Max >= 0 && n > r.Max) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,123 @@
+/*
+ * MarineNP Kekulization
+ * Purpose: Assign alternating single and double bonds to aromatic systems
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file turns aromatic bonds back into explicit single and double bonds,
+ * which file formats such as molfiles expect. Every aromatic atom that
+ * donates one electron to its ring gets exactly one double bond to another
+ * such atom, found by a backtracking perfect matching.
+ */
+
+package chem
+
+// kekuleLimit bounds the matching attempts for one molecule
+const kekuleLimit = 100000
+
+// Kekulize returns the bond orders of the molecule with every aromatic bond
+// replaced by a single or double bond. It reports false, leaving aromatic
+// bonds in place, if no valid assignment exists.
+func (m *Molecule) Kekulize() ([]BondOrder, bool) {
+	orders := make([]BondOrder, len(m.Bonds))
+	aromatic := false
+	for i, b := range m.Bonds {
+		orders[i] = b.Order
+		if b.Order == Aromatic {
+			aromatic = true
+		}
+	}
+	if !aromatic {
+		return orders, true
+	}
+
+	// Aromatic atoms that still need a double bond
+	needs := make([]bool, len(m.Atoms))
+	var pending []int
+	for atom := range m.Atoms {
+		if m.needsDoubleBond(atom) {
+			needs[atom] = true
+			pending = append(pending, atom)
+		}
+	}
+
+	partner := make([]int, len(m.Atoms))
+	for i := range partner {
+		partner[i] = -1
+	}
+	candidates := func(atom int) []edge {
+		var result []edge
+		for _, e := range m.adjacency[atom] {
+			if m.Bonds[e.bond].Order == Aromatic && needs[e.atom] && partner[e.atom] < 0 {
+				result = append(result, e)
+			}
+		}
+		return result
+	}
+
+	steps := 0
+	var match func(left int) bool
+	match = func(left int) bool {
+		if left == 0 {
+			return true
+		}
+		steps++
+		if steps > kekuleLimit {
+			return false
+		}
+		// Match the most constrained atom first
+		best, bestCandidates := -1, []edge(nil)
+		for _, atom := range pending {
+			if partner[atom] >= 0 {
+				continue
+			}
+			c := candidates(atom)
+			if best < 0 || len(c) < len(bestCandidates) {
+				best, bestCandidates = atom, c
+			}
+			if len(c) == 0 {
+				return false
+			}
+		}
+		for _, e := range bestCandidates {
+			partner[best], partner[e.atom] = e.atom, best
+			if match(left - 2) {
+				return true
+			}
+			partner[best], partner[e.atom] = -1, -1
+		}
+		return false
+	}
+	if len(pending)%2 != 0 || !match(len(pending)) {
+		return orders, false
+	}
+
+	for i, b := range m.Bonds {
+		if b.Order != Aromatic {
+			continue
+		}
+		orders[i] = Single
+		if partner[b.A] == b.B {
+			orders[i] = Double
+		}
+	}
+	return orders, true
+}
+
+// needsDoubleBond reports whether an aromatic atom donates a single electron
+// to its ring and has no double bond outside it yet
+func (m *Molecule) needsDoubleBond(atom int) bool {
+	if !m.Atoms[atom].Aromatic {
+		return false
+	}
+	for _, e := range m.adjacency[atom] {
+		if m.Bonds[e.bond].Order == Double {
+			return false
+		}
+	}
+	if m.Atoms[atom].Element == 6 && m.Atoms[atom].Charge > 0 {
+		return false
+	}
+	electrons, _ := m.piElectrons(atom)
+	return electrons == 1
+}
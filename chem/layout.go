@@ -0,0 +1,458 @@
+/*
+ * MarineNP 2D Layout
+ * Purpose: Generate 2D depiction coordinates for molecules parsed from SMILES
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file lays out ring systems as fused regular polygons and grows chains
+ * from them as zigzags, spreading substituents into the widest free angle
+ * around each atom. Separate components are placed side by side. The layout
+ * does not resolve overlaps; it gives file formats that need coordinates a
+ * readable depiction for the typical natural product.
+ */
+
+package chem
+
+import (
+	"math"
+	"sort"
+)
+
+// BondLength is the length of every bond in a layout
+const BondLength = 1.5
+
+// Point is a 2D atom position
+type Point struct {
+	X, Y float64
+}
+
+func (p Point) add(q Point) Point     { return Point{p.X + q.X, p.Y + q.Y} }
+func (p Point) sub(q Point) Point     { return Point{p.X - q.X, p.Y - q.Y} }
+func (p Point) scale(f float64) Point { return Point{p.X * f, p.Y * f} }
+func (p Point) angle() float64        { return math.Atan2(p.Y, p.X) }
+func (p Point) length() float64       { return math.Hypot(p.X, p.Y) }
+func polar(r, theta float64) Point    { return Point{r * math.Cos(theta), r * math.Sin(theta)} }
+func (p Point) rotate(theta float64) Point {
+	sin, cos := math.Sincos(theta)
+	return Point{p.X*cos - p.Y*sin, p.X*sin + p.Y*cos}
+}
+
+// layout holds the state of one layout run
+type layout struct {
+	m      *Molecule
+	pos    []Point
+	placed []bool
+	turn   []float64 // Zigzag direction of chain atoms, +1 or -1
+
+	system  []int     // Ring system of each atom, -1 outside rings
+	systems [][][]int // Rings of each ring system
+}
+
+// Layout returns 2D coordinates for every atom of the molecule
+func (m *Molecule) Layout() []Point {
+	l := &layout{
+		m:      m,
+		pos:    make([]Point, len(m.Atoms)),
+		placed: make([]bool, len(m.Atoms)),
+		turn:   make([]float64, len(m.Atoms)),
+	}
+	l.findRingSystems()
+
+	right := 0.0
+	for start := range m.Atoms {
+		if l.placed[start] {
+			continue
+		}
+		component := l.growComponent(start)
+
+		// Place the component to the right of the previous one
+		minX, maxX, minY, maxY := math.Inf(1), math.Inf(-1), math.Inf(1), math.Inf(-1)
+		for _, atom := range component {
+			minX, maxX = math.Min(minX, l.pos[atom].X), math.Max(maxX, l.pos[atom].X)
+			minY, maxY = math.Min(minY, l.pos[atom].Y), math.Max(maxY, l.pos[atom].Y)
+		}
+		shift := Point{right - minX, -(minY + maxY) / 2}
+		if start == 0 {
+			shift.X = -minX
+		}
+		for _, atom := range component {
+			l.pos[atom] = l.pos[atom].add(shift)
+		}
+		right = maxX + shift.X + 2*BondLength
+	}
+	return l.pos
+}
+
+// findRingSystems groups ring atoms joined by ring bonds and assigns every
+// smallest ring to its system
+func (l *layout) findRingSystems() {
+	m := l.m
+	l.system = make([]int, len(m.Atoms))
+	for i := range l.system {
+		l.system[i] = -1
+	}
+	for atom := range m.Atoms {
+		if l.system[atom] >= 0 || !m.IsRingAtom(atom) {
+			continue
+		}
+		id := len(l.systems)
+		l.systems = append(l.systems, nil)
+		l.system[atom] = id
+		stack := []int{atom}
+		for len(stack) > 0 {
+			a := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for _, e := range m.adjacency[a] {
+				if m.ringBonds[e.bond] && l.system[e.atom] < 0 {
+					l.system[e.atom] = id
+					stack = append(stack, e.atom)
+				}
+			}
+		}
+	}
+	for _, ring := range m.smallestRings() {
+		id := l.system[ring[0]]
+		l.systems[id] = append(l.systems[id], ring)
+	}
+	// Largest rings first, so that fused rings are drawn around them
+	for _, rings := range l.systems {
+		sort.SliceStable(rings, func(i, j int) bool { return len(rings[i]) > len(rings[j]) })
+	}
+}
+
+// growComponent lays out the connected component of an atom around the
+// origin and returns its atoms
+func (l *layout) growComponent(start int) []int {
+	var queue []int
+	if id := l.system[start]; id >= 0 {
+		queue = l.placeRingSystem(id, start, Point{}, 0)
+	} else {
+		l.place(start, Point{})
+		l.turn[start] = 1
+		queue = []int{start}
+	}
+
+	var component []int
+	for len(queue) > 0 {
+		atom := queue[0]
+		queue = queue[1:]
+		component = append(component, atom)
+
+		var children []edge
+		for _, e := range l.m.adjacency[atom] {
+			if !l.placed[e.atom] {
+				children = append(children, e)
+			}
+		}
+		for i, theta := range l.childAngles(atom, children) {
+			child := children[i].atom
+			target := l.pos[atom].add(polar(BondLength, theta))
+			if id := l.system[child]; id >= 0 {
+				queue = append(queue, l.placeRingSystem(id, child, target, theta)...)
+				continue
+			}
+			l.place(child, target)
+			l.turn[child] = -l.turn[atom]
+			if l.turn[child] == 0 {
+				l.turn[child] = 1
+			}
+			queue = append(queue, child)
+		}
+	}
+	return component
+}
+
+// childAngles returns the directions in which the unplaced neighbours of an
+// atom are drawn
+func (l *layout) childAngles(atom int, children []edge) []float64 {
+	if len(children) == 0 {
+		return nil
+	}
+	var directions []float64
+	linear := false
+	doubles := 0
+	for _, e := range l.m.adjacency[atom] {
+		switch l.m.Bonds[e.bond].Order {
+		case Triple:
+			linear = true
+		case Double:
+			doubles++
+		}
+		if l.placed[e.atom] {
+			directions = append(directions, l.pos[e.atom].sub(l.pos[atom]).angle())
+		}
+	}
+	linear = linear || doubles > 1
+
+	angles := make([]float64, len(children))
+	switch {
+	case len(directions) == 0:
+		step := 2 * math.Pi / float64(len(children))
+		offset := 0.0
+		if len(children) <= 3 {
+			step, offset = 2*math.Pi/3, math.Pi/6
+		}
+		for i := range children {
+			angles[i] = offset + float64(i)*step
+		}
+
+	case len(directions) == 1 && len(children) == 1:
+		// Continue a chain as a zigzag, or straight through sp atoms
+		angles[0] = directions[0] + math.Pi
+		if !linear {
+			angles[0] += l.turn[atom] * math.Pi / 3
+		}
+
+	default:
+		// Spread the children evenly over the widest free angle
+		sort.Float64s(directions)
+		gapStart, gap := directions[len(directions)-1], directions[0]+2*math.Pi-directions[len(directions)-1]
+		for i := 1; i < len(directions); i++ {
+			if d := directions[i] - directions[i-1]; d > gap {
+				gapStart, gap = directions[i-1], d
+			}
+		}
+		for i := range children {
+			angles[i] = gapStart + gap*float64(i+1)/float64(len(children)+1)
+		}
+	}
+	return angles
+}
+
+// place sets the position of an atom
+func (l *layout) place(atom int, p Point) {
+	l.pos[atom] = p
+	l.placed[atom] = true
+}
+
+// placeRingSystem lays out a ring system so that the entry atom lies at
+// target and the system extends from it in direction theta, returning the
+// atoms of the system
+func (l *layout) placeRingSystem(id, entry int, target Point, theta float64) []int {
+	local := map[int]Point{}
+	rings := l.systems[id]
+	done := make([]bool, len(rings))
+
+	for count := 0; count < len(rings); count++ {
+		// Prefer a ring sharing a bond with the layout so far, then one
+		// sharing an atom; the first ring starts the layout
+		next, shared := -1, -1
+		for i, ring := range rings {
+			if done[i] {
+				continue
+			}
+			if s := sharedEdge(ring, local); s >= 0 {
+				next, shared = i, s
+				break
+			}
+			if next < 0 && (len(local) == 0 || sharesAtom(ring, local)) {
+				next = i
+			}
+		}
+		if next < 0 {
+			break
+		}
+		done[next] = true
+		ring := rings[next]
+
+		switch {
+		case len(local) == 0:
+			n := float64(len(ring))
+			radius := BondLength / (2 * math.Sin(math.Pi/n))
+			for i, atom := range ring {
+				local[atom] = polar(radius, math.Pi/2+2*math.Pi*float64(i)/n)
+			}
+		case shared >= 0:
+			l.fuseRing(ring, shared, local)
+		default:
+			spiroRing(ring, local)
+		}
+	}
+
+	// Rotate the system so that it points away from the atom it hangs off
+	rotation := 0.0
+	if outward := centroid(local).sub(local[entry]); outward.length() > 1e-9 {
+		rotation = theta - outward.angle()
+	}
+
+	atoms := make([]int, 0, len(local))
+	for atom, p := range local {
+		l.place(atom, target.add(p.sub(local[entry]).rotate(rotation)))
+		atoms = append(atoms, atom)
+	}
+	sort.Ints(atoms)
+	return atoms
+}
+
+// sharedEdge returns the index i of a ring bond ring[i]-ring[i+1] whose atoms
+// are both placed, or -1
+func sharedEdge(ring []int, local map[int]Point) int {
+	for i, atom := range ring {
+		_, a := local[atom]
+		_, b := local[ring[(i+1)%len(ring)]]
+		if a && b {
+			return i
+		}
+	}
+	return -1
+}
+
+// sharesAtom reports whether any atom of the ring is placed
+func sharesAtom(ring []int, local map[int]Point) bool {
+	for _, atom := range ring {
+		if _, ok := local[atom]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// centroid returns the mean position of the placed atoms
+func centroid(local map[int]Point) Point {
+	c := Point{}
+	for _, p := range local {
+		c = c.add(p)
+	}
+	return c.scale(1 / float64(len(local)))
+}
+
+// fuseRing draws a ring as a regular polygon on its placed bond
+// ring[i]-ring[i+1], on the side away from the placed ring neighbours of the
+// bond that are not part of the new ring
+func (l *layout) fuseRing(ring []int, i int, local map[int]Point) {
+	n := len(ring)
+	a, b := local[ring[i]], local[ring[(i+1)%n]]
+	mid := a.add(b).scale(0.5)
+	edge := b.sub(a)
+	normal := Point{-edge.Y, edge.X}.scale(1 / edge.length())
+	apothem := edge.length() / (2 * math.Tan(math.Pi/float64(n)))
+	inRing := map[int]bool{}
+	for _, atom := range ring {
+		inRing[atom] = true
+	}
+	away := map[int]Point{}
+	for _, end := range []int{ring[i], ring[(i+1)%n]} {
+		for _, e := range l.m.adjacency[end] {
+			if p, ok := local[e.atom]; ok && !inRing[e.atom] {
+				away[e.atom] = p
+			}
+		}
+	}
+	if len(away) == 0 {
+		away = local
+	}
+	center := mid.add(normal.scale(apothem))
+	if other := mid.sub(normal.scale(apothem)); other.sub(centroid(away)).length() > center.sub(centroid(away)).length() {
+		center = other
+	}
+
+	// Walk on from ring[i+1] in the direction that leads back to ring[i]
+	radius := b.sub(center).length()
+	start := b.sub(center).angle()
+	step := start - a.sub(center).angle()
+	step = math.Remainder(step, 2*math.Pi)
+	polygon := map[int]Point{}
+	for k := 2; k < n; k++ {
+		atom := ring[(i+k)%n]
+		p := center.add(polar(radius, start+float64(k-1)*step))
+		// In a bridged system the placed atoms do not lie on the polygon
+		if q, ok := local[atom]; ok && q.sub(p).length() > BondLength/10 {
+			l.bridgeRing(ring, local)
+			return
+		}
+		polygon[atom] = p
+	}
+	for atom, p := range polygon {
+		if _, ok := local[atom]; !ok {
+			local[atom] = p
+		}
+	}
+}
+
+// bridgeRing draws every run of unplaced ring atoms as an arc between the
+// placed atoms at its ends, bulging away from the atoms placed so far
+func (l *layout) bridgeRing(ring []int, local map[int]Point) {
+	n := len(ring)
+	for first := 0; first < n; first++ {
+		_, before := local[ring[(first+n-1)%n]]
+		if _, placed := local[ring[first]]; placed || !before {
+			continue
+		}
+		var run []int
+		for k := first; ; k = (k + 1) % n {
+			if _, placed := local[ring[k]]; placed {
+				break
+			}
+			run = append(run, ring[k])
+		}
+		p, q := local[ring[(first+n-1)%n]], local[ring[(first+len(run))%n]]
+		arc(run, p, q, centroid(local), local)
+	}
+}
+
+// arc places atoms on a sine-shaped arc from p to q, bowed away from origin,
+// whose bonds are as close to BondLength as the span allows
+func arc(atoms []int, p, q, origin Point, local map[int]Point) {
+	segments := float64(len(atoms) + 1)
+	span := q.sub(p)
+	normal := Point{-span.Y, span.X}
+	if normal.length() < 1e-9 {
+		normal = Point{0, 1}
+	}
+	normal = normal.scale(1 / normal.length())
+	if mid := p.add(q).scale(0.5); mid.add(normal).sub(origin).length() < mid.sub(normal).sub(origin).length() {
+		normal = normal.scale(-1)
+	}
+
+	at := func(k int, height float64) Point {
+		t := float64(k) / segments
+		return p.add(span.scale(t)).add(normal.scale(height * math.Sin(math.Pi*t)))
+	}
+	length := func(height float64) float64 {
+		total := 0.0
+		for k := 1; k <= len(atoms)+1; k++ {
+			total += at(k, height).sub(at(k-1, height)).length()
+		}
+		return total
+	}
+	low, high := 0.0, segments*BondLength
+	for iteration := 0; iteration < 40 && length(low) < segments*BondLength; iteration++ {
+		mid := (low + high) / 2
+		if length(mid) < segments*BondLength {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	for k, atom := range atoms {
+		local[atom] = at(k+1, low)
+	}
+}
+
+// spiroRing draws a ring sharing a single placed atom, pointing away from
+// the atoms placed so far
+func spiroRing(ring []int, local map[int]Point) {
+	n := len(ring)
+	pivot := 0
+	for i, atom := range ring {
+		if _, ok := local[atom]; ok {
+			pivot = i
+			break
+		}
+	}
+	p := local[ring[pivot]]
+	outward := p.sub(centroid(local))
+	direction := outward.angle()
+	if outward.length() < 1e-9 {
+		direction = 0
+	}
+	radius := BondLength / (2 * math.Sin(math.Pi/float64(n)))
+	center := p.add(polar(radius, direction))
+	start := direction + math.Pi
+	for k := 1; k < n; k++ {
+		atom := ring[(pivot+k)%n]
+		if _, ok := local[atom]; !ok {
+			local[atom] = center.add(polar(radius, start+2*math.Pi*float64(k)/float64(n)))
+		}
+	}
+}
@@ -0,0 +1,128 @@
+/*
+ * MarineNP Substructure Matching
+ * Purpose: Find a query graph inside a molecule
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file implements backtracking subgraph isomorphism. Query atoms are
+ * visited in breadth-first order so that every atom after the first of its
+ * component is tried only against neighbours of an already mapped atom.
+ */
+
+package chem
+
+// MatchLimit bounds the number of partial mappings tried for one molecule so
+// that a pathological query cannot stall a search; the molecule is then
+// reported as not matching.
+const MatchLimit = 200000
+
+// plan is the order in which query atoms are mapped
+type plan struct {
+	order  []int // Query atoms in mapping order
+	parent []int // Already mapped neighbour of each atom, or -1 for a component root
+}
+
+// plan computes the mapping order, starting each component at its most
+// specific atom so that the root has as few candidates as possible
+func (q *Query) plan() plan {
+	p := plan{parent: make([]int, len(q.Atoms))}
+	visited := make([]bool, len(q.Atoms))
+
+	for len(p.order) < len(q.Atoms) {
+		root := -1
+		for atom := range q.Atoms {
+			if visited[atom] {
+				continue
+			}
+			if root < 0 || (q.Atoms[root].Label == "" && q.Atoms[atom].Label != "") {
+				root = atom
+			}
+		}
+
+		visited[root] = true
+		p.parent[root] = -1
+		queue := []int{root}
+		for len(queue) > 0 {
+			atom := queue[0]
+			queue = queue[1:]
+			p.order = append(p.order, atom)
+			for _, e := range q.adjacency[atom] {
+				if !visited[e.atom] {
+					visited[e.atom] = true
+					p.parent[e.atom] = atom
+					queue = append(queue, e.atom)
+				}
+			}
+		}
+	}
+	return p
+}
+
+// Matches reports whether the query is a substructure of the molecule
+func (q *Query) Matches(m *Molecule) bool {
+	if len(q.Atoms) > len(m.Atoms) || len(q.Bonds) > len(m.Bonds) {
+		return false
+	}
+
+	p := q.plan()
+	mapping := make([]int, len(q.Atoms))
+	for i := range mapping {
+		mapping[i] = -1
+	}
+	used := make([]bool, len(m.Atoms))
+	steps := 0
+
+	var extend func(depth int) bool
+	extend = func(depth int) bool {
+		if depth == len(p.order) {
+			return true
+		}
+		steps++
+		if steps > MatchLimit {
+			return false
+		}
+
+		atom := p.order[depth]
+		try := func(candidate int) bool {
+			if used[candidate] || !q.Atoms[atom].expr.matches(m, candidate) {
+				return false
+			}
+			// Every query bond to an already mapped atom must exist in the molecule
+			for _, e := range q.adjacency[atom] {
+				target := mapping[e.atom]
+				if target < 0 {
+					continue
+				}
+				bond := m.bondBetween(candidate, target)
+				if bond < 0 || !q.Bonds[e.bond].expr.matches(m, bond) {
+					return false
+				}
+			}
+			mapping[atom] = candidate
+			used[candidate] = true
+			if extend(depth + 1) {
+				return true
+			}
+			mapping[atom] = -1
+			used[candidate] = false
+			return false
+		}
+
+		if parent := p.parent[atom]; parent >= 0 {
+			for _, e := range m.adjacency[mapping[parent]] {
+				if try(e.atom) {
+					return true
+				}
+			}
+			return false
+		}
+		for candidate := range m.Atoms {
+			if try(candidate) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return extend(0)
+}
@@ -0,0 +1,66 @@
+package chem
+
+import "testing"
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		query    string
+		molecule string
+		want     bool
+	}{
+		// SMILES queries
+		{"c1ccccc1", "Cc1ccccc1", true},
+		{"c1ccccc1", "C1=CC=CC=C1C", true},
+		{"c1ccccc1", "C1CCCCC1", false},
+		{"C1CCCCC1", "c1ccccc1", false},
+		{"CCO", "CC(C)CO", true},
+		{"C=O", "CC(=O)O", true},
+		{"C=O", "CCO", false},
+		{"c1cc[nH]c1", "C1=CNC=C1", true},
+		{"c1cc[nH]c1", "Cn1cccc1", false},
+		{"O=c1cccc[nH]1", "O=C1C=CC=CN1", true},
+		{"O=c1cccc[nH]1", "O=c1ccccn1C", false},
+		{"[O-]", "CC(=O)[O-]", true},
+		{"[O-]", "CC(=O)O", false},
+		{"CCCCCCC", "CCCCCC", false},
+		// SMARTS queries
+		{"[#6]~[#8]", "CC(=O)O", true},
+		{"[#7]", "CCO", false},
+		{"[OX2H]", "CCO", true},
+		{"[OX2H]", "COC", false},
+		{"[C,N]=O", "NC=O", true},
+		{"[!#6;!#1]", "CCCl", true},
+		{"[!#6;!#1]", "CCC", false},
+		{"[CX4]Cl", "ClC(Cl)(Cl)Cl", true},
+		{"[R]", "C1CC1", true},
+		{"[R]", "CCC", false},
+		{"c:c", "c1ccccc1", true},
+		{"a", "C1=CNC=C1", true},
+		{"C-,=O", "CC(=O)O", true},
+		{"*1***1", "C1CCC1", true},
+		{"*1***1", "C1CCCC1", false},
+	}
+	for _, tt := range tests {
+		q, err := ParseQuery(tt.query)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", tt.query, err)
+			continue
+		}
+		m, err := ParseSMILES(tt.molecule)
+		if err != nil {
+			t.Errorf("ParseSMILES(%q): %v", tt.molecule, err)
+			continue
+		}
+		if got := q.Matches(m); got != tt.want {
+			t.Errorf("%q matches %q = %v, want %v", tt.query, tt.molecule, got, tt.want)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, query := range []string{"", "[#6", "C(", "[Zz]", "C1CC"} {
+		if _, err := ParseQuery(query); err == nil {
+			t.Errorf("ParseQuery(%q): expected an error", query)
+		}
+	}
+}
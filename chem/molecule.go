@@ -0,0 +1,420 @@
+/*
+ * MarineNP Molecule Graphs
+ * Purpose: In-memory molecular graph used for structure search
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file defines the atoms, bonds and derived ring and hydrogen information
+ * of a molecule parsed from SMILES. It also perceives aromaticity so that
+ * Kekulé and aromatic spellings of the same ring compare equal.
+ */
+
+package chem
+
+import "sort"
+
+// BondOrder is the order of a bond; aromatic bonds have their own order
+type BondOrder int
+
+const (
+	Single BondOrder = iota + 1
+	Double
+	Triple
+	Quadruple
+	Aromatic
+)
+
+// Symbol returns the SMILES symbol of the bond order
+func (o BondOrder) Symbol() string {
+	switch o {
+	case Double:
+		return "="
+	case Triple:
+		return "#"
+	case Quadruple:
+		return "$"
+	case Aromatic:
+		return ":"
+	default:
+		return "-"
+	}
+}
+
+// valence returns the contribution of the bond to an atom's valence
+func (o BondOrder) valence() int {
+	if o == Aromatic {
+		return 1
+	}
+	return int(o)
+}
+
+// Atom is a single atom of a molecule
+type Atom struct {
+	Element  int // Atomic number, 0 for an unknown (*) atom
+	Aromatic bool
+	Charge   int
+	Isotope  int
+	HCount   int  // Explicit hydrogens for bracket atoms, implicit otherwise
+	Bracket  bool // Written as a bracket atom, e.g. [nH]
+}
+
+// Symbol returns the element symbol, lower-case for aromatic atoms
+func (a *Atom) Symbol() string {
+	symbol := ElementSymbol(a.Element)
+	if a.Aromatic {
+		return lower(symbol)
+	}
+	return symbol
+}
+
+// Bond connects two atoms of a molecule
+type Bond struct {
+	A, B  int
+	Order BondOrder
+}
+
+// Other returns the atom at the other end of the bond
+func (b *Bond) Other(atom int) int {
+	if b.A == atom {
+		return b.B
+	}
+	return b.A
+}
+
+// edge is an adjacency entry: the neighbouring atom and the bond leading to it
+type edge struct {
+	atom, bond int
+}
+
+// Molecule is a molecular graph
+type Molecule struct {
+	Atoms []Atom
+	Bonds []Bond
+
+	adjacency [][]edge
+	ringBonds []bool
+}
+
+// addAtom appends an atom and returns its index
+func (m *Molecule) addAtom(a Atom) int {
+	m.Atoms = append(m.Atoms, a)
+	m.adjacency = append(m.adjacency, nil)
+	return len(m.Atoms) - 1
+}
+
+// addBond connects two atoms and returns the bond index
+func (m *Molecule) addBond(a, b int, order BondOrder) int {
+	m.Bonds = append(m.Bonds, Bond{A: a, B: b, Order: order})
+	index := len(m.Bonds) - 1
+	m.adjacency[a] = append(m.adjacency[a], edge{atom: b, bond: index})
+	m.adjacency[b] = append(m.adjacency[b], edge{atom: a, bond: index})
+	return index
+}
+
+// bondBetween returns the index of the bond between two atoms, or -1
+func (m *Molecule) bondBetween(a, b int) int {
+	for _, e := range m.adjacency[a] {
+		if e.atom == b {
+			return e.bond
+		}
+	}
+	return -1
+}
+
+// Degree returns the number of explicit neighbours of an atom
+func (m *Molecule) Degree(atom int) int {
+	return len(m.adjacency[atom])
+}
+
+// IsRingBond reports whether a bond is part of a ring
+func (m *Molecule) IsRingBond(bond int) bool {
+	return m.ringBonds[bond]
+}
+
+// IsRingAtom reports whether an atom is part of a ring
+func (m *Molecule) IsRingAtom(atom int) bool {
+	for _, e := range m.adjacency[atom] {
+		if m.ringBonds[e.bond] {
+			return true
+		}
+	}
+	return false
+}
+
+// finish derives ring membership, aromaticity and implicit hydrogens once
+// all atoms and bonds have been added. Atoms written in Kekulé form get their
+// hydrogens from their written bonds before aromaticity is perceived, so that
+// the NH of C1=CNC=C1 or O=C1C=CC=CN1 is kept once the ring is aromatic.
+func (m *Molecule) finish() {
+	m.findRingBonds()
+	kekule := make([]bool, len(m.Atoms))
+	for i := range m.Atoms {
+		if !m.Atoms[i].Bracket && !m.Atoms[i].Aromatic {
+			m.Atoms[i].HCount = m.implicitHydrogens(i)
+			kekule[i] = true
+		}
+	}
+	m.perceiveAromaticity()
+	for i := range m.Atoms {
+		if !m.Atoms[i].Bracket && !kekule[i] {
+			m.Atoms[i].HCount = m.implicitHydrogens(i)
+		}
+	}
+}
+
+// findRingBonds marks every bond that is not a bridge of the graph
+func (m *Molecule) findRingBonds() {
+	m.ringBonds = make([]bool, len(m.Bonds))
+	order := make([]int, len(m.Atoms))
+	low := make([]int, len(m.Atoms))
+	counter := 0
+
+	var visit func(atom, viaBond int)
+	visit = func(atom, viaBond int) {
+		counter++
+		order[atom] = counter
+		low[atom] = counter
+		for _, e := range m.adjacency[atom] {
+			if e.bond == viaBond {
+				continue
+			}
+			if order[e.atom] == 0 {
+				visit(e.atom, e.bond)
+				if low[e.atom] < low[atom] {
+					low[atom] = low[e.atom]
+				}
+				// A tree edge is a ring bond unless it is a bridge
+				m.ringBonds[e.bond] = low[e.atom] <= order[atom]
+			} else {
+				if order[e.atom] < low[atom] {
+					low[atom] = order[e.atom]
+				}
+				m.ringBonds[e.bond] = true
+			}
+		}
+	}
+
+	for atom := range m.Atoms {
+		if order[atom] == 0 {
+			visit(atom, -1)
+		}
+	}
+}
+
+// smallestRings returns, for every ring bond, the smallest ring through it.
+// Duplicates are removed; this is enough for aromaticity perception.
+func (m *Molecule) smallestRings() [][]int {
+	seen := map[string]bool{}
+	var rings [][]int
+	for bond := range m.Bonds {
+		if !m.ringBonds[bond] {
+			continue
+		}
+		ring := m.shortestCycle(bond)
+		if ring == nil {
+			continue
+		}
+		key := append([]int(nil), ring...)
+		sort.Ints(key)
+		k := intsKey(key)
+		if !seen[k] {
+			seen[k] = true
+			rings = append(rings, ring)
+		}
+	}
+	return rings
+}
+
+// shortestCycle finds the shortest path between the ends of a bond that does
+// not use the bond itself, returning the ring's atoms in path order
+func (m *Molecule) shortestCycle(bond int) []int {
+	start, goal := m.Bonds[bond].A, m.Bonds[bond].B
+	parent := make([]int, len(m.Atoms))
+	for i := range parent {
+		parent[i] = -2
+	}
+	parent[start] = -1
+	queue := []int{start}
+	for len(queue) > 0 {
+		atom := queue[0]
+		queue = queue[1:]
+		if atom == goal {
+			break
+		}
+		for _, e := range m.adjacency[atom] {
+			if e.bond == bond || parent[e.atom] != -2 || !m.ringBonds[e.bond] {
+				continue
+			}
+			parent[e.atom] = atom
+			queue = append(queue, e.atom)
+		}
+	}
+	if parent[goal] == -2 {
+		return nil
+	}
+	var ring []int
+	for atom := goal; atom != -1; atom = parent[atom] {
+		ring = append(ring, atom)
+	}
+	return ring
+}
+
+// perceiveAromaticity marks Kekulé rings with 4n+2 pi electrons as aromatic,
+// repeating until fused ring systems stop changing
+func (m *Molecule) perceiveAromaticity() {
+	rings := m.smallestRings()
+	for changed := true; changed; {
+		changed = false
+		for _, ring := range rings {
+			if len(ring) < 5 || len(ring) > 7 || m.ringIsAromatic(ring) {
+				continue
+			}
+			electrons := 0
+			ok := true
+			for _, atom := range ring {
+				n, allowed := m.piElectrons(atom)
+				if !allowed {
+					ok = false
+					break
+				}
+				electrons += n
+			}
+			if !ok || electrons%4 != 2 {
+				continue
+			}
+			for i, atom := range ring {
+				m.Atoms[atom].Aromatic = true
+				next := ring[(i+1)%len(ring)]
+				m.Bonds[m.bondBetween(atom, next)].Order = Aromatic
+			}
+			changed = true
+		}
+	}
+}
+
+// ringIsAromatic reports whether every bond of the ring is already aromatic
+func (m *Molecule) ringIsAromatic(ring []int) bool {
+	for i, atom := range ring {
+		bond := m.bondBetween(atom, ring[(i+1)%len(ring)])
+		if bond < 0 || m.Bonds[bond].Order != Aromatic {
+			return false
+		}
+	}
+	return true
+}
+
+// piElectrons returns the number of electrons an atom donates to a ring's pi
+// system and whether the atom can be part of an aromatic ring at all
+func (m *Molecule) piElectrons(atom int) (int, bool) {
+	a := &m.Atoms[atom]
+	if a.Aromatic {
+		switch a.Element {
+		case 6:
+			if a.Charge < 0 {
+				return 2, true
+			}
+			return 1, true
+		case 7, 15:
+			if a.HCount > 0 || (m.Degree(atom) == 3 && a.Charge == 0) {
+				return 2, true
+			}
+			return 1, true
+		case 8, 16, 34:
+			return 2, true
+		}
+		return 1, true
+	}
+
+	doubles, exocyclic := 0, false
+	for _, e := range m.adjacency[atom] {
+		bond := &m.Bonds[e.bond]
+		switch bond.Order {
+		case Double, Aromatic:
+			doubles++
+			if bond.Order == Double && !m.ringBonds[e.bond] {
+				switch m.Atoms[e.atom].Element {
+				case 7, 8, 16:
+					exocyclic = true
+				default:
+					return 0, false
+				}
+			}
+		case Triple:
+			return 0, false
+		}
+	}
+	if exocyclic {
+		return 0, true
+	}
+	if doubles == 1 {
+		return 1, true
+	}
+	if doubles > 1 {
+		return 0, false
+	}
+
+	// Atoms without a double bond donate a lone pair or a negative charge
+	switch {
+	case a.Element == 6 && a.Charge < 0:
+		return 2, true
+	case a.Element == 6 && a.Charge > 0:
+		return 0, true
+	case a.Element == 7 || a.Element == 15:
+		if a.Charge == 0 {
+			return 2, true
+		}
+	case a.Element == 8 || a.Element == 16 || a.Element == 34:
+		if a.Charge == 0 {
+			return 2, true
+		}
+	}
+	return 0, false
+}
+
+// implicitHydrogens applies the OpenSMILES default valence rules to an
+// organic-subset atom
+func (m *Molecule) implicitHydrogens(atom int) int {
+	a := &m.Atoms[atom]
+	valences := defaultValences[a.Element]
+	if len(valences) == 0 {
+		return 0
+	}
+	used := 0
+	for _, e := range m.adjacency[atom] {
+		used += m.Bonds[e.bond].Order.valence()
+	}
+	// An aromatic atom keeps one valence for the pi system and never expands
+	// to a higher valence, so a three-connected n gets no hydrogen
+	if a.Aromatic {
+		if h := valences[0] - used - 1; h > 0 {
+			return h
+		}
+		return 0
+	}
+	for _, v := range valences {
+		if v >= used {
+			return v - used
+		}
+	}
+	return 0
+}
+
+// intsKey builds a map key from a sorted list of atom indices
+func intsKey(values []int) string {
+	key := make([]byte, 0, len(values)*3)
+	for _, v := range values {
+		key = append(key, byte(v>>16), byte(v>>8), byte(v))
+	}
+	return string(key)
+}
+
+// lower converts an ASCII element symbol to lower case
+func lower(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c >= 'A' && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
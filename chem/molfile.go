@@ -0,0 +1,89 @@
+/*
+ * MarineNP Molfiles
+ * Purpose: Write molecules as MDL V2000 molfiles and SD file records
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file writes the connection table of a molecule with 2D layout
+ * coordinates and Kekulé bond orders, the structure block of an SD file.
+ * Stereochemistry is not written since SMILES chirality is not kept.
+ */
+
+package chem
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MolBlock returns the molecule as a V2000 molfile whose first line is name
+func (m *Molecule) MolBlock(name string) string {
+	var b strings.Builder
+	writeHeader(&b, name, len(m.Atoms), len(m.Bonds))
+
+	pos := m.Layout()
+	for i := range m.Atoms {
+		fmt.Fprintf(&b, "%10.4f%10.4f%10.4f %-3s 0  0  0  0  0  0  0  0  0  0  0  0\n",
+			pos[i].X, pos[i].Y, 0.0, ElementSymbol(m.Atoms[i].Element))
+	}
+
+	// Aromatic bonds are written as bond type 4 only if they cannot be kekulized
+	orders, _ := m.Kekulize()
+	for i, bond := range m.Bonds {
+		order := int(orders[i])
+		if orders[i] == Aromatic {
+			order = 4
+		}
+		fmt.Fprintf(&b, "%3d%3d%3d  0\n", bond.A+1, bond.B+1, order)
+	}
+
+	var charges, isotopes [][2]int
+	for i, a := range m.Atoms {
+		if a.Charge != 0 {
+			charges = append(charges, [2]int{i + 1, a.Charge})
+		}
+		if a.Isotope != 0 {
+			isotopes = append(isotopes, [2]int{i + 1, a.Isotope})
+		}
+	}
+	writeProperty(&b, "CHG", charges)
+	writeProperty(&b, "ISO", isotopes)
+	b.WriteString("M  END\n")
+	return b.String()
+}
+
+// EmptyMolBlock returns a molfile without atoms, for records whose structure
+// could not be read
+func EmptyMolBlock(name string) string {
+	var b strings.Builder
+	writeHeader(&b, name, 0, 0)
+	b.WriteString("M  END\n")
+	return b.String()
+}
+
+// writeHeader writes the header and counts lines of a molfile
+func writeHeader(b *strings.Builder, name string, atoms, bonds int) {
+	// The name line may not hold line breaks and is limited to 80 characters
+	name = strings.Join(strings.Fields(name), " ")
+	if len(name) > 80 {
+		name = name[:80]
+	}
+	fmt.Fprintf(b, "%s\n  MarineNP          2D\n\n", name)
+	fmt.Fprintf(b, "%3d%3d  0  0  0  0  0  0  0  0999 V2000\n", atoms, bonds)
+}
+
+// writeProperty writes atom values as M lines of up to eight entries
+func writeProperty(b *strings.Builder, tag string, values [][2]int) {
+	for len(values) > 0 {
+		n := len(values)
+		if n > 8 {
+			n = 8
+		}
+		fmt.Fprintf(b, "M  %s%3d", tag, n)
+		for _, v := range values[:n] {
+			fmt.Fprintf(b, " %3d %3d", v[0], v[1])
+		}
+		b.WriteString("\n")
+		values = values[n:]
+	}
+}
@@ -0,0 +1,98 @@
+/*
+ * MarineNP Similarity Fingerprints
+ * Purpose: Circular (Morgan/ECFP-like) fingerprints and Tanimoto similarity
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file computes circular fingerprints in the style of ECFP4: every atom
+ * starts from a hash of its local invariants, which is then repeatedly
+ * combined with the hashes of its neighbours, and every hash seen up to
+ * MorganRadius bonds away sets one bit.
+ */
+
+package chem
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math/bits"
+	"sort"
+)
+
+// MorganRadius is the number of neighbour iterations; 2 corresponds to ECFP4
+const MorganRadius = 2
+
+// MorganFingerprint returns the circular fingerprint of a molecule
+func MorganFingerprint(m *Molecule) Fingerprint {
+	f := NewFingerprint()
+	ids := make([]uint32, len(m.Atoms))
+	for i := range m.Atoms {
+		a := &m.Atoms[i]
+		ring := 0
+		if m.IsRingAtom(i) {
+			ring = 1
+		}
+		aromatic := 0
+		if a.Aromatic {
+			aromatic = 1
+		}
+		ids[i] = hashInts(a.Element, m.Degree(i), a.HCount, a.Charge, a.Isotope, ring, aromatic)
+		f.set(ids[i])
+	}
+
+	type neighbour struct {
+		order BondOrder
+		id    uint32
+	}
+	for radius := 1; radius <= MorganRadius; radius++ {
+		next := make([]uint32, len(ids))
+		for i := range m.Atoms {
+			neighbours := make([]neighbour, 0, len(m.adjacency[i]))
+			for _, e := range m.adjacency[i] {
+				neighbours = append(neighbours, neighbour{order: m.Bonds[e.bond].Order, id: ids[e.atom]})
+			}
+			sort.Slice(neighbours, func(a, b int) bool {
+				if neighbours[a].order != neighbours[b].order {
+					return neighbours[a].order < neighbours[b].order
+				}
+				return neighbours[a].id < neighbours[b].id
+			})
+
+			values := []int{radius, int(ids[i])}
+			for _, n := range neighbours {
+				values = append(values, int(n.order), int(n.id))
+			}
+			next[i] = hashInts(values...)
+			f.set(next[i])
+		}
+		ids = next
+	}
+	return f
+}
+
+// hashInts hashes a list of integers
+func hashInts(values ...int) uint32 {
+	h := fnv.New32a()
+	var buf [8]byte
+	for _, v := range values {
+		binary.LittleEndian.PutUint64(buf[:], uint64(v))
+		h.Write(buf[:])
+	}
+	return h.Sum32()
+}
+
+// Tanimoto returns the Tanimoto (Jaccard) similarity of two fingerprints
+func Tanimoto(a, b Fingerprint) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	common, union := 0, 0
+	for i := range a {
+		common += bits.OnesCount64(a[i] & b[i])
+		union += bits.OnesCount64(a[i] | b[i])
+	}
+	if union == 0 {
+		return 0
+	}
+	return float64(common) / float64(union)
+}
@@ -0,0 +1,522 @@
+/*
+ * MarineNP Substructure Queries
+ * Purpose: Parse SMILES and SMARTS substructure queries
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file builds the query graphs matched by structure search. A query is
+ * read as SMILES when possible, so that a plain structure such as "c1ccccc1O"
+ * behaves as users expect, and as SMARTS otherwise. The supported SMARTS
+ * primitives are *, A, a, #n, element symbols, H, D, X, R, charges and
+ * isotopes for atoms, and - = # : ~ @ for bonds, combined with ! & , ;.
+ */
+
+package chem
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Query is a substructure query graph
+type Query struct {
+	Atoms []QueryAtom
+	Bonds []QueryBond
+
+	adjacency [][]edge
+}
+
+// QueryAtom is an atom of a query; Label is the exact atom symbol a matching
+// atom must have, or empty when the expression allows several
+type QueryAtom struct {
+	expr  atomExpr
+	Label string
+}
+
+// QueryBond is a bond of a query; Label is the exact bond symbol a matching
+// bond must have, or empty when the expression allows several
+type QueryBond struct {
+	A, B  int
+	expr  bondExpr
+	Label string
+}
+
+func (q *Query) addAtom(expr atomExpr) int {
+	q.Atoms = append(q.Atoms, QueryAtom{expr: expr, Label: expr.label()})
+	q.adjacency = append(q.adjacency, nil)
+	return len(q.Atoms) - 1
+}
+
+func (q *Query) addBond(a, b int, expr bondExpr) {
+	q.Bonds = append(q.Bonds, QueryBond{A: a, B: b, expr: expr, Label: expr.label()})
+	index := len(q.Bonds) - 1
+	q.adjacency[a] = append(q.adjacency[a], edge{atom: b, bond: index})
+	q.adjacency[b] = append(q.adjacency[b], edge{atom: a, bond: index})
+}
+
+func (q *Query) bondBetween(a, b int) int {
+	for _, e := range q.adjacency[a] {
+		if e.atom == b {
+			return e.bond
+		}
+	}
+	return -1
+}
+
+// ParseQuery parses a substructure query, trying SMILES first and SMARTS second
+func ParseQuery(text string) (*Query, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, fmt.Errorf("empty structure query")
+	}
+	if m, err := ParseSMILES(text); err == nil {
+		return FromMolecule(m), nil
+	}
+	return ParseSMARTS(text)
+}
+
+// FromMolecule builds a query matching the molecule's element, aromaticity
+// and bond orders. Charges and isotopes are matched when set, and hydrogen
+// counts only when written in a bracket atom.
+func FromMolecule(m *Molecule) *Query {
+	q := &Query{}
+	for _, a := range m.Atoms {
+		var expr atomExpr = atomPrimitive{kind: atomAny}
+		if a.Element > 0 {
+			expr = atomPrimitive{kind: atomSymbol, value: a.Element, aromatic: a.Aromatic}
+		}
+		if a.Charge != 0 {
+			expr = atomAnd{expr, atomPrimitive{kind: atomCharge, value: a.Charge}}
+		}
+		if a.Isotope != 0 {
+			expr = atomAnd{expr, atomPrimitive{kind: atomIsotope, value: a.Isotope}}
+		}
+		if a.Bracket {
+			expr = atomAnd{expr, atomPrimitive{kind: atomHCount, value: a.HCount}}
+		}
+		q.addAtom(expr)
+	}
+	for _, b := range m.Bonds {
+		q.addBond(b.A, b.B, bondPrimitive{kind: bondOrder, order: b.Order})
+	}
+	return q
+}
+
+// ParseSMARTS parses a SMARTS pattern into a query
+func ParseSMARTS(text string) (*Query, error) {
+	b := &smartsBuilder{query: &Query{}}
+	if err := walk(strings.TrimSpace(text), b); err != nil {
+		return nil, err
+	}
+	if len(b.query.Atoms) == 0 {
+		return nil, fmt.Errorf("SMARTS contains no atoms")
+	}
+	return b.query, nil
+}
+
+// atomExpr is a predicate over an atom of a molecule
+type atomExpr interface {
+	matches(m *Molecule, atom int) bool
+	label() string
+}
+
+type atomKind int
+
+const (
+	atomAny          atomKind = iota // *
+	atomAromatic                     // a
+	atomAliphatic                    // A
+	atomElement                      // #n, any aromaticity
+	atomSymbol                       // element with aromaticity, e.g. C or c
+	atomHCount                       // H<n>, total hydrogens
+	atomDegree                       // D<n>, explicit connections
+	atomConnectivity                 // X<n>, total connections
+	atomRing                         // R (value 1) or R0 (value 0)
+	atomCharge                       // +<n> or -<n>
+	atomIsotope                      // leading mass number
+)
+
+type atomPrimitive struct {
+	kind     atomKind
+	value    int
+	aromatic bool
+}
+
+func (p atomPrimitive) matches(m *Molecule, atom int) bool {
+	a := &m.Atoms[atom]
+	switch p.kind {
+	case atomAny:
+		return true
+	case atomAromatic:
+		return a.Aromatic
+	case atomAliphatic:
+		return !a.Aromatic
+	case atomElement:
+		return a.Element == p.value
+	case atomSymbol:
+		return a.Element == p.value && a.Aromatic == p.aromatic
+	case atomHCount:
+		return a.HCount == p.value
+	case atomDegree:
+		return m.Degree(atom) == p.value
+	case atomConnectivity:
+		return m.Degree(atom)+a.HCount == p.value
+	case atomRing:
+		return m.IsRingAtom(atom) == (p.value > 0)
+	case atomCharge:
+		return a.Charge == p.value
+	case atomIsotope:
+		return a.Isotope == p.value
+	}
+	return false
+}
+
+func (p atomPrimitive) label() string {
+	if p.kind != atomSymbol {
+		return ""
+	}
+	a := Atom{Element: p.value, Aromatic: p.aromatic}
+	return a.Symbol()
+}
+
+type atomNot struct{ expr atomExpr }
+
+func (n atomNot) matches(m *Molecule, atom int) bool { return !n.expr.matches(m, atom) }
+func (n atomNot) label() string                      { return "" }
+
+type atomAnd struct{ left, right atomExpr }
+
+func (e atomAnd) matches(m *Molecule, atom int) bool {
+	return e.left.matches(m, atom) && e.right.matches(m, atom)
+}
+
+// label of a conjunction is the label of either side, since both must hold
+func (e atomAnd) label() string {
+	if l := e.left.label(); l != "" {
+		return l
+	}
+	return e.right.label()
+}
+
+type atomOr struct{ left, right atomExpr }
+
+func (e atomOr) matches(m *Molecule, atom int) bool {
+	return e.left.matches(m, atom) || e.right.matches(m, atom)
+}
+
+func (e atomOr) label() string {
+	if l := e.left.label(); l != "" && l == e.right.label() {
+		return l
+	}
+	return ""
+}
+
+// bondExpr is a predicate over a bond of a molecule
+type bondExpr interface {
+	matches(m *Molecule, bond int) bool
+	label() string
+}
+
+type bondKind int
+
+const (
+	bondOrder    bondKind = iota // - = # $ :
+	bondAny                      // ~
+	bondRing                     // @
+	bondImplicit                 // no symbol: single or aromatic
+)
+
+type bondPrimitive struct {
+	kind  bondKind
+	order BondOrder
+}
+
+func (p bondPrimitive) matches(m *Molecule, bond int) bool {
+	order := m.Bonds[bond].Order
+	switch p.kind {
+	case bondOrder:
+		return order == p.order
+	case bondAny:
+		return true
+	case bondRing:
+		return m.IsRingBond(bond)
+	case bondImplicit:
+		return order == Single || order == Aromatic
+	}
+	return false
+}
+
+func (p bondPrimitive) label() string {
+	if p.kind != bondOrder {
+		return ""
+	}
+	return p.order.Symbol()
+}
+
+type bondNot struct{ expr bondExpr }
+
+func (n bondNot) matches(m *Molecule, bond int) bool { return !n.expr.matches(m, bond) }
+func (n bondNot) label() string                      { return "" }
+
+type bondAnd struct{ left, right bondExpr }
+
+func (e bondAnd) matches(m *Molecule, bond int) bool {
+	return e.left.matches(m, bond) && e.right.matches(m, bond)
+}
+
+func (e bondAnd) label() string {
+	if l := e.left.label(); l != "" {
+		return l
+	}
+	return e.right.label()
+}
+
+type bondOr struct{ left, right bondExpr }
+
+func (e bondOr) matches(m *Molecule, bond int) bool {
+	return e.left.matches(m, bond) || e.right.matches(m, bond)
+}
+
+func (e bondOr) label() string {
+	if l := e.left.label(); l != "" && l == e.right.label() {
+		return l
+	}
+	return ""
+}
+
+// smartsBuilder builds a Query from SMARTS atoms and bonds
+type smartsBuilder struct {
+	query *Query
+}
+
+func (b *smartsBuilder) parseAtom(s *scanner) (int, error) {
+	if s.peek() == '[' {
+		s.pos++
+		expr, err := parseExpression(s, parseAtomPrimitive, atomCombine, true)
+		if err != nil {
+			return 0, err
+		}
+		if s.peek() != ']' {
+			return 0, s.errorf("unterminated bracket atom")
+		}
+		s.pos++
+		return b.query.addAtom(expr.(atomExpr)), nil
+	}
+
+	switch s.peek() {
+	case '*':
+		s.pos++
+		return b.query.addAtom(atomPrimitive{kind: atomAny}), nil
+	case 'A':
+		s.pos++
+		return b.query.addAtom(atomPrimitive{kind: atomAliphatic}), nil
+	case 'a':
+		s.pos++
+		return b.query.addAtom(atomPrimitive{kind: atomAromatic}), nil
+	}
+	for _, symbol := range organicSubset {
+		if symbol != "*" && strings.HasPrefix(s.text[s.pos:], symbol) {
+			s.pos += len(symbol)
+			aromatic := symbol[0] >= 'a' && symbol[0] <= 'z'
+			element, _ := AtomicNumber(strings.ToUpper(symbol[:1]) + symbol[1:])
+			return b.query.addAtom(atomPrimitive{kind: atomSymbol, value: element, aromatic: aromatic}), nil
+		}
+	}
+	return 0, s.errorf("unexpected character %q", s.peek())
+}
+
+func (b *smartsBuilder) parseBond(s *scanner) (interface{}, bool, error) {
+	if !strings.ContainsRune("-=#$:~@!/\\", rune(s.peek())) {
+		return nil, false, nil
+	}
+	expr, err := parseExpression(s, parseBondPrimitive, bondCombine, false)
+	if err != nil {
+		return nil, false, err
+	}
+	return expr, true, nil
+}
+
+func (b *smartsBuilder) connect(a, c int, bond interface{}) error {
+	if b.query.bondBetween(a, c) >= 0 {
+		return fmt.Errorf("duplicate bond")
+	}
+	expr, ok := bond.(bondExpr)
+	if !ok {
+		expr = bondPrimitive{kind: bondImplicit}
+	}
+	b.query.addBond(a, c, expr)
+	return nil
+}
+
+// Logical operators of SMARTS expressions, in increasing precedence
+const (
+	opLowAnd  = ';'
+	opOr      = ','
+	opHighAnd = '&'
+	opNot     = '!'
+)
+
+// parseExpression parses a SMARTS atom or bond expression using the
+// precedence ! > & > , > ;. Inside bracket atoms, juxtaposed primitives are
+// and-ed as if joined by &.
+func parseExpression(s *scanner, primitive func(*scanner) (interface{}, error), combine func(op byte, left, right interface{}) interface{}, juxtapose bool) (interface{}, error) {
+	var level func(op byte) (interface{}, error)
+	level = func(op byte) (interface{}, error) {
+		if op == opNot {
+			if s.peek() == opNot {
+				s.pos++
+				expr, err := level(opNot)
+				if err != nil {
+					return nil, err
+				}
+				return combine(opNot, expr, nil), nil
+			}
+			return primitive(s)
+		}
+
+		next := map[byte]byte{opLowAnd: opOr, opOr: opHighAnd, opHighAnd: opNot}[op]
+		left, err := level(next)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			c := s.peek()
+			if c == op {
+				s.pos++
+			} else if op != opHighAnd || !juxtapose || !startsPrimitive(c) {
+				return left, nil
+			}
+			right, err := level(next)
+			if err != nil {
+				return nil, err
+			}
+			left = combine(op, left, right)
+		}
+	}
+	return level(opLowAnd)
+}
+
+// startsPrimitive reports whether a character can begin a primitive that is
+// implicitly and-ed with the previous one, e.g. the H of [CH2]
+func startsPrimitive(c byte) bool {
+	return c != 0 && c != ']' && c != opLowAnd && c != opOr
+}
+
+func atomCombine(op byte, left, right interface{}) interface{} {
+	switch op {
+	case opNot:
+		return atomNot{left.(atomExpr)}
+	case opOr:
+		return atomOr{left.(atomExpr), right.(atomExpr)}
+	}
+	return atomAnd{left.(atomExpr), right.(atomExpr)}
+}
+
+func bondCombine(op byte, left, right interface{}) interface{} {
+	switch op {
+	case opNot:
+		return bondNot{left.(bondExpr)}
+	case opOr:
+		return bondOr{left.(bondExpr), right.(bondExpr)}
+	}
+	return bondAnd{left.(bondExpr), right.(bondExpr)}
+}
+
+// parseAtomPrimitive reads one primitive of a bracket atom expression
+func parseAtomPrimitive(s *scanner) (interface{}, error) {
+	rest := s.text[s.pos:]
+	switch c := s.peek(); {
+	case c == '*':
+		s.pos++
+		return atomPrimitive{kind: atomAny}, nil
+	case c == '#':
+		s.pos++
+		n := s.readNumber()
+		if n < 0 {
+			return nil, s.errorf("missing atomic number")
+		}
+		return atomPrimitive{kind: atomElement, value: n}, nil
+	case c == '+' || c == '-':
+		return atomPrimitive{kind: atomCharge, value: readCharge(s)}, nil
+	case isDigit(c):
+		return atomPrimitive{kind: atomIsotope, value: s.readNumber()}, nil
+	case c == '@':
+		// Chirality is accepted but not matched
+		for s.peek() == '@' || s.peek() == '?' {
+			s.pos++
+		}
+		return atomPrimitive{kind: atomAny}, nil
+	case len(rest) >= 2 && rest[0] >= 'A' && rest[0] <= 'Z' && rest[1] >= 'a' && rest[1] <= 'z':
+		if n, ok := AtomicNumber(rest[:2]); ok {
+			s.pos += 2
+			return atomPrimitive{kind: atomSymbol, value: n}, nil
+		}
+	}
+
+	for _, symbol := range []string{"se", "as", "te"} {
+		if strings.HasPrefix(rest, symbol) {
+			s.pos += 2
+			n, _ := AtomicNumber(strings.ToUpper(symbol[:1]) + symbol[1:])
+			return atomPrimitive{kind: atomSymbol, value: n, aromatic: true}, nil
+		}
+	}
+
+	c := s.peek()
+	counted := map[byte]atomKind{'H': atomHCount, 'D': atomDegree, 'X': atomConnectivity, 'R': atomRing}
+	if kind, ok := counted[c]; ok {
+		s.pos++
+		n := s.readNumber()
+		// Ring counts are not tracked, so R<n> matches any ring atom
+		if n < 0 || (kind == atomRing && n > 0) {
+			n = 1
+		}
+		return atomPrimitive{kind: kind, value: n}, nil
+	}
+
+	switch c {
+	case 'a':
+		s.pos++
+		return atomPrimitive{kind: atomAromatic}, nil
+	case 'A':
+		s.pos++
+		return atomPrimitive{kind: atomAliphatic}, nil
+	}
+
+	if c >= 'a' && c <= 'z' {
+		if n, ok := AtomicNumber(strings.ToUpper(string(c))); ok {
+			s.pos++
+			return atomPrimitive{kind: atomSymbol, value: n, aromatic: true}, nil
+		}
+	}
+	if c >= 'A' && c <= 'Z' {
+		if n, ok := AtomicNumber(string(c)); ok {
+			s.pos++
+			return atomPrimitive{kind: atomSymbol, value: n}, nil
+		}
+	}
+	return nil, s.errorf("unsupported SMARTS primitive %q", c)
+}
+
+// parseBondPrimitive reads one bond primitive
+func parseBondPrimitive(s *scanner) (interface{}, error) {
+	c := s.peek()
+	s.pos++
+	switch c {
+	case '-', '/', '\\':
+		return bondPrimitive{kind: bondOrder, order: Single}, nil
+	case '=':
+		return bondPrimitive{kind: bondOrder, order: Double}, nil
+	case '#':
+		return bondPrimitive{kind: bondOrder, order: Triple}, nil
+	case '$':
+		return bondPrimitive{kind: bondOrder, order: Quadruple}, nil
+	case ':':
+		return bondPrimitive{kind: bondOrder, order: Aromatic}, nil
+	case '~':
+		return bondPrimitive{kind: bondAny}, nil
+	case '@':
+		return bondPrimitive{kind: bondRing}, nil
+	}
+	s.pos--
+	return nil, s.errorf("unsupported bond primitive %q", c)
+}
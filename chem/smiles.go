@@ -0,0 +1,365 @@
+/*
+ * MarineNP SMILES Parser
+ * Purpose: Parse SMILES strings into molecular graphs
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file implements the part of the OpenSMILES grammar that is shared with
+ * SMARTS (branches, ring closures, disconnections) and the SMILES-specific
+ * atoms and bonds. Stereochemistry is read but not kept, since structure
+ * search in MarineNP compares constitution only.
+ */
+
+package chem
+
+import (
+	"fmt"
+	"strings"
+)
+
+// scanner walks a line notation string
+type scanner struct {
+	text string
+	pos  int
+}
+
+func (s *scanner) done() bool { return s.pos >= len(s.text) }
+
+func (s *scanner) peek() byte {
+	if s.done() {
+		return 0
+	}
+	return s.text[s.pos]
+}
+
+// errorf reports a syntax error at the current position
+func (s *scanner) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s at position %d", fmt.Sprintf(format, args...), s.pos+1)
+}
+
+// readNumber consumes a run of digits, returning -1 if there is none
+func (s *scanner) readNumber() int {
+	start := s.pos
+	n := 0
+	for !s.done() && isDigit(s.peek()) {
+		n = n*10 + int(s.peek()-'0')
+		s.pos++
+	}
+	if s.pos == start {
+		return -1
+	}
+	return n
+}
+
+// lineBuilder receives the atoms and bonds found while walking a SMILES or
+// SMARTS string. Bonds are opaque to the walker; a nil bond means that no
+// bond symbol was written and the builder's default applies.
+type lineBuilder interface {
+	parseAtom(s *scanner) (int, error)
+	parseBond(s *scanner) (interface{}, bool, error)
+	connect(a, b int, bond interface{}) error
+}
+
+// walk parses the grammar shared by SMILES and SMARTS
+func walk(text string, b lineBuilder) error {
+	s := &scanner{text: text}
+	prev := -1
+	var branches []int
+	var pending interface{}
+
+	type openRing struct {
+		atom int
+		bond interface{}
+	}
+	rings := map[int]openRing{}
+
+	for !s.done() {
+		ch := s.peek()
+		switch {
+		case ch == '(':
+			if prev < 0 || pending != nil {
+				return s.errorf("unexpected branch")
+			}
+			branches = append(branches, prev)
+			s.pos++
+
+		case ch == ')':
+			if len(branches) == 0 || pending != nil {
+				return s.errorf("unbalanced branch")
+			}
+			prev = branches[len(branches)-1]
+			branches = branches[:len(branches)-1]
+			s.pos++
+
+		case ch == '.':
+			if pending != nil {
+				return s.errorf("bond before disconnection")
+			}
+			prev = -1
+			s.pos++
+
+		case isDigit(ch) || ch == '%':
+			if prev < 0 {
+				return s.errorf("ring closure without an atom")
+			}
+			var number int
+			if ch == '%' {
+				s.pos++
+				if s.pos+2 > len(s.text) || !isDigit(s.text[s.pos]) || !isDigit(s.text[s.pos+1]) {
+					return s.errorf("invalid ring closure")
+				}
+				number = int(s.text[s.pos]-'0')*10 + int(s.text[s.pos+1]-'0')
+				s.pos += 2
+			} else {
+				number = int(ch - '0')
+				s.pos++
+			}
+			if open, ok := rings[number]; ok {
+				bond := pending
+				if bond == nil {
+					bond = open.bond
+				}
+				if open.atom == prev {
+					return s.errorf("ring closure %d bonds an atom to itself", number)
+				}
+				if err := b.connect(open.atom, prev, bond); err != nil {
+					return s.errorf("%v", err)
+				}
+				delete(rings, number)
+			} else {
+				rings[number] = openRing{atom: prev, bond: pending}
+			}
+			pending = nil
+
+		default:
+			bond, ok, err := b.parseBond(s)
+			if err != nil {
+				return err
+			}
+			if ok {
+				if pending != nil || prev < 0 {
+					return s.errorf("unexpected bond")
+				}
+				pending = bond
+				continue
+			}
+			atom, err := b.parseAtom(s)
+			if err != nil {
+				return err
+			}
+			if prev >= 0 {
+				if err := b.connect(prev, atom, pending); err != nil {
+					return s.errorf("%v", err)
+				}
+			} else if pending != nil {
+				return s.errorf("bond without a preceding atom")
+			}
+			pending = nil
+			prev = atom
+		}
+	}
+
+	switch {
+	case len(rings) > 0:
+		return fmt.Errorf("unclosed ring")
+	case len(branches) > 0:
+		return fmt.Errorf("unclosed branch")
+	case pending != nil:
+		return fmt.Errorf("dangling bond at end of input")
+	}
+	return nil
+}
+
+// ParseSMILES parses a SMILES string into a molecule. Anything after the
+// first whitespace (often a name) is ignored.
+func ParseSMILES(text string) (*Molecule, error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty SMILES")
+	}
+
+	b := &smilesBuilder{mol: &Molecule{}}
+	if err := walk(fields[0], b); err != nil {
+		return nil, err
+	}
+	if len(b.mol.Atoms) == 0 {
+		return nil, fmt.Errorf("SMILES contains no atoms")
+	}
+	b.mol.finish()
+	return b.mol, nil
+}
+
+// smilesBuilder builds a Molecule from SMILES atoms and bonds
+type smilesBuilder struct {
+	mol *Molecule
+}
+
+// organicSubset lists the atoms that may be written without brackets,
+// two-letter symbols first so that "Cl" is not read as "C"
+var organicSubset = []string{"Cl", "Br", "B", "C", "N", "O", "P", "S", "F", "I", "b", "c", "n", "o", "p", "s", "*"}
+
+// aromaticSymbols lists the lower-case symbols allowed in bracket atoms
+var aromaticSymbols = []string{"se", "as", "te", "b", "c", "n", "o", "p", "s"}
+
+func (b *smilesBuilder) parseAtom(s *scanner) (int, error) {
+	if s.peek() == '[' {
+		atom, err := parseBracketAtom(s)
+		if err != nil {
+			return 0, err
+		}
+		return b.mol.addAtom(atom), nil
+	}
+
+	for _, symbol := range organicSubset {
+		if strings.HasPrefix(s.text[s.pos:], symbol) {
+			s.pos += len(symbol)
+			atom := Atom{}
+			if symbol != "*" {
+				atom.Aromatic = symbol[0] >= 'a' && symbol[0] <= 'z'
+				atom.Element, _ = AtomicNumber(strings.ToUpper(symbol[:1]) + symbol[1:])
+			}
+			return b.mol.addAtom(atom), nil
+		}
+	}
+	return 0, s.errorf("unexpected character %q", s.peek())
+}
+
+func (b *smilesBuilder) parseBond(s *scanner) (interface{}, bool, error) {
+	var order BondOrder
+	switch s.peek() {
+	case '-', '/', '\\':
+		order = Single
+	case '=':
+		order = Double
+	case '#':
+		order = Triple
+	case '$':
+		order = Quadruple
+	case ':':
+		order = Aromatic
+	default:
+		return nil, false, nil
+	}
+	s.pos++
+	return order, true, nil
+}
+
+func (b *smilesBuilder) connect(a, c int, bond interface{}) error {
+	if b.mol.bondBetween(a, c) >= 0 {
+		return fmt.Errorf("duplicate bond")
+	}
+	order, ok := bond.(BondOrder)
+	if !ok {
+		order = Single
+		if b.mol.Atoms[a].Aromatic && b.mol.Atoms[c].Aromatic {
+			order = Aromatic
+		}
+	}
+	b.mol.addBond(a, c, order)
+	return nil
+}
+
+// parseBracketAtom reads a SMILES bracket atom such as [13CH3+] or [nH]
+func parseBracketAtom(s *scanner) (Atom, error) {
+	s.pos++ // '['
+	atom := Atom{Bracket: true}
+
+	if isotope := s.readNumber(); isotope > 0 {
+		atom.Isotope = isotope
+	}
+
+	symbol, aromatic, ok := readElement(s)
+	if !ok {
+		return atom, s.errorf("invalid element in bracket atom")
+	}
+	atom.Aromatic = aromatic
+	atom.Element, _ = AtomicNumber(symbol)
+
+	// Chirality is accepted but not stored
+	for s.peek() == '@' {
+		s.pos++
+	}
+	for _, class := range []string{"TH", "AL", "SP", "TB", "OH"} {
+		if strings.HasPrefix(s.text[s.pos:], class) {
+			s.pos += len(class)
+			s.readNumber()
+			break
+		}
+	}
+
+	if s.peek() == 'H' {
+		s.pos++
+		atom.HCount = 1
+		if n := s.readNumber(); n >= 0 {
+			atom.HCount = n
+		}
+	}
+
+	atom.Charge = readCharge(s)
+
+	// Atom classes are accepted but not stored
+	if s.peek() == ':' {
+		s.pos++
+		s.readNumber()
+	}
+
+	if s.peek() != ']' {
+		return atom, s.errorf("unterminated bracket atom")
+	}
+	s.pos++
+	return atom, nil
+}
+
+// readElement reads an element symbol, returning the capitalised symbol and
+// whether it was written as aromatic
+func readElement(s *scanner) (string, bool, bool) {
+	rest := s.text[s.pos:]
+	if strings.HasPrefix(rest, "*") {
+		s.pos++
+		return "*", false, true
+	}
+	for _, symbol := range aromaticSymbols {
+		if strings.HasPrefix(rest, symbol) {
+			s.pos += len(symbol)
+			return strings.ToUpper(symbol[:1]) + symbol[1:], true, true
+		}
+	}
+	if len(rest) >= 2 {
+		if _, ok := AtomicNumber(rest[:2]); ok && rest[1] >= 'a' && rest[1] <= 'z' {
+			s.pos += 2
+			return rest[:2], false, true
+		}
+	}
+	if len(rest) >= 1 {
+		if _, ok := AtomicNumber(rest[:1]); ok && rest[0] >= 'A' && rest[0] <= 'Z' {
+			s.pos++
+			return rest[:1], false, true
+		}
+	}
+	return "", false, false
+}
+
+// readCharge reads a charge written as +, ++, +2, -, --, -3
+func readCharge(s *scanner) int {
+	sign := 0
+	switch s.peek() {
+	case '+':
+		sign = 1
+	case '-':
+		sign = -1
+	default:
+		return 0
+	}
+	s.pos++
+	if n := s.readNumber(); n >= 0 {
+		return sign * n
+	}
+	charge := sign
+	for (sign > 0 && s.peek() == '+') || (sign < 0 && s.peek() == '-') {
+		charge += sign
+		s.pos++
+	}
+	return charge
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
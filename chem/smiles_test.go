@@ -0,0 +1,75 @@
+package chem
+
+import "testing"
+
+func TestParseSMILES(t *testing.T) {
+	tests := []struct {
+		smiles  string
+		formula string
+		atoms   int
+		bonds   int
+	}{
+		{"C", "CH4", 1, 0},
+		{"CCO", "C2H6O", 3, 2},
+		{"CC(=O)O acetic acid", "C2H4O2", 4, 3},
+		{"c1ccccc1", "C6H6", 6, 6},
+		{"C1=CC=CC=C1", "C6H6", 6, 6},
+		{"c1cc[nH]c1", "C4H5N", 5, 5},
+		{"C1=CNC=C1", "C4H5N", 5, 5},
+		{"O=C1C=CC=CN1", "C5H5NO", 7, 7},
+		{"[NH4+]", "H4N", 1, 0},
+		{"ClCBr", "CH2BrCl", 3, 2},
+		{"C%10CC%10", "C3H6", 3, 3},
+		{"[13CH4]", "CH4", 1, 0},
+	}
+	for _, tt := range tests {
+		m, err := ParseSMILES(tt.smiles)
+		if err != nil {
+			t.Errorf("ParseSMILES(%q): %v", tt.smiles, err)
+			continue
+		}
+		if len(m.Atoms) != tt.atoms || len(m.Bonds) != tt.bonds {
+			t.Errorf("ParseSMILES(%q): %d atoms and %d bonds, want %d and %d", tt.smiles, len(m.Atoms), len(m.Bonds), tt.atoms, tt.bonds)
+		}
+		if got := m.Formula().String(); got != tt.formula {
+			t.Errorf("ParseSMILES(%q).Formula() = %s, want %s", tt.smiles, got, tt.formula)
+		}
+	}
+}
+
+func TestParseSMILESErrors(t *testing.T) {
+	for _, smiles := range []string{"", "   ", "C1CC", "C(C", "CC)", "C=", "[Xx]", "C[", "Q"} {
+		if _, err := ParseSMILES(smiles); err == nil {
+			t.Errorf("ParseSMILES(%q): expected an error", smiles)
+		}
+	}
+}
+
+func TestAromaticity(t *testing.T) {
+	tests := []struct {
+		smiles   string
+		aromatic []bool
+		hcount   []int
+	}{
+		{"C1=CC=CC=C1", []bool{true, true, true, true, true, true}, []int{1, 1, 1, 1, 1, 1}},
+		{"C1=CNC=C1", []bool{true, true, true, true, true}, []int{1, 1, 1, 1, 1}},
+		{"c1cc[nH]c1", []bool{true, true, true, true, true}, []int{1, 1, 1, 1, 1}},
+		{"O=C1C=CC=CN1", []bool{false, true, true, true, true, true, true}, []int{0, 0, 1, 1, 1, 1, 1}},
+		{"C1=COC=C1", []bool{true, true, true, true, true}, []int{1, 1, 0, 1, 1}},
+		{"C1=CC=CC1", []bool{false, false, false, false, false}, []int{1, 1, 1, 1, 2}},
+		{"C1CCCCC1", []bool{false, false, false, false, false, false}, []int{2, 2, 2, 2, 2, 2}},
+	}
+	for _, tt := range tests {
+		m, err := ParseSMILES(tt.smiles)
+		if err != nil {
+			t.Errorf("ParseSMILES(%q): %v", tt.smiles, err)
+			continue
+		}
+		for i, a := range m.Atoms {
+			if a.Aromatic != tt.aromatic[i] || a.HCount != tt.hcount[i] {
+				t.Errorf("ParseSMILES(%q): atom %d %s aromatic %v with %d H, want aromatic %v with %d H",
+					tt.smiles, i, a.Symbol(), a.Aromatic, a.HCount, tt.aromatic[i], tt.hcount[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,396 @@
+/*
+ * MarineNP Columnar Files
+ * Purpose: Write database rows as Parquet or Arrow IPC files
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file writes rows in the columnar formats loaded by pandas, polars and
+ * the arrow R package, keeping the column types of the database: integers,
+ * floats, booleans and timestamps rather than their text. Rows are written in
+ * batches so that memory use does not grow with the number of rows.
+ */
+
+package columnar
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v14/arrow"
+	"github.com/apache/arrow/go/v14/arrow/array"
+	"github.com/apache/arrow/go/v14/arrow/ipc"
+	"github.com/apache/arrow/go/v14/arrow/memory"
+	"github.com/apache/arrow/go/v14/parquet"
+	"github.com/apache/arrow/go/v14/parquet/compress"
+	"github.com/apache/arrow/go/v14/parquet/pqarrow"
+	"gorm.io/gorm"
+)
+
+// File formats
+const (
+	Parquet = "parquet"
+	Arrow   = "arrow" // Arrow IPC file, also known as Feather v2
+)
+
+// BatchRows is the number of rows buffered before they are written as one
+// Parquet row group or Arrow record batch
+const BatchRows = 16384
+
+// Type is the type of a column
+type Type int
+
+// Column types
+const (
+	String Type = iota
+	Int
+	Float
+	Bool
+	Timestamp
+	Binary
+)
+
+// Column is a named and typed column
+type Column struct {
+	Name string
+	Type Type
+}
+
+// ColumnsOf returns the columns of a table with the types of their declared
+// SQLite types. Integer columns named *_at or *_date hold Unix timestamps,
+// as written by models.SQLiteTime.
+func ColumnsOf(db *gorm.DB, table string) ([]Column, error) {
+	var info []struct {
+		Name string
+		Type string
+	}
+	if err := db.Raw(fmt.Sprintf("SELECT name, type FROM pragma_table_info('%s')", table)).Scan(&info).Error; err != nil {
+		return nil, err
+	}
+	if len(info) == 0 {
+		return nil, fmt.Errorf("table %s not found", table)
+	}
+
+	columns := make([]Column, len(info))
+	for i, c := range info {
+		columns[i] = Column{Name: c.Name, Type: declaredType(c.Name, c.Type)}
+	}
+	return columns, nil
+}
+
+// declaredType maps a declared SQLite column type to a column type, following
+// the SQLite type affinity rules
+func declaredType(name, declared string) Type {
+	declared = strings.ToUpper(declared)
+	switch {
+	case strings.Contains(declared, "BOOL"):
+		return Bool
+	case strings.Contains(declared, "DATE"), strings.Contains(declared, "TIME"):
+		return Timestamp
+	case strings.Contains(declared, "INT"):
+		if strings.HasSuffix(name, "_at") || strings.HasSuffix(name, "_date") {
+			return Timestamp
+		}
+		return Int
+	case strings.Contains(declared, "CHAR"), strings.Contains(declared, "CLOB"), strings.Contains(declared, "TEXT"):
+		return String
+	case strings.Contains(declared, "BLOB"):
+		return Binary
+	case strings.Contains(declared, "REAL"), strings.Contains(declared, "FLOA"), strings.Contains(declared, "DOUB"),
+		strings.Contains(declared, "NUM"), strings.Contains(declared, "DEC"):
+		return Float
+	}
+	return String
+}
+
+// arrowType returns the Arrow type of a column type
+func (t Type) arrowType() arrow.DataType {
+	switch t {
+	case Int:
+		return arrow.PrimitiveTypes.Int64
+	case Float:
+		return arrow.PrimitiveTypes.Float64
+	case Bool:
+		return arrow.FixedWidthTypes.Boolean
+	case Timestamp:
+		return arrow.FixedWidthTypes.Timestamp_ms
+	case Binary:
+		return arrow.BinaryTypes.Binary
+	}
+	return arrow.BinaryTypes.String
+}
+
+// Writer writes rows of values, one per column, as a Parquet or Arrow file
+type Writer struct {
+	columns []Column
+	builder *array.RecordBuilder
+	rows    int
+	parquet *pqarrow.FileWriter
+	ipc     *ipc.FileWriter
+}
+
+// NewWriter starts a file of the columns in format on w. Close must be called
+// to complete the file; w itself is not closed.
+func NewWriter(w io.Writer, format string, columns []Column) (*Writer, error) {
+	fields := make([]arrow.Field, len(columns))
+	for i, c := range columns {
+		fields[i] = arrow.Field{Name: c.Name, Type: c.Type.arrowType(), Nullable: true}
+	}
+	schema := arrow.NewSchema(fields, nil)
+
+	mem := memory.NewGoAllocator()
+	cw := &Writer{columns: columns, builder: array.NewRecordBuilder(mem, schema)}
+	var err error
+	switch format {
+	case Parquet:
+		props := parquet.NewWriterProperties(
+			parquet.WithCompression(compress.Codecs.Snappy),
+			parquet.WithMaxRowGroupLength(BatchRows),
+			parquet.WithAllocator(mem),
+		)
+		// The Parquet writer closes writers it is given, so w is hidden
+		// behind a writer without Close
+		cw.parquet, err = pqarrow.NewFileWriter(schema, struct{ io.Writer }{w}, props,
+			pqarrow.NewArrowWriterProperties(pqarrow.WithStoreSchema()))
+	case Arrow:
+		cw.ipc, err = ipc.NewFileWriter(&positionWriter{w: w}, ipc.WithSchema(schema), ipc.WithAllocator(mem))
+	default:
+		err = fmt.Errorf("unknown columnar format %s", format)
+	}
+	if err != nil {
+		cw.builder.Release()
+		return nil, err
+	}
+	return cw, nil
+}
+
+// Append adds a row, writing a batch once BatchRows rows are buffered. Values
+// that do not convert to the type of their column are written as nulls.
+func (w *Writer) Append(values []interface{}) error {
+	for i, c := range w.columns {
+		appendValue(w.builder.Field(i), c.Type, values[i])
+	}
+	w.rows++
+	if w.rows >= BatchRows {
+		return w.flush()
+	}
+	return nil
+}
+
+// flush writes the buffered rows as one batch
+func (w *Writer) flush() error {
+	if w.rows == 0 {
+		return nil
+	}
+	record := w.builder.NewRecord()
+	defer record.Release()
+	w.rows = 0
+	if w.parquet != nil {
+		return w.parquet.Write(record)
+	}
+	return w.ipc.Write(record)
+}
+
+// Close writes the remaining rows and the file footer
+func (w *Writer) Close() error {
+	defer w.builder.Release()
+	if err := w.flush(); err != nil {
+		return err
+	}
+	if w.parquet != nil {
+		return w.parquet.Close()
+	}
+	return w.ipc.Close()
+}
+
+// appendValue appends a scanned database value to a column builder
+func appendValue(b array.Builder, t Type, value interface{}) {
+	switch v := Value(t, value).(type) {
+	case int64:
+		b.(*array.Int64Builder).Append(v)
+	case float64:
+		b.(*array.Float64Builder).Append(v)
+	case bool:
+		b.(*array.BooleanBuilder).Append(v)
+	case time.Time:
+		b.(*array.TimestampBuilder).Append(arrow.Timestamp(v.UnixMilli()))
+	case []byte:
+		b.(*array.BinaryBuilder).Append(v)
+	case string:
+		b.(*array.StringBuilder).Append(v)
+	default:
+		b.AppendNull()
+	}
+}
+
+// Value converts a scanned database value to the Go type of a column type:
+// int64, float64, bool, time.Time, []byte or string. Values that do not
+// convert, like NULL, are nil.
+func Value(t Type, value interface{}) interface{} {
+	value = widen(value)
+	var ok bool
+	var v interface{}
+	switch t {
+	case Int:
+		v, ok = toInt(value)
+	case Float:
+		v, ok = toFloat(value)
+	case Bool:
+		v, ok = toBool(value)
+	case Timestamp:
+		v, ok = toTime(value)
+	case Binary:
+		switch b := value.(type) {
+		case []byte:
+			v, ok = b, true
+		case string:
+			v, ok = []byte(b), true
+		}
+	default:
+		switch s := value.(type) {
+		case nil:
+		case string:
+			v, ok = s, true
+		case []byte:
+			v, ok = string(s), true
+		default:
+			v, ok = fmt.Sprintf("%v", s), true
+		}
+	}
+	if !ok {
+		return nil
+	}
+	return v
+}
+
+// widen converts values of the integer, float, bool and string kinds, such as
+// the int fields of scanned models, to int64, float64, bool and string
+func widen(value interface{}) interface{} {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.String:
+		return v.String()
+	}
+	return value
+}
+
+func toInt(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<63 {
+			return int64(v), true
+		}
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		return n, err == nil
+	case []byte:
+		return toInt(string(v))
+	}
+	return 0, false
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	case []byte:
+		return toFloat(string(v))
+	}
+	return 0, false
+}
+
+func toBool(value interface{}) (bool, bool) {
+	switch v := value.(type) {
+	case bool:
+		return v, true
+	case int64:
+		return v != 0, true
+	case float64:
+		return v != 0, true
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "1", "t", "true", "y", "yes":
+			return true, true
+		case "0", "f", "false", "n", "no":
+			return false, true
+		}
+	case []byte:
+		return toBool(string(v))
+	}
+	return false, false
+}
+
+// timeLayouts are the text timestamp layouts read from the database
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05", "2006-01-02"}
+
+// toTime reads a timestamp stored as Unix seconds, as written by
+// models.SQLiteTime, or as text. Out of range values, which SQLiteTime reads
+// as the zero time, are nulls.
+func toTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, !v.IsZero()
+	case int64:
+		if v >= 0 && v <= 253402300799 {
+			return time.Unix(v, 0), true
+		}
+	case float64:
+		return toTime(int64(v))
+	case string:
+		if n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+			return toTime(n)
+		}
+		for _, layout := range timeLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, true
+			}
+		}
+	case []byte:
+		return toTime(string(v))
+	}
+	return time.Time{}, false
+}
+
+// positionWriter counts the bytes written for the Arrow file writer, which
+// asks for its position but does not seek
+type positionWriter struct {
+	w   io.Writer
+	pos int64
+}
+
+func (p *positionWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.pos += int64(n)
+	return n, err
+}
+
+func (p *positionWriter) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekCurrent {
+		return p.pos, fmt.Errorf("columnar: cannot seek in a stream")
+	}
+	return p.pos, nil
+}
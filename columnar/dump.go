@@ -0,0 +1,122 @@
+/*
+ * MarineNP Columnar Release Dump
+ * Purpose: Dump the database tables as Parquet or Arrow IPC files
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file writes every table of a release into a zip with one columnar
+ * file per table, the counterpart of the CSV tables archive.
+ */
+
+package columnar
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"strings"
+
+	"marinenp/fulltext"
+
+	"gorm.io/gorm"
+)
+
+// skippedTables are not dumped: fingerprints are derived from the structures
+// and rebuilt with "marinenp fingerprints"
+var skippedTables = map[string]bool{
+	"fingerprints": true,
+}
+
+// skipped reports whether a table is left out of the dump. Besides
+// skippedTables, these are the tables of the full-text index, which is
+// rebuilt with "marinenp index": its state table, a half-built index left
+// by an interrupted rebuild, and the shadow tables of any virtual table.
+func skipped(table string, virtual []string) bool {
+	if skippedTables[table] || strings.HasPrefix(table, fulltext.Table+"_") {
+		return true
+	}
+	for _, name := range virtual {
+		if strings.HasPrefix(table, name+"_") {
+			return true
+		}
+	}
+	return false
+}
+
+// TableRows is the number of rows dumped from a table
+type TableRows struct {
+	Table string
+	Rows  int64
+}
+
+// Dump writes every table of the database to w as a zip of files in format,
+// named after the tables. Virtual tables are not dumped.
+func Dump(db *gorm.DB, format string, w io.Writer) ([]TableRows, error) {
+	var tables, virtual []string
+	err := db.Raw("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND sql NOT LIKE 'CREATE VIRTUAL%' ORDER BY name").
+		Scan(&tables).Error
+	if err != nil {
+		return nil, err
+	}
+	err = db.Raw("SELECT name FROM sqlite_master WHERE type = 'table' AND sql LIKE 'CREATE VIRTUAL%'").
+		Scan(&virtual).Error
+	if err != nil {
+		return nil, err
+	}
+
+	archive := zip.NewWriter(w)
+	var counts []TableRows
+	for _, table := range tables {
+		if skipped(table, virtual) {
+			continue
+		}
+		file, err := archive.Create(table + "." + format)
+		if err != nil {
+			return counts, err
+		}
+		rows, err := dumpTable(db, table, format, file)
+		if err != nil {
+			return counts, fmt.Errorf("table %s: %w", table, err)
+		}
+		counts = append(counts, TableRows{Table: table, Rows: rows})
+	}
+	return counts, archive.Close()
+}
+
+// dumpTable writes all rows of a table as one file
+func dumpTable(db *gorm.DB, table, format string, w io.Writer) (int64, error) {
+	columns, err := ColumnsOf(db, table)
+	if err != nil {
+		return 0, err
+	}
+	writer, err := NewWriter(w, format, columns)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := db.Raw(fmt.Sprintf(`SELECT * FROM "%s"`, table)).Rows()
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	var count int64
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return count, err
+		}
+		if err := writer.Append(values); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+	return count, writer.Close()
+}
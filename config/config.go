@@ -16,6 +16,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -25,6 +26,11 @@ type Config struct {
 	Server   ServerConfig
 	Database DatabaseConfig
 	API      APIConfig
+	Export   ExportConfig
+	DarwinCore DarwinCoreConfig
+	SPARQL   SPARQLConfig
+	Crossref CrossrefConfig
+	Worms    WormsConfig
 	Version  string
 	LastUpdate string
 }
@@ -51,6 +57,28 @@ type DatabaseConfig struct {
 type APIConfig struct {
 	Prefix        string
 	CorsAllowOrigin string
+	PublicURL     string // Public URL of the server, the base of RDF resource IRIs
+}
+
+// ExportConfig contains settings of asynchronous export jobs
+type ExportConfig struct {
+	Dir       string        // Directory holding finished export files
+	Workers   int           // Exports that may run at the same time
+	QueueSize int           // Jobs that may wait for a worker
+	Retention time.Duration // How long finished exports can be downloaded
+}
+
+// DarwinCoreConfig contains the dataset metadata of Darwin Core Archives
+type DarwinCoreConfig struct {
+	Publisher string // Organization publishing the dataset
+	Contact   string // Contact email address
+	License   string // License URL
+}
+
+// SPARQLConfig contains the limits of graph queries
+type SPARQLConfig struct {
+	Timeout    time.Duration // How long a query may run
+	MaxResults int64         // Solutions returned at most per query
 }
 
 // GetDSN returns the appropriate database connection string based on the database type
@@ -62,6 +90,17 @@ func (c *DatabaseConfig) GetDSN() string {
 		c.Host, c.Port, c.User, c.Password, c.Name, c.SSLMode)
 }
 
+// CrossrefConfig contains the source of citation metadata
+type CrossrefConfig struct {
+	Source string // Crossref REST API URL, a server like it, or a dump file
+	Mailto string // Contact email sent to the API to identify the requests
+}
+
+// WormsConfig contains the source of organism classifications
+type WormsConfig struct {
+	Source string // WoRMS REST API URL, a server like it, or a dump file
+}
+
 // LoadConfig initializes and returns the application configuration
 // It loads settings from environment variables with sensible defaults
 func LoadConfig() *Config {
@@ -74,6 +113,30 @@ func LoadConfig() *Config {
 	port, _ := strconv.Atoi(getEnv("PORT", "8080"))
 	dbPort, _ := strconv.Atoi(getEnv("DB_PORT", "5432"))
 
+	// Export job limits, falling back to the defaults when invalid
+	exportWorkers, err := strconv.Atoi(getEnv("EXPORT_WORKERS", "2"))
+	if err != nil || exportWorkers < 1 {
+		exportWorkers = 2
+	}
+	exportQueue, err := strconv.Atoi(getEnv("EXPORT_QUEUE_SIZE", "100"))
+	if err != nil || exportQueue < 1 {
+		exportQueue = 100
+	}
+	exportRetention, err := time.ParseDuration(getEnv("EXPORT_RETENTION", "24h"))
+	if err != nil || exportRetention <= 0 {
+		exportRetention = 24 * time.Hour
+	}
+
+	// Graph query limits
+	sparqlTimeout, err := time.ParseDuration(getEnv("SPARQL_TIMEOUT", "30s"))
+	if err != nil || sparqlTimeout <= 0 {
+		sparqlTimeout = 30 * time.Second
+	}
+	sparqlMaxResults, err := strconv.ParseInt(getEnv("SPARQL_MAX_RESULTS", "10000"), 10, 64)
+	if err != nil || sparqlMaxResults < 1 {
+		sparqlMaxResults = 10000
+	}
+
 	return &Config{
 		Server: ServerConfig{
 			Port: port,
@@ -92,6 +155,29 @@ func LoadConfig() *Config {
 		API: APIConfig{
 			Prefix:          getEnv("API_PREFIX", "/api/v1"),
 			CorsAllowOrigin: getEnv("CORS_ALLOW_ORIGIN", "*"),
+			PublicURL:       getEnv("PUBLIC_URL", "https://marinenp.scicloud.eu"),
+		},
+		Export: ExportConfig{
+			Dir:       getEnv("EXPORT_DIR", "exports"),
+			Workers:   exportWorkers,
+			QueueSize: exportQueue,
+			Retention: exportRetention,
+		},
+		DarwinCore: DarwinCoreConfig{
+			Publisher: getEnv("DWCA_PUBLISHER", "MarineNP Team"),
+			Contact:   getEnv("DWCA_CONTACT_EMAIL", ""),
+			License:   getEnv("DWCA_LICENSE", ""),
+		},
+		SPARQL: SPARQLConfig{
+			Timeout:    sparqlTimeout,
+			MaxResults: sparqlMaxResults,
+		},
+		Crossref: CrossrefConfig{
+			Source: getEnv("CROSSREF_SOURCE", "https://api.crossref.org"),
+			Mailto: getEnv("CROSSREF_MAILTO", ""),
+		},
+		Worms: WormsConfig{
+			Source: getEnv("WORMS_SOURCE", "https://www.marinespecies.org/rest"),
 		},
 		Version:    getEnv("APP_VERSION", "1.0.0"),
 		LastUpdate: getEnv("LAST_UPDATE", "2025-06-08"),
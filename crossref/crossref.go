@@ -0,0 +1,312 @@
+/*
+ * MarineNP Crossref
+ * Purpose: Read citation metadata from Crossref-format sources
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file looks up the metadata of DOIs in a source of Crossref works: the
+ * Crossref REST API or a server answering /works/{doi} like it, or a local
+ * JSON dump of works as written by the API, by the Crossref public data file
+ * or as JSON Lines, optionally gzipped.
+ */
+
+package crossref
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by a source that has no work for a DOI
+var ErrNotFound = errors.New("DOI not found")
+
+// Source looks up works by DOI
+type Source interface {
+	// Work returns the work of a DOI, or ErrNotFound
+	Work(doi string) (*Work, error)
+	// Name identifies the source in the provenance of the metadata
+	Name() string
+}
+
+// Work is a Crossref work with the fields used for citations. Record holds
+// the work as read from the source.
+type Work struct {
+	DOI                 string   `json:"DOI"`
+	Title               []string `json:"title"`
+	Subtitle            []string `json:"subtitle"`
+	ContainerTitle      []string `json:"container-title"`
+	ShortContainerTitle []string `json:"short-container-title"`
+	Author              []struct {
+		Given  string `json:"given"`
+		Family string `json:"family"`
+		Name   string `json:"name"`
+	} `json:"author"`
+	Volume          string  `json:"volume"`
+	Issue           string  `json:"issue"`
+	Page            string  `json:"page"`
+	Issued          dateRef `json:"issued"`
+	PublishedPrint  dateRef `json:"published-print"`
+	PublishedOnline dateRef `json:"published-online"`
+
+	Record json.RawMessage `json:"-"`
+}
+
+// dateRef is a Crossref partial date
+type dateRef struct {
+	DateParts [][]*int `json:"date-parts"`
+}
+
+// year returns the year of a date, or 0 when it has none
+func (d dateRef) year() int {
+	if len(d.DateParts) == 0 || len(d.DateParts[0]) == 0 || d.DateParts[0][0] == nil {
+		return 0
+	}
+	return *d.DateParts[0][0]
+}
+
+// markupPattern matches the JATS and HTML tags of Crossref titles
+var markupPattern = regexp.MustCompile(`<[^>]+>`)
+
+// text removes the markup and extra whitespace of a Crossref string
+func text(s string) string {
+	s = html.UnescapeString(markupPattern.ReplaceAllString(s, ""))
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// first returns the first non-empty string of a Crossref list
+func first(values []string) string {
+	for _, v := range values {
+		if v = text(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// TitleText returns the title of the work with its subtitle
+func (w *Work) TitleText() string {
+	title := first(w.Title)
+	if subtitle := first(w.Subtitle); subtitle != "" && title != "" {
+		title += ": " + subtitle
+	}
+	return title
+}
+
+// AuthorList returns the authors of the work as "Family, Given" names, or
+// whole names for organizations, separated by semicolons
+func (w *Work) AuthorList() string {
+	names := make([]string, 0, len(w.Author))
+	for _, a := range w.Author {
+		family, given := text(a.Family), text(a.Given)
+		switch {
+		case family != "" && given != "":
+			names = append(names, family+", "+given)
+		case family != "":
+			names = append(names, family)
+		case text(a.Name) != "":
+			names = append(names, text(a.Name))
+		}
+	}
+	return strings.Join(names, "; ")
+}
+
+// Journal returns the journal or book the work appeared in
+func (w *Work) Journal() string {
+	if journal := first(w.ContainerTitle); journal != "" {
+		return journal
+	}
+	return first(w.ShortContainerTitle)
+}
+
+// Year returns the publication year of the work, or 0 when it is unknown
+func (w *Work) Year() int {
+	for _, d := range []dateRef{w.Issued, w.PublishedPrint, w.PublishedOnline} {
+		if year := d.year(); year > 0 {
+			return year
+		}
+	}
+	return 0
+}
+
+// doiPrefixes are the resolver and scheme prefixes a DOI may be written with
+var doiPrefixes = []string{"https://doi.org/", "http://doi.org/", "https://dx.doi.org/", "http://dx.doi.org/", "doi:"}
+
+// NormalizeDOI returns a DOI without its resolver or doi: prefix
+func NormalizeDOI(doi string) string {
+	doi = strings.TrimSpace(doi)
+	for _, prefix := range doiPrefixes {
+		if len(doi) >= len(prefix) && strings.EqualFold(doi[:len(prefix)], prefix) {
+			doi = doi[len(prefix):]
+		}
+	}
+	return doi
+}
+
+// doiKey returns the lookup key of a DOI, as DOIs are case-insensitive
+func doiKey(doi string) string {
+	return strings.ToLower(NormalizeDOI(doi))
+}
+
+// NewSource returns the source at a location: an http(s) URL of a server
+// answering /works/{doi} like the Crossref REST API, or the path of a dump
+func NewSource(location, userAgent string) (Source, error) {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		return &apiSource{
+			base:      strings.TrimSuffix(location, "/"),
+			userAgent: userAgent,
+			client:    &http.Client{Timeout: 30 * time.Second},
+		}, nil
+	}
+	return loadDump(location)
+}
+
+// apiSource reads works from the Crossref REST API or a server like it
+type apiSource struct {
+	base      string
+	userAgent string
+	client    *http.Client
+}
+
+func (s *apiSource) Name() string { return s.base }
+
+func (s *apiSource) Work(doi string) (*Work, error) {
+	req, err := http.NewRequest(http.MethodGet, s.base+"/works/"+url.PathEscape(NormalizeDOI(doi)), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if s.userAgent != "" {
+		req.Header.Set("User-Agent", s.userAgent)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%s answered %s", s.base, resp.Status)
+	}
+
+	var envelope struct {
+		Message json.RawMessage `json:"message"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("invalid response for %s: %w", doi, err)
+	}
+	return parseWork(envelope.Message)
+}
+
+// parseWork decodes a work, keeping its record
+func parseWork(data json.RawMessage) (*Work, error) {
+	var w Work
+	if err := json.Unmarshal(data, &w); err != nil {
+		return nil, err
+	}
+	if w.DOI == "" {
+		return nil, errors.New("work without a DOI")
+	}
+	w.Record = data
+	return &w, nil
+}
+
+// dumpSource holds the works of a dump by DOI
+type dumpSource struct {
+	path  string
+	works map[string]json.RawMessage
+}
+
+func (s *dumpSource) Name() string { return s.path }
+
+func (s *dumpSource) Work(doi string) (*Work, error) {
+	data, ok := s.works[doiKey(doi)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return parseWork(data)
+}
+
+// loadDump reads a dump of works: a JSON array of works, or a sequence of
+// JSON documents, such as JSON Lines, each a work, an API response holding a
+// work in "message" or a list of works in "items" or "message.items"
+func loadDump(path string) (*dumpSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var r io.Reader = bufio.NewReader(file)
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	s := &dumpSource{path: path, works: map[string]json.RawMessage{}}
+	dec := json.NewDecoder(r)
+	for {
+		var doc json.RawMessage
+		if err := dec.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid dump %s: %w", path, err)
+		}
+		if err := s.add(doc); err != nil {
+			return nil, fmt.Errorf("invalid dump %s: %w", path, err)
+		}
+	}
+	return s, nil
+}
+
+// add adds the works of a JSON document of a dump
+func (s *dumpSource) add(doc json.RawMessage) error {
+	doc = bytes.TrimSpace(doc)
+	if len(doc) > 0 && doc[0] == '[' {
+		var works []json.RawMessage
+		if err := json.Unmarshal(doc, &works); err != nil {
+			return err
+		}
+		for _, w := range works {
+			if err := s.add(w); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var fields struct {
+		DOI     string          `json:"DOI"`
+		Message json.RawMessage `json:"message"`
+		Items   json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal(doc, &fields); err != nil {
+		return err
+	}
+	switch {
+	case fields.DOI != "":
+		s.works[doiKey(fields.DOI)] = doc
+	case fields.Message != nil:
+		return s.add(fields.Message)
+	case fields.Items != nil:
+		return s.add(fields.Items)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+/*
+ * MarineNP Citation Enrichment
+ * Purpose: Store the Crossref metadata of the DOIs of citations
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file fills the citation_metadata table from a Crossref-format source.
+ * It is run with "marinenp enrich" after each data import. Citations whose
+ * DOI has been looked up are skipped unless a refresh is asked for, so that
+ * an interrupted run can be resumed.
+ */
+
+package crossref
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"marinenp/lookup"
+	"marinenp/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Enrich looks up the DOIs of the citations in a source and stores their
+// metadata. With refresh, citations looked up before are looked up again.
+func Enrich(db *gorm.DB, source Source, refresh bool) (lookup.Counts, error) {
+	if err := db.AutoMigrate(&models.CitationMetadata{}); err != nil {
+		return lookup.Counts{}, err
+	}
+
+	return lookup.Run(db, lookup.Task[models.Citation]{
+		Next: func(last *models.Citation) ([]models.Citation, error) {
+			var lastID int64
+			if last != nil {
+				lastID = last.ID
+			}
+			query := db.Model(&models.Citation{}).
+				Select("id, doi").
+				Where("id > ? AND TRIM(COALESCE(doi, '')) != ''", lastID)
+			if !refresh {
+				query = query.Where("NOT EXISTS (SELECT 1 FROM citation_metadata WHERE citation_metadata.citation_id = citations.id)")
+			}
+			var citations []models.Citation
+			err := query.Order("id").Limit(lookup.BatchSize).Find(&citations).Error
+			return citations, err
+		},
+		Lookup: func(citation models.Citation) (interface{}, bool, error) {
+			metadata := &models.CitationMetadata{
+				CitationID: citation.ID,
+				DOI:        NormalizeDOI(citation.DOI),
+				Source:     source.Name(),
+				Status:     models.MetadataNotFound,
+				UpdatedAt:  models.SQLiteTime(time.Now().UTC()),
+			}
+			work, err := source.Work(citation.DOI)
+			if errors.Is(err, ErrNotFound) {
+				return metadata, false, nil
+			}
+			if err != nil {
+				return nil, false, err
+			}
+			fill(metadata, work)
+			return metadata, true, nil
+		},
+		Describe: func(citation models.Citation) string {
+			return fmt.Sprintf("citation %d (%s)", citation.ID, citation.DOI)
+		},
+		Upsert: clause.OnConflict{
+			Columns: []clause.Column{{Name: "citation_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{
+				"doi", "status", "title", "authors", "journal", "year",
+				"volume", "issue", "pages", "source", "record", "updated_at",
+			}),
+		},
+	})
+}
+
+// fill sets the metadata of a work
+func fill(m *models.CitationMetadata, w *Work) {
+	m.Status = models.MetadataFound
+	m.Title = w.TitleText()
+	m.Authors = w.AuthorList()
+	m.Journal = w.Journal()
+	if year := w.Year(); year > 0 {
+		m.Year = &year
+	}
+	m.Volume = text(w.Volume)
+	m.Issue = text(w.Issue)
+	m.Pages = text(w.Page)
+	m.Record = string(w.Record)
+}
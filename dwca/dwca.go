@@ -0,0 +1,340 @@
+/*
+ * MarineNP Darwin Core Archive
+ * Purpose: Export the producing organisms of natural products for GBIF and OBIS
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file writes a Darwin Core Archive with a Taxon core, a checklist of the
+ * WoRMS taxa reported to produce marine natural products keyed by AphiaID,
+ * and an extended measurement or fact per natural product of the taxon.
+ * MarineNP records no date or place of the organisms, so the taxa are not
+ * occurrences, which OBIS requires to have an eventDate and coordinates. The
+ * archive describes itself with meta.xml and eml.xml, so that GBIF and OBIS
+ * tools can validate and ingest it.
+ */
+
+package dwca
+
+import (
+	"archive/zip"
+	"bufio"
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"marinenp/query"
+	"os"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Files of the archive
+const (
+	MetaFile        = "meta.xml"
+	MetadataFile    = "eml.xml"
+	TaxonFile       = "taxon.txt"
+	MeasurementFile = "extendedmeasurementorfact.txt"
+)
+
+// Row types and term namespaces
+const (
+	taxonRowType       = "http://rs.tdwg.org/dwc/terms/Taxon"
+	measurementRowType = "http://rs.iobis.org/obis/terms/ExtendedMeasurementOrFact"
+	dwcTerms           = "http://rs.tdwg.org/dwc/terms/"
+)
+
+// wormsLSID is the LSID of a WoRMS taxon, the scientificNameID OBIS expects
+const wormsLSID = "urn:lsid:marinespecies.org:taxname:%d"
+
+// measurementType is the measurementType of natural product facts
+const measurementType = "natural product"
+
+// taxonTerms are the Darwin Core terms of the taxon file columns. The first
+// column, taxonID, is also the id of the taxa. The classification is filled
+// by "marinenp taxonomy".
+var taxonTerms = []string{
+	"taxonID",
+	"scientificNameID",
+	"scientificName",
+	"taxonRank",
+	"kingdom",
+	"phylum",
+	"class",
+	"order",
+	"family",
+	"genus",
+	"taxonRemarks",
+}
+
+// measurementTerms are the Darwin Core terms of the measurement file columns
+// after the core id
+var measurementTerms = []string{
+	"measurementID",
+	"measurementType",
+	"measurementValue",
+	"measurementMethod",
+	"measurementRemarks",
+}
+
+// Title is the title of the dataset
+const Title = "Marine organisms producing natural products in MarineNP"
+
+// Metadata describes the dataset in eml.xml
+type Metadata struct {
+	Publisher string // Organization creating and publishing the dataset
+	Contact   string // Contact email address, optional
+	License   string // License URL, optional
+	Version   string // Database release
+	PubDate   string // Publication date as YYYY-MM-DD
+	Search    string // Search selecting the molecules, empty for all
+}
+
+// Counts are the numbers of records written
+type Counts struct {
+	Taxa         int64
+	Measurements int64
+}
+
+// taxon is the WoRMS taxon being written
+type taxon struct {
+	aphiaID        int64
+	name, rank     string
+	classification []string // Kingdom to genus, empty if not classified
+	names          []string // Names of the organisms with the AphiaID
+	molecules      int64
+	molecule       int64 // Id of the last molecule of the taxon
+}
+
+// Write writes the Darwin Core Archive of the marine organisms with a WoRMS
+// AphiaID that produce the molecules matching the filter to w. The facts are
+// buffered in a temporary file while the taxa are written.
+func Write(db *gorm.DB, filter *query.Filter, meta Metadata, w io.Writer) (Counts, error) {
+	var counts Counts
+	archive := zip.NewWriter(w)
+
+	for _, file := range []struct {
+		name string
+		doc  interface{}
+	}{{MetaFile, newArchiveDescriptor()}, {MetadataFile, newEML(meta)}} {
+		f, err := archive.Create(file.name)
+		if err != nil {
+			return counts, err
+		}
+		if err := writeXML(f, file.doc); err != nil {
+			return counts, fmt.Errorf("%s: %w", file.name, err)
+		}
+	}
+
+	buffer, err := os.CreateTemp("", "marinenp-dwca-*.txt")
+	if err != nil {
+		return counts, err
+	}
+	defer os.Remove(buffer.Name())
+	defer buffer.Close()
+
+	taxonFile, err := archive.Create(TaxonFile)
+	if err != nil {
+		return counts, err
+	}
+	taxa := bufio.NewWriter(taxonFile)
+	measurements := bufio.NewWriter(buffer)
+	writeRow(taxa, taxonTerms)
+	writeRow(measurements, append([]string{"id"}, measurementTerms...))
+
+	if err := writeRecords(db, filter, taxa, measurements, &counts); err != nil {
+		return counts, err
+	}
+	if err := taxa.Flush(); err != nil {
+		return counts, err
+	}
+	if err := measurements.Flush(); err != nil {
+		return counts, err
+	}
+
+	measurementFile, err := archive.Create(MeasurementFile)
+	if err != nil {
+		return counts, err
+	}
+	if _, err := buffer.Seek(0, io.SeekStart); err != nil {
+		return counts, err
+	}
+	if _, err := io.Copy(measurementFile, buffer); err != nil {
+		return counts, err
+	}
+	return counts, archive.Close()
+}
+
+// writeRecords streams the organism molecule links in AphiaID order, writing
+// a fact per molecule and a taxon once all molecules of the taxon are written
+func writeRecords(db *gorm.DB, filter *query.Filter, taxa, measurements *bufio.Writer, counts *Counts) error {
+	tx := db.Table("molecules").
+		Select(`organisms.aphiaid_worms, organisms.name, organisms.name_aphia_worms, organisms.rank,
+			organism_taxonomy.kingdom, organism_taxonomy.phylum, organism_taxonomy.class,
+			organism_taxonomy."order", organism_taxonomy.family, organism_taxonomy.genus,
+			molecules.id, molecules.identifier, molecules.name, molecules.standard_inchi_key,
+			(SELECT GROUP_CONCAT(citations.doi, ' ') FROM citables
+				JOIN citations ON citations.id = citables.citation_id
+				WHERE citables.citable_id = molecules.id AND citables.citable_type = 'App\Models\Molecule'
+				AND citations.doi IS NOT NULL AND citations.doi != '') AS dois`).
+		Joins("JOIN molecule_organism ON molecule_organism.molecule_id = molecules.id").
+		Joins("JOIN organisms ON organisms.id = molecule_organism.organism_id").
+		Joins("LEFT JOIN organism_taxonomy ON organism_taxonomy.aphiaid_worms = organisms.aphiaid_worms").
+		Where("organisms.is_marine = TRUE AND organisms.aphiaid_worms > 0")
+	rows, err := filter.Apply(tx).Order("organisms.aphiaid_worms, molecules.id, organisms.id").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var current *taxon
+	for rows.Next() {
+		var aphiaID, moleculeID int64
+		var name, aphiaName, rank, identifier, moleculeName, inchiKey, dois sql.NullString
+		classification := make([]sql.NullString, 6)
+		err := rows.Scan(&aphiaID, &name, &aphiaName, &rank,
+			&classification[0], &classification[1], &classification[2],
+			&classification[3], &classification[4], &classification[5],
+			&moleculeID, &identifier, &moleculeName, &inchiKey, &dois)
+		if err != nil {
+			return err
+		}
+
+		if current == nil || current.aphiaID != aphiaID {
+			if current != nil {
+				if err := writeTaxon(taxa, current); err != nil {
+					return err
+				}
+				counts.Taxa++
+			}
+			current = &taxon{aphiaID: aphiaID, name: aphiaName.String, rank: rank.String}
+			for _, rank := range classification {
+				current.classification = append(current.classification, rank.String)
+			}
+		}
+		if current.name == "" {
+			current.name = name.String
+		}
+		current.names = appendNew(current.names, name.String)
+
+		// A molecule is listed once per taxon, even when several of the
+		// organisms of the taxon produce it
+		if current.molecule == moleculeID {
+			continue
+		}
+		current.molecule = moleculeID
+		current.molecules++
+
+		// The fact is sourced from the publications reporting the molecule
+		var references []string
+		for _, doi := range strings.Fields(dois.String) {
+			references = appendNew(references, doiURL(doi))
+		}
+		method := ""
+		if len(references) > 0 {
+			method = "Reported in " + strings.Join(references, " | ")
+		}
+
+		remarks := []string{}
+		if moleculeName.String != "" {
+			remarks = append(remarks, moleculeName.String)
+		}
+		if inchiKey.String != "" {
+			remarks = append(remarks, "InChIKey "+inchiKey.String)
+		}
+		id := taxonID(aphiaID)
+		err = writeRow(measurements, []string{
+			id,
+			fmt.Sprintf("marinenp:aphia:%d:%s", aphiaID, identifier.String),
+			measurementType,
+			identifier.String,
+			method,
+			strings.Join(remarks, "; "),
+		})
+		if err != nil {
+			return err
+		}
+		counts.Measurements++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if current != nil {
+		if err := writeTaxon(taxa, current); err != nil {
+			return err
+		}
+		counts.Taxa++
+	}
+	return nil
+}
+
+// writeTaxon writes a taxon with the organism names it was reported under
+func writeTaxon(w *bufio.Writer, t *taxon) error {
+	products := "natural products"
+	if t.molecules == 1 {
+		products = "natural product"
+	}
+	remarks := fmt.Sprintf("Reported producer of %d marine %s in MarineNP", t.molecules, products)
+	if len(t.names) > 0 {
+		remarks += ", as " + strings.Join(t.names, " | ")
+	}
+	id := taxonID(t.aphiaID)
+	values := []string{id, id, t.name, strings.ToLower(t.rank)}
+	values = append(values, t.classification...)
+	return writeRow(w, append(values, remarks))
+}
+
+// taxonID returns the id of a WoRMS taxon, its LSID
+func taxonID(aphiaID int64) string {
+	return fmt.Sprintf(wormsLSID, aphiaID)
+}
+
+// doiURL returns the resolver URL of a DOI
+func doiURL(doi string) string {
+	if strings.HasPrefix(doi, "http://") || strings.HasPrefix(doi, "https://") {
+		return doi
+	}
+	return "https://doi.org/" + strings.TrimPrefix(strings.ToLower(doi), "doi:")
+}
+
+// appendNew appends a non-empty value to a list unless it is already in it
+func appendNew(list []string, value string) []string {
+	if value == "" {
+		return list
+	}
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+// rowCleaner removes the separators of the text files from values, as the
+// files do not quote fields
+var rowCleaner = strings.NewReplacer("\t", " ", "\r\n", " ", "\n", " ", "\r", " ")
+
+// writeRow writes a tab separated row
+func writeRow(w *bufio.Writer, values []string) error {
+	for i, value := range values {
+		if i > 0 {
+			w.WriteByte('\t')
+		}
+		w.WriteString(rowCleaner.Replace(value))
+	}
+	return w.WriteByte('\n')
+}
+
+// writeXML writes an XML document with its declaration
+func writeXML(w io.Writer, doc interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,159 @@
+/*
+ * MarineNP Darwin Core Archive Metadata
+ * Purpose: Describe the files and the dataset of Darwin Core Archives
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file builds the archive descriptor, meta.xml, mapping the columns of
+ * the text files to Darwin Core terms, and the dataset metadata, eml.xml, in
+ * the GBIF profile of the Ecological Metadata Language.
+ */
+
+package dwca
+
+import (
+	"encoding/xml"
+	"fmt"
+	"time"
+)
+
+// archiveDescriptor is meta.xml
+type archiveDescriptor struct {
+	XMLName   xml.Name      `xml:"http://rs.tdwg.org/dwc/text/ archive"`
+	Metadata  string        `xml:"metadata,attr"`
+	Core      archiveFile   `xml:"core"`
+	Extension []archiveFile `xml:"extension"`
+}
+
+// archiveFile describes a text file of the archive
+type archiveFile struct {
+	Encoding          string         `xml:"encoding,attr"`
+	FieldsTerminated  string         `xml:"fieldsTerminatedBy,attr"`
+	LinesTerminated   string         `xml:"linesTerminatedBy,attr"`
+	FieldsEnclosed    string         `xml:"fieldsEnclosedBy,attr"`
+	IgnoreHeaderLines int            `xml:"ignoreHeaderLines,attr"`
+	RowType           string         `xml:"rowType,attr"`
+	Location          string         `xml:"files>location"`
+	ID                *archiveIndex  `xml:"id,omitempty"`
+	CoreID            *archiveIndex  `xml:"coreid,omitempty"`
+	Fields            []archiveField `xml:"field"`
+}
+
+type archiveIndex struct {
+	Index int `xml:"index,attr"`
+}
+
+type archiveField struct {
+	Index int    `xml:"index,attr"`
+	Term  string `xml:"term,attr"`
+}
+
+// newArchiveFile describes a tab separated file with a header line whose
+// columns are the terms, following an id column if offset is 1
+func newArchiveFile(location, rowType string, terms []string, offset int) archiveFile {
+	file := archiveFile{
+		Encoding:          "UTF-8",
+		FieldsTerminated:  `\t`,
+		LinesTerminated:   `\n`,
+		IgnoreHeaderLines: 1,
+		RowType:           rowType,
+		Location:          location,
+	}
+	for i, term := range terms {
+		file.Fields = append(file.Fields, archiveField{Index: i + offset, Term: dwcTerms + term})
+	}
+	return file
+}
+
+// newArchiveDescriptor describes the taxon core, identified by its taxonID
+// column, and the measurement extension
+func newArchiveDescriptor() archiveDescriptor {
+	core := newArchiveFile(TaxonFile, taxonRowType, taxonTerms, 0)
+	core.ID = &archiveIndex{Index: 0}
+	extension := newArchiveFile(MeasurementFile, measurementRowType, measurementTerms, 1)
+	extension.CoreID = &archiveIndex{Index: 0}
+	return archiveDescriptor{
+		Metadata:  MetadataFile,
+		Core:      core,
+		Extension: []archiveFile{extension},
+	}
+}
+
+// eml is eml.xml. The elements follow the order of the EML schema.
+type eml struct {
+	XMLName        xml.Name   `xml:"eml:eml"`
+	XMLNSEML       string     `xml:"xmlns:eml,attr"`
+	XMLNSXSI       string     `xml:"xmlns:xsi,attr"`
+	SchemaLocation string     `xml:"xsi:schemaLocation,attr"`
+	PackageID      string     `xml:"packageId,attr"`
+	System         string     `xml:"system,attr"`
+	Scope          string     `xml:"scope,attr"`
+	Lang           string     `xml:"xml:lang,attr"`
+	Dataset        emlDataset `xml:"dataset"`
+	DateStamp      string     `xml:"additionalMetadata>metadata>gbif>dateStamp"`
+	HierarchyLevel string     `xml:"additionalMetadata>metadata>gbif>hierarchyLevel"`
+}
+
+type emlDataset struct {
+	Title              string     `xml:"title"`
+	Creator            emlParty   `xml:"creator"`
+	MetadataProvider   emlParty   `xml:"metadataProvider"`
+	PubDate            string     `xml:"pubDate,omitempty"`
+	Language           string     `xml:"language"`
+	Abstract           []string   `xml:"abstract>para"`
+	Keywords           []string   `xml:"keywordSet>keyword"`
+	IntellectualRights *emlRights `xml:"intellectualRights,omitempty"`
+	Contact            emlParty   `xml:"contact"`
+	Methods            []string   `xml:"methods>methodStep>description>para"`
+}
+
+type emlParty struct {
+	OrganizationName string `xml:"organizationName"`
+	Email            string `xml:"electronicMailAddress,omitempty"`
+}
+
+type emlRights struct {
+	Para string `xml:"para"`
+}
+
+// newEML describes the dataset
+func newEML(meta Metadata) eml {
+	party := emlParty{OrganizationName: meta.Publisher, Email: meta.Contact}
+	search := "all marine molecules"
+	if meta.Search != "" {
+		search = "the marine molecules matching the search " + meta.Search
+	}
+	doc := eml{
+		XMLNSEML:       "eml://ecoinformatics.org/eml-2.1.1",
+		XMLNSXSI:       "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: "eml://ecoinformatics.org/eml-2.1.1 http://rs.gbif.org/schema/eml-gbif-profile/1.2/eml.xsd",
+		PackageID:      "marinenp-organisms/" + meta.Version,
+		System:         "http://gbif.org",
+		Scope:          "system",
+		Lang:           "eng",
+		Dataset: emlDataset{
+			Title:            Title,
+			Creator:          party,
+			MetadataProvider: party,
+			PubDate:          meta.PubDate,
+			Language:         "eng",
+			Abstract: []string{
+				"Marine organisms reported in the literature to produce natural products, " +
+					"taken from the MarineNP database of marine natural products. Each taxon " +
+					"is a WoRMS taxon, identified by its AphiaID, and each of its extended " +
+					"measurements or facts is a natural product reported for the taxon.",
+			},
+			Keywords: []string{"marine natural products", "secondary metabolites", "Checklist"},
+			Contact:  party,
+			Methods: []string{
+				fmt.Sprintf("Organisms with a WoRMS AphiaID linked to %s of MarineNP release %s.", search, meta.Version),
+			},
+		},
+		DateStamp:      time.Now().UTC().Format(time.RFC3339),
+		HierarchyLevel: "dataset",
+	}
+	if meta.License != "" {
+		doc.Dataset.IntellectualRights = &emlRights{Para: "This work is licensed under " + meta.License + "."}
+	}
+	return doc
+}
@@ -0,0 +1,278 @@
+/*
+ * MarineNP Full-Text Index
+ * Purpose: Ranked keyword search over molecule names and identifiers
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file keeps an SQLite FTS5 index of the names, synonyms, IUPAC names,
+ * CAS numbers, identifiers and organism names of the marine molecules, with
+ * the molecule id as rowid. The index is rebuilt when the server starts on a
+ * database that changed since it was built, or with "marinenp index". FTS5
+ * needs the sqlite_fts5 build tag; without it keyword search matches the
+ * molecule columns with LIKE only, unranked and without highlights.
+ */
+
+package fulltext
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Table is the FTS5 table of the index
+const Table = "molecule_search"
+
+// ErrUnavailable is returned when SQLite was built without FTS5
+var ErrUnavailable = errors.New("SQLite was built without FTS5, build with -tags sqlite_fts5 for ranked keyword search")
+
+// Columns are the indexed columns, in index order
+var Columns = []string{"identifier", "name", "synonyms", "iupac_name", "cas", "standard_inchi_key", "organisms"}
+
+// weights are the BM25 weights of the columns: identifiers and names rank
+// above IUPAC names and organisms
+const weights = "bm25(10.0, 8.0, 5.0, 2.0, 8.0, 8.0, 1.0)"
+
+// ready reports whether the index is up to date and can be queried
+var ready atomic.Bool
+
+// Ready reports whether keyword searches can use the index
+func Ready() bool {
+	return ready.Load()
+}
+
+// Load makes the index ready, rebuilding it if the molecules or organisms
+// changed since it was built. It returns whether the index was rebuilt.
+func Load(db *gorm.DB) (bool, error) {
+	if err := probe(db); err != nil {
+		return false, err
+	}
+	signature, err := currentSignature(db)
+	if err != nil {
+		return false, err
+	}
+	var built []string
+	if db.Migrator().HasTable(Table) && db.Migrator().HasTable(Table+"_state") {
+		if err := db.Table(Table+"_state").Pluck("signature", &built).Error; err != nil {
+			return false, err
+		}
+	}
+	if len(built) == 1 && built[0] == signature {
+		ready.Store(true)
+		return false, nil
+	}
+	_, err = Build(db)
+	return err == nil, err
+}
+
+// Build rebuilds the index and returns the number of molecules indexed. The
+// index is written to a new table that replaces the old one at the end, so
+// that searches can use the old index meanwhile.
+func Build(db *gorm.DB) (int64, error) {
+	if err := probe(db); err != nil {
+		return 0, err
+	}
+	signature, err := currentSignature(db)
+	if err != nil {
+		return 0, err
+	}
+
+	next := Table + "_next"
+	statements := []string{
+		"DROP TABLE IF EXISTS " + next,
+		"CREATE VIRTUAL TABLE " + next + " USING fts5(" + strings.Join(Columns, ", ") +
+			", tokenize = 'unicode61 remove_diacritics 2', prefix = '2 3')",
+		"INSERT INTO " + next + "(" + next + ", rank) VALUES ('rank', '" + weights + "')",
+		`INSERT INTO ` + next + `(rowid, ` + strings.Join(Columns, ", ") + `)
+		SELECT molecules.id, molecules.identifier, molecules.name, molecules.synonyms,
+			molecules.iupac_name, molecules.cas, molecules.standard_inchi_key,
+			(SELECT GROUP_CONCAT(COALESCE(organisms.name, '') ||
+					CASE WHEN organisms.name_aphia_worms != organisms.name THEN ' ' || organisms.name_aphia_worms ELSE '' END, ' | ')
+				FROM molecule_organism JOIN organisms ON organisms.id = molecule_organism.organism_id
+				WHERE molecule_organism.molecule_id = molecules.id AND organisms.is_marine = TRUE)
+		FROM molecules WHERE molecules.is_marine = TRUE`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return 0, err
+		}
+	}
+	var count int64
+	if err := db.Table(next).Count(&count).Error; err != nil {
+		return 0, err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range []string{
+			"DROP TABLE IF EXISTS " + Table,
+			"ALTER TABLE " + next + " RENAME TO " + Table,
+			"CREATE TABLE IF NOT EXISTS " + Table + "_state (signature TEXT, built_at INTEGER)",
+			"DELETE FROM " + Table + "_state",
+		} {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Exec("INSERT INTO "+Table+"_state (signature, built_at) VALUES (?, ?)", signature, time.Now().Unix()).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	ready.Store(true)
+	return count, nil
+}
+
+// probe returns ErrUnavailable if SQLite lacks FTS5, without logging the
+// failed statement
+func probe(db *gorm.DB) error {
+	db = db.Session(&gorm.Session{Logger: db.Logger.LogMode(logger.Silent)})
+	if err := db.Exec("CREATE VIRTUAL TABLE temp.fts5_probe USING fts5(x)").Error; err != nil {
+		if strings.Contains(err.Error(), "no such module") {
+			return ErrUnavailable
+		}
+		return err
+	}
+	return db.Exec("DROP TABLE temp.fts5_probe").Error
+}
+
+// currentSignature summarizes the indexed tables, so that an index built
+// from other data is detected when the server starts
+func currentSignature(db *gorm.DB) (string, error) {
+	var signature string
+	err := db.Raw(`SELECT (SELECT COUNT(*) FROM molecules) || ':' ||
+		(SELECT COALESCE(MAX(id), 0) FROM molecules) || ':' ||
+		(SELECT COALESCE(MAX(updated_at), '') FROM molecules) || ':' ||
+		(SELECT COUNT(*) FROM molecule_organism) || ':' ||
+		(SELECT COALESCE(MAX(updated_at), '') FROM organisms)`).Row().Scan(&signature)
+	return signature, err
+}
+
+// smilesPattern matches keywords written only with the atoms, ring bonds and
+// branches of SMILES, such as C1CCOC1 or CC(=O)O
+var smilesPattern = regexp.MustCompile(`^(Cl|Br|[BCNOPSFIbcnops]|[0-9()%+.=#@/\\\[\]-])+$`)
+
+// structural reports whether a keyword is an InChI or a SMILES fragment,
+// which the index does not hold. Identifiers such as CNP0000001 are not.
+func structural(keyword string) bool {
+	upper := strings.ToUpper(keyword)
+	if strings.HasPrefix(upper, "CNP") {
+		return false
+	}
+	if strings.HasPrefix(upper, "INCHI=") || strings.ContainsAny(keyword, "=#[]@\\") {
+		return true
+	}
+	return smilesPattern.MatchString(keyword) && strings.ContainsAny(keyword, "0123456789()") &&
+		strings.ContainsAny(keyword, "BCNOPSFIbcnops")
+}
+
+// Match translates a keyword into an FTS5 query. Words must all match, in
+// any column, as the start of a word; words in double quotes match as a
+// phrase of whole words, unless followed by *. Words are split into tokens
+// like the index, so that 4368-28-9 matches the CAS number as a phrase. It
+// returns false for keywords without words and for structural keywords.
+func Match(keyword string) (string, bool) {
+	keyword = strings.TrimSpace(keyword)
+	if structural(keyword) {
+		return "", false
+	}
+
+	var terms []string
+	add := func(text string, prefix bool) {
+		tokens := strings.FieldsFunc(text, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if len(tokens) == 0 {
+			return
+		}
+		term := `"` + strings.Join(tokens, " ") + `"`
+		if prefix {
+			term += "*"
+		}
+		terms = append(terms, term)
+	}
+
+	for rest := keyword; rest != ""; {
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+		if rest == "" {
+			break
+		}
+		var word string
+		if rest[0] == '"' {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				word, rest = rest[1:], ""
+			} else {
+				word, rest = rest[1:end+1], rest[end+2:]
+			}
+			prefix := strings.HasPrefix(rest, "*")
+			rest = strings.TrimPrefix(rest, "*")
+			add(word, prefix)
+			continue
+		}
+		if end := strings.IndexFunc(rest, unicode.IsSpace); end >= 0 {
+			word, rest = rest[:end], rest[end:]
+		} else {
+			word, rest = rest, ""
+		}
+		add(word, true)
+	}
+
+	if len(terms) == 0 {
+		return "", false
+	}
+	return strings.Join(terms, " AND "), true
+}
+
+// Highlights returns the matched snippets of molecules by molecule id and
+// column, with the matches wrapped in <mark> tags. Only columns with a match
+// are included. The ids are bound as one JSON array, as in
+// query.IDPredicate.
+func Highlights(db *gorm.DB, match string, ids []int64) (map[int64]map[string]string, error) {
+	highlights := map[int64]map[string]string{}
+	if len(ids) == 0 {
+		return highlights, nil
+	}
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, len(Columns))
+	for i, column := range Columns {
+		columns[i] = fmt.Sprintf("snippet(%s, %d, '<mark>', '</mark>', '…', 16) AS %s", Table, i, column)
+	}
+	rows, err := db.Raw("SELECT rowid, "+strings.Join(columns, ", ")+" FROM "+Table+
+		" WHERE "+Table+" MATCH ? AND rowid IN (SELECT value FROM json_each(?))", match, string(data)).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		snippets := make([]*string, len(Columns))
+		dest := []interface{}{&id}
+		for i := range snippets {
+			dest = append(dest, &snippets[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		matched := map[string]string{}
+		for i, snippet := range snippets {
+			if snippet != nil && strings.Contains(*snippet, "<mark>") {
+				matched[Columns[i]] = *snippet
+			}
+		}
+		highlights[id] = matched
+	}
+	return highlights, rows.Err()
+}
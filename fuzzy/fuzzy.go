@@ -0,0 +1,293 @@
+/*
+ * MarineNP Fuzzy Name Matching
+ * Purpose: Suggest organism and compound names for misspelled searches
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file provides an in-memory index of names for "did you mean"
+ * suggestions. Candidate names are found by the trigrams they share with the
+ * searched text, then scored by the Damerau-Levenshtein distance between the
+ * text and the words of the name it resembles most, so that "Aspergilus"
+ * suggests "Aspergillus" and "tetrodoxin" suggests "Tetrodotoxin".
+ */
+
+package fuzzy
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Kind is the kind of name a suggestion comes from
+type Kind string
+
+const (
+	Organism Kind = "organism" // Organism name or WoRMS name
+	Molecule Kind = "molecule" // Molecule name
+	Synonym  Kind = "synonym"  // Molecule synonym
+)
+
+// MinScore is the lowest confidence of a suggestion
+const MinScore = 0.7
+
+// minLength is the shortest text suggestions are made for, and maxLength
+// the longest name indexed; longer names are systematic names that are not
+// typed by hand
+const (
+	minLength = 4
+	maxLength = 64
+)
+
+// candidateShare is the share of the trigrams of the text a name must have
+// to be scored, and maxCandidates the number of names scored at most, those
+// sharing the most trigrams, so that texts made of common trigrams stay fast
+const (
+	candidateShare = 0.4
+	maxCandidates  = 5000
+)
+
+// name is an indexed name
+type name struct {
+	text string
+	kind Kind
+}
+
+// Index holds names by trigram
+type Index struct {
+	names    []name
+	seen     map[string]bool
+	postings map[string][]int32
+}
+
+// New returns an empty index
+func New() *Index {
+	return &Index{seen: map[string]bool{}, postings: map[string][]int32{}}
+}
+
+// Add indexes a name of a kind. Blank, very short or long names and names
+// added before are ignored.
+func (ix *Index) Add(kind Kind, text string) {
+	text = strings.Join(strings.Fields(text), " ")
+	if length := utf8.RuneCountInString(text); length < minLength || length > maxLength {
+		return
+	}
+	lower := strings.ToLower(text)
+	key := string(kind) + "\x00" + lower
+	if ix.seen[key] {
+		return
+	}
+	ix.seen[key] = true
+
+	id := int32(len(ix.names))
+	ix.names = append(ix.names, name{text: text, kind: kind})
+	for _, gram := range trigrams(lower) {
+		ix.postings[gram] = append(ix.postings[gram], id)
+	}
+}
+
+// Size returns the number of indexed names
+func (ix *Index) Size() int {
+	return len(ix.names)
+}
+
+// Suggestion is a name resembling a searched text. Text is the part of the
+// name that resembles it, and Score the confidence of the suggestion, from
+// MinScore to 1. Names holds up to maxNames of the indexed names containing
+// Text, for looking them up.
+type Suggestion struct {
+	Text  string   `json:"text"`
+	Kind  Kind     `json:"type"`
+	Score float64  `json:"score"`
+	Names []string `json:"-"`
+}
+
+// maxNames is the number of indexed names kept per suggestion
+const maxNames = 10
+
+// Suggest returns up to limit suggestions for a text, most confident first,
+// from the names of the kinds given, or of all kinds if none is given
+func (ix *Index) Suggest(text string, limit int, kinds ...Kind) []Suggestion {
+	query := strings.ToLower(strings.Join(strings.Fields(text), " "))
+	if utf8.RuneCountInString(query) < minLength {
+		return []Suggestion{}
+	}
+	allowed := func(kind Kind) bool {
+		if len(kinds) == 0 {
+			return true
+		}
+		for _, k := range kinds {
+			if k == kind {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Count the trigrams each name shares with the text, and group the names
+	// sharing enough of them by count
+	grams := trigrams(query)
+	shared := make([]uint16, len(ix.names))
+	var touched []int32
+	for _, gram := range grams {
+		for _, id := range ix.postings[gram] {
+			if shared[id] == 0 {
+				touched = append(touched, id)
+			}
+			shared[id]++
+		}
+	}
+	need := int(math.Ceil(candidateShare * float64(len(grams))))
+	byCount := make([][]int32, len(grams)+1)
+	for _, id := range touched {
+		if count := int(shared[id]); count >= need && allowed(ix.names[id].kind) {
+			byCount[count] = append(byCount[count], id)
+		}
+	}
+	var candidates []int32
+	for count := len(grams); count >= need && len(candidates) < maxCandidates; count-- {
+		ids := byCount[count]
+		if room := maxCandidates - len(candidates); len(ids) > room {
+			ids = ids[:room]
+		}
+		candidates = append(candidates, ids...)
+	}
+
+	// Score the candidates, keeping the best score of each suggested text
+	type scored struct {
+		Suggestion
+		names int
+	}
+	best := map[string]*scored{}
+	words := strings.Fields(query)
+	for _, id := range candidates {
+		n := ix.names[id]
+		match, score := closestWords(words, n.text)
+		key := strings.ToLower(match)
+		if score < MinScore || key == query {
+			continue
+		}
+		if s, ok := best[key]; ok {
+			// Ties are broken by kind and spelling, so that the suggestion
+			// does not depend on the order names were added in
+			s.names++
+			if len(s.Names) < maxNames {
+				s.Names = append(s.Names, n.text)
+			}
+			if score > s.Score || score == s.Score && (n.kind < s.Kind || n.kind == s.Kind && match < s.Text) {
+				s.Text, s.Kind, s.Score = match, n.kind, score
+			}
+			continue
+		}
+		best[key] = &scored{Suggestion{Text: match, Kind: n.kind, Score: score, Names: []string{n.text}}, 1}
+	}
+
+	list := make([]*scored, 0, len(best))
+	for _, s := range best {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Score != list[j].Score {
+			return list[i].Score > list[j].Score
+		}
+		if list[i].names != list[j].names {
+			return list[i].names > list[j].names
+		}
+		return list[i].Text < list[j].Text
+	})
+
+	suggestions := []Suggestion{}
+	for _, s := range list {
+		if len(suggestions) == limit {
+			break
+		}
+		s.Score = math.Round(s.Score*1000) / 1000
+		suggestions = append(suggestions, s.Suggestion)
+	}
+	return suggestions
+}
+
+// closestWords returns the run of consecutive words of a name, as many as
+// the words of the query, that is closest to the query, with its similarity:
+// one minus the edit distance relative to the longer of both
+func closestWords(query []string, text string) (string, float64) {
+	words := strings.Fields(text)
+	n := len(query)
+	if n > len(words) {
+		n = len(words)
+	}
+	q := []rune(strings.Join(query, " "))
+
+	var match string
+	score := -1.0
+	for start := 0; start+n <= len(words); start++ {
+		candidate := strings.Join(words[start:start+n], " ")
+		c := []rune(strings.ToLower(candidate))
+		longest := len(q)
+		if len(c) > longest {
+			longest = len(c)
+		}
+		if s := 1 - float64(distance(q, c))/float64(longest); s > score {
+			match, score = candidate, s
+		}
+	}
+	return match, score
+}
+
+// distance returns the optimal string alignment distance between a and b:
+// the number of insertions, deletions, substitutions and transpositions of
+// adjacent characters turning a into b
+func distance(a, b []rune) int {
+	rows := make([][]int, len(a)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(b)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d := smallest(rows[i-1][j]+1, rows[i][j-1]+1, rows[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d = smallest(d, rows[i-2][j-2]+1)
+			}
+			rows[i][j] = d
+		}
+	}
+	return rows[len(a)][len(b)]
+}
+
+// smallest returns the smallest of its arguments
+func smallest(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// trigrams returns the distinct trigrams of the words of a lowercase text,
+// each word padded with a space on both sides
+func trigrams(text string) []string {
+	seen := map[string]bool{}
+	var grams []string
+	for _, word := range strings.Fields(text) {
+		r := []rune(" " + word + " ")
+		for i := 0; i+3 <= len(r); i++ {
+			gram := string(r[i : i+3])
+			if !seen[gram] {
+				seen[gram] = true
+				grams = append(grams, gram)
+			}
+		}
+	}
+	return grams
+}
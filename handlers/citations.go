@@ -5,21 +5,59 @@
  * Date: 2025-06-10
  *
  * This file provides endpoints for accessing and managing literature citations
- * associated with marine natural products in the MarineNP database.
+ * associated with marine natural products in the MarineNP database, as JSON
+ * or as BibTeX, RIS and CSL-JSON for reference managers: single citations,
+ * citation searches, and the references of a molecule or a molecule search.
  */
 
 package handlers
 
 import (
+	"encoding/json"
+	"fmt"
+	"marinenp/bibliography"
 	"marinenp/models"
+	"marinenp/query"
+	"marinenp/rdf"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
+// citationMediaTypes are the media types offered for citations: JSON, RDF
+// and the bibliography formats
+var citationMediaTypes = append(append([]offeredType{}, rdfMediaTypes...),
+	offeredType{"application/x-bibtex", bibliography.BibTeX},
+	offeredType{"application/x-research-info-systems", bibliography.RIS},
+	offeredType{"application/vnd.citationstyles.csl+json", bibliography.CSLJSON},
+)
+
+// citationFormat returns the bibliography format of the format parameter,
+// empty for JSON, responding with a 400 error and returning false if it is
+// not a bibliography format
+func citationFormat(c *gin.Context) (string, bool) {
+	format := c.Query("format")
+	if format == "" {
+		return "", true
+	}
+	if _, ok := bibliography.ContentTypes[format]; !ok {
+		ErrorResponse(c, 400, "format must be bibtex, ris or csl")
+		return "", false
+	}
+	return format, true
+}
+
 // GetCitations handles GET /api/v1/citations
+// With ?format=bibtex|ris|csl every matching citation is downloaded as a file
+// in that format, in the order of the search and without pagination.
 func GetCitations(c *gin.Context) {
+	format, ok := citationFormat(c)
+	if !ok {
+		return
+	}
 	params := ParseQueryParams(c)
 	var citations []models.Citation
 	var total int64
@@ -29,13 +67,12 @@ func GetCitations(c *gin.Context) {
 
 	// Apply search if provided
 	if params.Search != "" {
-		query = query.Where("LOWER(title) LIKE ? OR LOWER(authors) LIKE ? OR LOWER(doi) LIKE ?",
-			"%"+strings.ToLower(params.Search)+"%", "%"+strings.ToLower(params.Search)+"%", "%"+strings.ToLower(params.Search)+"%")
+		search := "%" + strings.ToLower(params.Search) + "%"
+		query = query.Where("LOWER(title) LIKE ? OR LOWER(authors) LIKE ? OR LOWER(doi) LIKE ? OR "+
+			"id IN (SELECT citation_id FROM citation_metadata WHERE LOWER(title) LIKE ? OR LOWER(authors) LIKE ? OR LOWER(journal) LIKE ?)",
+			search, search, search, search, search, search)
 	}
 
-	// Get total count
-	query.Count(&total)
-
 	// Apply ordering
 	if params.OrderByString != "" {
 		order := params.OrderByString
@@ -47,12 +84,20 @@ func GetCitations(c *gin.Context) {
 		query = query.Order("citations.id ASC")
 	}
 
+	if format != "" {
+		sendCitations(c, format, "marinenp_citations", query)
+		return
+	}
+
+	// Get total count
+	query.Count(&total)
+
 	// Apply pagination
 	offset := (params.PageNumber - 1) * params.PerPageNumber
 	query = query.Offset(offset).Limit(params.PerPageNumber)
 
 	// Execute query
-	result := query.Find(&citations)
+	result := query.Preload("Metadata").Find(&citations)
 
 	if result.Error != nil {
 		ErrorResponse(c, 500, "Failed to fetch citations")
@@ -73,16 +118,263 @@ func GetCitations(c *gin.Context) {
 }
 
 // GetCitationByID handles GET /api/v1/citations/:id
+// The citation is JSON, RDF when negotiated as in rdfRequest, or BibTeX, RIS
+// or CSL-JSON when negotiated or given as ?format=bibtex|ris|csl.
 func GetCitationByID(c *gin.Context) {
-	id := c.Param("id")
+	id, format := negotiateRecord(c, c.Param("id"), citationMediaTypes)
+	if c.Query("format") != "" {
+		var ok bool
+		if format, ok = citationFormat(c); !ok {
+			return
+		}
+	}
 	var citation models.Citation
 
-	result := db.First(&citation, id)
+	result := db.Preload("Metadata").First(&citation, id)
 
 	if result.Error != nil {
 		ErrorResponse(c, 404, "Citation not found")
 		return
 	}
 
+	if _, ok := bibliography.ContentTypes[format]; ok {
+		c.Header("Content-Type", bibliography.ContentTypes[format])
+		c.Status(http.StatusOK)
+		w, _ := bibliography.NewWriter(c.Writer, format)
+		err := w.Write(&citation)
+		if err == nil {
+			err = w.Close()
+		}
+		if err != nil {
+			c.Error(err)
+		}
+		return
+	}
+
+	if format != "" {
+		sendRDF(c, format, func(r rdf.Resources, g *rdf.Graph) { r.AddCitation(g, &citation) })
+		return
+	}
+
 	SuccessResponse(c, citation)
-} 
\ No newline at end of file
+}
+
+// GetMoleculesByCitation handles GET /api/v1/citations/:id/molecules
+func GetMoleculesByCitation(c *gin.Context) {
+	id := c.Param("id")
+	params := ParseQueryParams(c)
+	sortField, sortable := query.LookupField(params.OrderByString)
+	var citation models.Citation
+	var total int64
+
+	// First check if citation exists
+	if err := db.First(&citation, id).Error; err != nil {
+		ErrorResponse(c, 404, "Citation not found")
+		return
+	}
+
+	// Build query for molecules
+	query := db.Model(&models.Molecule{}).
+		Joins(`JOIN citables ON citables.citable_id = molecules.id AND citables.citable_type = 'App\Models\Molecule'`).
+		Where("citables.citation_id = ? AND molecules.is_marine = TRUE", citation.ID)
+
+	// Apply search if provided
+	if params.Search != "" {
+		search := "%" + strings.ToLower(params.Search) + "%"
+		query = query.Where("LOWER(molecules.name) LIKE ? OR LOWER(molecules.canonical_smiles) LIKE ? OR LOWER(molecules.identifier) LIKE ?",
+			search, search, search)
+	}
+
+	// Get total count
+	query.Count(&total)
+
+	// Apply ordering, on the sortable molecule fields of the search only
+	if sortable && sortField.Sortable() {
+		order := "molecules." + sortField.Column
+		if params.OrderDir == "desc" {
+			order += " DESC"
+		}
+		query = query.Order(order)
+	}
+	query = query.Order("molecules.id ASC")
+
+	// Apply pagination
+	offset := (params.PageNumber - 1) * params.PerPageNumber
+	query = query.Offset(offset).Limit(params.PerPageNumber)
+
+	// Execute query with preloads
+	var molecules []models.Molecule
+	result := query.Preload("Properties").
+		Preload("Organisms").
+		Preload("GeoLocations").
+		Find(&molecules)
+
+	if result.Error != nil {
+		ErrorResponse(c, 500, "Failed to fetch molecules for citation")
+		return
+	}
+
+	PaginatedSuccessResponse(c, molecules, total, params.PageNumber)
+}
+
+// GetMoleculeCitations handles GET /api/v1/molecules/:identifier/citations
+// The references of a molecule are JSON, or a file with
+// ?format=bibtex|ris|csl.
+func GetMoleculeCitations(c *gin.Context) {
+	format, ok := citationFormat(c)
+	if !ok {
+		return
+	}
+	var molecule models.Molecule
+	if err := db.Where("identifier = ?", c.Param("identifier")).First(&molecule).Error; err != nil {
+		ErrorResponse(c, 404, "Molecule not found")
+		return
+	}
+
+	query := db.Model(&models.Citation{}).
+		Select("citations.*").
+		Joins(`JOIN citables ON citables.citation_id = citations.id AND citables.citable_type = 'App\Models\Molecule'`).
+		Where("citables.citable_id = ?", molecule.ID).
+		Order("citables.id")
+	if format != "" {
+		sendCitations(c, format, "marinenp_"+molecule.Identifier+"_citations", query)
+		return
+	}
+
+	var citations []models.Citation
+	if err := query.Preload("Metadata").Find(&citations).Error; err != nil {
+		ErrorResponse(c, 500, "Failed to fetch citations")
+		return
+	}
+	SuccessResponse(c, gin.H{
+		"citations": citations,
+		"total":     len(citations),
+	})
+}
+
+// GetMoleculeSetCitations handles GET and POST /api/v1/molecules/citations
+// Returns the citations of the molecules matching the search filter, each
+// once, as JSON or as a file with ?format=bibtex|ris|csl.
+func GetMoleculeSetCitations(c *gin.Context) {
+	format, ok := citationFormat(c)
+	if !ok {
+		return
+	}
+	filter, ok := parseFilter(c)
+	if !ok {
+		return
+	}
+
+	cited := filter.Apply(db.Table("molecules").
+		Select("citables.citation_id").
+		Joins(`JOIN citables ON citables.citable_id = molecules.id AND citables.citable_type = 'App\Models\Molecule'`))
+	query := db.Model(&models.Citation{}).
+		Where("citations.id IN (?)", cited).
+		Order("citations.id")
+	if format != "" {
+		sendCitations(c, format, "marinenp_references", query)
+		return
+	}
+
+	var citations []models.Citation
+	if err := query.Preload("Metadata").Find(&citations).Error; err != nil {
+		ErrorResponse(c, 500, "Failed to fetch citations")
+		return
+	}
+	SuccessResponse(c, gin.H{
+		"citations": citations,
+		"total":     len(citations),
+	})
+}
+
+// sendCitations streams the citations of a query as a file download. As the
+// response is already under way when a row fails, errors and the number of
+// citations are reported in the X-Export-Error and X-Export-Rows trailers.
+func sendCitations(c *gin.Context, format, name string, query *gorm.DB) {
+	filename := name + "_" + time.Now().Format("20060102_150405") + bibliography.Extensions[format]
+	c.Header("Content-Type", bibliography.ContentTypes[format])
+	c.Header("Content-Disposition", "attachment;filename="+filename)
+	c.Header("Trailer", "X-Export-Rows, X-Export-Error")
+	c.Status(http.StatusOK)
+
+	w, _ := bibliography.NewWriter(c.Writer, format)
+	err := writeCitationRows(w, query)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		c.Writer.Header().Set("X-Export-Error", err.Error())
+	}
+	c.Writer.Header().Set("X-Export-Rows", fmt.Sprintf("%d", w.Count()))
+}
+
+// citationBatchSize is the number of citations whose metadata is loaded at
+// once when citations are streamed
+const citationBatchSize = 500
+
+// writeCitationRows writes the citations of a query one row at a time, with
+// their metadata loaded per batch
+func writeCitationRows(w *bibliography.Writer, query *gorm.DB) error {
+	rows, err := query.Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	batch := make([]models.Citation, 0, citationBatchSize)
+	flush := func() error {
+		if err := loadCitationMetadata(batch); err != nil {
+			return err
+		}
+		for i := range batch {
+			if err := w.Write(&batch[i]); err != nil {
+				return err
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+	for rows.Next() {
+		var citation models.Citation
+		if err := db.ScanRows(rows, &citation); err != nil {
+			return err
+		}
+		if batch = append(batch, citation); len(batch) == citationBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// loadCitationMetadata sets the metadata of citations read without it. The
+// ids are bound as one JSON array, as in query.IDPredicate.
+func loadCitationMetadata(citations []models.Citation) error {
+	if len(citations) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(citations))
+	for i, citation := range citations {
+		ids[i] = citation.ID
+	}
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+	var metadata []models.CitationMetadata
+	if err := db.Where("citation_id IN (SELECT value FROM json_each(?))", string(data)).Find(&metadata).Error; err != nil {
+		return err
+	}
+	byCitation := make(map[int64]*models.CitationMetadata, len(metadata))
+	for i := range metadata {
+		byCitation[metadata[i].CitationID] = &metadata[i]
+	}
+	for i := range citations {
+		citations[i].Metadata = byCitation[citations[i].ID]
+	}
+	return nil
+}
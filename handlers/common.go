@@ -12,6 +12,7 @@ package handlers
 
 import (
 	"marinenp/models"
+	"marinenp/query"
 	"net/http"
 	"strconv"
 
@@ -99,6 +100,19 @@ func ErrorResponse(c *gin.Context, status int, msg string) {
 	})
 }
 
+// FilterErrorResponse sends a 400 response describing why a search filter was rejected
+func FilterErrorResponse(c *gin.Context, err error) {
+	var detail interface{}
+	if qerr, ok := err.(*query.Error); ok {
+		detail = qerr
+	}
+	c.JSON(http.StatusBadRequest, Response{
+		Status: http.StatusBadRequest,
+		Msg:    err.Error(),
+		Data:   detail,
+	})
+}
+
 // PaginatedSuccessResponse sends a standardized paginated response
 func PaginatedSuccessResponse(c *gin.Context, data interface{}, total int64, page int) {
 	// Marshal response using custom JSON marshaler
@@ -0,0 +1,321 @@
+/*
+ * MarineNP Dereplication Handlers
+ * Purpose: HTTP handlers for exact-mass dereplication of LC-MS features
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file matches observed m/z values against the exact molecular weights
+ * of marine molecules, for a list of adducts and a ppm tolerance. Candidates
+ * can be restricted by organism, taxon or any molecule search condition.
+ */
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"marinenp/chem"
+	"marinenp/models"
+	"marinenp/query"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Dereplication defaults and limits
+const (
+	defaultDereplicationPPM = 5.0
+	maxDereplicationPPM     = 100.0
+	maxDereplicationMZ      = 1000
+)
+
+// DereplicationRequest is the JSON body of POST /api/v1/molecules/dereplicate
+type DereplicationRequest struct {
+	MZ       []float64       `json:"mz"`
+	Mode     string          `json:"mode"`               // "positive" or "negative"
+	Adducts  []string        `json:"adducts,omitempty"`  // Defaults to the common adducts of the mode
+	PPM      float64         `json:"ppm,omitempty"`      // Tolerance in parts per million
+	Organism string          `json:"organism,omitempty"` // Restrict to molecules of matching organisms
+	Taxon    string          `json:"taxon,omitempty"`    // Restrict to molecules of a taxon, e.g. a genus or family
+	Filter   *query.Document `json:"filter,omitempty"`   // Further molecule search conditions
+}
+
+// DereplicationCandidate is a molecule whose adduct matches an observed m/z
+type DereplicationCandidate struct {
+	MoleculeID           int64   `json:"molecule_id"`
+	Identifier           string  `json:"identifier"`
+	Name                 string  `json:"name"`
+	MolecularFormula     string  `json:"molecular_formula"`
+	CanonicalSmiles      string  `json:"canonical_smiles"`
+	ExactMolecularWeight float64 `json:"exact_molecular_weight"`
+	Adduct               string  `json:"adduct"`
+	TheoreticalMZ        float64 `json:"theoretical_mz"`
+	PPMError             float64 `json:"ppm_error"`
+}
+
+// DereplicationResult lists the candidates of one observed m/z
+type DereplicationResult struct {
+	MZ         float64                  `json:"mz"`
+	Candidates []DereplicationCandidate `json:"candidates"`
+}
+
+// Dereplicate handles GET and POST /api/v1/molecules/dereplicate
+// POST takes a DereplicationRequest; GET takes the same fields as query
+// parameters, with mz and adducts comma-separated, and the search filter in
+// the usual conditions[i][...] form.
+func Dereplicate(c *gin.Context) {
+	req, filter, ok := parseDereplicationRequest(c)
+	if !ok {
+		return
+	}
+
+	// Validate the request
+	if len(req.MZ) == 0 || len(req.MZ) > maxDereplicationMZ {
+		ErrorResponse(c, 400, fmt.Sprintf("Between 1 and %d m/z values are required", maxDereplicationMZ))
+		return
+	}
+	for _, mz := range req.MZ {
+		if !(mz > 0) || math.IsInf(mz, 0) {
+			ErrorResponse(c, 400, fmt.Sprintf("Invalid m/z value: %v", mz))
+			return
+		}
+	}
+	req.Mode = strings.ToLower(req.Mode)
+	if _, ok := chem.Adducts[req.Mode]; !ok {
+		ErrorResponse(c, 400, "mode must be positive or negative")
+		return
+	}
+	if len(req.Adducts) == 0 {
+		req.Adducts = chem.DefaultAdducts[req.Mode]
+	}
+	adducts := make([]chem.Adduct, 0, len(req.Adducts))
+	for _, name := range req.Adducts {
+		adduct, ok := chem.LookupAdduct(req.Mode, strings.TrimSpace(name))
+		if !ok {
+			var supported []string
+			for _, a := range chem.Adducts[req.Mode] {
+				supported = append(supported, a.Name)
+			}
+			ErrorResponse(c, 400, fmt.Sprintf("Unsupported %s mode adduct: %s (supported: %s)", req.Mode, name, strings.Join(supported, ", ")))
+			return
+		}
+		adducts = append(adducts, adduct)
+	}
+	if req.PPM == 0 {
+		req.PPM = defaultDereplicationPPM
+	}
+	if req.PPM < 0 || req.PPM > maxDereplicationPPM {
+		ErrorResponse(c, 400, fmt.Sprintf("ppm must be between 0 and %v", maxDereplicationPPM))
+		return
+	}
+
+	// Organism and taxon restrictions are ordinary organism conditions
+	restrictions := []struct{ operator, value string }{
+		{string(query.OpContains), req.Organism},
+		{string(query.OpInTaxon), req.Taxon},
+	}
+	for _, r := range restrictions {
+		if strings.TrimSpace(r.value) == "" {
+			continue
+		}
+		condition, qerr := query.NewCondition("organism", r.operator, r.value)
+		if qerr != nil {
+			FilterErrorResponse(c, qerr)
+			return
+		}
+		filter.Require(condition)
+	}
+	if !checkTaxa(c, filter.Taxa()) || !resolveConditions(c, filter) {
+		return
+	}
+
+	// Neutral mass window of every m/z and adduct pair
+	type window struct {
+		row    int // Index of the m/z in the request
+		adduct chem.Adduct
+		lo, hi float64
+	}
+	var windows []window
+	minMass, maxMass := math.Inf(1), math.Inf(-1)
+	for row, mz := range req.MZ {
+		for _, adduct := range adducts {
+			w := window{
+				row:    row,
+				adduct: adduct,
+				lo:     adduct.NeutralMass(mz / (1 + req.PPM/1e6)),
+				hi:     adduct.NeutralMass(mz / (1 - req.PPM/1e6)),
+			}
+			windows = append(windows, w)
+			minMass = math.Min(minMass, w.lo)
+			maxMass = math.Max(maxMass, w.hi)
+		}
+	}
+
+	// Load the masses of all molecules matching the filter within the windows
+	var masses []struct {
+		MoleculeID           int64
+		ExactMolecularWeight float64
+	}
+	err := filter.Apply(db.Model(&models.Molecule{})).
+		Joins("JOIN properties ON properties.molecule_id = molecules.id").
+		Select("molecules.id AS molecule_id, CAST(properties.exact_molecular_weight AS REAL) AS exact_molecular_weight").
+		Where("CAST(properties.exact_molecular_weight AS REAL) BETWEEN ? AND ?", minMass, maxMass).
+		Order("exact_molecular_weight").
+		Scan(&masses).Error
+	if err != nil {
+		ErrorResponse(c, 500, "Failed to load molecular weights")
+		return
+	}
+
+	// Match every window against the sorted masses
+	results := make([]DereplicationResult, len(req.MZ))
+	index := 0
+	total := 0
+	var ids []int64
+	for i, mz := range req.MZ {
+		results[i] = DereplicationResult{MZ: mz, Candidates: []DereplicationCandidate{}}
+		for ; index < len(windows) && windows[index].row == i; index++ {
+			w := windows[index]
+			start := sort.Search(len(masses), func(j int) bool { return masses[j].ExactMolecularWeight >= w.lo })
+			for j := start; j < len(masses) && masses[j].ExactMolecularWeight <= w.hi; j++ {
+				theoretical := w.adduct.MZ(masses[j].ExactMolecularWeight)
+				ppm := chem.PPM(mz, theoretical)
+				if math.Abs(ppm) > req.PPM {
+					continue
+				}
+				results[i].Candidates = append(results[i].Candidates, DereplicationCandidate{
+					MoleculeID:           masses[j].MoleculeID,
+					ExactMolecularWeight: masses[j].ExactMolecularWeight,
+					Adduct:               w.adduct.Name,
+					TheoreticalMZ:        theoretical,
+					PPMError:             ppm,
+				})
+				ids = append(ids, masses[j].MoleculeID)
+				total++
+			}
+		}
+		candidates := results[i].Candidates
+		sort.SliceStable(candidates, func(a, b int) bool {
+			if math.Abs(candidates[a].PPMError) != math.Abs(candidates[b].PPMError) {
+				return math.Abs(candidates[a].PPMError) < math.Abs(candidates[b].PPMError)
+			}
+			return candidates[a].MoleculeID < candidates[b].MoleculeID
+		})
+	}
+
+	// Fill in the molecule details of all candidates
+	var details []struct {
+		ID               int64
+		Identifier       string
+		Name             string
+		CanonicalSmiles  string
+		MolecularFormula string
+	}
+	sql, args := query.IDPredicate(ids)
+	err = db.Model(&models.Molecule{}).
+		Joins("LEFT JOIN properties ON properties.molecule_id = molecules.id").
+		Select("molecules.id, molecules.identifier, molecules.name, molecules.canonical_smiles, properties.molecular_formula").
+		Where(sql, args...).
+		Scan(&details).Error
+	if err != nil {
+		ErrorResponse(c, 500, "Failed to load candidate molecules")
+		return
+	}
+	byID := make(map[int64]int, len(details))
+	for i, d := range details {
+		byID[d.ID] = i
+	}
+	for i := range results {
+		for j := range results[i].Candidates {
+			candidate := &results[i].Candidates[j]
+			if k, ok := byID[candidate.MoleculeID]; ok {
+				candidate.Identifier = details[k].Identifier
+				candidate.Name = details[k].Name
+				candidate.CanonicalSmiles = details[k].CanonicalSmiles
+				candidate.MolecularFormula = details[k].MolecularFormula
+			}
+		}
+	}
+
+	names := make([]string, len(adducts))
+	for i, adduct := range adducts {
+		names[i] = adduct.Name
+	}
+	SuccessResponse(c, gin.H{
+		"mode":    req.Mode,
+		"adducts": names,
+		"ppm":     req.PPM,
+		"results": results,
+		"total":   total,
+	})
+}
+
+// parseDereplicationRequest reads the request from a JSON body or from query
+// parameters, responding with a 400 error and returning false if it is malformed
+func parseDereplicationRequest(c *gin.Context) (*DereplicationRequest, *query.Filter, bool) {
+	req := &DereplicationRequest{}
+
+	if c.Request.Method == http.MethodPost {
+		body, err := c.GetRawData()
+		if err != nil {
+			ErrorResponse(c, 400, "Failed to read request body")
+			return nil, nil, false
+		}
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(req); err != nil {
+			ErrorResponse(c, 400, "Malformed dereplication request: "+err.Error())
+			return nil, nil, false
+		}
+		doc := req.Filter
+		if doc == nil {
+			doc = &query.Document{}
+		}
+		filter, err := doc.Filter()
+		if err != nil {
+			FilterErrorResponse(c, err)
+			return nil, nil, false
+		}
+		return req, filter, true
+	}
+
+	for _, value := range splitList(c.Query("mz")) {
+		mz, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			ErrorResponse(c, 400, fmt.Sprintf("Invalid m/z value: %s", value))
+			return nil, nil, false
+		}
+		req.MZ = append(req.MZ, mz)
+	}
+	req.Mode = c.Query("mode")
+	req.Adducts = splitList(c.Query("adducts"))
+	if ppm := c.Query("ppm"); ppm != "" {
+		var err error
+		if req.PPM, err = strconv.ParseFloat(ppm, 64); err != nil {
+			ErrorResponse(c, 400, fmt.Sprintf("Invalid ppm value: %s", ppm))
+			return nil, nil, false
+		}
+	}
+	req.Organism = c.Query("organism")
+	req.Taxon = c.Query("taxon")
+
+	filter, err := query.Parse(c.Request.URL.Query())
+	if err != nil {
+		FilterErrorResponse(c, err)
+		return nil, nil, false
+	}
+	return req, filter, true
+}
+
+// splitList splits a comma or whitespace separated parameter
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+}
@@ -0,0 +1,446 @@
+/*
+ * MarineNP Export Streaming
+ * Purpose: Stream molecule exports into zip or gzip archives
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file writes molecule exports page by page, paginating on molecules.id
+ * so that memory use does not grow with the size of the export. Records are
+ * formatted as CSV rows or SD file records and written straight into the
+ * archive, which records a failure part way through instead of ending as a
+ * silently truncated download.
+ */
+
+package handlers
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"marinenp/chem"
+	"marinenp/columnar"
+	"marinenp/models"
+	"marinenp/query"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// exportPageSize is the number of records loaded per export query
+const exportPageSize = 2000
+
+// exportErrorFile is added to a zip export that failed part way through
+const exportErrorFile = "export-error.txt"
+
+// recordWriter writes exported molecule records in one file format. Flush
+// is called after each page of records and Close after the last one.
+type recordWriter interface {
+	Write(record map[string]interface{}) error
+	Flush() error
+	Close() error
+}
+
+// exportFormats maps the format parameter, also the data file extension, to
+// the record writer of each export format
+var exportFormats = map[string]func(w io.Writer, headers []string) (recordWriter, error){
+	"csv":            newCSVRecordWriter,
+	"sdf":            newSDFRecordWriter,
+	columnar.Parquet: newColumnarRecordWriter(columnar.Parquet),
+	columnar.Arrow:   newColumnarRecordWriter(columnar.Arrow),
+}
+
+// exportOptions are the format and archive of an export and the search
+// recorded with it
+type exportOptions struct {
+	Format      string
+	Compression string
+	Relations   string // How related organisms, locations and citations are exported
+	QueryName   string
+	FullQuery   string
+}
+
+// parseExportOptions reads the format, compression and relations parameters
+// and the search of an export request, responding with a 400 error and returning
+// false if they are invalid. POST requests record their JSON filter
+// document, GET requests the equivalent search URL.
+func parseExportOptions(c *gin.Context) (exportOptions, bool) {
+	opts := exportOptions{
+		Format:      strings.ToLower(c.DefaultQuery("format", "csv")),
+		Compression: strings.ToLower(c.DefaultQuery("compression", "zip")),
+		Relations:   strings.ToLower(c.DefaultQuery("relations", relationsNone)),
+		QueryName:   "search-query.txt",
+		FullQuery:   fmt.Sprintf("/api/v1/molecules/search?%s", c.Request.URL.RawQuery),
+	}
+	if _, ok := exportFormats[opts.Format]; !ok && opts.Format != xlsxFormat {
+		ErrorResponse(c, 400, "format must be csv, sdf, parquet, arrow or xlsx")
+		return opts, false
+	}
+	if _, ok := exportCompressions[opts.Compression]; !ok {
+		ErrorResponse(c, 400, "compression must be zip or gzip")
+		return opts, false
+	}
+	switch opts.Relations {
+	case relationsNone, relationsColumns:
+	case relationsFiles:
+		if opts.Compression != "zip" {
+			ErrorResponse(c, 400, "relations=files needs compression=zip")
+			return opts, false
+		}
+	default:
+		ErrorResponse(c, 400, "relations must be none, files or columns")
+		return opts, false
+	}
+	if opts.Format == xlsxFormat {
+		// Workbooks are zip files holding the search and the relations as
+		// sheets
+		opts.Compression = ""
+		opts.Relations = relationsNone
+	}
+	if body, ok := c.Get(filterDocumentKey); ok {
+		opts.QueryName = "search-query.json"
+		opts.FullQuery = string(body.([]byte))
+	}
+	return opts, true
+}
+
+// FileName returns the download file name of an export made at t
+func (o exportOptions) FileName(t time.Time) string {
+	if o.Format == xlsxFormat {
+		return fmt.Sprintf("molecules_%s.xlsx", t.Format("20060102_150405"))
+	}
+	if o.Compression == "gzip" {
+		return fmt.Sprintf("molecules_%s.%s.gz", t.Format("20060102_150405"), o.Format)
+	}
+	return fmt.Sprintf("molecules_%s.zip", t.Format("20060102_150405"))
+}
+
+// Headers returns the columns of the exported records: exportHeaders, then
+// the relation list columns if relations are exported as columns
+func (o exportOptions) Headers() []string {
+	if o.Relations != relationsColumns {
+		return exportHeaders
+	}
+	headers := append([]string{}, exportHeaders...)
+	for _, relation := range exportRelations {
+		for _, list := range relation.Lists {
+			headers = append(headers, list[0])
+		}
+	}
+	return headers
+}
+
+// ContentType returns the content type of the export file
+func (o exportOptions) ContentType() string {
+	if o.Format == xlsxFormat {
+		return xlsxContentType
+	}
+	return exportCompressions[o.Compression]
+}
+
+// writeExport writes the archive of every molecule matching the filter to w,
+// recording a failure part way through in the archive. It returns the number
+// of rows written and the error that stopped the export, if any.
+func writeExport(w io.Writer, filter *query.Filter, opts exportOptions, progress func(rows int64)) (int64, error) {
+	if opts.Format == xlsxFormat {
+		rows, err := writeXLSX(w, filter, opts, progress)
+		if err != nil {
+			log.Printf("Export failed after %d records: %v", rows, err)
+		}
+		return rows, err
+	}
+
+	archive, err := newExportArchive(w, opts.Compression, opts.QueryName, opts.FullQuery, "molecules."+opts.Format)
+	if err != nil {
+		return 0, err
+	}
+	writer, err := exportFormats[opts.Format](archive.data, opts.Headers())
+	var rows int64
+	if err == nil {
+		rows, err = streamRecords(filter, opts.Relations == relationsColumns, writer, progress)
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err == nil && opts.Relations == relationsFiles {
+		err = writeRelationFiles(archive.zip, filter, opts.Format)
+	}
+	if err != nil {
+		log.Printf("Export failed after %d records: %v", rows, err)
+		if err := archive.Fail(rows, err); err != nil {
+			log.Printf("Failed to record export error: %v", err)
+		}
+	}
+	if closeErr := archive.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return rows, err
+}
+
+// csvRecordWriter writes records as rows of the export headers
+type csvRecordWriter struct {
+	w       *csv.Writer
+	headers []string
+}
+
+func newCSVRecordWriter(w io.Writer, headers []string) (recordWriter, error) {
+	cw := csv.NewWriter(w)
+	return &csvRecordWriter{w: cw, headers: headers}, cw.Write(headers)
+}
+
+func (w *csvRecordWriter) Write(record map[string]interface{}) error {
+	return w.w.Write(exportRow(record, w.headers))
+}
+
+func (w *csvRecordWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+func (w *csvRecordWriter) Close() error { return w.Flush() }
+
+// sdfRecordWriter writes records as SD file records
+type sdfRecordWriter struct {
+	w       io.Writer
+	headers []string
+}
+
+func newSDFRecordWriter(w io.Writer, headers []string) (recordWriter, error) {
+	return &sdfRecordWriter{w: w, headers: headers}, nil
+}
+
+func (w *sdfRecordWriter) Write(record map[string]interface{}) error {
+	_, err := io.WriteString(w.w, sdfRecord(record, w.headers))
+	return err
+}
+
+func (w *sdfRecordWriter) Flush() error { return nil }
+
+func (w *sdfRecordWriter) Close() error { return nil }
+
+// columnarRecordWriter writes records as a Parquet or Arrow file with the
+// export headers as columns, typed as their database columns
+type columnarRecordWriter struct {
+	w       *columnar.Writer
+	headers []string
+	values  []interface{}
+}
+
+func newColumnarRecordWriter(format string) func(w io.Writer, headers []string) (recordWriter, error) {
+	return func(w io.Writer, headers []string) (recordWriter, error) {
+		columns, _, err := exportColumns(headers)
+		if err != nil {
+			return nil, err
+		}
+		cw, err := columnar.NewWriter(w, format, columns)
+		if err != nil {
+			return nil, err
+		}
+		return &columnarRecordWriter{w: cw, headers: headers, values: make([]interface{}, len(columns))}, nil
+	}
+}
+
+func (w *columnarRecordWriter) Write(record map[string]interface{}) error {
+	for i, header := range w.headers {
+		w.values[i] = record[header]
+	}
+	return w.w.Append(w.values)
+}
+
+// Flush does nothing, since rows are written in batches of columnar.BatchRows
+func (w *columnarRecordWriter) Flush() error { return nil }
+
+func (w *columnarRecordWriter) Close() error { return w.w.Close() }
+
+// exportColumns returns the export headers with the types of their database
+// columns, and which of them are properties columns. Properties columns take
+// precedence, as in exportSelect records. Other columns are text.
+func exportColumns(headers []string) ([]columnar.Column, map[string]bool, error) {
+	types := make(map[string]columnar.Type)
+	properties := make(map[string]bool)
+	for _, table := range []string{"molecules", "properties"} {
+		columns, err := columnar.ColumnsOf(db, table)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, c := range columns {
+			types[c.Name] = c.Type
+			properties[c.Name] = table == "properties"
+		}
+	}
+	columns := make([]columnar.Column, len(headers))
+	for i, header := range headers {
+		columns[i] = columnar.Column{Name: header, Type: types[header]}
+	}
+	return columns, properties, nil
+}
+
+// exportSelect selects molecules joined with their properties, the records
+// written by exportRow. The molecule id is repeated as molecule_key since
+// properties.id shadows it. A molecule with several properties rows is
+// exported once, with the first of them, so that there is one record per
+// molecule and paging by molecule id neither drops nor repeats records.
+func exportSelect() *gorm.DB {
+	return db.Model(&models.Molecule{}).
+		Select("molecules.*, properties.*, molecules.id AS molecule_key").
+		Joins("LEFT JOIN properties ON properties.id = " +
+			"(SELECT MIN(first_properties.id) FROM properties AS first_properties WHERE first_properties.molecule_id = molecules.id)")
+}
+
+// exportRow formats an exported record as a CSV row of the headers
+func exportRow(record map[string]interface{}, headers []string) []string {
+	row := make([]string, len(headers))
+	for i, header := range headers {
+		value := record[header]
+		if value != nil {
+			row[i] = fmt.Sprintf("%v", value)
+		}
+	}
+	return row
+}
+
+// sdfRecord formats an exported record as an SD file record: the structure
+// drawn from canonical_smiles, then every non-empty export column as a data
+// item. A SMILES that cannot be read gives a record without atoms.
+func sdfRecord(record map[string]interface{}, headers []string) string {
+	identifier := fmt.Sprintf("%v", record["identifier"])
+	block := chem.EmptyMolBlock(identifier)
+	if smiles, ok := record["canonical_smiles"].(string); ok {
+		if mol, err := chem.ParseSMILES(smiles); err == nil {
+			block = mol.MolBlock(identifier)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(block)
+	for i, value := range exportRow(record, headers) {
+		// Data items end at a blank line, so blank lines are dropped
+		var lines []string
+		for _, line := range strings.Split(strings.ReplaceAll(value, "\r", ""), "\n") {
+			if strings.TrimSpace(line) != "" {
+				lines = append(lines, line)
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "> <%s>\n%s\n\n", headers[i], strings.Join(lines, "\n"))
+	}
+	b.WriteString("$$$$\n")
+	return b.String()
+}
+
+// streamRecords writes every molecule matching the filter in molecule id
+// order, one page at a time, calling progress with the rows written so far
+// after each page. With lists, the relation list columns are added to the
+// records. It returns the number of rows written.
+func streamRecords(filter *query.Filter, lists bool, w recordWriter, progress func(rows int64)) (int64, error) {
+	var rows, last int64
+	for {
+		var records []map[string]interface{}
+		err := filter.Apply(exportSelect()).
+			Where("molecules.id > ?", last).
+			Order("molecules.id").
+			Limit(exportPageSize).
+			Find(&records).Error
+		if err == nil && lists {
+			err = addRelationLists(records)
+		}
+		if err != nil {
+			return rows, err
+		}
+
+		for _, record := range records {
+			if err := w.Write(record); err != nil {
+				return rows, err
+			}
+			rows++
+		}
+		if err := w.Flush(); err != nil {
+			return rows, err
+		}
+		if progress != nil {
+			progress(rows)
+		}
+
+		if len(records) < exportPageSize {
+			return rows, nil
+		}
+		key, ok := records[len(records)-1]["molecule_key"].(int64)
+		if !ok {
+			return rows, fmt.Errorf("unexpected molecule id %v", records[len(records)-1]["molecule_key"])
+		}
+		last = key
+	}
+}
+
+// exportArchive is the container of an export: a zip holding the query
+// that produced the export and the data file, or a gzip of the data alone
+type exportArchive struct {
+	zip  *zip.Writer
+	gzip *gzip.Writer
+	data io.Writer
+}
+
+// exportCompressions lists the archive types and their content types
+var exportCompressions = map[string]string{
+	"zip":  "application/zip",
+	"gzip": "application/gzip",
+}
+
+// newExportArchive starts an archive on w and returns it ready for the data
+// file. queryName and fullQuery are only written to zip archives.
+func newExportArchive(w io.Writer, compression, queryName, fullQuery, dataName string) (*exportArchive, error) {
+	a := &exportArchive{}
+	if compression == "gzip" {
+		a.gzip = gzip.NewWriter(w)
+		a.gzip.Name = dataName
+		a.data = a.gzip
+		return a, nil
+	}
+
+	a.zip = zip.NewWriter(w)
+	queryFile, err := a.zip.Create(queryName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(queryFile, fullQuery); err != nil {
+		return nil, err
+	}
+	if a.data, err = a.zip.Create(dataName); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Fail records that the export stopped after rows records. A zip gets an
+// error file and stays readable; a gzip is left without its end marker so
+// that decompressing it reports the truncation.
+func (a *exportArchive) Fail(rows int64, cause error) error {
+	if a.zip == nil {
+		err := a.gzip.Flush()
+		a.gzip = nil
+		return err
+	}
+	errorFile, err := a.zip.Create(exportErrorFile)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(errorFile, "Export failed after %d records: %v\nThe data file is incomplete.\n", rows, cause)
+	return err
+}
+
+// Close finishes the archive
+func (a *exportArchive) Close() error {
+	switch {
+	case a.zip != nil:
+		return a.zip.Close()
+	case a.gzip != nil:
+		return a.gzip.Close()
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+/*
+ * MarineNP Export Jobs
+ * Purpose: Run large molecule exports in the background
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file queues export requests as jobs that a bounded pool of workers
+ * writes to files, independently of the request that created them. Clients
+ * poll a job for its progress and download the finished file, which is kept
+ * for the configured retention period. Jobs are held in memory, so jobs and
+ * their files do not outlive a restart of the server.
+ */
+
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"marinenp/models"
+	"marinenp/query"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Export job statuses
+const (
+	ExportQueued    = "queued"
+	ExportRunning   = "running"
+	ExportCompleted = "completed"
+	ExportFailed    = "failed"
+)
+
+// ExportJob is an export written in the background
+type ExportJob struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	Format      string     `json:"format"`
+	Compression string     `json:"compression"`
+	Relations   string     `json:"relations"`
+	Rows        int64      `json:"rows"`  // Rows written so far
+	Total       int64      `json:"total"` // Rows matching the filter, known once running
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"` // When the job and its file are removed
+	DownloadURL string     `json:"download_url,omitempty"`
+
+	filter   *query.Filter
+	opts     exportOptions
+	path     string
+	fileName string
+}
+
+// exportJobQueue holds the export jobs and feeds them to the workers
+type exportJobQueue struct {
+	mu        sync.Mutex
+	jobs      map[string]*ExportJob
+	queue     chan *ExportJob
+	dir       string
+	retention time.Duration
+}
+
+// exportJobs is nil until StartExportJobs is called
+var exportJobs *exportJobQueue
+
+// StartExportJobs starts the export workers, writing files to dir and
+// removing finished jobs after retention. At most workers exports run at a
+// time and up to queueSize more wait for a worker.
+func StartExportJobs(dir string, workers, queueSize int, retention time.Duration) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// Files left by an earlier run belong to jobs that no longer exist
+	leftovers, err := filepath.Glob(filepath.Join(dir, "export-*"))
+	if err != nil {
+		return err
+	}
+	for _, path := range leftovers {
+		os.Remove(path)
+	}
+
+	q := &exportJobQueue{
+		jobs:      make(map[string]*ExportJob),
+		queue:     make(chan *ExportJob, queueSize),
+		dir:       dir,
+		retention: retention,
+	}
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	go q.expire()
+	exportJobs = q
+	return nil
+}
+
+// work runs queued jobs one at a time
+func (q *exportJobQueue) work() {
+	for job := range q.queue {
+		q.run(job)
+	}
+}
+
+// run writes the export of a job to its file, updating its progress. Failed
+// exports are not kept.
+func (q *exportJobQueue) run(job *ExportJob) {
+	started := time.Now().UTC()
+	q.update(job, func() {
+		job.Status = ExportRunning
+		job.StartedAt = &started
+	})
+
+	var total int64
+	err := job.filter.Apply(db.Model(&models.Molecule{})).Count(&total).Error
+	if err == nil {
+		q.update(job, func() { job.Total = total })
+		err = q.write(job)
+	}
+
+	finished := time.Now().UTC()
+	expires := finished.Add(q.retention)
+	q.update(job, func() {
+		job.FinishedAt = &finished
+		job.ExpiresAt = &expires
+		if err != nil {
+			job.Status = ExportFailed
+			job.Error = err.Error()
+			return
+		}
+		job.Status = ExportCompleted
+		job.DownloadURL = "/api/v1/exports/" + job.ID + "/download"
+	})
+	if err != nil {
+		log.Printf("Export job %s failed: %v", job.ID, err)
+		os.Remove(job.path)
+	}
+}
+
+// write streams the export into a partial file, renamed into place once
+// complete so that only finished exports can be downloaded
+func (q *exportJobQueue) write(job *ExportJob) error {
+	partial := job.path + ".part"
+	file, err := os.Create(partial)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(partial)
+
+	_, err = writeExport(file, job.filter, job.opts, func(rows int64) {
+		q.update(job, func() { job.Rows = rows })
+	})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(partial, job.path)
+}
+
+// expire removes finished jobs and their files once their retention ends
+func (q *exportJobQueue) expire() {
+	interval := time.Minute
+	if q.retention < interval {
+		interval = q.retention
+	}
+	for now := range time.Tick(interval) {
+		q.mu.Lock()
+		for id, job := range q.jobs {
+			if job.ExpiresAt != nil && now.After(*job.ExpiresAt) {
+				os.Remove(job.path)
+				delete(q.jobs, id)
+			}
+		}
+		q.mu.Unlock()
+	}
+}
+
+// update changes a job while holding the lock
+func (q *exportJobQueue) update(job *ExportJob, change func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	change()
+}
+
+// get returns a copy of a job, or false if it does not exist or has expired
+func (q *exportJobQueue) get(id string) (ExportJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return ExportJob{}, false
+	}
+	return *job, true
+}
+
+// newExportJobID returns a random job id, which also serves as the only
+// credential needed to read the job
+func newExportJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// CreateExportJob handles POST /api/v1/exports
+// The body is a JSON search filter as for POST /api/v1/molecules/export, and
+// the format and compression parameters are the same. The job is queued and
+// returned with HTTP status 202; it runs even if the client disconnects.
+func CreateExportJob(c *gin.Context) {
+	if exportJobs == nil {
+		ErrorResponse(c, 503, "Export jobs are not available")
+		return
+	}
+	filter, ok := parseFilter(c)
+	if !ok {
+		return
+	}
+	opts, ok := parseExportOptions(c)
+	if !ok {
+		return
+	}
+
+	id, err := newExportJobID()
+	if err != nil {
+		ErrorResponse(c, 500, "Failed to create export job")
+		return
+	}
+	now := time.Now().UTC()
+	job := &ExportJob{
+		ID:          id,
+		Status:      ExportQueued,
+		Format:      opts.Format,
+		Compression: opts.Compression,
+		Relations:   opts.Relations,
+		CreatedAt:   now,
+		filter:      filter,
+		opts:        opts,
+		path:        filepath.Join(exportJobs.dir, "export-"+id),
+		fileName:    opts.FileName(now),
+	}
+
+	exportJobs.mu.Lock()
+	select {
+	case exportJobs.queue <- job:
+		exportJobs.jobs[id] = job
+	default:
+		exportJobs.mu.Unlock()
+		ErrorResponse(c, 503, "Too many export jobs are waiting, try again later")
+		return
+	}
+	snapshot := *job
+	exportJobs.mu.Unlock()
+
+	c.Header("Location", "/api/v1/exports/"+id)
+	c.JSON(http.StatusAccepted, Response{
+		Status: 0,
+		Msg:    "Export job queued",
+		Data:   snapshot,
+	})
+}
+
+// GetExportJob handles GET /api/v1/exports/:id
+func GetExportJob(c *gin.Context) {
+	if exportJobs == nil {
+		ErrorResponse(c, 503, "Export jobs are not available")
+		return
+	}
+	job, ok := exportJobs.get(c.Param("id"))
+	if !ok {
+		ErrorResponse(c, 404, "Export job not found or expired")
+		return
+	}
+	SuccessResponse(c, job)
+}
+
+// DownloadExportJob handles GET /api/v1/exports/:id/download
+func DownloadExportJob(c *gin.Context) {
+	if exportJobs == nil {
+		ErrorResponse(c, 503, "Export jobs are not available")
+		return
+	}
+	job, ok := exportJobs.get(c.Param("id"))
+	if !ok {
+		ErrorResponse(c, 404, "Export job not found or expired")
+		return
+	}
+	if job.Status != ExportCompleted {
+		ErrorResponse(c, 409, fmt.Sprintf("Export job is %s", job.Status))
+		return
+	}
+	c.Header("Content-Type", job.opts.ContentType())
+	c.Header("X-Export-Rows", fmt.Sprintf("%d", job.Rows))
+	c.FileAttachment(job.path, job.fileName)
+}
@@ -0,0 +1,131 @@
+/*
+ * MarineNP InChIKey Handlers
+ * Purpose: HTTP handlers for looking up molecules by InChIKey
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file resolves full standard InChIKeys and their 14-character
+ * connectivity block. Connectivity lookups return every stereoisomer and
+ * tautomer sharing the skeleton, grouped under their parent molecule.
+ */
+
+package handlers
+
+import (
+	"marinenp/models"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// inchiKeyPattern matches a full standard InChIKey
+	inchiKeyPattern = regexp.MustCompile(`^[A-Z]{14}-[A-Z]{10}-[A-Z]$`)
+	// connectivityPattern matches the connectivity block of an InChIKey
+	connectivityPattern = regexp.MustCompile(`^[A-Z]{14}$`)
+)
+
+// VariantGroup is a parent molecule with the variants found for a lookup
+type VariantGroup struct {
+	ParentID int64             `json:"parent_id"`
+	Parent   *models.Molecule  `json:"parent"`   // Nil if the parent is not in the database
+	Variants []models.Molecule `json:"variants"` // Matched molecules other than the parent
+}
+
+// GetMoleculesByInChIKey handles GET /api/v1/molecules/inchikey/:key
+// The key is either a full standard InChIKey or its first 14 characters; the
+// latter matches every stereoisomer and tautomer with the same connectivity.
+func GetMoleculesByInChIKey(c *gin.Context) {
+	key := strings.ToUpper(strings.TrimSpace(c.Param("key")))
+
+	query := db.Preload("Properties").
+		Preload("Organisms", "is_marine = TRUE").
+		Where("molecules.is_marine = TRUE")
+	match := "full"
+	switch {
+	case inchiKeyPattern.MatchString(key):
+		query = query.Where("standard_inchi_key = ?", key)
+	case connectivityPattern.MatchString(key):
+		match = "connectivity"
+		query = query.Where("standard_inchi_key LIKE ?", key+"-%")
+	default:
+		ErrorResponse(c, 400, "Expected a standard InChIKey or its 14-character connectivity block")
+		return
+	}
+
+	var molecules []models.Molecule
+	if err := query.Order("molecules.id").Find(&molecules).Error; err != nil {
+		ErrorResponse(c, 500, "Failed to look up InChIKey")
+		return
+	}
+	if len(molecules) == 0 {
+		ErrorResponse(c, 404, "Molecule not found")
+		return
+	}
+
+	groups, err := groupVariants(molecules)
+	if err != nil {
+		ErrorResponse(c, 500, "Failed to load parent molecules")
+		return
+	}
+
+	SuccessResponse(c, gin.H{
+		"inchikey": key,
+		"match":    match,
+		"groups":   groups,
+		"total":    len(molecules),
+	})
+}
+
+// groupVariants groups molecules under their parent, using parent_id for
+// variants and the molecule itself for parents and standalone molecules.
+// Parents that were not among the molecules are loaded.
+func groupVariants(molecules []models.Molecule) ([]*VariantGroup, error) {
+	byParent := map[int64]*VariantGroup{}
+	var missing []int64
+	for i := range molecules {
+		m := &molecules[i]
+		parentID := m.ID
+		if m.ParentID > 0 && !m.IsParent {
+			parentID = int64(m.ParentID)
+		}
+		group, ok := byParent[parentID]
+		if !ok {
+			group = &VariantGroup{ParentID: parentID, Variants: []models.Molecule{}}
+			byParent[parentID] = group
+		}
+		if m.ID == parentID {
+			group.Parent = m
+		} else {
+			group.Variants = append(group.Variants, *m)
+		}
+	}
+
+	for id, group := range byParent {
+		if group.Parent == nil {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		var parents []models.Molecule
+		err := db.Preload("Properties").
+			Preload("Organisms", "is_marine = TRUE").
+			Where("id IN ?", missing).
+			Find(&parents).Error
+		if err != nil {
+			return nil, err
+		}
+		for i := range parents {
+			byParent[parents[i].ID].Parent = &parents[i]
+		}
+	}
+
+	groups := make([]*VariantGroup, 0, len(byParent))
+	for _, group := range byParent {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].ParentID < groups[j].ParentID })
+	return groups, nil
+}
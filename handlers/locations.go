@@ -16,6 +16,7 @@ import (
 	"io"
 	"log"
 	"marinenp/models"
+	"marinenp/rdf"
 	"net/http"
 	"strconv"
 	"strings"
@@ -82,8 +83,9 @@ func GetLocations(c *gin.Context) {
 }
 
 // GetLocationByID handles GET /api/v1/locations/:id
+// The location is JSON, or RDF when negotiated as in rdfRequest.
 func GetLocationByID(c *gin.Context) {
-	id := c.Param("id")
+	id, format := rdfRequest(c, c.Param("id"))
 	var location models.GeoLocation
 
 	result := db.Preload("Molecules", func(db *gorm.DB) *gorm.DB {
@@ -95,6 +97,11 @@ func GetLocationByID(c *gin.Context) {
 		return
 	}
 
+	if format != "" {
+		sendRDF(c, format, func(r rdf.Resources, g *rdf.Graph) { r.AddLocation(g, &location) })
+		return
+	}
+
 	SuccessResponse(c, location)
 }
 
@@ -11,16 +11,15 @@
 package handlers
 
 import (
-	"archive/zip"
-	"bytes"
-	"encoding/csv"
 	"fmt"
-	"io"
+	"marinenp/fulltext"
 	"marinenp/models"
+	"marinenp/query"
+	"marinenp/rdf"
 	"math"
 	"net/http"
+	"sort"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -28,8 +27,10 @@ import (
 )
 
 // GetMoleculeByID handles GET /api/v1/molecules/:identifier
+// The molecule is JSON with its citations, or RDF when negotiated as in
+// rdfRequest.
 func GetMoleculeByID(c *gin.Context) {
-	identifier := c.Param("identifier")
+	identifier, format := rdfRequest(c, c.Param("identifier"))
 	var molecule models.Molecule
 
 	result := db.Preload("Properties").
@@ -43,6 +44,33 @@ func GetMoleculeByID(c *gin.Context) {
 		return
 	}
 
+	citations, err := models.MoleculeCitations(db, []models.Molecule{molecule})
+	if err != nil {
+		ErrorResponse(c, 500, "Failed to fetch citations")
+		return
+	}
+	molecule.Citations = citations[molecule.ID]
+	if err := loadCitationMetadata(molecule.Citations); err != nil {
+		ErrorResponse(c, 500, "Failed to fetch citations")
+		return
+	}
+
+	if format != "" {
+		sendRDF(c, format, func(r rdf.Resources, g *rdf.Graph) {
+			r.AddMolecule(g, &molecule, molecule.Citations)
+			for i := range molecule.Organisms {
+				r.AddOrganism(g, &molecule.Organisms[i])
+			}
+			for i := range molecule.Citations {
+				r.AddCitation(g, &molecule.Citations[i])
+			}
+			for i := range molecule.GeoLocations {
+				r.AddLocation(g, &molecule.GeoLocations[i])
+			}
+		})
+		return
+	}
+
 	SuccessResponse(c, molecule)
 }
 
@@ -74,261 +102,23 @@ func GetPropertyRanges(c *gin.Context) {
 	SuccessResponse(c, ranges)
 }
 
-// SearchMolecules handles GET /api/v1/molecules/search
+// SearchMolecules handles GET and POST /api/v1/molecules/search
 func SearchMolecules(c *gin.Context) {
+	filter, ok := parseFilter(c)
+	if !ok {
+		return
+	}
+	searchMolecules(c, filter)
+}
+
+// searchMolecules responds with one page of the molecules matching the filter
+func searchMolecules(c *gin.Context, filter *query.Filter) {
 	params := ParseQueryParams(c)
 	var molecules []models.Molecule
 	var total int64
 
-	// Build the base query, keeping the original constraint.
-	query := db.Model(&models.Molecule{})
-
-	// --- Dynamic Query Parameter Parsing ---
-	// Get all query parameters from the URL to handle flexible filtering.
-	queryParams := c.Request.URL.Query()
-
-	// Debug: Print all query parameters
-	fmt.Printf("Query Parameters: %+v\n", queryParams)
-
-	// Handle conditions array if present
-	conditions := make([]struct {
-		Field    string `json:"field"`
-		Operator string `json:"operator"`
-		Value    string `json:"value"`
-	}, 0)
-
-	// Parse conditions from query parameters
-	i := 0
-	for {
-		field := queryParams.Get(fmt.Sprintf("conditions[%d][field]", i))
-		operator := queryParams.Get(fmt.Sprintf("conditions[%d][operator]", i))
-		value := queryParams.Get(fmt.Sprintf("conditions[%d][value]", i))
-
-		if field == "" && operator == "" && value == "" {
-			break
-		}
-
-		conditions = append(conditions, struct {
-			Field    string `json:"field"`
-			Operator string `json:"operator"`
-			Value    string `json:"value"`
-		}{
-			Field:    field,
-			Operator: operator,
-			Value:    value,
-		})
-		i++
-	}
-
-	fmt.Printf("Parsed conditions: %+v\n", conditions)
-
-	// Check if we need to join with properties table
-	needsPropertiesJoin := false
-	needsOrganismJoin := false
-	for _, condition := range conditions {
-		if strings.HasPrefix(condition.Field, "properties.") {
-			needsPropertiesJoin = true
-		}
-		if condition.Field == "organism" || condition.Field == "organism_id" {
-			needsOrganismJoin = true
-		}
-	}
-
-	// Join with properties table if needed
-	if needsPropertiesJoin {
-		query = query.Joins("JOIN properties ON properties.molecule_id = molecules.id")
-	}
-
-	// Join with organism table if needed
-	if needsOrganismJoin {
-		query = query.Joins("JOIN molecule_organism ON molecule_organism.molecule_id = molecules.id").
-			Joins("JOIN organisms ON organisms.id = molecule_organism.organism_id")
-		query = query.Where("organisms.is_marine = TRUE")
-	} else {
-		query = query.Where("molecules.is_marine = TRUE")
-	}
-
-	// Apply each condition to the query
-	for _, condition := range conditions {
-		// Skip if any part is missing
-		if condition.Field == "" || condition.Operator == "" || condition.Value == "" {
-			fmt.Printf("Skipping condition due to missing parts: %+v\n", condition)
-			continue
-		}
-
-		// Handle organism filter
-		if condition.Field == "organism" {
-			sqlOperator := ""
-			switch condition.Operator {
-			case "eq":
-				sqlOperator = "="
-			case "ne":
-				sqlOperator = "!="
-			case "contains":
-				sqlOperator = "LIKE"
-				condition.Value = "%" + strings.ToLower(condition.Value) + "%"
-			case "startsWith":
-				sqlOperator = "LIKE"
-				condition.Value = strings.ToLower(condition.Value) + "%"
-			case "endsWith":
-				sqlOperator = "LIKE"
-				condition.Value = "%" + strings.ToLower(condition.Value)
-			default:
-				fmt.Printf("Invalid operator for organism filter: %s\n", condition.Operator)
-				continue
-			}
-			query = query.Where(
-				"(LOWER(organisms.name) "+sqlOperator+" ? OR "+
-				"LOWER(organisms.iri) "+sqlOperator+" ? OR "+
-				"LOWER(organisms.slug) "+sqlOperator+" ? OR "+
-				"LOWER(organisms.name_aphia_worms) "+sqlOperator+" ?)",
-				condition.Value, condition.Value, condition.Value, condition.Value)
-			continue
-		}
-
-		// Handle organism_id filter
-		if condition.Field == "organism_id" {
-			sqlOperator := ""
-			switch condition.Operator {
-			case "eq":
-				sqlOperator = "="
-			case "ne":
-				sqlOperator = "!="
-			default:
-				fmt.Printf("Invalid operator for organism_id filter: %s\n", condition.Operator)
-				continue
-			}
-			// Convert value to integer
-			organismID, err := strconv.Atoi(condition.Value)
-			if err != nil {
-				fmt.Printf("Invalid organism_id value: %s\n", condition.Value)
-				continue
-			}
-			// Use the integer value directly
-			query = query.Where("molecule_organism.organism_id "+sqlOperator+" ?", organismID)
-			continue
-		}
-
-		// Handle property filters
-		if strings.HasPrefix(condition.Field, "properties.") {
-			propertyField := strings.TrimPrefix(condition.Field, "properties.")
-			
-			sqlOperator := ""
-			switch condition.Operator {
-			case "eq":
-				sqlOperator = "="
-			case "ne":
-				sqlOperator = "!="
-			case "lt":
-				sqlOperator = "<"
-			case "lte":
-				sqlOperator = "<="
-			case "gt":
-				sqlOperator = ">"
-			case "gte":
-				sqlOperator = ">="
-			case "contains":
-				sqlOperator = "LIKE"
-				condition.Value = "%" + strings.ToLower(condition.Value) + "%"
-			case "startsWith":
-				sqlOperator = "LIKE"
-				condition.Value = strings.ToLower(condition.Value) + "%"
-			case "endsWith":
-				sqlOperator = "LIKE"
-				condition.Value = "%" + strings.ToLower(condition.Value)
-			default:
-				fmt.Printf("Invalid operator for property filter: %s\n", condition.Operator)
-				continue
-			}
-
-			// Check if the field is numeric
-			isNumeric := false
-			switch propertyField {
-			case "heavy_atom_count", "total_atom_count", "rotatable_bond_count",
-				"hydrogen_bond_acceptors", "hydrogen_bond_donors",
-				"hydrogen_bond_acceptors_lipinski", "hydrogen_bond_donors_lipinski",
-				"lipinski_rule_of_five_violations", "aromatic_rings_count",
-				"number_of_minimal_rings", "molecular_weight", "exact_molecular_weight",
-				"alogp", "topological_polar_surface_area", "formal_charge",
-				"van_der_walls_volume", "qed_drug_likeliness", "np_likeness",
-				"fractioncsp3":
-				isNumeric = true
-			}
-
-			if isNumeric {
-				// For numeric fields, use CAST to ensure proper numeric comparison
-				query = query.Where("CAST(properties."+propertyField+" AS REAL) "+sqlOperator+" ?", condition.Value)
-			} else {
-				query = query.Where("properties."+propertyField+" "+sqlOperator+" ?", condition.Value)
-			}
-		} else {
-			// Handle direct field filters
-			sqlOperator := ""
-			switch condition.Operator {
-			case "eq":
-				sqlOperator = "="
-			case "ne":
-				sqlOperator = "!="
-			case "lt":
-				sqlOperator = "<"
-			case "lte":
-				sqlOperator = "<="
-			case "gt":
-				sqlOperator = ">"
-			case "gte":
-				sqlOperator = ">="
-			case "contains":
-				sqlOperator = "LIKE"
-				condition.Value = "%" + strings.ToLower(condition.Value) + "%"
-			case "startsWith":
-				sqlOperator = "LIKE"
-				condition.Value = strings.ToLower(condition.Value) + "%"
-			case "endsWith":
-				sqlOperator = "LIKE"
-				condition.Value = "%" + strings.ToLower(condition.Value)
-			default:
-				fmt.Printf("Invalid operator for direct field filter: %s\n", condition.Operator)
-				continue
-			}
-
-			// Check if the field is numeric
-			isNumeric := false
-			switch condition.Field {
-			case "id", "organism_count", "geo_count", "citation_count", "collection_count",
-				"synonym_count", "variants_count":
-				isNumeric = true
-			}
-
-			if isNumeric {
-				// For numeric fields, use CAST to ensure proper numeric comparison
-				query = query.Where("CAST("+condition.Field+" AS INTEGER) "+sqlOperator+" ?", condition.Value)
-			} else {
-				query = query.Where("LOWER("+condition.Field+") "+sqlOperator+" ?", condition.Value)
-			}
-		}
-	}
-
-	// Handle keyword search if present
-	if keyword := queryParams.Get("keyword"); keyword != "" {
-		searchValue := "%" + strings.ToLower(keyword) + "%"
-		query = query.Where(
-			"LOWER(name) LIKE ? OR " +
-			"LOWER(canonical_smiles) LIKE ? OR " +
-			"LOWER(identifier) LIKE ? OR " +
-			"LOWER(cas) LIKE ? OR " +
-			"LOWER(synonyms) LIKE ? OR " +
-			"LOWER(iupac_name) LIKE ? OR " +
-			"LOWER(standard_inchi) LIKE ? OR " +
-			"LOWER(standard_inchi_key) LIKE ?",
-			searchValue, searchValue, searchValue, searchValue, searchValue,
-			searchValue, searchValue, searchValue)
-	}
-
-	// Debug: Print the final SQL query
-	sql := query.ToSQL(func(tx *gorm.DB) *gorm.DB {
-		return tx.Find(&models.Molecule{})
-	})
-	fmt.Printf("Final SQL Query: %s\n", sql)
+	// Build the filtered query
+	query := filter.Apply(db.Model(&models.Molecule{}))
 
 	// Get total count *after* all filtering has been applied
 	if err := query.Count(&total).Error; err != nil {
@@ -336,18 +126,8 @@ func SearchMolecules(c *gin.Context) {
 		return
 	}
 
-	// Apply ordering using parameters from the helper function
-	if params.OrderByString != "" {
-		order := params.OrderByString
-		if params.OrderDir == "desc" {
-			order += " DESC"
-		}
-		query = query.Order("molecules.id ASC").Order(order)
-	} else {
-		query = query.Order("molecules.id ASC")
-	}
-
-	// Apply pagination using parameters from the helper function
+	// Apply ordering and pagination
+	query = filter.ApplyOrder(query)
 	offset := (params.PageNumber - 1) * params.PerPageNumber
 	query = query.Offset(offset).Limit(params.PerPageNumber)
 
@@ -362,466 +142,186 @@ func SearchMolecules(c *gin.Context) {
 		return
 	}
 
-	// Marshal the response using our custom marshaler
-	jsonData, err := models.MarshalToJSON(gin.H{
+	response := gin.H{
 		"molecules": molecules,
 		"total":    total,
-	})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to marshal response"})
-		return
 	}
 
-	c.Data(http.StatusOK, "application/json", jsonData)
-}
-
-// ExportMolecules handles GET /api/v1/molecules/export
-func ExportMolecules(c *gin.Context) {
-	params := ParseQueryParams(c)
-
-	// Build the base query, keeping the original constraint.
-	query := db.Model(&models.Molecule{})
-
-	// --- Dynamic Query Parameter Parsing ---
-	// Get all query parameters from the URL to handle flexible filtering.
-	queryParams := c.Request.URL.Query()
-
-	// Handle conditions array if present
-	conditions := make([]struct {
-		Field    string `json:"field"`
-		Operator string `json:"operator"`
-		Value    string `json:"value"`
-	}, 0)
-
-	// Parse conditions from query parameters
-	i := 0
-	for {
-		field := queryParams.Get(fmt.Sprintf("conditions[%d][field]", i))
-		operator := queryParams.Get(fmt.Sprintf("conditions[%d][operator]", i))
-		value := queryParams.Get(fmt.Sprintf("conditions[%d][value]", i))
-
-		if field == "" && operator == "" && value == "" {
-			break
-		}
-
-		conditions = append(conditions, struct {
-			Field    string `json:"field"`
-			Operator string `json:"operator"`
-			Value    string `json:"value"`
-		}{
-			Field:    field,
-			Operator: operator,
-			Value:    value,
-		})
-		i++
+	// Suggest names close to a keyword that found nothing
+	if total == 0 && filter.Keyword != "" {
+		response["suggestions"] = suggest(filter.Keyword)
 	}
 
-	// Check if we need to join with properties table
-	needsPropertiesJoin := false
-	needsOrganismJoin := false
-	for _, condition := range conditions {
-		if strings.HasPrefix(condition.Field, "properties.") {
-			needsPropertiesJoin = true
+	// Add the matched snippets of a ranked keyword search, by molecule id
+	if match, ok := filter.KeywordMatch(); ok {
+		ids := make([]int64, len(molecules))
+		for i, molecule := range molecules {
+			ids[i] = molecule.ID
 		}
-		if condition.Field == "organism" || condition.Field == "organism_id" {
-			needsOrganismJoin = true
+		highlights, err := fulltext.Highlights(db, match, ids)
+		if err != nil {
+			ErrorResponse(c, 500, "Failed to highlight matches")
+			return
 		}
+		response["highlights"] = highlights
 	}
 
-	// Join with properties table if needed
-	if needsPropertiesJoin {
-		query = query.Joins("JOIN properties ON properties.molecule_id = molecules.id")
-	}
-
-	// Join with organism table if needed
-	if needsOrganismJoin {
-		query = query.Joins("JOIN molecule_organism ON molecule_organism.molecule_id = molecules.id").
-			Joins("JOIN organisms ON organisms.id = molecule_organism.organism_id")
-		query = query.Where("organisms.is_marine = TRUE")
-	} else {
-		query = query.Where("molecules.is_marine = TRUE")
+	// Marshal the response using our custom marshaler
+	jsonData, err := models.MarshalToJSON(response)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to marshal response"})
+		return
 	}
 
-	// Process conditions in batches to avoid parameter limit
-	const batchSize = 100
-	for i := 0; i < len(conditions); i += batchSize {
-		end := i + batchSize
-		if end > len(conditions) {
-			end = len(conditions)
-		}
-
-		batch := conditions[i:end]
-		for _, condition := range batch {
-			if condition.Field == "" || condition.Operator == "" || condition.Value == "" {
-				continue
-			}
-
-			// Handle organism filter
-			if condition.Field == "organism" {
-				sqlOperator := ""
-				switch condition.Operator {
-				case "eq":
-					sqlOperator = "="
-				case "ne":
-					sqlOperator = "!="
-				case "contains":
-					sqlOperator = "LIKE"
-					condition.Value = "%" + strings.ToLower(condition.Value) + "%"
-				case "startsWith":
-					sqlOperator = "LIKE"
-					condition.Value = strings.ToLower(condition.Value) + "%"
-				case "endsWith":
-					sqlOperator = "LIKE"
-					condition.Value = "%" + strings.ToLower(condition.Value)
-				default:
-					continue
-				}
-				query = query.Where(
-					"(LOWER(organisms.name) "+sqlOperator+" ? OR "+
-					"LOWER(organisms.iri) "+sqlOperator+" ? OR "+
-					"LOWER(organisms.slug) "+sqlOperator+" ? OR "+
-					"LOWER(organisms.name_aphia_worms) "+sqlOperator+" ?)",
-					condition.Value, condition.Value, condition.Value, condition.Value)
-				continue
-			}
-
-			// Handle organism_id filter
-			if condition.Field == "organism_id" {
-				sqlOperator := ""
-				switch condition.Operator {
-				case "eq":
-					sqlOperator = "="
-				case "ne":
-					sqlOperator = "!="
-				default:
-					fmt.Printf("Invalid operator for organism_id filter: %s\n", condition.Operator)
-					continue
-				}
-				// Convert value to integer
-				organismID, err := strconv.Atoi(condition.Value)
-				if err != nil {
-					fmt.Printf("Invalid organism_id value: %s\n", condition.Value)
-					continue
-				}
-				// Use the integer value directly
-				query = query.Where("molecule_organism.organism_id "+sqlOperator+" ?", organismID)
-				continue
-			}
-
-			// Handle property filters
-			if strings.HasPrefix(condition.Field, "properties.") {
-				propertyField := strings.TrimPrefix(condition.Field, "properties.")
-				
-				sqlOperator := ""
-				switch condition.Operator {
-				case "eq":
-					sqlOperator = "="
-				case "ne":
-					sqlOperator = "!="
-				case "lt":
-					sqlOperator = "<"
-				case "lte":
-					sqlOperator = "<="
-				case "gt":
-					sqlOperator = ">"
-				case "gte":
-					sqlOperator = ">="
-				case "contains":
-					sqlOperator = "LIKE"
-					condition.Value = "%" + strings.ToLower(condition.Value) + "%"
-				case "startsWith":
-					sqlOperator = "LIKE"
-					condition.Value = strings.ToLower(condition.Value) + "%"
-				case "endsWith":
-					sqlOperator = "LIKE"
-					condition.Value = "%" + strings.ToLower(condition.Value)
-				default:
-					fmt.Printf("Invalid operator for property filter: %s\n", condition.Operator)
-					continue
-				}
-
-				// Check if the field is numeric
-				isNumeric := false
-				switch propertyField {
-				case "heavy_atom_count", "total_atom_count", "rotatable_bond_count",
-					"hydrogen_bond_acceptors", "hydrogen_bond_donors",
-					"hydrogen_bond_acceptors_lipinski", "hydrogen_bond_donors_lipinski",
-					"lipinski_rule_of_five_violations", "aromatic_rings_count",
-					"number_of_minimal_rings", "molecular_weight", "exact_molecular_weight",
-					"alogp", "topological_polar_surface_area", "formal_charge",
-					"van_der_walls_volume", "qed_drug_likeliness", "np_likeness",
-					"fractioncsp3":
-					isNumeric = true
-				}
-
-				if isNumeric {
-					// For numeric fields, use CAST to ensure proper numeric comparison
-					query = query.Where("CAST(properties."+propertyField+" AS REAL) "+sqlOperator+" ?", condition.Value)
-				} else {
-					query = query.Where("properties."+propertyField+" "+sqlOperator+" ?", condition.Value)
-				}
-			} else {
-				// Handle direct field filters
-				sqlOperator := ""
-				switch condition.Operator {
-				case "eq":
-					sqlOperator = "="
-				case "ne":
-					sqlOperator = "!="
-				case "lt":
-					sqlOperator = "<"
-				case "lte":
-					sqlOperator = "<="
-				case "gt":
-					sqlOperator = ">"
-				case "gte":
-					sqlOperator = ">="
-				case "contains":
-					sqlOperator = "LIKE"
-					condition.Value = "%" + strings.ToLower(condition.Value) + "%"
-				case "startsWith":
-					sqlOperator = "LIKE"
-					condition.Value = strings.ToLower(condition.Value) + "%"
-				case "endsWith":
-					sqlOperator = "LIKE"
-					condition.Value = "%" + strings.ToLower(condition.Value)
-				default:
-					fmt.Printf("Invalid operator for direct field filter: %s\n", condition.Operator)
-					continue
-				}
-
-				// Check if the field is numeric
-				isNumeric := false
-				switch condition.Field {
-				case "id", "organism_count", "geo_count", "citation_count", "collection_count",
-					"synonym_count", "variants_count":
-					isNumeric = true
-				}
+	c.Data(http.StatusOK, "application/json", jsonData)
+}
 
-				if isNumeric {
-					// For numeric fields, use CAST to ensure proper numeric comparison
-					query = query.Where("CAST("+condition.Field+" AS INTEGER) "+sqlOperator+" ?", condition.Value)
-				} else {
-					query = query.Where("LOWER("+condition.Field+") "+sqlOperator+" ?", condition.Value)
-				}
-			}
-		}
-	}
+// filterDocumentKey is the context key holding the raw JSON filter of a POST request
+const filterDocumentKey = "filterDocument"
 
-	// Handle keyword search if present
-	if keyword := queryParams.Get("keyword"); keyword != "" {
-		searchValue := "%" + strings.ToLower(keyword) + "%"
-		query = query.Where(
-			"LOWER(name) LIKE ? OR " +
-			"LOWER(canonical_smiles) LIKE ? OR " +
-			"LOWER(identifier) LIKE ? OR " +
-			"LOWER(cas) LIKE ? OR " +
-			"LOWER(synonyms) LIKE ? OR " +
-			"LOWER(iupac_name) LIKE ? OR " +
-			"LOWER(standard_inchi) LIKE ? OR " +
-			"LOWER(standard_inchi_key) LIKE ?",
-			searchValue, searchValue, searchValue, searchValue, searchValue,
-			searchValue, searchValue, searchValue)
+// parseFilter parses the molecule search filter of the request, checks its
+// taxa and resolves its structure and formula conditions, responding with an
+// error and returning false if any fails
+func parseFilter(c *gin.Context) (*query.Filter, bool) {
+	filter, ok := decodeFilter(c)
+	if !ok || !checkTaxa(c, filter.Taxa()) || !resolveConditions(c, filter) {
+		return nil, false
 	}
+	return filter, true
+}
 
-	// Apply ordering
-	if params.OrderByString != "" {
-		order := params.OrderByString
-		if params.OrderDir == "desc" {
-			order += " DESC"
-		}
-		query = query.Order("molecules.id ASC").Order(order)
+// decodeFilter parses the molecule search filter of the request, responding
+// with a 400 error and returning false if it is invalid. GET requests carry the
+// filter in the query string, POST requests as a JSON document in the body.
+func decodeFilter(c *gin.Context) (*query.Filter, bool) {
+	var filter *query.Filter
+	var err error
+	if c.Request.Method == http.MethodPost {
+		var body []byte
+		if body, err = c.GetRawData(); err != nil {
+			ErrorResponse(c, 400, "Failed to read request body")
+			return nil, false
+		}
+		c.Set(filterDocumentKey, body)
+		filter, err = query.ParseJSON(body)
 	} else {
-		query = query.Order("molecules.id ASC")
-	}
-
-	// Create a buffer for CSV data
-	var csvBuffer bytes.Buffer
-	csvWriter := csv.NewWriter(&csvBuffer)
-
-	// Define organized headers in logical groups
-	headers := []string{
-		// Basic Information
-		"identifier",
-		"name",
-		"cas",
-		"iupac_name",
-		"synonyms",
-		"molecular_formula",
-		
-		// Structure Information
-		"canonical_smiles",
-		"sugar_free_smiles",
-		"standard_inchi",
-		"standard_inchi_key",
-		"murcko_framework",
-		"has_stereo",
-		
-		// Physical Properties
-		"molecular_weight",
-		"exact_molecular_weight",
-		"total_atom_count",
-		"heavy_atom_count",
-		"rotatable_bond_count",
-		"hydrogen_bond_acceptors",
-		"hydrogen_bond_donors",
-		"topological_polar_surface_area",
-		"alogp",
-		"formal_charge",
-		"van_der_walls_volume",
-		
-		// Chemical Classification
-		"chemical_class",
-		"chemical_sub_class",
-		"chemical_super_class",
-		"direct_parent_classification",
-		"np_classifier_class",
-		"np_classifier_superclass",
-		"np_classifier_pathway",
-		
-		// Drug-like Properties
-		"lipinski_rule_of_five_violations",
-		"hydrogen_bond_acceptors_lipinski",
-		"hydrogen_bond_donors_lipinski",
-		"qed_drug_likeliness",
-		"np_likeness",
-		
-		// Sugar Information
-		"contains_sugar",
-		"contains_linear_sugars",
-		"contains_ring_sugars",
-		"np_classifier_is_glycoside",
-		
-		// Ring Information
-		"aromatic_rings_count",
-		"number_of_minimal_rings",
-		"fractioncsp3",
-		
-		// Status and Metadata
-		"status",
-		"is_marine",
-		"citation_count",
-		"organism_count",
-		"geo_count",
+		filter, err = query.Parse(c.Request.URL.Query())
 	}
-
-	// Write header
-	if err := csvWriter.Write(headers); err != nil {
-		ErrorResponse(c, 500, "Failed to write CSV header")
-		return
+	if err != nil {
+		FilterErrorResponse(c, err)
+		return nil, false
 	}
+	return filter, true
+}
 
-	// Process in chunks
-	const chunkSize = 50000
-	offset := 0
-
-	// Create a fresh query for export using the model
-	exportQuery := db.Model(&models.Molecule{}).
-		Select("molecules.*, properties.*").
-		Joins("LEFT JOIN properties ON properties.molecule_id = molecules.id")
-
-	// Check if we need organism join
-	if needsOrganismJoin {
-		exportQuery = exportQuery.
-			Joins("LEFT JOIN molecule_organism ON molecule_organism.molecule_id = molecules.id").
-			Joins("LEFT JOIN organisms ON organisms.id = molecule_organism.organism_id")
-	}
+// exportHeaders are the CSV columns of a molecule export, in logical groups
+var exportHeaders = []string{
+	// Basic Information
+	"identifier",
+	"name",
+	"cas",
+	"iupac_name",
+	"synonyms",
+	"molecular_formula",
 	
-	// Apply the same conditions from the original query
-	exportQuery = exportQuery.Where(query.Statement.Clauses["WHERE"].Expression)
-
-	for {
-		var records []map[string]interface{}
-		
-		// Get a chunk of records using the export query
-		result := exportQuery.Offset(offset).Limit(chunkSize).Find(&records)
-
-		if result.Error != nil {
-			ErrorResponse(c, 500, "Failed to export molecules")
-			return
-		}
-
-		// If no more results, break
-		if len(records) == 0 {
-			break
-		}
-
-		// Write data rows for this chunk
-		for _, record := range records {
-			row := make([]string, len(headers))
-			for i, header := range headers {
-				value := record[header]
-				if value != nil {
-					row[i] = fmt.Sprintf("%v", value)
-				}
-			}
-			if err := csvWriter.Write(row); err != nil {
-				ErrorResponse(c, 500, "Failed to write CSV row")
-				return
-			}
-		}
+	// Structure Information
+	"canonical_smiles",
+	"sugar_free_smiles",
+	"standard_inchi",
+	"standard_inchi_key",
+	"murcko_framework",
+	"has_stereo",
+	
+	// Physical Properties
+	"molecular_weight",
+	"exact_molecular_weight",
+	"total_atom_count",
+	"heavy_atom_count",
+	"rotatable_bond_count",
+	"hydrogen_bond_acceptors",
+	"hydrogen_bond_donors",
+	"topological_polar_surface_area",
+	"alogp",
+	"formal_charge",
+	"van_der_walls_volume",
+	
+	// Chemical Classification
+	"chemical_class",
+	"chemical_sub_class",
+	"chemical_super_class",
+	"direct_parent_classification",
+	"np_classifier_class",
+	"np_classifier_superclass",
+	"np_classifier_pathway",
+	
+	// Drug-like Properties
+	"lipinski_rule_of_five_violations",
+	"hydrogen_bond_acceptors_lipinski",
+	"hydrogen_bond_donors_lipinski",
+	"qed_drug_likeliness",
+	"np_likeness",
+	
+	// Sugar Information
+	"contains_sugar",
+	"contains_linear_sugars",
+	"contains_ring_sugars",
+	"np_classifier_is_glycoside",
+	
+	// Ring Information
+	"aromatic_rings_count",
+	"number_of_minimal_rings",
+	"fractioncsp3",
+	
+	// Status and Metadata
+	"status",
+	"is_marine",
+	"citation_count",
+	"organism_count",
+	"geo_count",
+}
 
-		// Flush after each chunk
-		csvWriter.Flush()
-		if err := csvWriter.Error(); err != nil {
-			ErrorResponse(c, 500, "Failed to flush CSV data")
-			return
-		}
-		
-		// Move to next chunk
-		offset += chunkSize
-	}
 
-	// Final flush of CSV data
-	csvWriter.Flush()
-	if err := csvWriter.Error(); err != nil {
-		ErrorResponse(c, 500, "Failed to finalize CSV data")
+// ExportMolecules handles GET and POST /api/v1/molecules/export
+// The export is streamed in molecule id order as a CSV table, or an SD file
+// with format=sdf or a typed Parquet or Arrow IPC file with format=parquet or
+// format=arrow, inside a zip that also records the search, or with
+// compression=gzip as a gzipped data file alone. A failure part way through is
+// recorded in the zip as export-error.txt and in the X-Export-Error trailer.
+// With format=xlsx the export is an Excel workbook, sent once it is complete.
+// relations=files adds the organisms, locations and citations of the molecules
+// to the zip as relation files, and relations=columns adds them to each
+// molecule as |-delimited list columns.
+func ExportMolecules(c *gin.Context) {
+	filter, ok := parseFilter(c)
+	if !ok {
 		return
 	}
 
-	// Set headers for zip download
-	c.Header("Content-Type", "application/zip")
-	c.Header("Content-Disposition", fmt.Sprintf("attachment;filename=molecules_%s.zip", time.Now().Format("20060102_150405")))
-
-	// Create zip writer
-	zipWriter := zip.NewWriter(c.Writer)
-	defer zipWriter.Close()
-
-	// Create search-query.txt in the zip
-	queryFile, err := zipWriter.Create("search-query.txt")
-	if err != nil {
-		ErrorResponse(c, 500, "Failed to create query file")
+	opts, ok := parseExportOptions(c)
+	if !ok {
 		return
 	}
 
-	// Write the full search query to the file
-	fullQuery := fmt.Sprintf("/api/v1/molecules/search?%s", c.Request.URL.RawQuery)
-	if _, err := io.WriteString(queryFile, fullQuery); err != nil {
-		ErrorResponse(c, 500, "Failed to write query to file")
-		return
-	}
+	// Set headers for the download; the trailers are set once it is complete
+	c.Header("Content-Type", opts.ContentType())
+	c.Header("Content-Disposition", "attachment;filename="+opts.FileName(time.Now()))
+	c.Header("Trailer", "X-Export-Rows, X-Export-Error")
 
-	// Create CSV file in the zip
-	csvFile, err := zipWriter.Create("molecules.csv")
+	rows, err := writeExport(c.Writer, filter, opts, func(int64) { c.Writer.Flush() })
 	if err != nil {
-		ErrorResponse(c, 500, "Failed to create zip file")
-		return
-	}
-
-	// Write the CSV buffer to the zip file
-	if _, err := io.Copy(csvFile, &csvBuffer); err != nil {
-		ErrorResponse(c, 500, "Failed to write CSV to zip")
-		return
+		c.Writer.Header().Set("X-Export-Error", err.Error())
 	}
+	c.Writer.Header().Set("X-Export-Rows", strconv.FormatInt(rows, 10))
+}
 
-	// Close the zip writer
-	if err := zipWriter.Close(); err != nil {
-		ErrorResponse(c, 500, "Failed to close zip file")
-		return
-	}
+// citationDimensions are the citation metadata columns the molecules can be
+// counted by in bar charts, by analysis parameter
+var citationDimensions = map[string]string{
+	"journal":          "citation_metadata.journal",
+	"publication_year": "citation_metadata.year",
 }
 
-// AnalyzeMolecules handles GET /api/v1/molecules/analyze
+// AnalyzeMolecules handles GET and POST /api/v1/molecules/analyze
 func AnalyzeMolecules(c *gin.Context) {
 	parameter := c.Query("parameter")
 	chartType := c.Query("chart_type")
@@ -830,239 +330,44 @@ func AnalyzeMolecules(c *gin.Context) {
 		return
 	}
 
-	// Build the base query using the same logic as SearchMolecules
-	query := db.Model(&models.Molecule{})
-
-	// Get all query parameters from the URL
-	queryParams := c.Request.URL.Query()
-
-	// Handle conditions array if present
-	conditions := make([]struct {
-		Field    string `json:"field"`
-		Operator string `json:"operator"`
-		Value    string `json:"value"`
-	}, 0)
-
-	// Parse conditions from query parameters
-	i := 0
-	for {
-		field := queryParams.Get(fmt.Sprintf("conditions[%d][field]", i))
-		operator := queryParams.Get(fmt.Sprintf("conditions[%d][operator]", i))
-		value := queryParams.Get(fmt.Sprintf("conditions[%d][value]", i))
-
-		if field == "" && operator == "" && value == "" {
-			break
-		}
-
-		conditions = append(conditions, struct {
-			Field    string `json:"field"`
-			Operator string `json:"operator"`
-			Value    string `json:"value"`
-		}{
-			Field:    field,
-			Operator: operator,
-			Value:    value,
-		})
-		i++
-	}
-
-	// Check if we need to join with properties table
-	needsPropertiesJoin := false
-	needsOrganismJoin := false
-	for _, condition := range conditions {
-		if strings.HasPrefix(condition.Field, "properties.") {
-			needsPropertiesJoin = true
-		}
-		if condition.Field == "organism" || condition.Field == "organism_id" {
-			needsOrganismJoin = true
-		}
-	}
-
-	// Join with properties table if needed - only once
-	if needsPropertiesJoin {
-		query = query.Joins("JOIN properties ON properties.molecule_id = molecules.id")
-	}
-
-	// Join with organism table if needed
-	if needsOrganismJoin {
-		query = query.Joins("JOIN molecule_organism ON molecule_organism.molecule_id = molecules.id").
-			Joins("JOIN organisms ON organisms.id = molecule_organism.organism_id")
-		query = query.Where("organisms.is_marine = TRUE")
-	} else {
-		query = query.Where("molecules.is_marine = TRUE")
-	}
-
-	// Apply each condition to the query
-	for _, condition := range conditions {
-		// Skip if any part is missing
-		if condition.Field == "" || condition.Operator == "" || condition.Value == "" {
-			continue
+	// Validate the analysed parameter before it is used as a column name
+	switch chartType {
+	case "sunburst":
+		if parameter != "classifire" && parameter != "np_classifier" {
+			ErrorResponse(c, 400, fmt.Sprintf("Unsupported sunburst parameter: %s", parameter))
+			return
 		}
-
-		// Handle organism filter
-		if condition.Field == "organism" {
-			sqlOperator := ""
-			switch condition.Operator {
-			case "eq":
-				sqlOperator = "="
-			case "ne":
-				sqlOperator = "!="
-			case "contains":
-				sqlOperator = "LIKE"
-				condition.Value = "%" + strings.ToLower(condition.Value) + "%"
-			case "startsWith":
-				sqlOperator = "LIKE"
-				condition.Value = strings.ToLower(condition.Value) + "%"
-			case "endsWith":
-				sqlOperator = "LIKE"
-				condition.Value = "%" + strings.ToLower(condition.Value)
-			default:
-				continue
-			}
-			query = query.Where(
-				"(LOWER(organisms.name) "+sqlOperator+" ? OR "+
-				"LOWER(organisms.iri) "+sqlOperator+" ? OR "+
-				"LOWER(organisms.slug) "+sqlOperator+" ? OR "+
-				"LOWER(organisms.name_aphia_worms) "+sqlOperator+" ?)",
-				condition.Value, condition.Value, condition.Value, condition.Value)
-			continue
+	case "timeline":
+		if parameter != "np_classifier_pathway" && parameter != "phylum" {
+			ErrorResponse(c, 400, fmt.Sprintf("Unsupported timeline parameter: %s", parameter))
+			return
 		}
-
-		// Handle organism_id filter
-		if condition.Field == "organism_id" {
-			sqlOperator := ""
-			switch condition.Operator {
-			case "eq":
-				sqlOperator = "="
-			case "ne":
-				sqlOperator = "!="
-			default:
-				continue
-			}
-			organismID, err := strconv.Atoi(condition.Value)
-			if err != nil {
-				continue
-			}
-			query = query.Where("molecule_organism.organism_id "+sqlOperator+" ?", organismID)
-			continue
+	case "density":
+		field, ok := query.LookupProperty(parameter)
+		if !ok || (field.Kind != query.KindInt && field.Kind != query.KindFloat) {
+			ErrorResponse(c, 400, fmt.Sprintf("Unsupported density parameter: %s", parameter))
+			return
 		}
-
-		// Handle property filters
-		if strings.HasPrefix(condition.Field, "properties.") {
-			propertyField := strings.TrimPrefix(condition.Field, "properties.")
-			
-			sqlOperator := ""
-			switch condition.Operator {
-			case "eq":
-				sqlOperator = "="
-			case "ne":
-				sqlOperator = "!="
-			case "lt":
-				sqlOperator = "<"
-			case "lte":
-				sqlOperator = "<="
-			case "gt":
-				sqlOperator = ">"
-			case "gte":
-				sqlOperator = ">="
-			case "contains":
-				sqlOperator = "LIKE"
-				condition.Value = "%" + strings.ToLower(condition.Value) + "%"
-			case "startsWith":
-				sqlOperator = "LIKE"
-				condition.Value = strings.ToLower(condition.Value) + "%"
-			case "endsWith":
-				sqlOperator = "LIKE"
-				condition.Value = "%" + strings.ToLower(condition.Value)
-			default:
-				continue
-			}
-
-			// Check if the field is numeric
-			isNumeric := false
-			switch propertyField {
-			case "heavy_atom_count", "total_atom_count", "rotatable_bond_count",
-				"hydrogen_bond_acceptors", "hydrogen_bond_donors",
-				"hydrogen_bond_acceptors_lipinski", "hydrogen_bond_donors_lipinski",
-				"lipinski_rule_of_five_violations", "aromatic_rings_count",
-				"number_of_minimal_rings", "molecular_weight", "exact_molecular_weight",
-				"alogp", "topological_polar_surface_area", "formal_charge",
-				"van_der_walls_volume", "qed_drug_likeliness", "np_likeness",
-				"fractioncsp3":
-				isNumeric = true
-			}
-
-			if isNumeric {
-				query = query.Where("CAST(properties."+propertyField+" AS REAL) "+sqlOperator+" ?", condition.Value)
-			} else {
-				query = query.Where("properties."+propertyField+" "+sqlOperator+" ?", condition.Value)
-			}
-		} else {
-			// Handle direct field filters
-			sqlOperator := ""
-			switch condition.Operator {
-			case "eq":
-				sqlOperator = "="
-			case "ne":
-				sqlOperator = "!="
-			case "lt":
-				sqlOperator = "<"
-			case "lte":
-				sqlOperator = "<="
-			case "gt":
-				sqlOperator = ">"
-			case "gte":
-				sqlOperator = ">="
-			case "contains":
-				sqlOperator = "LIKE"
-				condition.Value = "%" + strings.ToLower(condition.Value) + "%"
-			case "startsWith":
-				sqlOperator = "LIKE"
-				condition.Value = strings.ToLower(condition.Value) + "%"
-			case "endsWith":
-				sqlOperator = "LIKE"
-				condition.Value = "%" + strings.ToLower(condition.Value)
-			default:
-				continue
-			}
-
-			// Check if the field is numeric
-			isNumeric := false
-			switch condition.Field {
-			case "id", "organism_count", "geo_count", "citation_count", "collection_count",
-				"synonym_count", "variants_count":
-				isNumeric = true
-			}
-
-			if isNumeric {
-				query = query.Where("CAST("+condition.Field+" AS INTEGER) "+sqlOperator+" ?", condition.Value)
-			} else {
-				query = query.Where("LOWER("+condition.Field+") "+sqlOperator+" ?", condition.Value)
-			}
+	default:
+		if _, ok := query.LookupProperty(parameter); !ok && citationDimensions[parameter] == "" {
+			ErrorResponse(c, 400, fmt.Sprintf("Unsupported parameter: %s", parameter))
+			return
 		}
 	}
 
-	// Handle keyword search if present
-	if keyword := queryParams.Get("keyword"); keyword != "" {
-		searchValue := "%" + strings.ToLower(keyword) + "%"
-		query = query.Where(
-			"LOWER(name) LIKE ? OR " +
-			"LOWER(canonical_smiles) LIKE ? OR " +
-			"LOWER(identifier) LIKE ? OR " +
-			"LOWER(cas) LIKE ? OR " +
-			"LOWER(synonyms) LIKE ? OR " +
-			"LOWER(iupac_name) LIKE ? OR " +
-			"LOWER(standard_inchi) LIKE ? OR " +
-			"LOWER(standard_inchi_key) LIKE ?",
-			searchValue, searchValue, searchValue, searchValue, searchValue,
-			searchValue, searchValue, searchValue)
+	filter, ok := parseFilter(c)
+	if !ok {
+		return
 	}
 
-	// Remove any duplicate joins that might have been added
-	query = query.Distinct()
+	// Build the filtered query using the same logic as SearchMolecules
+	query := filter.Apply(db.Model(&models.Molecule{}))
 
 	// Handle different chart types
 	switch chartType {
+	case "timeline":
+		analyzeTimeline(c, query, parameter)
+
 	case "sunburst":
 		// First get the filtered molecule IDs
 		var moleculeIDs []uint
@@ -1323,9 +628,27 @@ func AnalyzeMolecules(c *gin.Context) {
 			Count int64  `gorm:"column:count"`
 		}
 
-		query = query.Select("properties."+parameter+" as value, COUNT(*) as count").
-			Group("properties."+parameter).
-			Order("count DESC")
+		if column, ok := citationDimensions[parameter]; ok {
+			// Molecules are counted once per value of their citations. The
+			// year is an integer, so only text dimensions can be empty.
+			order := "count DESC"
+			present := column + " IS NOT NULL AND " + column + " != ''"
+			if parameter == "publication_year" {
+				order = "value"
+				present = column + " IS NOT NULL"
+			}
+			query = query.Joins(`JOIN citables ON citables.citable_id = molecules.id AND citables.citable_type = 'App\Models\Molecule'`).
+				Joins("JOIN citation_metadata ON citation_metadata.citation_id = citables.citation_id").
+				Where(present).
+				Select(column+" as value, COUNT(DISTINCT molecules.id) as count").
+				Group(column).
+				Order(order)
+		} else {
+			query = query.Joins("JOIN properties ON properties.molecule_id = molecules.id").
+				Select("properties."+parameter+" as value, COUNT(*) as count").
+				Group("properties."+parameter).
+				Order("count DESC")
+		}
 
 		if err := query.Find(&result).Error; err != nil {
 			ErrorResponse(c, 500, fmt.Sprintf("Failed to get categorical values: %v", err))
@@ -1348,4 +671,84 @@ func AnalyzeMolecules(c *gin.Context) {
 			"parameter": parameter,
 		})
 	}
-}
\ No newline at end of file
+}
+
+// analyzeTimeline responds with the number of molecules first reported in
+// each year, by NP Classifier pathway or by phylum of their marine organisms.
+// A molecule is first reported in the earliest publication year of its
+// citations; molecules with organisms of several phyla count for each.
+func analyzeTimeline(c *gin.Context, molecules *gorm.DB, parameter string) {
+	firstReported := molecules.
+		Select("molecules.id AS molecule_id, MIN(citation_metadata.year) AS year").
+		Joins(`JOIN citables ON citables.citable_id = molecules.id AND citables.citable_type = 'App\Models\Molecule'`).
+		Joins("JOIN citation_metadata ON citation_metadata.citation_id = citables.citation_id").
+		Where("citation_metadata.year IS NOT NULL").
+		Group("molecules.id")
+
+	timeline := db.Table("(?) AS first_reported", firstReported)
+	if parameter == "phylum" {
+		phyla := db.Table("molecule_organism").
+			Select("DISTINCT molecule_organism.molecule_id, organism_taxonomy.phylum").
+			Joins("JOIN organisms ON organisms.id = molecule_organism.organism_id AND organisms.is_marine = TRUE").
+			Joins("JOIN organism_taxonomy ON organism_taxonomy.aphiaid_worms = organisms.aphiaid_worms").
+			Where("organism_taxonomy.phylum != ''")
+		timeline = timeline.
+			Joins("LEFT JOIN (?) AS phyla ON phyla.molecule_id = first_reported.molecule_id", phyla).
+			Select("first_reported.year AS year, COALESCE(phyla.phylum, 'Unknown') AS series, COUNT(*) AS count")
+	} else {
+		timeline = timeline.
+			Joins("LEFT JOIN properties ON properties.molecule_id = first_reported.molecule_id").
+			Select("first_reported.year AS year, COALESCE(NULLIF(properties.np_classifier_pathway, ''), 'Unclassified') AS series, COUNT(*) AS count")
+	}
+
+	var rows []struct {
+		Year   int
+		Series string
+		Count  int64
+	}
+	if err := timeline.Group("year, series").Order("year, series").Find(&rows).Error; err != nil {
+		ErrorResponse(c, 500, fmt.Sprintf("Failed to analyze the timeline: %v", err))
+		return
+	}
+	if len(rows) == 0 {
+		SuccessResponse(c, gin.H{
+			"years":     []int{},
+			"series":    []struct{}{},
+			"parameter": parameter,
+		})
+		return
+	}
+
+	// Every year between the first and the last is listed, so that years
+	// without new molecules show as zero
+	first, last := rows[0].Year, rows[len(rows)-1].Year
+	years := make([]int, 0, last-first+1)
+	for year := first; year <= last; year++ {
+		years = append(years, year)
+	}
+	type series struct {
+		Name   string  `json:"name"`
+		Values []int64 `json:"values"`
+		Total  int64   `json:"total"`
+	}
+	var all []*series
+	byName := map[string]*series{}
+	for _, row := range rows {
+		s, ok := byName[row.Series]
+		if !ok {
+			s = &series{Name: row.Series, Values: make([]int64, len(years))}
+			byName[row.Series] = s
+			all = append(all, s)
+		}
+		s.Values[row.Year-first] += row.Count
+		s.Total += row.Count
+	}
+	// The largest series come first, as in the bar charts
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Total > all[j].Total })
+
+	SuccessResponse(c, gin.H{
+		"years":     years,
+		"series":    all,
+		"parameter": parameter,
+	})
+}
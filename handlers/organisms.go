@@ -11,9 +11,17 @@
 package handlers
 
 import (
+	"fmt"
+	"marinenp/config"
+	"marinenp/dwca"
+	"marinenp/fuzzy"
 	"marinenp/models"
+	"marinenp/query"
+	"marinenp/rdf"
 	"net/http"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -75,19 +83,25 @@ func GetOrganisms(c *gin.Context) {
 }
 
 // GetOrganismByID handles GET /api/v1/organisms/:id
+// The organism is JSON, or RDF when negotiated as in rdfRequest.
 func GetOrganismByID(c *gin.Context) {
-	id := c.Param("id")
+	id, format := rdfRequest(c, c.Param("id"))
 	var organism models.Organism
 
 	result := db.Preload("Molecules", func(db *gorm.DB) *gorm.DB {
 		return db.Select("id, name, canonical_smiles, identifier") // Only select necessary fields to prevent loops
-	}).First(&organism, id)
+	}).Preload("Taxonomy").First(&organism, id)
 
 	if result.Error != nil {
 		ErrorResponse(c, 404, "Organism not found")
 		return
 	}
 
+	if format != "" {
+		sendRDF(c, format, func(r rdf.Resources, g *rdf.Graph) { r.AddOrganism(g, &organism) })
+		return
+	}
+
 	SuccessResponse(c, organism)
 }
 
@@ -150,8 +164,9 @@ func GetMoleculesByOrganism(c *gin.Context) {
 func GetOrganismsAutocomplete(c *gin.Context) {
 	// Format response for autocomplete
 	type AutocompleteOption struct {
-		Label string `json:"label"`
-		Value int64  `json:"value"`
+		Label string  `json:"label"`
+		Value int64   `json:"value"`
+		Score float64 `json:"score,omitempty"`
 	}
 
 	search := c.Query("search")
@@ -183,5 +198,117 @@ func GetOrganismsAutocomplete(c *gin.Context) {
 		})
 	}
 
+	// Without a match, offer the organisms named like the names closest to
+	// the search, with the confidence of the suggestion
+	if len(organisms) == 0 {
+		scores := map[string]float64{}
+		var names []string
+		for _, suggestion := range suggest(search, fuzzy.Organism) {
+			for _, name := range suggestion.Names {
+				name = strings.ToLower(name)
+				if _, ok := scores[name]; !ok {
+					scores[name] = suggestion.Score
+					names = append(names, name)
+				}
+			}
+		}
+
+		var suggested []models.Organism
+		if len(names) > 0 {
+			err := db.Model(&models.Organism{}).
+				Where("is_marine = TRUE AND aphiaid_worms IS NOT NULL").
+				Where("LOWER(name) IN ? OR LOWER(name_aphia_worms) IN ?", names, names).
+				Find(&suggested).Error
+			if err != nil {
+				ErrorResponse(c, 500, "Failed to fetch organisms")
+				return
+			}
+		}
+
+		for _, org := range suggested {
+			score := scores[strings.ToLower(org.Name)]
+			if s := scores[strings.ToLower(org.NameAphiaWorms)]; s > score {
+				score = s
+			}
+			options = append(options, AutocompleteOption{
+				Label: org.Name,
+				Value: int64(*org.AphiaIDWorms),
+				Score: score,
+			})
+		}
+		sort.SliceStable(options, func(i, j int) bool { return options[i].Score > options[j].Score })
+		if len(options) > 10 {
+			options = options[:10]
+		}
+	}
+
 	SuccessResponse(c, options)
-} 
\ No newline at end of file
+}
+
+// checkTaxa responds with a 400 error and returns false if a taxon names no
+// marine organism or genus while the organisms have not been classified by
+// WoRMS, as such a taxon, e.g. a family, would silently match nothing until
+// "marinenp taxonomy" has been run
+func checkTaxa(c *gin.Context, taxa []string) bool {
+	if len(taxa) == 0 {
+		return true
+	}
+	var classified int64
+	if err := db.Model(&models.OrganismTaxonomy{}).Limit(1).Count(&classified).Error; err != nil {
+		ErrorResponse(c, 500, "Failed to check organism classification")
+		return false
+	}
+	if classified > 0 {
+		return true
+	}
+	for _, taxon := range taxa {
+		sql, args := query.TaxonNamePredicate(taxon)
+		var named int64
+		if err := db.Model(&models.Organism{}).Where("is_marine = TRUE").Where(sql, args...).Limit(1).Count(&named).Error; err != nil {
+			ErrorResponse(c, 500, "Failed to check taxon")
+			return false
+		}
+		if named == 0 {
+			ErrorResponse(c, 400, fmt.Sprintf("Taxon %q matches no organism or genus; taxa above genus need the WoRMS classification of \"marinenp taxonomy\"", taxon))
+			return false
+		}
+	}
+	return true
+}
+
+// ExportDarwinCore handles GET and POST /api/v1/organisms/dwca
+// The export is a Darwin Core Archive with a Taxon core listing the WoRMS
+// taxa that produce marine molecules, and a measurement or fact per natural
+// product of the taxon. The molecules can be narrowed with a search filter as for
+// /api/v1/molecules/search. A failure part way through truncates the archive
+// and is reported in the X-Export-Error trailer.
+func ExportDarwinCore(c *gin.Context) {
+	filter, ok := parseFilter(c)
+	if !ok {
+		return
+	}
+
+	cfg := config.LoadConfig()
+	meta := dwca.Metadata{
+		Publisher: cfg.DarwinCore.Publisher,
+		Contact:   cfg.DarwinCore.Contact,
+		License:   cfg.DarwinCore.License,
+		Version:   cfg.Version,
+		PubDate:   cfg.LastUpdate,
+	}
+	if body, ok := c.Get(filterDocumentKey); ok {
+		meta.Search = string(body.([]byte))
+	} else if c.Request.URL.RawQuery != "" {
+		meta.Search = "/api/v1/molecules/search?" + c.Request.URL.RawQuery
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", "attachment;filename=marinenp_dwca_"+time.Now().Format("20060102_150405")+".zip")
+	c.Header("Trailer", "X-Export-Rows, X-Export-Error")
+
+	counts, err := dwca.Write(db, filter, meta, c.Writer)
+	if err != nil {
+		c.Writer.Header().Set("X-Export-Error", err.Error())
+	}
+	c.Writer.Header().Set("X-Export-Rows", fmt.Sprintf("%d", counts.Taxa))
+}
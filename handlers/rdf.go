@@ -0,0 +1,121 @@
+/*
+ * MarineNP RDF Handlers
+ * Purpose: Serve molecules, organisms, citations and locations as RDF
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file negotiates the representation of single records: JSON by default,
+ * or Turtle, N-Triples or JSON-LD when the Accept header asks for them or the
+ * record id ends with .ttl, .nt or .jsonld.
+ */
+
+package handlers
+
+import (
+	"marinenp/config"
+	"marinenp/rdf"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rdfSuffixes are the id suffixes selecting an RDF format
+var rdfSuffixes = map[string]string{
+	".ttl":    rdf.Turtle,
+	".nt":     rdf.NTriples,
+	".jsonld": rdf.JSONLD,
+}
+
+// offeredType is a media type offered for a record and its format
+type offeredType struct{ mediaType, format string }
+
+// rdfMediaTypes are the media types offered for records, JSON first so that
+// it wins ties and wildcards
+var rdfMediaTypes = []offeredType{
+	{gin.MIMEJSON, ""},
+	{"application/ld+json", rdf.JSONLD},
+	{"text/turtle", rdf.Turtle},
+	{"application/n-triples", rdf.NTriples},
+}
+
+// rdfRequest returns the record id without its format suffix and the RDF
+// format requested, which is empty for JSON. A suffix takes precedence over
+// the Accept header.
+func rdfRequest(c *gin.Context, id string) (string, string) {
+	return negotiateRecord(c, id, rdfMediaTypes)
+}
+
+// negotiateRecord is rdfRequest with the media types offered for a record
+func negotiateRecord(c *gin.Context, id string, offeredTypes []offeredType) (string, string) {
+	for suffix, format := range rdfSuffixes {
+		if strings.HasSuffix(id, suffix) {
+			return strings.TrimSuffix(id, suffix), format
+		}
+	}
+	return id, acceptedFormat(c.GetHeader("Accept"), offeredTypes)
+}
+
+// acceptedFormat returns the format of the offered media type with the
+// highest quality in an Accept header, the first offered on ties. The quality
+// of a media type is that of its most specific match, so
+// "text/*;q=0.5, text/turtle" prefers Turtle.
+func acceptedFormat(accept string, offeredTypes []offeredType) string {
+	best, bestQuality := "", 0.0
+	for i, offered := range offeredTypes {
+		quality, specificity := 0.0, -1
+		if strings.TrimSpace(accept) == "" && i == 0 {
+			quality = 1
+		}
+		for _, entry := range strings.Split(accept, ",") {
+			params := strings.Split(entry, ";")
+			mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+			var s int
+			switch {
+			case mediaType == offered.mediaType:
+				s = 2
+			case mediaType == offered.mediaType[:strings.Index(offered.mediaType, "/")]+"/*":
+				s = 1
+			case mediaType == "*/*":
+				s = 0
+			default:
+				continue
+			}
+			if s < specificity {
+				continue
+			}
+			q := 1.0
+			for _, param := range params[1:] {
+				if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+					if f, err := strconv.ParseFloat(v, 64); err == nil {
+						q = f
+					}
+				}
+			}
+			quality, specificity = q, s
+		}
+		if quality > bestQuality {
+			best, bestQuality = offered.format, quality
+		}
+	}
+	return best
+}
+
+// rdfResources names the resources under the public URL of the API
+func rdfResources() rdf.Resources {
+	cfg := config.LoadConfig()
+	return rdf.Resources{Base: strings.TrimSuffix(cfg.API.PublicURL, "/") + cfg.API.Prefix}
+}
+
+// sendRDF responds with the graph built by describe in an RDF format
+func sendRDF(c *gin.Context, format string, describe func(r rdf.Resources, g *rdf.Graph)) {
+	r := rdfResources()
+	g := rdf.NewGraph(r.Prefixes())
+	describe(r, g)
+	c.Header("Content-Type", rdf.ContentTypes[format])
+	c.Header("Vary", "Accept")
+	c.Status(200)
+	if err := g.Write(c.Writer, format); err != nil {
+		c.Error(err)
+	}
+}
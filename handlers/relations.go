@@ -0,0 +1,291 @@
+/*
+ * MarineNP Export Relations
+ * Purpose: Export the organisms, locations and citations of molecules
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file defines the rows related to exported molecules and writes them
+ * either as relation files next to the molecules in an export zip, or as list
+ * columns of the molecule records, and as the relation sheets of workbooks.
+ */
+
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"fmt"
+	"marinenp/columnar"
+	"marinenp/query"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Values of the relations export parameter
+const (
+	relationsNone    = "none"    // Only the organism, location and citation counts
+	relationsFiles   = "files"   // A relation file per related table in the zip
+	relationsColumns = "columns" // List columns in the molecule records
+)
+
+// relationSeparator joins the values of list columns, as in synonyms. The
+// lists of a relation have one value per related row, so that they align.
+// Backslashes and separators within values are escaped with a backslash, so
+// that "a|b" is listed as a\|b.
+const relationSeparator = "|"
+
+// relationEscaper escapes the values of list columns
+var relationEscaper = strings.NewReplacer(`\`, `\\`, relationSeparator, `\`+relationSeparator)
+
+// exportRelation is a table of the rows related to exported molecules
+type exportRelation struct {
+	Name    string      // Sheet name in workbooks
+	File    string      // File name in zips, without extension
+	Joins   []string    // Joins from molecules to the related rows
+	Order   string      // Order of the related rows of a molecule
+	Columns [][3]string // Header, table and column of each column
+	Lists   [][2]string // List column name and the header of the listed column
+}
+
+// exportRelations are exported in this order
+var exportRelations = []exportRelation{
+	{
+		Name: "Organisms",
+		File: "molecule_organism",
+		Joins: []string{
+			"JOIN molecule_organism ON molecule_organism.molecule_id = molecules.id",
+			"JOIN organisms ON organisms.id = molecule_organism.organism_id AND organisms.is_marine = TRUE",
+		},
+		Order: "molecule_organism.id",
+		Columns: [][3]string{
+			{"identifier", "molecules", "identifier"},
+			{"organism_id", "organisms", "id"},
+			{"organism", "organisms", "name"},
+			{"rank", "organisms", "rank"},
+			{"aphiaid_worms", "organisms", "aphiaid_worms"},
+			{"name_aphia_worms", "organisms", "name_aphia_worms"},
+			{"environment_aphia_worms", "organisms", "environment_aphia_worms"},
+			{"is_marine", "organisms", "is_marine"},
+			{"organism_parts", "molecule_organism", "organism_parts"},
+		},
+		Lists: [][2]string{
+			{"organisms", "organism"},
+			{"aphiaid_worms", "aphiaid_worms"},
+			{"name_aphia_worms", "name_aphia_worms"},
+		},
+	},
+	{
+		Name: "Geolocations",
+		File: "molecule_geo_location",
+		Joins: []string{
+			"JOIN geo_location_molecule ON geo_location_molecule.molecule_id = molecules.id",
+			"JOIN geo_locations ON geo_locations.id = geo_location_molecule.geo_location_id",
+		},
+		Order: "geo_location_molecule.id",
+		Columns: [][3]string{
+			{"identifier", "molecules", "identifier"},
+			{"geo_location_id", "geo_locations", "id"},
+			{"geo_location", "geo_locations", "name"},
+			{"locations", "geo_location_molecule", "locations"},
+		},
+		Lists: [][2]string{
+			{"geo_locations", "geo_location"},
+		},
+	},
+	{
+		Name: "Citations",
+		File: "molecule_citation",
+		Joins: []string{
+			`JOIN citables ON citables.citable_id = molecules.id AND citables.citable_type = 'App\Models\Molecule'`,
+			"JOIN citations ON citations.id = citables.citation_id",
+		},
+		Order: "citables.id",
+		Columns: [][3]string{
+			{"identifier", "molecules", "identifier"},
+			{"citation_id", "citations", "id"},
+			{"doi", "citations", "doi"},
+			{"title", "citations", "title"},
+			{"authors", "citations", "authors"},
+			{"citation_text", "citations", "citation_text"},
+		},
+		Lists: [][2]string{
+			{"citation_dois", "doi"},
+		},
+	},
+}
+
+// columns returns the relation columns with the types of their database
+// columns
+func (r exportRelation) columns() ([]columnar.Column, error) {
+	tableTypes := make(map[string]map[string]columnar.Type)
+	columns := make([]columnar.Column, len(r.Columns))
+	for i, c := range r.Columns {
+		header, table, column := c[0], c[1], c[2]
+		if tableTypes[table] == nil {
+			tableColumns, err := columnar.ColumnsOf(db, table)
+			if err != nil {
+				return nil, err
+			}
+			tableTypes[table] = make(map[string]columnar.Type)
+			for _, tc := range tableColumns {
+				tableTypes[table][tc.Name] = tc.Type
+			}
+		}
+		columns[i] = columnar.Column{Name: header, Type: tableTypes[table][column]}
+	}
+	return columns, nil
+}
+
+// rows calls fn with the molecule id and the column values of each related
+// row of the molecules selected by scope, in molecule id order
+func (r exportRelation) rows(scope func(tx *gorm.DB) *gorm.DB, fn func(id int64, values []interface{}) error) error {
+	selects := []string{"molecules.id"}
+	for _, c := range r.Columns {
+		selects = append(selects, c[1]+"."+c[2])
+	}
+	tx := db.Table("molecules").Select(strings.Join(selects, ", "))
+	for _, join := range r.Joins {
+		tx = tx.Joins(join)
+	}
+	result, err := scope(tx).Order("molecules.id").Order(r.Order).Rows()
+	if err != nil {
+		return err
+	}
+	defer result.Close()
+
+	var id int64
+	values := make([]interface{}, len(r.Columns))
+	pointers := []interface{}{&id}
+	for i := range values {
+		pointers = append(pointers, &values[i])
+	}
+	for result.Next() {
+		if err := result.Scan(pointers...); err != nil {
+			return err
+		}
+		if err := fn(id, values); err != nil {
+			return err
+		}
+	}
+	return result.Err()
+}
+
+// addRelationLists adds the relation list columns to a page of export
+// records
+func addRelationLists(records []map[string]interface{}) error {
+	ids := make([]int64, 0, len(records))
+	byID := make(map[int64]map[string]interface{}, len(records))
+	for _, record := range records {
+		if id, ok := record["molecule_key"].(int64); ok {
+			ids = append(ids, id)
+			byID[id] = record
+		}
+	}
+	sql, args := query.IDPredicate(ids)
+	scope := func(tx *gorm.DB) *gorm.DB { return tx.Where(sql, args...) }
+
+	for _, relation := range exportRelations {
+		// Index of the listed column of each list
+		listed := make([]int, len(relation.Lists))
+		for i, list := range relation.Lists {
+			for j, c := range relation.Columns {
+				if c[0] == list[1] {
+					listed[i] = j
+				}
+			}
+		}
+
+		lists := make(map[int64][][]string)
+		err := relation.rows(scope, func(id int64, values []interface{}) error {
+			if lists[id] == nil {
+				lists[id] = make([][]string, len(relation.Lists))
+			}
+			for i, column := range listed {
+				value := ""
+				if values[column] != nil {
+					value = relationEscaper.Replace(fmt.Sprintf("%v", columnar.Value(columnar.String, values[column])))
+				}
+				lists[id][i] = append(lists[id][i], value)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for id, record := range byID {
+			for i, list := range relation.Lists {
+				value := ""
+				if lists[id] != nil {
+					value = strings.Join(lists[id][i], relationSeparator)
+				}
+				record[list[0]] = value
+			}
+		}
+	}
+	return nil
+}
+
+// writeRelationFiles adds a file per relation of the molecules matching the
+// filter to an export zip, in the export format for Parquet and Arrow exports
+// and as CSV otherwise
+func writeRelationFiles(archive *zip.Writer, filter *query.Filter, format string) error {
+	scope := func(tx *gorm.DB) *gorm.DB { return filter.Apply(tx) }
+	for _, relation := range exportRelations {
+		columns, err := relation.columns()
+		if err != nil {
+			return err
+		}
+
+		if format != columnar.Parquet && format != columnar.Arrow {
+			file, err := archive.Create(relation.File + ".csv")
+			if err != nil {
+				return err
+			}
+			w := csv.NewWriter(file)
+			headers := make([]string, len(columns))
+			for i, c := range columns {
+				headers[i] = c.Name
+			}
+			w.Write(headers)
+			row := make([]string, len(columns))
+			err = relation.rows(scope, func(_ int64, values []interface{}) error {
+				for i, value := range values {
+					row[i] = ""
+					if value != nil {
+						row[i] = fmt.Sprintf("%v", columnar.Value(columnar.String, value))
+					}
+				}
+				return w.Write(row)
+			})
+			if err != nil {
+				return fmt.Errorf("%s: %w", relation.File, err)
+			}
+			w.Flush()
+			if err := w.Error(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		file, err := archive.Create(relation.File + "." + format)
+		if err != nil {
+			return err
+		}
+		w, err := columnar.NewWriter(file, format, columns)
+		if err != nil {
+			return err
+		}
+		err = relation.rows(scope, func(_ int64, values []interface{}) error {
+			return w.Append(values)
+		})
+		if err == nil {
+			err = w.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", relation.File, err)
+		}
+	}
+	return nil
+}
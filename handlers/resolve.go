@@ -0,0 +1,267 @@
+/*
+ * MarineNP Identifier Resolution Handlers
+ * Purpose: HTTP handlers for resolving batches of mixed identifiers
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file matches lists of MarineNP identifiers, InChIKeys, CAS numbers,
+ * names and synonyms against the marine molecules in one pass, reporting per
+ * input which molecules and which field matched and whether the match is
+ * ambiguous. Results are returned as JSON or as a CSV export.
+ */
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"marinenp/models"
+	"marinenp/query"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxResolveIdentifiers bounds the inputs of one resolution request
+const maxResolveIdentifiers = 5000
+
+// Resolution fields, from the most to the least specific. An input is
+// reported with the matches of its most specific matching field only.
+var resolveFields = []string{"identifier", "standard_inchi_key", "connectivity", "cas", "name", "synonym"}
+
+// ResolveRequest is the JSON body of POST /api/v1/molecules/resolve
+type ResolveRequest struct {
+	Identifiers []string `json:"identifiers"`
+}
+
+// ResolvedMolecule summarises a molecule matched by an input
+type ResolvedMolecule struct {
+	ID               int64  `json:"id"`
+	Identifier       string `json:"identifier"`
+	Name             string `json:"name"`
+	StandardInchiKey string `json:"standard_inchi_key"`
+	CanonicalSmiles  string `json:"canonical_smiles"`
+}
+
+// ResolveResult lists the molecules matched by one input
+type ResolveResult struct {
+	Input     string             `json:"input"`
+	Field     string             `json:"field,omitempty"` // Field that matched, empty if none did
+	Ambiguous bool               `json:"ambiguous"`       // More than one molecule matched
+	Molecules []ResolvedMolecule `json:"molecules"`
+}
+
+// ResolveIdentifiers handles POST /api/v1/molecules/resolve
+// With ?format=csv the results are sent like an export, one row per input and
+// matched molecule prefixed with the input, field and ambiguity.
+func ResolveIdentifiers(c *gin.Context) {
+	body, err := c.GetRawData()
+	if err != nil {
+		ErrorResponse(c, 400, "Failed to read request body")
+		return
+	}
+	var req ResolveRequest
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		ErrorResponse(c, 400, "Malformed resolution request: "+err.Error())
+		return
+	}
+	if len(req.Identifiers) == 0 || len(req.Identifiers) > maxResolveIdentifiers {
+		ErrorResponse(c, 400, fmt.Sprintf("Between 1 and %d identifiers are required", maxResolveIdentifiers))
+		return
+	}
+	format := strings.ToLower(c.DefaultQuery("format", "json"))
+	if format != "json" && format != "csv" {
+		ErrorResponse(c, 400, "format must be json or csv")
+		return
+	}
+
+	results, err := resolveIdentifiers(req.Identifiers)
+	if err != nil {
+		ErrorResponse(c, 500, "Failed to resolve identifiers")
+		return
+	}
+
+	if format == "csv" {
+		sendResolveExport(c, string(body), results)
+		return
+	}
+
+	matched, ambiguous := 0, 0
+	for _, r := range results {
+		if len(r.Molecules) > 0 {
+			matched++
+		}
+		if r.Ambiguous {
+			ambiguous++
+		}
+	}
+	SuccessResponse(c, gin.H{
+		"results":   results,
+		"total":     len(results),
+		"matched":   matched,
+		"unmatched": len(results) - matched,
+		"ambiguous": ambiguous,
+	})
+}
+
+// resolveIdentifiers matches the inputs against every field of the marine
+// molecules in a single scan
+func resolveIdentifiers(inputs []string) ([]ResolveResult, error) {
+	// Inputs by normalized text; duplicates share their matches
+	wanted := make(map[string][]int, len(inputs))
+	for i, input := range inputs {
+		key := normalizeIdentifier(input)
+		if key != "" {
+			wanted[key] = append(wanted[key], i)
+		}
+	}
+
+	// Matches of every input, per field rank
+	matches := make([][][]ResolvedMolecule, len(inputs))
+	for i := range matches {
+		matches[i] = make([][]ResolvedMolecule, len(resolveFields))
+	}
+	record := func(text string, rank int, m ResolvedMolecule) {
+		for _, i := range wanted[normalizeIdentifier(text)] {
+			found := matches[i][rank]
+			if len(found) == 0 || found[len(found)-1].ID != m.ID {
+				matches[i][rank] = append(found, m)
+			}
+		}
+	}
+
+	rows, err := db.Model(&models.Molecule{}).
+		Select("id, identifier, name, cas, synonyms, standard_inchi_key, canonical_smiles").
+		Where("is_marine = TRUE").
+		Order("id").
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m ResolvedMolecule
+		var cas, synonyms *string
+		var identifier, name, inchiKey, smiles *string
+		if err := rows.Scan(&m.ID, &identifier, &name, &cas, &synonyms, &inchiKey, &smiles); err != nil {
+			return nil, err
+		}
+		m.Identifier, m.Name = deref(identifier), deref(name)
+		m.StandardInchiKey, m.CanonicalSmiles = deref(inchiKey), deref(smiles)
+
+		record(m.Identifier, 0, m)
+		record(m.StandardInchiKey, 1, m)
+		if block, _, ok := strings.Cut(m.StandardInchiKey, "-"); ok {
+			record(block, 2, m)
+		}
+		for _, value := range splitValues(deref(cas)) {
+			record(value, 3, m)
+		}
+		record(m.Name, 4, m)
+		for _, value := range splitValues(deref(synonyms)) {
+			record(value, 5, m)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	results := make([]ResolveResult, len(inputs))
+	for i, input := range inputs {
+		results[i] = ResolveResult{Input: input, Molecules: []ResolvedMolecule{}}
+		for rank, found := range matches[i] {
+			if len(found) > 0 {
+				results[i].Field = resolveFields[rank]
+				results[i].Molecules = found
+				results[i].Ambiguous = len(found) > 1
+				break
+			}
+		}
+	}
+	return results, nil
+}
+
+// sendResolveExport sends the results as an export zip whose rows carry the
+// export columns of each matched molecule, after the input, field and ambiguity
+func sendResolveExport(c *gin.Context, request string, results []ResolveResult) {
+	var ids []int64
+	for _, r := range results {
+		for _, m := range r.Molecules {
+			ids = append(ids, m.ID)
+		}
+	}
+
+	// Export records keyed by identifier, which unlike id is not shadowed by
+	// the properties columns
+	var records []map[string]interface{}
+	sql, args := query.IDPredicate(ids)
+	if err := exportSelect().Where(sql, args...).Find(&records).Error; err != nil {
+		ErrorResponse(c, 500, "Failed to export molecules")
+		return
+	}
+	byIdentifier := make(map[string]map[string]interface{}, len(records))
+	for _, record := range records {
+		byIdentifier[fmt.Sprintf("%v", record["identifier"])] = record
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", "attachment;filename=molecules_"+time.Now().Format("20060102_150405")+".zip")
+	archive, err := newExportArchive(c.Writer, "zip", "resolve-request.json", request, "molecules.csv")
+	if err != nil {
+		ErrorResponse(c, 500, "Failed to create export archive")
+		return
+	}
+	csvWriter := csv.NewWriter(archive.data)
+	csvWriter.Write(append([]string{"input", "matched_field", "ambiguous"}, exportHeaders...))
+	for _, r := range results {
+		prefix := []string{r.Input, r.Field, fmt.Sprintf("%v", r.Ambiguous)}
+		if len(r.Molecules) == 0 {
+			csvWriter.Write(append(prefix, make([]string, len(exportHeaders))...))
+			continue
+		}
+		for _, m := range r.Molecules {
+			csvWriter.Write(append(append([]string{}, prefix...), exportRow(byIdentifier[m.Identifier], exportHeaders)...))
+		}
+	}
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		log.Printf("Resolve export failed: %v", err)
+		archive.Fail(0, err)
+	}
+	archive.Close()
+}
+
+// normalizeIdentifier folds case and whitespace so that inputs compare equal
+// to the stored values they were copied from
+func normalizeIdentifier(text string) string {
+	return strings.ToLower(strings.Join(strings.Fields(text), " "))
+}
+
+// splitValues splits a multi-valued column, stored either as a JSON array or
+// as text separated by |, ; or newlines
+func splitValues(text string) []string {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "[") {
+		var values []string
+		if json.Unmarshal([]byte(text), &values) == nil {
+			return values
+		}
+	}
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return r == '|' || r == ';' || r == '\n'
+	})
+}
+
+// deref returns the value of a nullable column, or "" for NULL
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
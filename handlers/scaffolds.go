@@ -0,0 +1,354 @@
+/*
+ * MarineNP Scaffold Handlers
+ * Purpose: HTTP handlers for browsing Murcko scaffolds
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file groups marine molecules by the Murcko framework stored in their
+ * properties. It lists scaffolds with molecule and organism counts and their
+ * dominant NP-classifier pathway, the molecules sharing a scaffold, and the
+ * scaffolds over-represented in a taxon compared with the rest of the database.
+ */
+
+package handlers
+
+import (
+	"fmt"
+	"marinenp/models"
+	"marinenp/query"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Scaffold enrichment defaults and limits
+const (
+	defaultEnrichmentMinCount = 2
+	defaultEnrichmentLimit    = 50
+	maxEnrichmentLimit        = 1000
+)
+
+// Scaffold is a Murcko framework with statistics over its molecules
+type Scaffold struct {
+	Scaffold        string  `json:"scaffold"`
+	MoleculeCount   int64   `json:"molecule_count"`
+	OrganismCount   int64   `json:"organism_count"`
+	Pathway         string  `json:"pathway"`          // Most common NP-classifier pathway
+	PathwayFraction float64 `json:"pathway_fraction"` // Share of the molecules in the pathway
+}
+
+// EnrichedScaffold compares the molecules of a scaffold in a taxon with the
+// whole database
+type EnrichedScaffold struct {
+	Scaffold       string  `json:"scaffold"`
+	TaxonCount     int64   `json:"taxon_count"`     // Molecules of the taxon with the scaffold
+	TotalCount     int64   `json:"total_count"`     // Molecules with the scaffold
+	FoldEnrichment float64 `json:"fold_enrichment"` // Share in the taxon over share overall
+	PValue         float64 `json:"p_value"`         // One-sided hypergeometric test
+}
+
+// scaffoldOrders maps the sort values accepted by GetScaffolds to columns
+var scaffoldOrders = map[string]string{
+	"molecule_count": "molecule_count",
+	"organism_count": "organism_count",
+	"scaffold":       "scaffold",
+}
+
+// scaffoldMolecules selects the id, scaffold and pathway of the marine
+// molecules matching the filter that have a scaffold
+func scaffoldMolecules(filter *query.Filter) *gorm.DB {
+	return filter.Apply(db.Model(&models.Molecule{}).
+		Joins("JOIN properties ON properties.molecule_id = molecules.id").
+		Select("molecules.id AS molecule_id, properties.murcko_framework AS scaffold, properties.np_classifier_pathway AS pathway").
+		Where("properties.murcko_framework <> ''"))
+}
+
+// GetScaffolds handles GET and POST /api/v1/scaffolds
+// Scaffolds are counted over the molecules matching the usual search filter
+// and ordered by sort, one of molecule_count (the default), organism_count or
+// scaffold, in sort_dir order; query restricts them to scaffolds containing a
+// SMILES text. orderByString and orderDir are left to the molecule filter.
+func GetScaffolds(c *gin.Context) {
+	filter, ok := parseFilter(c)
+	if !ok {
+		return
+	}
+	params := ParseQueryParams(c)
+
+	scaffolds := func() *gorm.DB {
+		tx := db.Table("(?) AS scaffold_molecules", scaffoldMolecules(filter))
+		if params.Search != "" {
+			tx = tx.Where("scaffold LIKE ?", "%"+params.Search+"%")
+		}
+		return tx
+	}
+
+	var total int64
+	if err := scaffolds().Distinct("scaffold").Count(&total).Error; err != nil {
+		ErrorResponse(c, 500, "Failed to count scaffolds")
+		return
+	}
+
+	// Counts sort most frequent first and scaffolds alphabetically by default
+	order, ok := scaffoldOrders[c.DefaultQuery("sort", "molecule_count")]
+	if !ok {
+		ErrorResponse(c, 400, "sort must be molecule_count, organism_count or scaffold")
+		return
+	}
+	direction := "DESC"
+	if order == "scaffold" {
+		direction = "ASC"
+	}
+	switch c.Query("sort_dir") {
+	case "asc":
+		direction = "ASC"
+	case "desc":
+		direction = "DESC"
+	}
+
+	var page []Scaffold
+	err := scaffolds().
+		Joins("LEFT JOIN molecule_organism ON molecule_organism.molecule_id = scaffold_molecules.molecule_id").
+		Joins("LEFT JOIN organisms ON organisms.id = molecule_organism.organism_id AND organisms.is_marine = TRUE").
+		Select("scaffold, COUNT(DISTINCT scaffold_molecules.molecule_id) AS molecule_count, COUNT(DISTINCT organisms.id) AS organism_count").
+		Group("scaffold").
+		Order(order + " " + direction).
+		Order("scaffold").
+		Offset((params.PageNumber - 1) * params.PerPageNumber).
+		Limit(params.PerPageNumber).
+		Scan(&page).Error
+	if err != nil {
+		ErrorResponse(c, 500, "Failed to fetch scaffolds")
+		return
+	}
+
+	if err := fillPathways(filter, page); err != nil {
+		ErrorResponse(c, 500, "Failed to fetch scaffold pathways")
+		return
+	}
+
+	// Marshal the response using our custom marshaler
+	jsonData, err := models.MarshalToJSON(gin.H{
+		"scaffolds": page,
+		"total":     total,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to marshal response"})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json", jsonData)
+}
+
+// fillPathways sets the dominant NP-classifier pathway of each scaffold;
+// ties go to the alphabetically first pathway
+func fillPathways(filter *query.Filter, scaffolds []Scaffold) error {
+	if len(scaffolds) == 0 {
+		return nil
+	}
+	names := make([]string, len(scaffolds))
+	for i, s := range scaffolds {
+		names[i] = s.Scaffold
+	}
+
+	var counts []struct {
+		Scaffold string
+		Pathway  string
+		Count    int64
+	}
+	err := db.Table("(?) AS scaffold_molecules", scaffoldMolecules(filter)).
+		Select("scaffold, pathway, COUNT(*) AS count").
+		Where("scaffold IN ?", names).
+		Where("pathway <> ''").
+		Group("scaffold, pathway").
+		Order("scaffold, count DESC, pathway").
+		Scan(&counts).Error
+	if err != nil {
+		return err
+	}
+
+	index := make(map[string]int, len(scaffolds))
+	for i, s := range scaffolds {
+		index[s.Scaffold] = i
+	}
+	for _, count := range counts {
+		s := &scaffolds[index[count.Scaffold]]
+		if s.Pathway == "" {
+			s.Pathway = count.Pathway
+			s.PathwayFraction = float64(count.Count) / float64(s.MoleculeCount)
+		}
+	}
+	return nil
+}
+
+// GetScaffoldMolecules handles GET and POST /api/v1/scaffolds/molecules
+// The scaffold is given as the scaffold query parameter, since scaffold SMILES
+// contain characters that do not fit in a path; results are paginated and
+// filtered like a molecule search.
+func GetScaffoldMolecules(c *gin.Context) {
+	filter, ok := decodeFilter(c)
+	if !ok {
+		return
+	}
+
+	scaffold := strings.TrimSpace(c.Query("scaffold"))
+	if scaffold == "" {
+		ErrorResponse(c, 400, "scaffold is required")
+		return
+	}
+	condition, qerr := query.NewCondition("properties.murcko_framework", string(query.OpEq), scaffold)
+	if qerr != nil {
+		FilterErrorResponse(c, qerr)
+		return
+	}
+	filter.Require(condition)
+
+	if !checkTaxa(c, filter.Taxa()) || !resolveConditions(c, filter) {
+		return
+	}
+	searchMolecules(c, filter)
+}
+
+// GetEnrichedScaffolds handles GET and POST /api/v1/scaffolds/enriched
+// It ranks the scaffolds of the molecules of a taxon, e.g. a genus, by how
+// over-represented they are compared with all molecules matching the search
+// filter. Only scaffolds found in at least min_count molecules of the taxon
+// are considered.
+func GetEnrichedScaffolds(c *gin.Context) {
+	filter, ok := parseFilter(c)
+	if !ok {
+		return
+	}
+
+	taxon := strings.TrimSpace(c.Query("taxon"))
+	if taxon == "" {
+		ErrorResponse(c, 400, "taxon is required")
+		return
+	}
+	condition, qerr := query.NewCondition("organism", string(query.OpInTaxon), taxon)
+	if qerr != nil {
+		qerr.Field = "taxon"
+		FilterErrorResponse(c, qerr)
+		return
+	}
+	if !checkTaxa(c, []string{taxon}) {
+		return
+	}
+	minCount, err := strconv.Atoi(c.DefaultQuery("min_count", strconv.Itoa(defaultEnrichmentMinCount)))
+	if err != nil || minCount < 1 {
+		ErrorResponse(c, 400, "min_count must be a positive integer")
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultEnrichmentLimit)))
+	if err != nil || limit < 1 || limit > maxEnrichmentLimit {
+		ErrorResponse(c, 400, fmt.Sprintf("limit must be between 1 and %d", maxEnrichmentLimit))
+		return
+	}
+
+	// Molecules with a scaffold overall and in the taxon
+	taxonSQL, taxonArgs := condition.SQL()
+	var total, taxonTotal int64
+	if err := scaffoldMolecules(filter).Count(&total).Error; err != nil {
+		ErrorResponse(c, 500, "Failed to count molecules")
+		return
+	}
+	if err := scaffoldMolecules(filter).Where(taxonSQL, taxonArgs...).Count(&taxonTotal).Error; err != nil {
+		ErrorResponse(c, 500, "Failed to count taxon molecules")
+		return
+	}
+
+	// Scaffold counts in the taxon, then overall for those scaffolds
+	var taxonCounts []struct {
+		Scaffold string
+		Count    int64
+	}
+	err = db.Table("(?) AS scaffold_molecules", scaffoldMolecules(filter).Where(taxonSQL, taxonArgs...)).
+		Select("scaffold, COUNT(*) AS count").
+		Group("scaffold").
+		Having("COUNT(*) >= ?", minCount).
+		Scan(&taxonCounts).Error
+	if err != nil {
+		ErrorResponse(c, 500, "Failed to count taxon scaffolds")
+		return
+	}
+
+	scaffolds := make([]EnrichedScaffold, 0, len(taxonCounts))
+	if len(taxonCounts) > 0 {
+		names := make([]string, len(taxonCounts))
+		for i, count := range taxonCounts {
+			names[i] = count.Scaffold
+		}
+		var totalCounts []struct {
+			Scaffold string
+			Count    int64
+		}
+		err = db.Table("(?) AS scaffold_molecules", scaffoldMolecules(filter)).
+			Select("scaffold, COUNT(*) AS count").
+			Where("scaffold IN ?", names).
+			Group("scaffold").
+			Scan(&totalCounts).Error
+		if err != nil {
+			ErrorResponse(c, 500, "Failed to count scaffolds")
+			return
+		}
+		totals := make(map[string]int64, len(totalCounts))
+		for _, count := range totalCounts {
+			totals[count.Scaffold] = count.Count
+		}
+
+		for _, count := range taxonCounts {
+			s := EnrichedScaffold{
+				Scaffold:   count.Scaffold,
+				TaxonCount: count.Count,
+				TotalCount: totals[count.Scaffold],
+			}
+			s.FoldEnrichment = (float64(s.TaxonCount) / float64(taxonTotal)) / (float64(s.TotalCount) / float64(total))
+			s.PValue = hypergeometricTail(s.TaxonCount, s.TotalCount, taxonTotal, total)
+			scaffolds = append(scaffolds, s)
+		}
+	}
+
+	sort.Slice(scaffolds, func(i, j int) bool {
+		if scaffolds[i].PValue != scaffolds[j].PValue {
+			return scaffolds[i].PValue < scaffolds[j].PValue
+		}
+		if scaffolds[i].FoldEnrichment != scaffolds[j].FoldEnrichment {
+			return scaffolds[i].FoldEnrichment > scaffolds[j].FoldEnrichment
+		}
+		return scaffolds[i].Scaffold < scaffolds[j].Scaffold
+	})
+	if len(scaffolds) > limit {
+		scaffolds = scaffolds[:limit]
+	}
+
+	SuccessResponse(c, gin.H{
+		"taxon":           taxon,
+		"taxon_molecules": taxonTotal,
+		"molecules":       total,
+		"scaffolds":       scaffolds,
+	})
+}
+
+// hypergeometricTail returns the probability of drawing at least k molecules
+// with a scaffold when drawing n of N molecules, K of which have the scaffold
+func hypergeometricTail(k, K, n, N int64) float64 {
+	logChoose := func(a, b int64) float64 {
+		x, _ := math.Lgamma(float64(a + 1))
+		y, _ := math.Lgamma(float64(b + 1))
+		z, _ := math.Lgamma(float64(a - b + 1))
+		return x - y - z
+	}
+	p := 0.0
+	for i := k; i <= K && i <= n; i++ {
+		if n-i > N-K {
+			continue
+		}
+		p += math.Exp(logChoose(K, i) + logChoose(N-K, n-i) - logChoose(N, n))
+	}
+	return math.Min(p, 1)
+}
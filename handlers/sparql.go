@@ -0,0 +1,95 @@
+/*
+ * MarineNP SPARQL Handlers
+ * Purpose: HTTP handler of the read-only graph query endpoint
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file answers SPARQL SELECT queries over the graph of molecules,
+ * organisms, citations and locations, as in the SPARQL protocol: the query is
+ * the query parameter of a GET, a form field of a POST or the body of a POST
+ * of application/sparql-query.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"marinenp/config"
+	"marinenp/sparql"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxSPARQLQuery limits the size of a query in bytes
+const maxSPARQLQuery = 64 << 10
+
+// QuerySPARQL handles GET and POST /api/v1/sparql
+// Results are SPARQL JSON results; the X-SPARQL-Truncated header is set when
+// solutions beyond SPARQL_MAX_RESULTS were left out.
+func QuerySPARQL(c *gin.Context) {
+	src := c.Query("query")
+	if c.Request.Method == http.MethodPost {
+		if strings.HasPrefix(c.ContentType(), "application/sparql-query") {
+			body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSPARQLQuery+1))
+			if err != nil {
+				ErrorResponse(c, 400, "Failed to read the query")
+				return
+			}
+			src = string(body)
+		} else {
+			src = c.PostForm("query")
+		}
+	}
+	if strings.TrimSpace(src) == "" {
+		sparqlErrorResponse(c, http.StatusBadRequest, "query is required", nil)
+		return
+	}
+	if len(src) > maxSPARQLQuery {
+		sparqlErrorResponse(c, http.StatusRequestEntityTooLarge, "query is too long", nil)
+		return
+	}
+
+	cfg := config.LoadConfig()
+	results, err := sparql.Execute(c.Request.Context(), db, src, sparql.Options{
+		Resources: rdfResources(),
+		Timeout:   cfg.SPARQL.Timeout,
+		MaxRows:   cfg.SPARQL.MaxResults,
+	})
+	var qerr *sparql.Error
+	switch {
+	case errors.As(err, &qerr):
+		sparqlErrorResponse(c, http.StatusBadRequest, err.Error(), qerr)
+		return
+	case errors.Is(err, sparql.ErrTimeout):
+		sparqlErrorResponse(c, http.StatusServiceUnavailable, err.Error(), nil)
+		return
+	case err != nil:
+		sparqlErrorResponse(c, http.StatusInternalServerError, "Failed to run the query: "+err.Error(), nil)
+		return
+	}
+
+	if results.Truncated {
+		c.Header("X-SPARQL-Truncated", "true")
+	}
+	c.Header("Content-Type", sparql.ContentType)
+	c.Status(http.StatusOK)
+	enc := json.NewEncoder(c.Writer)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(results); err != nil {
+		c.Error(err)
+	}
+}
+
+// sparqlErrorResponse sends an error with its HTTP status, as SPARQL clients
+// do not read the status of the response body
+func sparqlErrorResponse(c *gin.Context, status int, msg string, detail interface{}) {
+	c.JSON(status, Response{
+		Status: status,
+		Msg:    msg,
+		Data:   detail,
+	})
+}
@@ -0,0 +1,254 @@
+/*
+ * MarineNP Structure Search Handlers
+ * Purpose: HTTP handlers for substructure search
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file provides the substructure and similarity search endpoints and
+ * resolves structure conditions of molecule filters against the in-memory
+ * structure index and formula conditions against the stored formulas.
+ */
+
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"marinenp/chem"
+	"marinenp/models"
+	"marinenp/query"
+	"marinenp/structure"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// structureIndex is nil until LoadStructureIndex has finished
+var structureIndex atomic.Pointer[structure.Index]
+
+// LoadStructureIndex loads the structure index used by structure searches.
+// It takes a while on the full dataset, so the server starts without it and
+// structure searches are refused until it is ready.
+func LoadStructureIndex() {
+	start := time.Now()
+	ix, err := structure.Load(db)
+	if err != nil {
+		log.Printf("Failed to load structure index: %v", err)
+		return
+	}
+	structureIndex.Store(ix)
+	log.Printf("Structure index loaded: %d molecules in %s", ix.Size(), time.Since(start).Round(time.Millisecond))
+}
+
+// loadedStructureIndex returns the structure index, responding with an error
+// and returning nil if it has not been loaded yet
+func loadedStructureIndex(c *gin.Context) *structure.Index {
+	ix := structureIndex.Load()
+	if ix == nil {
+		ErrorResponse(c, 503, "Structure search is not available yet, please retry shortly")
+	}
+	return ix
+}
+
+// errNoStructureIndex aborts resolving a filter whose structure conditions
+// cannot be matched yet
+var errNoStructureIndex = errors.New("structure index not loaded")
+
+// resolveConditions matches the structure conditions of the filter against
+// the structure index and its formula conditions against the stored formulas,
+// responding with an error and returning false if a structure condition
+// cannot be matched because the index is not available
+func resolveConditions(c *gin.Context, filter *query.Filter) bool {
+	if !filter.NeedsResolving() {
+		return true
+	}
+	var ix *structure.Index
+	err := filter.Resolve(func(condition query.Condition) ([]int64, error) {
+		switch v := condition.Value.(type) {
+		case *query.Structure:
+			if ix == nil {
+				if ix = loadedStructureIndex(c); ix == nil {
+					return nil, errNoStructureIndex
+				}
+			}
+			return ix.Substructure(v.Query), nil
+		case *query.FormulaQuery:
+			return structure.MatchFormulas(db, v.Matches)
+		}
+		return nil, fmt.Errorf("no resolver for field %s", condition.Field.Name)
+	})
+	if errors.Is(err, errNoStructureIndex) {
+		return false
+	}
+	if err != nil {
+		ErrorResponse(c, 500, "Failed to run structure search")
+		return false
+	}
+	return true
+}
+
+// SubstructureSearch handles GET and POST /api/v1/molecules/substructure
+// The smiles parameter holds a SMILES or SMARTS query; any further search
+// conditions restrict the results as in SearchMolecules.
+func SubstructureSearch(c *gin.Context) {
+	filter, ok := decodeFilter(c)
+	if !ok {
+		return
+	}
+
+	condition, qerr := query.NewCondition("structure", string(query.OpSubstructure), c.Query("smiles"))
+	if qerr != nil {
+		qerr.Field = "smiles"
+		FilterErrorResponse(c, qerr)
+		return
+	}
+	filter.Require(condition)
+
+	if !checkTaxa(c, filter.Taxa()) || !resolveConditions(c, filter) {
+		return
+	}
+	searchMolecules(c, filter)
+}
+
+// Similarity search defaults and limits
+const (
+	defaultSimilarityThreshold = 0.5
+	defaultSimilarityLimit     = 50
+	maxSimilarityLimit         = 1000
+)
+
+// SimilarMolecule is a molecule together with its similarity to the query
+type SimilarMolecule struct {
+	models.Molecule
+	Similarity float64 `json:"similarity"`
+}
+
+// SimilaritySearch handles GET and POST /api/v1/molecules/similarity
+// The query structure is given either as smiles or as the identifier of a
+// MarineNP molecule. Results are the marine molecules with a Tanimoto
+// similarity of at least threshold, most similar first, limited to limit and
+// restricted by any further search conditions.
+func SimilaritySearch(c *gin.Context) {
+	threshold, err := strconv.ParseFloat(c.DefaultQuery("threshold", strconv.FormatFloat(defaultSimilarityThreshold, 'f', -1, 64)), 64)
+	if err != nil || threshold < 0 || threshold > 1 {
+		ErrorResponse(c, 400, "threshold must be a number between 0 and 1")
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultSimilarityLimit)))
+	if err != nil || limit <= 0 || limit > maxSimilarityLimit {
+		ErrorResponse(c, 400, "limit must be between 1 and "+strconv.Itoa(maxSimilarityLimit))
+		return
+	}
+
+	ix := loadedStructureIndex(c)
+	if ix == nil {
+		return
+	}
+	fp, ok := similarityQuery(c, ix)
+	if !ok {
+		return
+	}
+
+	filter, ok := parseFilter(c)
+	if !ok {
+		return
+	}
+
+	// Keep only the hits that also match the filter
+	hits := ix.Similar(fp, threshold)
+	ids := make([]int64, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ID
+	}
+	var allowed []int64
+	sql, args := query.IDPredicate(ids)
+	if err := filter.Apply(db.Model(&models.Molecule{})).Where(sql, args...).Pluck("molecules.id", &allowed).Error; err != nil {
+		ErrorResponse(c, 500, "Failed to search molecules")
+		return
+	}
+	allowedSet := make(map[int64]bool, len(allowed))
+	for _, id := range allowed {
+		allowedSet[id] = true
+	}
+	matched := hits[:0]
+	for _, hit := range hits {
+		if allowedSet[hit.ID] {
+			matched = append(matched, hit)
+		}
+	}
+	total := len(matched)
+	if len(matched) > limit {
+		matched = matched[:limit]
+	}
+
+	// Load the top hits and return them in similarity order
+	pageIDs := make([]int64, len(matched))
+	for i, hit := range matched {
+		pageIDs[i] = hit.ID
+	}
+	var molecules []models.Molecule
+	result := db.Preload("Properties").
+		Preload("Organisms").
+		Preload("GeoLocations").
+		Where("id IN ?", pageIDs).
+		Find(&molecules)
+	if result.Error != nil {
+		ErrorResponse(c, 500, "Failed to search molecules")
+		return
+	}
+	byID := make(map[int64]models.Molecule, len(molecules))
+	for _, m := range molecules {
+		byID[m.ID] = m
+	}
+	similar := make([]SimilarMolecule, 0, len(matched))
+	for _, hit := range matched {
+		if m, ok := byID[hit.ID]; ok {
+			similar = append(similar, SimilarMolecule{Molecule: m, Similarity: hit.Similarity})
+		}
+	}
+
+	jsonData, err := models.MarshalToJSON(gin.H{
+		"molecules": similar,
+		"total":     total,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to marshal response"})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json", jsonData)
+}
+
+// similarityQuery returns the fingerprint of the smiles or identifier
+// parameter, responding with an error and returning false if neither gives
+// a usable structure
+func similarityQuery(c *gin.Context, ix *structure.Index) (chem.Fingerprint, bool) {
+	smiles, identifier := c.Query("smiles"), c.Query("identifier")
+	if (smiles == "") == (identifier == "") {
+		ErrorResponse(c, 400, "Exactly one of smiles or identifier is required")
+		return nil, false
+	}
+
+	if identifier != "" {
+		var molecule models.Molecule
+		if err := db.Select("id, canonical_smiles").Where("identifier = ?", identifier).First(&molecule).Error; err != nil {
+			ErrorResponse(c, 404, "Molecule not found")
+			return nil, false
+		}
+		if fp, ok := ix.Fingerprint(molecule.ID); ok {
+			return fp, true
+		}
+		smiles = molecule.CanonicalSmiles
+	}
+
+	mol, err := chem.ParseSMILES(smiles)
+	if err != nil {
+		ErrorResponse(c, 400, "Invalid SMILES: "+err.Error())
+		return nil, false
+	}
+	return chem.MorganFingerprint(mol), true
+}
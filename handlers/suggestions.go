@@ -0,0 +1,97 @@
+/*
+ * MarineNP Search Suggestion Handlers
+ * Purpose: "Did you mean" suggestions for searches without results
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file loads the names of the marine organisms and molecules and the
+ * synonyms of the molecules into the in-memory fuzzy index, and suggests the
+ * names closest to a keyword or organism search that found nothing.
+ */
+
+package handlers
+
+import (
+	"log"
+	"marinenp/fuzzy"
+	"marinenp/models"
+	"sync/atomic"
+	"time"
+)
+
+// maxSuggestions is the number of suggestions made for a search
+const maxSuggestions = 5
+
+// suggestionIndex is nil until LoadSuggestionIndex has finished
+var suggestionIndex atomic.Pointer[fuzzy.Index]
+
+// LoadSuggestionIndex loads the names suggested for searches without
+// results. The server starts without them and makes no suggestions until
+// they are loaded.
+func LoadSuggestionIndex() {
+	start := time.Now()
+	ix, err := loadSuggestionIndex()
+	if err != nil {
+		log.Printf("Failed to load suggestion index: %v", err)
+		return
+	}
+	suggestionIndex.Store(ix)
+	log.Printf("Suggestion index loaded: %d names in %s", ix.Size(), time.Since(start).Round(time.Millisecond))
+}
+
+// loadSuggestionIndex reads the names of the marine organisms, and the names
+// and synonyms of the marine molecules
+func loadSuggestionIndex() (*fuzzy.Index, error) {
+	ix := fuzzy.New()
+
+	rows, err := db.Model(&models.Organism{}).
+		Select("name, name_aphia_worms").
+		Where("is_marine = TRUE").
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, wormsName *string
+		if err := rows.Scan(&name, &wormsName); err != nil {
+			return nil, err
+		}
+		ix.Add(fuzzy.Organism, deref(name))
+		ix.Add(fuzzy.Organism, deref(wormsName))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Model(&models.Molecule{}).
+		Select("name, synonyms").
+		Where("is_marine = TRUE").
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, synonyms *string
+		if err := rows.Scan(&name, &synonyms); err != nil {
+			return nil, err
+		}
+		ix.Add(fuzzy.Molecule, deref(name))
+		for _, synonym := range splitValues(deref(synonyms)) {
+			ix.Add(fuzzy.Synonym, synonym)
+		}
+	}
+	return ix, rows.Err()
+}
+
+// suggest returns the names of the kinds given, or of all kinds, closest to
+// a text that found nothing, most confident first. It returns an empty list
+// while the suggestion index is loading.
+func suggest(text string, kinds ...fuzzy.Kind) []fuzzy.Suggestion {
+	ix := suggestionIndex.Load()
+	if ix == nil {
+		return []fuzzy.Suggestion{}
+	}
+	return ix.Suggest(text, maxSuggestions, kinds...)
+}
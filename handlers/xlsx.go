@@ -0,0 +1,225 @@
+/*
+ * MarineNP Spreadsheet Export
+ * Purpose: Export molecules as an Excel workbook
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file writes the molecules matching a search as an XLSX workbook with
+ * sheets for the molecules, their properties, their organisms with WoRMS
+ * AphiaIDs, their geographic locations, their citations and the search
+ * itself. Cells keep the types of their database columns and the header rows
+ * are frozen. Sheets are streamed to temporary files, so the workbook is sent
+ * once it is complete.
+ */
+
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"marinenp/columnar"
+	"marinenp/query"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+// xlsxFormat is the format parameter of workbook exports, which are sent as
+// the workbook itself rather than inside an archive
+const xlsxFormat = "xlsx"
+
+// xlsxContentType is the content type of workbooks
+const xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// xlsxSheet streams the rows of one sheet below its frozen header row
+type xlsxSheet struct {
+	stream *excelize.StreamWriter
+	types  []columnar.Type
+	row    int
+	values []interface{}
+}
+
+// newXLSXSheet adds a sheet with a frozen header row of the columns
+func newXLSXSheet(f *excelize.File, name string, headerStyle int, columns []columnar.Column) (*xlsxSheet, error) {
+	if _, err := f.NewSheet(name); err != nil {
+		return nil, err
+	}
+	stream, err := f.NewStreamWriter(name)
+	if err != nil {
+		return nil, err
+	}
+	err = stream.SetPanes(&excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"})
+	if err != nil {
+		return nil, err
+	}
+
+	sheet := &xlsxSheet{stream: stream, types: make([]columnar.Type, len(columns)), row: 1}
+	header := make([]interface{}, len(columns))
+	for i, c := range columns {
+		header[i] = excelize.Cell{StyleID: headerStyle, Value: c.Name}
+		sheet.types[i] = c.Type
+	}
+	if err := stream.SetRow("A1", header); err != nil {
+		return nil, err
+	}
+	sheet.values = make([]interface{}, len(columns))
+	return sheet, nil
+}
+
+// Append writes a row of database values as cells of the column types
+func (s *xlsxSheet) Append(values []interface{}) error {
+	for i, value := range values {
+		s.values[i] = columnar.Value(s.types[i], value)
+	}
+	return s.SetRow(s.values)
+}
+
+// SetRow writes a row of cell values as they are
+func (s *xlsxSheet) SetRow(values []interface{}) error {
+	s.row++
+	cell, err := excelize.CoordinatesToCellName(1, s.row)
+	if err != nil {
+		return fmt.Errorf("sheet rows: %w", err)
+	}
+	return s.stream.SetRow(cell, values)
+}
+
+// xlsxRecordWriter writes export records to the molecules and properties
+// sheets, which share the identifier column
+type xlsxRecordWriter struct {
+	molecules, properties            *xlsxSheet
+	moleculeHeaders, propertyHeaders []string
+	values                           []interface{}
+}
+
+func (w *xlsxRecordWriter) Write(record map[string]interface{}) error {
+	for _, sheet := range []struct {
+		sheet   *xlsxSheet
+		headers []string
+	}{{w.molecules, w.moleculeHeaders}, {w.properties, w.propertyHeaders}} {
+		w.values = w.values[:0]
+		for _, header := range sheet.headers {
+			w.values = append(w.values, record[header])
+		}
+		if err := sheet.sheet.Append(w.values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *xlsxRecordWriter) Flush() error { return nil }
+
+func (w *xlsxRecordWriter) Close() error { return nil }
+
+// writeXLSX writes the workbook of every molecule matching the filter to w
+// and returns the number of molecules written
+func writeXLSX(w io.Writer, filter *query.Filter, opts exportOptions, progress func(rows int64)) (int64, error) {
+	f := excelize.NewFile()
+	defer f.Close()
+	headerStyle, err := f.NewStyle(&excelize.Style{
+		Font: &excelize.Font{Bold: true},
+		Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"#DDEBF7"}},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Molecules and properties, split by the table of each export column
+	columns, properties, err := exportColumns(exportHeaders)
+	if err != nil {
+		return 0, err
+	}
+	records := &xlsxRecordWriter{}
+	var moleculeColumns, propertyColumns []columnar.Column
+	for _, c := range columns {
+		if properties[c.Name] {
+			propertyColumns = append(propertyColumns, c)
+			records.propertyHeaders = append(records.propertyHeaders, c.Name)
+		} else {
+			moleculeColumns = append(moleculeColumns, c)
+			records.moleculeHeaders = append(records.moleculeHeaders, c.Name)
+		}
+	}
+	propertyColumns = append([]columnar.Column{{Name: "identifier"}}, propertyColumns...)
+	records.propertyHeaders = append([]string{"identifier"}, records.propertyHeaders...)
+
+	if records.molecules, err = newXLSXSheet(f, "Molecules", headerStyle, moleculeColumns); err != nil {
+		return 0, err
+	}
+	if records.properties, err = newXLSXSheet(f, "Properties", headerStyle, propertyColumns); err != nil {
+		return 0, err
+	}
+	f.DeleteSheet("Sheet1")
+	rows, err := streamRecords(filter, false, records, progress)
+	if err != nil {
+		return rows, err
+	}
+	if err := records.molecules.stream.Flush(); err != nil {
+		return rows, err
+	}
+	if err := records.properties.stream.Flush(); err != nil {
+		return rows, err
+	}
+
+	links := make([]int, len(exportRelations))
+	for i, relation := range exportRelations {
+		if links[i], err = writeXLSXRelation(f, relation, filter, headerStyle); err != nil {
+			return rows, fmt.Errorf("%s sheet: %w", relation.Name, err)
+		}
+	}
+
+	// The search that produced the workbook
+	search, err := newXLSXSheet(f, "Query", headerStyle, []columnar.Column{{Name: "field"}, {Name: "value"}})
+	if err != nil {
+		return rows, err
+	}
+	queryType := "url"
+	if strings.HasSuffix(opts.QueryName, ".json") {
+		queryType = "json"
+	}
+	summary := [][]interface{}{
+		{"query", opts.FullQuery},
+		{"query_type", queryType},
+		{"exported_at", time.Now().UTC().Format(time.RFC3339)},
+		{"molecules", rows},
+	}
+	for i, relation := range exportRelations {
+		summary = append(summary, []interface{}{strings.ToLower(relation.Name) + "_links", links[i]})
+	}
+	for _, row := range summary {
+		if err := search.SetRow(row); err != nil {
+			return rows, err
+		}
+	}
+	if err := search.stream.Flush(); err != nil {
+		return rows, err
+	}
+
+	f.SetActiveSheet(0)
+	return rows, f.Write(w)
+}
+
+// writeXLSXRelation writes the sheet of a relation of the molecules matching
+// the filter, returning the number of rows written
+func writeXLSXRelation(f *excelize.File, relation exportRelation, filter *query.Filter, headerStyle int) (int, error) {
+	columns, err := relation.columns()
+	if err != nil {
+		return 0, err
+	}
+	sheet, err := newXLSXSheet(f, relation.Name, headerStyle, columns)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	err = relation.rows(func(tx *gorm.DB) *gorm.DB { return filter.Apply(tx) }, func(_ int64, values []interface{}) error {
+		count++
+		return sheet.Append(values)
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, sheet.stream.Flush()
+}
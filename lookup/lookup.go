@@ -0,0 +1,90 @@
+/*
+ * MarineNP Source Lookups
+ * Purpose: Look up database rows in an external source and store the results
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file runs the lookups of "marinenp enrich" and "marinenp taxonomy":
+ * items are read from the database in batches, looked up one by one in a
+ * source, and the result of each is stored as it arrives, so that an
+ * interrupted run keeps what it found. A run stops when the source fails
+ * for many items in a row, as it is then most likely unreachable.
+ */
+
+package lookup
+
+import (
+	"fmt"
+	"log"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BatchSize is the number of items read per query
+const BatchSize = 500
+
+// maxConsecutiveFailures stops a run whose source is unreachable
+const maxConsecutiveFailures = 10
+
+// Counts are the outcomes of a run
+type Counts struct {
+	Checked  int // Items looked up
+	Found    int // Items the source knows
+	NotFound int // Items the source does not know
+	Failed   int // Items that could not be looked up
+}
+
+// Task describes the lookups of a run over items of type T
+type Task[T any] struct {
+	// Next returns up to BatchSize items following last, which is nil for
+	// the first batch. The run ends with an empty batch.
+	Next func(last *T) ([]T, error)
+	// Lookup returns a pointer to the row storing the result of an item,
+	// and whether the source knows the item. Items failing are skipped.
+	Lookup func(item T) (row interface{}, found bool, err error)
+	// Describe names an item in log messages
+	Describe func(item T) string
+	// Upsert replaces the row stored by an earlier run
+	Upsert clause.OnConflict
+}
+
+// Run looks up every item of a task and stores the results
+func Run[T any](db *gorm.DB, task Task[T]) (Counts, error) {
+	var counts Counts
+	consecutive := 0
+	var last *T
+	for {
+		items, err := task.Next(last)
+		if err != nil {
+			return counts, err
+		}
+		if len(items) == 0 {
+			return counts, nil
+		}
+		last = &items[len(items)-1]
+
+		for _, item := range items {
+			counts.Checked++
+			row, found, err := task.Lookup(item)
+			if err != nil {
+				log.Printf("Skipping %s: %v", task.Describe(item), err)
+				counts.Failed++
+				if consecutive++; consecutive >= maxConsecutiveFailures {
+					return counts, fmt.Errorf("%d lookups failed in a row, last: %w", consecutive, err)
+				}
+				continue
+			}
+			consecutive = 0
+			if found {
+				counts.Found++
+			} else {
+				counts.NotFound++
+			}
+
+			if err := db.Clauses(task.Upsert).Create(row).Error; err != nil {
+				return counts, err
+			}
+		}
+	}
+}
@@ -11,12 +11,25 @@
 package main
 
 import (
+	"compress/gzip"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"strings"
 	"time"
 
+	"marinenp/columnar"
 	"marinenp/config"
+	"marinenp/crossref"
+	"marinenp/dwca"
+	"marinenp/fulltext"
 	"marinenp/handlers"
+	"marinenp/models"
+	"marinenp/query"
+	"marinenp/rdf"
+	"marinenp/structure"
+	"marinenp/worms"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/driver/sqlite"
@@ -62,10 +75,194 @@ func main() {
 	sqlDB.SetMaxOpenConns(100)
 	sqlDB.SetConnMaxLifetime(time.Hour)
 
+	// Maintenance Commands
+	// "marinenp fingerprints" precomputes structure and similarity search
+	// fingerprints and exits
+	if len(os.Args) > 1 && os.Args[1] == "fingerprints" {
+		count, err := structure.BuildFingerprints(db)
+		if err != nil {
+			log.Fatal("Failed to build fingerprints:", err)
+		}
+		fmt.Printf("Stored fingerprints for %d molecules\n", count)
+		return
+	}
+
+	// "marinenp dump rdf <file.nt[.gz]>" writes the marine molecules, organisms
+	// and citations of the release as N-Triples, gzipped for a .gz file, and
+	// exits
+	if len(os.Args) == 4 && os.Args[1] == "dump" && os.Args[2] == "rdf" {
+		file, err := os.Create(os.Args[3])
+		if err != nil {
+			log.Fatal("Failed to create dump file:", err)
+		}
+		var w io.WriteCloser = file
+		if strings.HasSuffix(os.Args[3], ".gz") {
+			w = gzip.NewWriter(file)
+		}
+		resources := rdf.Resources{Base: strings.TrimSuffix(cfg.API.PublicURL, "/") + cfg.API.Prefix}
+		counts, err := rdf.Dump(db, resources, w)
+		if err == nil && w != file {
+			err = w.Close()
+		}
+		if err == nil {
+			err = file.Close()
+		}
+		if err != nil {
+			log.Fatal("Failed to dump RDF:", err)
+		}
+		fmt.Printf("Dumped %d triples of %d molecules, %d organisms, %d citations and %d locations\n",
+			counts.Triples, counts.Molecules, counts.Organisms, counts.Citations, counts.Locations)
+		return
+	}
+
+	// "marinenp dump parquet|arrow <file.zip>" writes every table of the
+	// release as a Parquet or Arrow IPC file into a zip and exits
+	if len(os.Args) > 1 && os.Args[1] == "dump" {
+		if len(os.Args) != 4 || (os.Args[2] != columnar.Parquet && os.Args[2] != columnar.Arrow) {
+			log.Fatal("Usage: marinenp dump parquet|arrow <file.zip> or marinenp dump rdf <file.nt[.gz]>")
+		}
+		file, err := os.Create(os.Args[3])
+		if err != nil {
+			log.Fatal("Failed to create dump file:", err)
+		}
+		tables, err := columnar.Dump(db, os.Args[2], file)
+		if err == nil {
+			err = file.Close()
+		}
+		if err != nil {
+			log.Fatal("Failed to dump tables:", err)
+		}
+		for _, t := range tables {
+			fmt.Printf("Dumped %d rows of %s\n", t.Rows, t.Table)
+		}
+		return
+	}
+
+	// "marinenp dwca <file.zip>" writes the Darwin Core Archive of the marine
+	// organisms producing the molecules of the release and exits
+	if len(os.Args) > 1 && os.Args[1] == "dwca" {
+		if len(os.Args) != 3 {
+			log.Fatal("Usage: marinenp dwca <file.zip>")
+		}
+		file, err := os.Create(os.Args[2])
+		if err != nil {
+			log.Fatal("Failed to create archive file:", err)
+		}
+		counts, err := dwca.Write(db, &query.Filter{}, dwca.Metadata{
+			Publisher: cfg.DarwinCore.Publisher,
+			Contact:   cfg.DarwinCore.Contact,
+			License:   cfg.DarwinCore.License,
+			Version:   cfg.Version,
+			PubDate:   cfg.LastUpdate,
+		}, file)
+		if err == nil {
+			err = file.Close()
+		}
+		if err != nil {
+			log.Fatal("Failed to write Darwin Core Archive:", err)
+		}
+		fmt.Printf("Wrote %d taxa with %d natural products\n", counts.Taxa, counts.Measurements)
+		return
+	}
+
+	// Lookups in external sources identify MarineNP and, for the polite use
+	// of the Crossref API, its contact address
+	userAgent := "MarineNP/" + cfg.Version
+	if cfg.Crossref.Mailto != "" {
+		userAgent += " (mailto:" + cfg.Crossref.Mailto + ")"
+	}
+
+	// "marinenp enrich [-refresh]" looks up the DOIs of the citations in the
+	// Crossref source of CROSSREF_SOURCE, stores their metadata and exits
+	if len(os.Args) > 1 && os.Args[1] == "enrich" {
+		if len(os.Args) > 3 || (len(os.Args) == 3 && os.Args[2] != "-refresh") {
+			log.Fatal("Usage: marinenp enrich [-refresh]")
+		}
+		source, err := crossref.NewSource(cfg.Crossref.Source, userAgent)
+		if err != nil {
+			log.Fatal("Failed to open Crossref source:", err)
+		}
+		counts, err := crossref.Enrich(db, source, len(os.Args) == 3)
+		fmt.Printf("Looked up %d DOIs: %d found, %d not found, %d failed\n",
+			counts.Checked, counts.Found, counts.NotFound, counts.Failed)
+		if err != nil {
+			log.Fatal("Failed to enrich citations:", err)
+		}
+		return
+	}
+
+	// "marinenp taxonomy [-refresh]" looks up the AphiaIDs of the marine
+	// organisms in the WoRMS source of WORMS_SOURCE, stores their
+	// classification and exits
+	if len(os.Args) > 1 && os.Args[1] == "taxonomy" {
+		if len(os.Args) > 3 || (len(os.Args) == 3 && os.Args[2] != "-refresh") {
+			log.Fatal("Usage: marinenp taxonomy [-refresh]")
+		}
+		source, err := worms.NewSource(cfg.Worms.Source, userAgent)
+		if err != nil {
+			log.Fatal("Failed to open WoRMS source:", err)
+		}
+		counts, err := worms.Classify(db, source, len(os.Args) == 3)
+		fmt.Printf("Looked up %d AphiaIDs: %d found, %d not found, %d failed\n",
+			counts.Checked, counts.Found, counts.NotFound, counts.Failed)
+		if err != nil {
+			log.Fatal("Failed to classify organisms:", err)
+		}
+		return
+	}
+
+	// "marinenp index" rebuilds the full-text index of keyword search and
+	// exits
+	if len(os.Args) > 1 && os.Args[1] == "index" {
+		count, err := fulltext.Build(db)
+		if err != nil {
+			log.Fatal("Failed to build full-text index:", err)
+		}
+		fmt.Printf("Indexed %d molecules\n", count)
+		return
+	}
+
+	// Citation Metadata and Organism Taxonomy
+	// Create the tables of citation metadata and organism classifications
+	// read by the endpoints if they have not been filled yet
+	if err := db.AutoMigrate(&models.CitationMetadata{}, &models.OrganismTaxonomy{}); err != nil {
+		log.Fatal("Failed to create citation metadata and taxonomy tables:", err)
+	}
+
 	// Handler Setup
 	// Initialize database connection in request handlers
 	handlers.SetDB(db)
 
+	// Structure Index
+	// Load molecule structures and fingerprints for structure and similarity
+	// search in the background
+	go handlers.LoadStructureIndex()
+
+	// Search Suggestions
+	// Load the organism and molecule names suggested for searches without
+	// results in the background
+	go handlers.LoadSuggestionIndex()
+
+	// Full-Text Index
+	// Rebuild the keyword search index in the background if the database
+	// changed since it was built; keyword search matches with LIKE meanwhile
+	go func() {
+		start := time.Now()
+		rebuilt, err := fulltext.Load(db)
+		switch {
+		case err != nil:
+			log.Printf("Full-text index not available, keyword search is unranked: %v", err)
+		case rebuilt:
+			log.Printf("Full-text index rebuilt in %s", time.Since(start).Round(time.Millisecond))
+		}
+	}()
+
+	// Export Jobs
+	// Start the workers that write exports in the background
+	if err := handlers.StartExportJobs(cfg.Export.Dir, cfg.Export.Workers, cfg.Export.QueueSize, cfg.Export.Retention); err != nil {
+		log.Fatal("Failed to start export jobs:", err)
+	}
+
 	// Router Setup
 	// Initialize Gin router with CORS configuration
 	r := gin.Default()
@@ -74,7 +271,7 @@ func main() {
 	// Enable Cross-Origin Resource Sharing with appropriate headers
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", cfg.API.CorsAllowOrigin)
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -93,17 +290,67 @@ func main() {
 		// Molecules Endpoints
 		// Endpoints for accessing and analyzing molecular data
 		api.GET("/molecules/:identifier", handlers.GetMoleculeByID)
+		api.GET("/molecules/inchikey/:key", handlers.GetMoleculesByInChIKey)
 		api.GET("/molecules/search", handlers.SearchMolecules)
 		api.GET("/molecules/properties/ranges", handlers.GetPropertyRanges)
 		api.GET("/molecules/export", handlers.ExportMolecules)
 		api.GET("/molecules/analyze", handlers.AnalyzeMolecules)
 
+		// POST variants take the search filter as a JSON document, for
+		// filters too large for a query string
+		api.POST("/molecules/search", handlers.SearchMolecules)
+		api.POST("/molecules/export", handlers.ExportMolecules)
+		api.POST("/molecules/analyze", handlers.AnalyzeMolecules)
+
+		// Export Jobs Endpoints
+		// Queue an export, poll its progress and download the finished file
+		api.POST("/exports", handlers.CreateExportJob)
+		api.GET("/exports/:id", handlers.GetExportJob)
+		api.GET("/exports/:id/download", handlers.DownloadExportJob)
+
+		// Structure Search Endpoints
+		// Substructure search with a SMILES or SMARTS query and Tanimoto
+		// similarity search with a SMILES or molecule identifier
+		api.GET("/molecules/substructure", handlers.SubstructureSearch)
+		api.POST("/molecules/substructure", handlers.SubstructureSearch)
+		api.GET("/molecules/similarity", handlers.SimilaritySearch)
+		api.POST("/molecules/similarity", handlers.SimilaritySearch)
+
+		// Dereplication Endpoints
+		// Match observed LC-MS m/z values against exact molecular weights
+		api.GET("/molecules/dereplicate", handlers.Dereplicate)
+		api.POST("/molecules/dereplicate", handlers.Dereplicate)
+
+		// Identifier Resolution Endpoints
+		// Resolve batches of identifiers, InChIKeys, CAS numbers, names and
+		// synonyms, as JSON or as a CSV export with ?format=csv
+		api.POST("/molecules/resolve", handlers.ResolveIdentifiers)
+
+		// Graph Query Endpoint
+		// Read-only SPARQL SELECT queries over the molecules, organisms,
+		// citations and locations
+		api.GET("/sparql", handlers.QuerySPARQL)
+		api.POST("/sparql", handlers.QuerySPARQL)
+
+		// Scaffolds Endpoints
+		// Murcko scaffolds with statistics, their molecules and the scaffolds
+		// enriched in a taxon
+		api.GET("/scaffolds", handlers.GetScaffolds)
+		api.POST("/scaffolds", handlers.GetScaffolds)
+		api.GET("/scaffolds/molecules", handlers.GetScaffoldMolecules)
+		api.POST("/scaffolds/molecules", handlers.GetScaffoldMolecules)
+		api.GET("/scaffolds/enriched", handlers.GetEnrichedScaffolds)
+		api.POST("/scaffolds/enriched", handlers.GetEnrichedScaffolds)
+
 		// Organisms Endpoints
-		// Endpoints for accessing organism data and their associated molecules
+		// Endpoints for accessing organism data and their associated molecules,
+		// and the Darwin Core Archive of the organisms for GBIF and OBIS
 		api.GET("/organisms", handlers.GetOrganisms)
 		api.GET("/organisms/:id", handlers.GetOrganismByID)
 		api.GET("/organisms/:id/molecules", handlers.GetMoleculesByOrganism)
 		api.GET("/organisms/autocomplete", handlers.GetOrganismsAutocomplete)
+		api.GET("/organisms/dwca", handlers.ExportDarwinCore)
+		api.POST("/organisms/dwca", handlers.ExportDarwinCore)
 
 		// Collections Endpoints
 		// Endpoints for accessing collection data and their molecules
@@ -112,9 +359,15 @@ func main() {
 		api.GET("/collections/:id/molecules", handlers.GetMoleculesByCollection)
 
 		// Citations Endpoints
-		// Endpoints for accessing literature citations
+		// Endpoints for accessing literature citations and the molecules they
+		// report, and the references of a molecule or a molecule search as
+		// JSON, BibTeX, RIS or CSL-JSON
 		api.GET("/citations", handlers.GetCitations)
 		api.GET("/citations/:id", handlers.GetCitationByID)
+		api.GET("/citations/:id/molecules", handlers.GetMoleculesByCitation)
+		api.GET("/molecules/:identifier/citations", handlers.GetMoleculeCitations)
+		api.GET("/molecules/citations", handlers.GetMoleculeSetCitations)
+		api.POST("/molecules/citations", handlers.GetMoleculeSetCitations)
 
 		// Geographic Locations Endpoints
 		// Endpoints for accessing location data and associated molecules
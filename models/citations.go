@@ -0,0 +1,51 @@
+/*
+ * MarineNP Citation Queries
+ * Purpose: Load the citations of molecules
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file loads the citations of molecules through the polymorphic citables
+ * table, for the JSON API as well as the RDF views and dump.
+ */
+
+package models
+
+import (
+	"encoding/json"
+
+	"gorm.io/gorm"
+)
+
+// CitableMolecule is the citable_type of the citations of molecules
+const CitableMolecule = `App\Models\Molecule`
+
+// MoleculeCitations returns the citations of molecules by molecule id. The
+// ids are bound as one JSON array, as in query.IDPredicate.
+func MoleculeCitations(db *gorm.DB, molecules []Molecule) (map[int64][]Citation, error) {
+	ids := make([]int64, len(molecules))
+	for i, m := range molecules {
+		ids[i] = m.ID
+	}
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		MoleculeID int64
+		Citation
+	}
+	err = db.Table("citations").
+		Select("citables.citable_id AS molecule_id, citations.*").
+		Joins("JOIN citables ON citables.citation_id = citations.id").
+		Where("citables.citable_type = ? AND citables.citable_id IN (SELECT value FROM json_each(?))", CitableMolecule, string(data)).
+		Order("citables.id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	citations := make(map[int64][]Citation, len(molecules))
+	for _, row := range rows {
+		citations[row.MoleculeID] = append(citations[row.MoleculeID], row.Citation)
+	}
+	return citations, nil
+}
@@ -48,6 +48,9 @@ type Molecule struct {
 	Properties          Properties `json:"properties" gorm:"foreignKey:MoleculeID"`
 	Organisms           []Organism `json:"organisms" gorm:"many2many:molecule_organism;"`
 	GeoLocations        []GeoLocation `json:"geo_locations" gorm:"many2many:geo_location_molecule;"`
+	// Citations are linked through the polymorphic citables table, which GORM
+	// cannot preload, and are loaded by the handlers that return them
+	Citations           []Citation `json:"citations,omitempty" gorm:"-"`
 }
 
 // Organism represents a biological organism with taxonomic and metadata information
@@ -65,6 +68,31 @@ type Organism struct {
 	EnvironmentAphiaWorms string  `json:"environment_aphia_worms"`
 	IsMarine            *bool     `json:"is_marine" gorm:"default:false"`
 	Molecules           []Molecule `json:"molecules" gorm:"many2many:molecule_organism;"`
+	Taxonomy            *OrganismTaxonomy `json:"taxonomy,omitempty" gorm:"foreignKey:AphiaIDWorms;references:AphiaIDWorms"`
+}
+
+// OrganismTaxonomy holds the WoRMS classification of an AphiaID, with the
+// source and time of retrieval. AphiaIDs the source does not know are kept
+// with the status "not_found" so that they are not looked up again.
+type OrganismTaxonomy struct {
+	ID           int64      `json:"-" gorm:"primaryKey"`
+	AphiaIDWorms int        `json:"aphiaid_worms" gorm:"column:aphiaid_worms;uniqueIndex"`
+	Status       string     `json:"status"` // "found" or "not_found"
+	Kingdom      string     `json:"kingdom"`
+	Phylum       string     `json:"phylum" gorm:"index"`
+	Class        string     `json:"class"`
+	Order        string     `json:"order"`
+	Family       string     `json:"family"`
+	Genus        string     `json:"genus"`
+	Source       string     `json:"source"` // URL or file the classification was read from
+	Record       string     `json:"-"`      // AphiaRecord as read from the source
+	CreatedAt    SQLiteTime `json:"created_at"`
+	UpdatedAt    SQLiteTime `json:"retrieved_at"`
+}
+
+// TableName specifies the table name for OrganismTaxonomy
+func (OrganismTaxonomy) TableName() string {
+	return "organism_taxonomy"
 }
 
 // Properties represents chemical properties and descriptors of a molecule
@@ -153,6 +181,40 @@ type Citation struct {
 	Active       bool      `json:"active"`
 	CreatedAt    SQLiteTime `json:"created_at"`
 	UpdatedAt    SQLiteTime `json:"updated_at"`
+	Metadata     *CitationMetadata `json:"metadata,omitempty" gorm:"foreignKey:CitationID"`
+}
+
+// CitationMetadata holds the metadata of a citation's DOI as retrieved from a
+// Crossref-format source, with the source and time of retrieval. DOIs the
+// source does not know are kept with the status "not_found" so that they are
+// not looked up again.
+type CitationMetadata struct {
+	ID         int64      `json:"-" gorm:"primaryKey"`
+	CitationID int64      `json:"-" gorm:"uniqueIndex"`
+	DOI        string     `json:"doi"`
+	Status     string     `json:"status"` // "found" or "not_found"
+	Title      string     `json:"title"`
+	Authors    string     `json:"authors"` // "Family, Given" names separated by semicolons
+	Journal    string     `json:"journal" gorm:"index"`
+	Year       *int       `json:"year" gorm:"index"`
+	Volume     string     `json:"volume"`
+	Issue      string     `json:"issue"`
+	Pages      string     `json:"pages"`
+	Source     string     `json:"source"` // URL or file the metadata was read from
+	Record     string     `json:"-"`      // Work as read from the source
+	CreatedAt  SQLiteTime `json:"created_at"`
+	UpdatedAt  SQLiteTime `json:"retrieved_at"`
+}
+
+// Statuses of citation metadata and organism taxonomy
+const (
+	MetadataFound    = "found"
+	MetadataNotFound = "not_found"
+)
+
+// TableName specifies the table name for CitationMetadata
+func (CitationMetadata) TableName() string {
+	return "citation_metadata"
 }
 
 // GeoLocation represents a geographic location where molecules were found
@@ -176,4 +238,17 @@ type OBISCache struct {
 // TableName specifies the table name for OBISCache
 func (OBISCache) TableName() string {
 	return "obis_cache"
-} 
\ No newline at end of file
+}
+
+// Fingerprint stores the precomputed substructure and similarity fingerprints
+// of a molecule, refreshed by the fingerprints command
+type Fingerprint struct {
+	ID           int64      `json:"id" gorm:"primaryKey"`
+	MoleculeID   int64      `json:"molecule_id" gorm:"uniqueIndex"`
+	Substructure []byte     `json:"-"`           // Path fingerprint used to screen substructure searches
+	Similarity   []byte     `json:"-"`           // Circular fingerprint used for Tanimoto similarity
+	Version      int        `json:"version"`     // chem.FingerprintVersion the fingerprints were computed with
+	SmilesHash   string     `json:"smiles_hash"` // Hash of the canonical SMILES the fingerprints were computed from
+	CreatedAt    SQLiteTime `json:"created_at"`
+	UpdatedAt    SQLiteTime `json:"updated_at"`
+}
@@ -0,0 +1,167 @@
+/*
+ * MarineNP Query Documents
+ * Purpose: JSON form of a molecule search filter
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file defines the JSON document accepted by the POST variants of the
+ * molecule search, export and analysis endpoints. Query-string requests are
+ * converted to the same Document, so both forms are validated by one code path.
+ */
+
+package query
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Document is the JSON form of a molecule search filter, e.g.
+//
+//	{
+//	  "keyword": "toxin",
+//	  "conditions": [
+//	    {"field": "properties.molecular_weight", "operator": "lt", "value": 500},
+//	    {"combinator": "or", "conditions": [
+//	      {"field": "organism", "operator": "contains", "value": "Aspergillus"},
+//	      {"field": "organism", "operator": "contains", "value": "Penicillium"}
+//	    ]},
+//	    {"not": true, "field": "properties.chemical_super_class", "operator": "eq", "value": "Lipids"}
+//	  ]
+//	}
+type Document struct {
+	Keyword    string  `json:"keyword,omitempty"`
+	Combinator string  `json:"combinator,omitempty"`
+	Not        bool    `json:"not,omitempty"`
+	Conditions []Entry `json:"conditions,omitempty"`
+	OrderBy    string  `json:"orderByString,omitempty"`
+	OrderDir   string  `json:"orderDir,omitempty"`
+}
+
+// Entry is either a condition (field, operator, value) or a nested group
+// (combinator, conditions); both may be negated with not
+type Entry struct {
+	Field      string      `json:"field,omitempty"`
+	Operator   string      `json:"operator,omitempty"`
+	Value      interface{} `json:"value,omitempty"`
+	Not        bool        `json:"not,omitempty"`
+	Combinator string      `json:"combinator,omitempty"`
+	Conditions []Entry     `json:"conditions,omitempty"`
+}
+
+// ParseJSON builds a Filter from a JSON Document. Unknown keys are rejected so
+// that a misspelt filter does not silently match everything.
+func ParseJSON(data []byte) (*Filter, error) {
+	var doc Document
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, &Error{Reason: fmt.Sprintf("malformed JSON filter: %v", err)}
+	}
+	return doc.Filter()
+}
+
+// Filter validates the document and returns the corresponding Filter
+func (d *Document) Filter() (*Filter, error) {
+	filter := &Filter{Keyword: strings.TrimSpace(d.Keyword)}
+
+	b := &builder{}
+	root, err := b.group("", "conditions", d.Combinator, d.Not, d.Conditions, 0)
+	if err != nil {
+		return nil, err
+	}
+	filter.Root = root
+
+	sort, err := parseSort(d.OrderBy, d.OrderDir)
+	if err != nil {
+		return nil, err
+	}
+	filter.Sort = sort
+
+	return filter, nil
+}
+
+// builder validates entries while tracking the overall number of conditions
+type builder struct {
+	count int
+}
+
+// group validates the entries of a group; path names the entry that opened
+// the group and is empty for the root
+func (b *builder) group(path, prefix, combinator string, negate bool, entries []Entry, depth int) (*Group, *Error) {
+	if depth > MaxDepth {
+		return nil, &Error{Path: path, Reason: fmt.Sprintf("groups may be nested at most %d levels deep", MaxDepth)}
+	}
+
+	g := &Group{Combinator: And, Negate: negate}
+	switch strings.ToLower(combinator) {
+	case "", "and":
+	case "or":
+		g.Combinator = Or
+	default:
+		return nil, &Error{Path: path, Reason: fmt.Sprintf("unknown combinator %q", combinator)}
+	}
+
+	for i, entry := range entries {
+		entryPath := fmt.Sprintf("%s[%d]", prefix, i)
+		value, err := entryValue(entryPath, entry.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		if entry.Conditions != nil || entry.Combinator != "" {
+			if entry.Field != "" {
+				return nil, &Error{Path: entryPath, Field: entry.Field, Reason: "an entry cannot be both a condition and a group"}
+			}
+			child, err := b.group(entryPath, entryPath+"[conditions]", entry.Combinator, entry.Not, entry.Conditions, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if len(child.Children) > 0 {
+				g.Children = append(g.Children, child)
+			}
+			continue
+		}
+
+		// Rows the user added but never filled in are ignored
+		if value == "" {
+			continue
+		}
+
+		b.count++
+		if b.count > MaxConditions {
+			return nil, &Error{Path: entryPath, Reason: fmt.Sprintf("at most %d conditions are allowed", MaxConditions)}
+		}
+
+		condition, err := NewCondition(entry.Field, entry.Operator, value)
+		if err != nil {
+			err.Path = entryPath
+			return nil, err
+		}
+		condition.Negate = entry.Not
+		g.Children = append(g.Children, condition)
+	}
+
+	return g, nil
+}
+
+// entryValue converts a JSON scalar into the string form used by NewCondition
+func entryValue(path string, value interface{}) (string, *Error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return "", &Error{Path: path, Reason: "value must be a string, number or boolean"}
+}
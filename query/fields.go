@@ -0,0 +1,193 @@
+/*
+ * MarineNP Query Fields
+ * Purpose: Whitelist of searchable molecule fields and their allowed operators
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file defines every field that may appear in a molecule search condition,
+ * the column it maps to and the operators that are valid for its value type.
+ * Anything not listed here is rejected before it can reach the SQL builder.
+ */
+
+package query
+
+// Kind describes the value type of a searchable field
+type Kind int
+
+const (
+	KindString Kind = iota
+	KindInt
+	KindFloat
+	KindBool
+)
+
+// String returns the name of the kind as used in error messages
+func (k Kind) String() string {
+	switch k {
+	case KindInt:
+		return "integer"
+	case KindFloat:
+		return "number"
+	case KindBool:
+		return "boolean"
+	default:
+		return "string"
+	}
+}
+
+// Source identifies which table a field is read from
+type Source int
+
+const (
+	SourceMolecule Source = iota
+	SourceProperties
+	SourceOrganism
+	SourceOrganismID
+	SourceCitation
+	SourceStructure
+	SourceFormula
+)
+
+// Operator is a comparison operator accepted in search conditions
+type Operator string
+
+const (
+	OpEq         Operator = "eq"
+	OpNe         Operator = "ne"
+	OpLt         Operator = "lt"
+	OpLte        Operator = "lte"
+	OpGt         Operator = "gt"
+	OpGte        Operator = "gte"
+	OpContains   Operator = "contains"
+	OpStartsWith Operator = "startsWith"
+	OpEndsWith   Operator = "endsWith"
+
+	// OpSubstructure matches molecules containing a SMILES or SMARTS query
+	OpSubstructure Operator = "substructure"
+	// OpInTaxon matches organisms named after a taxon or below it, e.g.
+	// "Aspergillus" matches "Aspergillus flavus", or classified in it by
+	// WoRMS, e.g. "Tetraodontidae" matches "Takifugu rubripes"
+	OpInTaxon Operator = "inTaxon"
+	// OpMatches matches molecular formulas against an element range pattern
+	OpMatches Operator = "matches"
+)
+
+// Field describes a searchable field of the molecule search
+type Field struct {
+	Name   string // Name used in the request, e.g. "properties.alogp"
+	Column string // Column name in the source table
+	Source Source
+	Kind   Kind
+}
+
+// operatorsByKind lists the operators that are valid for each value type
+var operatorsByKind = map[Kind][]Operator{
+	KindString: {OpEq, OpNe, OpLt, OpLte, OpGt, OpGte, OpContains, OpStartsWith, OpEndsWith},
+	KindInt:    {OpEq, OpNe, OpLt, OpLte, OpGt, OpGte},
+	KindFloat:  {OpEq, OpNe, OpLt, OpLte, OpGt, OpGte},
+	KindBool:   {OpEq, OpNe},
+}
+
+// Operators returns the operators that are valid for the field
+func (f *Field) Operators() []Operator {
+	switch f.Source {
+	case SourceOrganism:
+		return []Operator{OpEq, OpNe, OpContains, OpStartsWith, OpEndsWith, OpInTaxon}
+	case SourceOrganismID:
+		return []Operator{OpEq, OpNe}
+	case SourceCitation:
+		if f.Kind == KindString {
+			return []Operator{OpEq, OpNe, OpContains, OpStartsWith, OpEndsWith}
+		}
+	case SourceStructure:
+		return []Operator{OpSubstructure}
+	case SourceFormula:
+		return []Operator{OpEq, OpMatches}
+	}
+	return operatorsByKind[f.Kind]
+}
+
+// Allows reports whether the operator is valid for the field
+func (f *Field) Allows(op Operator) bool {
+	for _, allowed := range f.Operators() {
+		if allowed == op {
+			return true
+		}
+	}
+	return false
+}
+
+// Sortable reports whether the field may be used to order molecule results
+func (f *Field) Sortable() bool {
+	return f.Source == SourceMolecule
+}
+
+// fields is the whitelist of searchable fields, keyed by request name
+var fields = map[string]*Field{}
+
+func register(source Source, prefix string, kind Kind, columns ...string) {
+	for _, column := range columns {
+		name := prefix + column
+		fields[name] = &Field{Name: name, Column: column, Source: source, Kind: kind}
+	}
+}
+
+func init() {
+	// Molecule columns
+	register(SourceMolecule, "", KindString,
+		"identifier", "name", "cas", "iupac_name", "synonyms",
+		"standard_inchi", "standard_inchi_key", "canonical_smiles",
+		"sugar_free_smiles", "murko_framework", "status")
+	register(SourceMolecule, "", KindInt,
+		"id", "organism_count", "geo_count", "citation_count", "collection_count",
+		"synonym_count", "variants_count", "annotation_level", "name_trust_level")
+	register(SourceMolecule, "", KindBool,
+		"has_stereo", "has_variants", "is_tautomer", "is_parent")
+
+	// Properties columns
+	register(SourceProperties, "properties.", KindString,
+		"molecular_formula", "murcko_framework", "chemical_class",
+		"chemical_sub_class", "chemical_super_class", "direct_parent_classification",
+		"np_classifier_pathway", "np_classifier_superclass", "np_classifier_class")
+	register(SourceProperties, "properties.", KindInt,
+		"total_atom_count", "heavy_atom_count", "rotatable_bond_count",
+		"hydrogen_bond_acceptors", "hydrogen_bond_donors",
+		"hydrogen_bond_acceptors_lipinski", "hydrogen_bond_donors_lipinski",
+		"lipinski_rule_of_five_violations", "aromatic_rings_count",
+		"number_of_minimal_rings", "formal_charge")
+	register(SourceProperties, "properties.", KindFloat,
+		"molecular_weight", "exact_molecular_weight", "alogp",
+		"topological_polar_surface_area", "van_der_walls_volume",
+		"qed_drug_likeliness", "np_likeness", "fractioncsp3")
+	register(SourceProperties, "properties.", KindBool,
+		"contains_sugar", "contains_ring_sugars", "contains_linear_sugars",
+		"np_classifier_is_glycoside")
+
+	// Organism lookups
+	fields["organism"] = &Field{Name: "organism", Source: SourceOrganism, Kind: KindString}
+	fields["organism_id"] = &Field{Name: "organism_id", Column: "organism_id", Source: SourceOrganismID, Kind: KindInt}
+
+	// Citation lookups, through the citables of the molecules, and the
+	// Crossref metadata of the citations
+	fields["citation"] = &Field{Name: "citation", Source: SourceCitation, Kind: KindString}
+	fields["doi"] = &Field{Name: "doi", Column: "citations.doi", Source: SourceCitation, Kind: KindString}
+	fields["journal"] = &Field{Name: "journal", Column: "citation_metadata.journal", Source: SourceCitation, Kind: KindString}
+	fields["publication_year"] = &Field{Name: "publication_year", Column: "citation_metadata.year", Source: SourceCitation, Kind: KindInt}
+
+	// Structure search over canonical_smiles
+	fields["structure"] = &Field{Name: "structure", Column: "canonical_smiles", Source: SourceStructure, Kind: KindString}
+
+	// Formula search over properties.molecular_formula, independent of element order
+	fields["formula"] = &Field{Name: "formula", Column: "molecular_formula", Source: SourceFormula, Kind: KindString}
+}
+
+// LookupField returns the whitelisted field with the given request name
+func LookupField(name string) (*Field, bool) {
+	f, ok := fields[name]
+	return f, ok
+}
+
+// LookupProperty returns the whitelisted properties field with the given column name
+func LookupProperty(column string) (*Field, bool) {
+	return LookupField("properties." + column)
+}
@@ -0,0 +1,290 @@
+/*
+ * MarineNP Query Filter
+ * Purpose: Typed representation of molecule search requests
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file defines the validated Filter and reads it from the
+ * conditions[i][field|operator|value], keyword and ordering parameters sent by
+ * the web interface. Conditions may be nested in AND/OR groups and negated.
+ * Search, export and analysis all build their SQL from the same Filter.
+ */
+
+package query
+
+import (
+	"fmt"
+	"marinenp/crossref"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Combinator joins the children of a Group
+type Combinator string
+
+const (
+	And Combinator = "and"
+	Or  Combinator = "or"
+)
+
+// Limits that keep a single request from producing an unbounded SQL statement
+const (
+	MaxDepth      = 8
+	MaxConditions = 1000
+)
+
+// Node is either a Condition or a Group
+type Node interface {
+	node()
+}
+
+// Condition is a single validated comparison, e.g. properties.alogp < 5
+type Condition struct {
+	Field    *Field
+	Operator Operator
+	Value    interface{} // string, int64, float64 or bool depending on Field.Kind; *Structure or *FormulaQuery for resolved fields
+	Negate   bool
+}
+
+// Group combines its children with AND or OR, optionally negated
+type Group struct {
+	Combinator Combinator
+	Negate     bool
+	Children   []Node
+}
+
+func (Condition) node() {}
+func (*Group) node()    {}
+
+// Sort is a validated ordering of molecule results
+type Sort struct {
+	Field *Field
+	Desc  bool
+}
+
+// Filter is the parsed form of a molecule search request
+type Filter struct {
+	Keyword string
+	Root    *Group
+	Sort    *Sort
+
+	// keywordMatch caches the full-text query of the keyword once
+	// KeywordMatch has decided how the keyword is matched
+	keywordMatch   string
+	keywordChecked bool
+}
+
+// Taxa returns the taxa of the inTaxon conditions of the filter
+func (f *Filter) Taxa() []string {
+	if f.Root == nil {
+		return nil
+	}
+	return f.Root.taxa()
+}
+
+func (g *Group) taxa() []string {
+	var taxa []string
+	for _, child := range g.Children {
+		switch n := child.(type) {
+		case Condition:
+			if n.Operator == OpInTaxon {
+				taxa = append(taxa, n.Value.(string))
+			}
+		case *Group:
+			taxa = append(taxa, n.taxa()...)
+		}
+	}
+	return taxa
+}
+
+// Require adds a condition or group that every result must match, on top
+// of whatever the root group already requires
+func (f *Filter) Require(n Node) {
+	if f.Root == nil || len(f.Root.Children) == 0 {
+		f.Root = &Group{Combinator: And}
+	} else if f.Root.Combinator != And || f.Root.Negate {
+		f.Root = &Group{Combinator: And, Children: []Node{f.Root}}
+	}
+	f.Root.Children = append(f.Root.Children, n)
+}
+
+// Error describes why a search request was rejected
+type Error struct {
+	Path     string `json:"path,omitempty"` // Parameter prefix of the offending condition
+	Field    string `json:"field,omitempty"`
+	Operator string `json:"operator,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	if e.Path == "" {
+		return "invalid query: " + e.Reason
+	}
+	return fmt.Sprintf("invalid condition %s: %s", e.Path, e.Reason)
+}
+
+// Parse builds a Filter from the query string of a molecule search request.
+// Conditions use the conditions[i][field|operator|value] form; an entry may
+// instead be a nested group with its own [combinator], [not] and [conditions]:
+//
+//	conditions[1][combinator]=or
+//	conditions[1][conditions][0][field]=organism ...
+//	conditions[2][not]=1&conditions[2][field]=properties.chemical_super_class ...
+func Parse(values url.Values) (*Filter, error) {
+	doc := &Document{
+		Keyword:    values.Get("keyword"),
+		Combinator: values.Get("combinator"),
+		OrderBy:    values.Get("orderByString"),
+		OrderDir:   values.Get("orderDir"),
+	}
+
+	var err *Error
+	if doc.Not, err = parseFlag("", values.Get("not")); err != nil {
+		return nil, err
+	}
+	if doc.Conditions, err = entriesFromValues(values, "conditions"); err != nil {
+		return nil, err
+	}
+
+	return doc.Filter()
+}
+
+// entriesFromValues collects the bracketed entries under prefix, e.g.
+// conditions[1][conditions], stopping at the first index with no parameters
+func entriesFromValues(values url.Values, prefix string) ([]Entry, *Error) {
+	var entries []Entry
+	for i := 0; ; i++ {
+		path := fmt.Sprintf("%s[%d]", prefix, i)
+		entry := Entry{
+			Field:      values.Get(path + "[field]"),
+			Operator:   values.Get(path + "[operator]"),
+			Combinator: values.Get(path + "[combinator]"),
+		}
+		value := values.Get(path + "[value]")
+		nested := hasPrefix(values, path+"[conditions]")
+
+		if entry.Field == "" && entry.Operator == "" && value == "" && entry.Combinator == "" && !nested {
+			break
+		}
+
+		if value != "" {
+			entry.Value = value
+		}
+		var err *Error
+		if entry.Not, err = parseFlag(path, values.Get(path+"[not]")); err != nil {
+			return nil, err
+		}
+		if nested {
+			if entry.Conditions, err = entriesFromValues(values, path+"[conditions]"); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// hasPrefix reports whether any request parameter starts with prefix
+func hasPrefix(values url.Values, prefix string) bool {
+	for key := range values {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseFlag reads a [not] flag sent as a query parameter
+func parseFlag(path, value string) (bool, *Error) {
+	if value == "" {
+		return false, nil
+	}
+	flag, err := convertValue(KindBool, value)
+	if err != nil {
+		return false, &Error{Path: path, Value: value, Reason: fmt.Sprintf("invalid negation flag %q", value)}
+	}
+	return flag.(bool), nil
+}
+
+// NewCondition validates a raw field/operator/value triple against the whitelist
+func NewCondition(field, operator, value string) (Condition, *Error) {
+	fail := func(reason string) (Condition, *Error) {
+		return Condition{}, &Error{Field: field, Operator: operator, Value: value, Reason: reason}
+	}
+
+	f, ok := LookupField(field)
+	if !ok {
+		return fail(fmt.Sprintf("unknown field %q", field))
+	}
+
+	// Boolean switches in the UI do not send an operator
+	op := Operator(operator)
+	if op == "" && f.Kind == KindBool {
+		op = OpEq
+	}
+	if !f.Allows(op) {
+		return fail(fmt.Sprintf("operator %q is not supported for field %q", operator, field))
+	}
+
+	typed, err := convertValue(f.Kind, value)
+	if err != nil {
+		return fail(fmt.Sprintf("value %q is not a valid %s", value, f.Kind))
+	}
+
+	switch f.Source {
+	case SourceStructure:
+		structure, err := NewStructure(value)
+		if err != nil {
+			return fail(err.Error())
+		}
+		typed = structure
+	case SourceFormula:
+		formula, err := NewFormulaQuery(op, value)
+		if err != nil {
+			return fail(err.Error())
+		}
+		typed = formula
+	case SourceCitation:
+		if f.Name == "doi" {
+			typed = crossref.NormalizeDOI(typed.(string))
+		}
+	}
+
+	return Condition{Field: f, Operator: op, Value: typed}, nil
+}
+
+// convertValue parses a raw request value into the Go type matching the field kind
+func convertValue(kind Kind, value string) (interface{}, error) {
+	value = strings.TrimSpace(value)
+	switch kind {
+	case KindInt:
+		return strconv.ParseInt(value, 10, 64)
+	case KindFloat:
+		return strconv.ParseFloat(value, 64)
+	case KindBool:
+		switch strings.ToLower(value) {
+		case "1", "true", "yes":
+			return true, nil
+		case "0", "false", "no":
+			return false, nil
+		}
+		return nil, fmt.Errorf("invalid boolean %q", value)
+	default:
+		return value, nil
+	}
+}
+
+// parseSort validates the orderByString/orderDir pair
+func parseSort(orderBy, orderDir string) (*Sort, *Error) {
+	if orderBy == "" {
+		return nil, nil
+	}
+	f, ok := LookupField(orderBy)
+	if !ok || !f.Sortable() {
+		return nil, &Error{Field: orderBy, Reason: fmt.Sprintf("cannot order by %q", orderBy)}
+	}
+	return &Sort{Field: f, Desc: orderDir == "desc"}, nil
+}
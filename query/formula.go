@@ -0,0 +1,50 @@
+/*
+ * MarineNP Formula Conditions
+ * Purpose: Molecular formula search conditions of the molecule filter
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file holds the value of formula conditions: an exact formula compared
+ * element by element, or an element range pattern. Like structure conditions
+ * they are resolved before the filter is applied, against the molecular
+ * formulas stored with the properties.
+ */
+
+package query
+
+import (
+	"fmt"
+
+	"marinenp/chem"
+)
+
+// FormulaQuery is the value of a formula condition
+type FormulaQuery struct {
+	Resolution
+	Text    string              // Query as written by the user
+	Exact   chem.Formula        // Formula to match exactly, for OpEq
+	Pattern chem.FormulaPattern // Element ranges to match, for OpMatches
+}
+
+// NewFormulaQuery parses the value of a formula condition
+func NewFormulaQuery(op Operator, text string) (*FormulaQuery, error) {
+	q := &FormulaQuery{Text: text}
+	var err error
+	if op == OpMatches {
+		q.Pattern, err = chem.ParseFormulaPattern(text)
+	} else {
+		q.Exact, err = chem.ParseFormula(text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid formula %q: %v", text, err)
+	}
+	return q, nil
+}
+
+// Matches reports whether a molecular formula satisfies the condition
+func (q *FormulaQuery) Matches(f chem.Formula) bool {
+	if q.Pattern != nil {
+		return q.Pattern.Matches(f)
+	}
+	return q.Exact.Equal(f)
+}
@@ -0,0 +1,101 @@
+/*
+ * MarineNP Resolved Conditions
+ * Purpose: Search conditions that are answered outside the database
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * Structure and formula queries cannot be answered in SQL. Before a filter
+ * is applied, the handlers resolve each such condition, structures against
+ * the in-memory structure index and formulas against the stored formulas,
+ * and the condition is then expressed as the set of molecule ids it matched.
+ */
+
+package query
+
+import "encoding/json"
+
+// Resolvable is implemented by condition values that are matched outside
+// the database
+type Resolvable interface {
+	resolution() *Resolution
+}
+
+// Resolution holds the molecule ids matched by a resolvable condition; it is
+// embedded in the condition's value
+type Resolution struct {
+	ids      []int64
+	resolved bool
+}
+
+func (r *Resolution) resolution() *Resolution { return r }
+
+// predicate matches the resolved ids, or nothing if the condition was never
+// resolved
+func (r *Resolution) predicate() (string, []interface{}) {
+	if !r.resolved {
+		return "1 = 0", nil
+	}
+	return IDPredicate(r.ids)
+}
+
+// Resolver returns the ids of the molecules matching a resolvable condition
+type Resolver func(c Condition) ([]int64, error)
+
+// Resolve runs the resolver for every resolvable condition of the filter
+func (f *Filter) Resolve(resolve Resolver) error {
+	return f.eachResolvable(func(c Condition, r *Resolution) error {
+		ids, err := resolve(c)
+		if err != nil {
+			return err
+		}
+		r.ids, r.resolved = ids, true
+		return nil
+	})
+}
+
+// NeedsResolving reports whether the filter contains a resolvable condition
+func (f *Filter) NeedsResolving() bool {
+	found := false
+	f.eachResolvable(func(Condition, *Resolution) error {
+		found = true
+		return nil
+	})
+	return found
+}
+
+// eachResolvable calls fn for every resolvable condition, stopping at the first error
+func (f *Filter) eachResolvable(fn func(Condition, *Resolution) error) error {
+	if f.Root == nil {
+		return nil
+	}
+	return f.Root.eachResolvable(fn)
+}
+
+func (g *Group) eachResolvable(fn func(Condition, *Resolution) error) error {
+	for _, child := range g.Children {
+		switch n := child.(type) {
+		case Condition:
+			if r, ok := n.Value.(Resolvable); ok {
+				if err := fn(n, r.resolution()); err != nil {
+					return err
+				}
+			}
+		case *Group:
+			if err := n.eachResolvable(fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// IDPredicate returns a WHERE fragment matching molecules by id. The ids are
+// bound as one JSON array so that the statement text, and thus the prepared
+// statement, stays the same whatever the number of ids.
+func IDPredicate(ids []int64) (string, []interface{}) {
+	if len(ids) == 0 {
+		return "1 = 0", nil
+	}
+	data, _ := json.Marshal(ids)
+	return "molecules.id IN (SELECT value FROM json_each(?))", []interface{}{string(data)}
+}
@@ -0,0 +1,297 @@
+/*
+ * MarineNP Query SQL Builder
+ * Purpose: Translate a validated Filter into GORM query clauses
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file applies a Filter to a query over the molecules table. Conditions on
+ * properties, organisms and citations are expressed as sub-selects on molecules.id so that
+ * the outer query never joins, never returns duplicate molecules, negation and
+ * OR behave per molecule, and the query can be reused unchanged for counting,
+ * paging, exporting and aggregation.
+ */
+
+package query
+
+import (
+	"strings"
+
+	"marinenp/fulltext"
+
+	"gorm.io/gorm"
+)
+
+// sqlOperators maps comparison operators to their SQL form
+var sqlOperators = map[Operator]string{
+	OpEq:         "=",
+	OpNe:         "!=",
+	OpLt:         "<",
+	OpLte:        "<=",
+	OpGt:         ">",
+	OpGte:        ">=",
+	OpContains:   "LIKE",
+	OpStartsWith: "LIKE",
+	OpEndsWith:   "LIKE",
+}
+
+// keywordColumns are the molecule columns matched by the free-text keyword
+var keywordColumns = []string{
+	"name", "canonical_smiles", "identifier", "cas", "synonyms",
+	"iupac_name", "standard_inchi", "standard_inchi_key",
+}
+
+// keywordOrganismColumns are the columns of the marine organisms of a
+// molecule matched by the free-text keyword, as in the full-text index
+var keywordOrganismColumns = []string{"name", "name_aphia_worms"}
+
+// organismColumns are the organism columns matched by the organism field
+var organismColumns = []string{"name", "iri", "slug", "name_aphia_worms"}
+
+// Apply adds the marine constraint, conditions and keyword of the filter to a
+// query whose model is models.Molecule
+func (f *Filter) Apply(tx *gorm.DB) *gorm.DB {
+	tx = tx.Where("molecules.is_marine = TRUE")
+
+	if f.Root != nil && len(f.Root.Children) > 0 {
+		sql, args := f.Root.SQL()
+		tx = tx.Where(sql, args...)
+	}
+
+	if f.Keyword != "" {
+		sql, args := keywordPredicate(f.Keyword)
+		if match, ok := f.KeywordMatch(); ok {
+			sql = "molecules.id IN (SELECT rowid FROM " + fulltext.Table + " WHERE " + fulltext.Table + " MATCH ?) OR " + sql
+			args = append([]interface{}{match}, args...)
+		}
+		tx = tx.Where("("+sql+")", args...)
+	}
+
+	return tx
+}
+
+// keywordPredicate returns the WHERE fragment matching the keyword as a
+// substring of the keyword columns of a molecule or of its marine organisms
+func keywordPredicate(keyword string) (string, []interface{}) {
+	pattern := "%" + escapeLike(strings.ToLower(keyword)) + "%"
+	var clauses []string
+	var args []interface{}
+	for _, column := range keywordColumns {
+		clauses = append(clauses, "LOWER(molecules."+column+") LIKE ? ESCAPE '\\'")
+		args = append(args, pattern)
+	}
+	organisms := make([]string, len(keywordOrganismColumns))
+	for i, column := range keywordOrganismColumns {
+		organisms[i] = "LOWER(organisms." + column + ") LIKE ? ESCAPE '\\'"
+		args = append(args, pattern)
+	}
+	clauses = append(clauses, "molecules.id IN (SELECT molecule_organism.molecule_id FROM molecule_organism "+
+		"JOIN organisms ON organisms.id = molecule_organism.organism_id "+
+		"WHERE organisms.is_marine = TRUE AND ("+strings.Join(organisms, " OR ")+"))")
+	return strings.Join(clauses, " OR "), args
+}
+
+// KeywordMatch returns the full-text query of the keyword, and whether the
+// full-text index is used to rank the keyword matches and highlight them.
+// The index adds its matches, such as words spread over several fields, to
+// the substring matches, so that "toxin" still finds tetrodotoxin. The decision is kept, so that
+// counting, paging and exporting the filter agree while the index loads.
+func (f *Filter) KeywordMatch() (string, bool) {
+	if !f.keywordChecked {
+		f.keywordChecked = true
+		if match, ok := fulltext.Match(f.Keyword); ok && fulltext.Ready() {
+			f.keywordMatch = match
+		}
+	}
+	return f.keywordMatch, f.keywordMatch != ""
+}
+
+// ApplyOrder adds the ordering of the filter, with the molecule id breaking
+// ties so that pages are stable. Without an explicit ordering, full-text
+// keyword matches are ranked by relevance, ahead of substring-only matches.
+func (f *Filter) ApplyOrder(tx *gorm.DB) *gorm.DB {
+	if f.Sort != nil {
+		order := "molecules." + f.Sort.Field.Column
+		if f.Sort.Desc {
+			order += " DESC"
+		}
+		tx = tx.Order(order)
+	} else if match, ok := f.KeywordMatch(); ok {
+		tx = tx.Joins("LEFT JOIN (SELECT rowid AS molecule_id, rank FROM "+fulltext.Table+" WHERE "+fulltext.Table+" MATCH ?) AS keyword_match ON keyword_match.molecule_id = molecules.id", match).
+			Order("keyword_match.rank IS NULL, keyword_match.rank")
+	}
+	return tx.Order("molecules.id ASC")
+}
+
+// SQL returns the WHERE fragment and arguments for the group and its children
+func (g *Group) SQL() (string, []interface{}) {
+	joiner := " AND "
+	if g.Combinator == Or {
+		joiner = " OR "
+	}
+
+	clauses := make([]string, 0, len(g.Children))
+	var args []interface{}
+	for _, child := range g.Children {
+		var sql string
+		var childArgs []interface{}
+		switch n := child.(type) {
+		case Condition:
+			sql, childArgs = n.SQL()
+		case *Group:
+			sql, childArgs = n.SQL()
+		}
+		clauses = append(clauses, sql)
+		args = append(args, childArgs...)
+	}
+
+	sql := "(" + strings.Join(clauses, joiner) + ")"
+	if g.Negate {
+		sql = "NOT " + sql
+	}
+	return sql, args
+}
+
+// SQL returns the WHERE fragment and arguments for the condition
+func (c Condition) SQL() (string, []interface{}) {
+	sql, args := c.predicate()
+	if c.Negate {
+		sql = "NOT (" + sql + ")"
+	}
+	return sql, args
+}
+
+// predicate returns the condition without its negation
+func (c Condition) predicate() (string, []interface{}) {
+	if r, ok := c.Value.(Resolvable); ok {
+		return r.resolution().predicate()
+	}
+
+	if c.Operator == OpInTaxon {
+		return c.taxonPredicate()
+	}
+
+	op := sqlOperators[c.Operator]
+	value := c.Value
+
+	var expr string
+	switch c.Field.Kind {
+	case KindInt:
+		expr = "CAST(%s AS INTEGER) " + op + " ?"
+	case KindFloat:
+		expr = "CAST(%s AS REAL) " + op + " ?"
+	case KindBool:
+		expr = "%s " + op + " ?"
+	default:
+		expr = "LOWER(%s) " + op + " ?"
+		value = stringPattern(c.Operator, value.(string))
+		if op == "LIKE" {
+			expr += " ESCAPE '\\'"
+		}
+	}
+
+	switch c.Field.Source {
+	case SourceProperties:
+		return "molecules.id IN (SELECT properties.molecule_id FROM properties WHERE " +
+			strings.Replace(expr, "%s", "properties."+c.Field.Column, 1) + ")", []interface{}{value}
+
+	case SourceOrganism:
+		clauses := make([]string, len(organismColumns))
+		args := make([]interface{}, len(organismColumns))
+		for i, column := range organismColumns {
+			clauses[i] = strings.Replace(expr, "%s", "organisms."+column, 1)
+			args[i] = value
+		}
+		return "molecules.id IN (SELECT molecule_organism.molecule_id FROM molecule_organism " +
+			"JOIN organisms ON organisms.id = molecule_organism.organism_id " +
+			"WHERE organisms.is_marine = TRUE AND (" + strings.Join(clauses, " OR ") + "))", args
+
+	case SourceOrganismID:
+		return "molecules.id IN (SELECT molecule_organism.molecule_id FROM molecule_organism WHERE " +
+			"molecule_organism.organism_id " + op + " ?)", []interface{}{value}
+
+	case SourceCitation:
+		columns := citationColumns
+		if c.Field.Column != "" {
+			columns = []string{c.Field.Column}
+		}
+		clauses := make([]string, len(columns))
+		args := make([]interface{}, len(columns))
+		for i, column := range columns {
+			clauses[i] = strings.Replace(expr, "%s", column, 1)
+			args[i] = value
+		}
+		return "molecules.id IN (SELECT citables.citable_id FROM citables " +
+			"JOIN citations ON citations.id = citables.citation_id " +
+			"LEFT JOIN citation_metadata ON citation_metadata.citation_id = citations.id " +
+			`WHERE citables.citable_type = 'App\Models\Molecule' AND (` + strings.Join(clauses, " OR ") + "))", args
+
+	default:
+		return strings.Replace(expr, "%s", "molecules."+c.Field.Column, 1), []interface{}{value}
+	}
+}
+
+// citationColumns are the citation and citation metadata columns matched by
+// the citation field
+var citationColumns = []string{
+	"citations.title", "citations.authors", "citations.citation_text", "citations.doi",
+	"citation_metadata.title", "citation_metadata.authors", "citation_metadata.journal",
+}
+
+// taxonColumns are the organism columns holding scientific names
+var taxonColumns = []string{"name", "name_aphia_worms"}
+
+// taxonRanks are the organism_taxonomy columns of the WoRMS classification
+var taxonRanks = []string{"kingdom", "phylum", "class", `"order"`, "family", "genus"}
+
+// taxonPredicate matches organisms named after the taxon, see
+// TaxonNamePredicate, and organisms whose WoRMS classification, filled by
+// "marinenp taxonomy", places them in the taxon at any rank from kingdom to
+// genus, e.g. a family
+func (c Condition) taxonPredicate() (string, []interface{}) {
+	taxon := strings.ToLower(c.Value.(string))
+	names, args := TaxonNamePredicate(taxon)
+	ranks := make([]string, len(taxonRanks))
+	for i, rank := range taxonRanks {
+		ranks[i] = "LOWER(organism_taxonomy." + rank + ")"
+	}
+	args = append(args, taxon)
+	return "molecules.id IN (SELECT molecule_organism.molecule_id FROM molecule_organism " +
+		"JOIN organisms ON organisms.id = molecule_organism.organism_id " +
+		"WHERE organisms.is_marine = TRUE AND (" + names + " OR " +
+		"organisms.aphiaid_worms IN (SELECT organism_taxonomy.aphiaid_worms FROM organism_taxonomy " +
+		"WHERE ? IN (" + strings.Join(ranks, ", ") + "))))", args
+}
+
+// TaxonNamePredicate returns the WHERE fragment matching organisms whose
+// scientific name is the taxon itself or starts with it followed by a space,
+// i.e. species of a genus, without their WoRMS classification
+func TaxonNamePredicate(taxon string) (string, []interface{}) {
+	taxon = strings.ToLower(taxon)
+	clauses := make([]string, len(taxonColumns))
+	var args []interface{}
+	for i, column := range taxonColumns {
+		clauses[i] = "LOWER(organisms." + column + ") = ? OR LOWER(organisms." + column + ") LIKE ? ESCAPE '\\'"
+		args = append(args, taxon, escapeLike(taxon)+" %")
+	}
+	return "(" + strings.Join(clauses, " OR ") + ")", args
+}
+
+// stringPattern lower-cases a string value and wraps it for LIKE operators
+func stringPattern(op Operator, value string) string {
+	value = strings.ToLower(value)
+	switch op {
+	case OpContains:
+		return "%" + escapeLike(value) + "%"
+	case OpStartsWith:
+		return escapeLike(value) + "%"
+	case OpEndsWith:
+		return "%" + escapeLike(value)
+	}
+	return value
+}
+
+// escapeLike escapes LIKE wildcards so user input is matched literally
+func escapeLike(value string) string {
+	replacer := strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_")
+	return replacer.Replace(value)
+}
@@ -0,0 +1,33 @@
+/*
+ * MarineNP Structure Conditions
+ * Purpose: Structure search conditions of the molecule filter
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file holds the value of structure conditions, which are resolved
+ * against the structure index before the filter is applied.
+ */
+
+package query
+
+import (
+	"fmt"
+
+	"marinenp/chem"
+)
+
+// Structure is the value of a structure condition
+type Structure struct {
+	Resolution
+	Text  string      // Query as written by the user
+	Query *chem.Query // Parsed query
+}
+
+// NewStructure parses a SMILES or SMARTS structure query
+func NewStructure(text string) (*Structure, error) {
+	q, err := chem.ParseQuery(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid structure query %q: %v", text, err)
+	}
+	return &Structure{Text: text, Query: q}, nil
+}
@@ -0,0 +1,99 @@
+/*
+ * MarineNP RDF Dump
+ * Purpose: Dump the marine molecules, organisms, citations and locations as N-Triples
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file writes the whole release as one N-Triples file, in batches of
+ * molecules so that memory use does not grow with the size of the release.
+ */
+
+package rdf
+
+import (
+	"io"
+	"marinenp/models"
+
+	"gorm.io/gorm"
+)
+
+// dumpBatch is the number of molecules described at a time
+const dumpBatch = 1000
+
+// DumpCounts are the numbers of resources dumped
+type DumpCounts struct {
+	Molecules, Organisms, Citations, Locations, Triples int64
+}
+
+// Dump writes the marine molecules, the marine organisms and the citations
+// and locations of the marine molecules to w as N-Triples
+func Dump(db *gorm.DB, r Resources, w io.Writer) (DumpCounts, error) {
+	var counts DumpCounts
+	g := NewGraph(r.Prefixes())
+	flush := func() error {
+		counts.Triples += int64(len(g.Triples))
+		err := g.WriteNTriples(w)
+		g.Reset()
+		return err
+	}
+
+	var molecules []models.Molecule
+	err := db.Preload("Properties").
+		Preload("Organisms", "is_marine = TRUE").
+		Preload("GeoLocations").
+		Where("is_marine = TRUE").
+		FindInBatches(&molecules, dumpBatch, func(tx *gorm.DB, batch int) error {
+			citations, err := models.MoleculeCitations(db, molecules)
+			if err != nil {
+				return err
+			}
+			for i := range molecules {
+				r.AddMolecule(g, &molecules[i], citations[molecules[i].ID])
+			}
+			counts.Molecules += int64(len(molecules))
+			return flush()
+		}).Error
+	if err != nil {
+		return counts, err
+	}
+
+	var organisms []models.Organism
+	err = db.Where("is_marine = TRUE").FindInBatches(&organisms, dumpBatch, func(tx *gorm.DB, batch int) error {
+		for i := range organisms {
+			r.AddOrganism(g, &organisms[i])
+		}
+		counts.Organisms += int64(len(organisms))
+		return flush()
+	}).Error
+	if err != nil {
+		return counts, err
+	}
+
+	var citations []models.Citation
+	cited := db.Table("citables").Select("citables.citation_id").
+		Joins("JOIN molecules ON molecules.id = citables.citable_id").
+		Where("citables.citable_type = ? AND molecules.is_marine = TRUE", models.CitableMolecule)
+	err = db.Where("id IN (?)", cited).FindInBatches(&citations, dumpBatch, func(tx *gorm.DB, batch int) error {
+		for i := range citations {
+			r.AddCitation(g, &citations[i])
+		}
+		counts.Citations += int64(len(citations))
+		return flush()
+	}).Error
+	if err != nil {
+		return counts, err
+	}
+
+	var locations []models.GeoLocation
+	located := db.Table("geo_location_molecule").Select("geo_location_molecule.geo_location_id").
+		Joins("JOIN molecules ON molecules.id = geo_location_molecule.molecule_id").
+		Where("molecules.is_marine = TRUE")
+	err = db.Where("id IN (?)", located).FindInBatches(&locations, dumpBatch, func(tx *gorm.DB, batch int) error {
+		for i := range locations {
+			r.AddLocation(g, &locations[i])
+		}
+		counts.Locations += int64(len(locations))
+		return flush()
+	}).Error
+	return counts, err
+}
@@ -0,0 +1,269 @@
+/*
+ * MarineNP RDF Vocabulary
+ * Purpose: Describe molecules, organisms and citations in standard vocabularies
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file maps the MarineNP records to RDF. Molecules are Bioschemas
+ * MolecularEntity resources whose structure descriptors are CHEMINF
+ * attributes, organisms are Bioschemas Taxon resources linked to their WoRMS
+ * LSIDs, citations are scholarly articles linked to their DOIs and locations
+ * are places. Molecules and taxa also carry the Wikidata properties for the
+ * same identifiers and for "found in taxon", so that the graph can be queried
+ * next to Wikidata.
+ */
+
+package rdf
+
+import (
+	"fmt"
+	"marinenp/models"
+	"strings"
+)
+
+// Vocabulary namespaces. schema.org uses http IRIs, as in Wikidata.
+const (
+	Schema     = "http://schema.org/"
+	DCTerms    = "http://purl.org/dc/terms/"
+	OBO        = "http://purl.obolibrary.org/obo/"
+	SIO        = "http://semanticscience.org/resource/"
+	Wikidata   = "http://www.wikidata.org/prop/direct/"
+	Bioschemas = "https://bioschemas.org/profiles/"
+)
+
+// Profiles the resources conform to
+const (
+	MoleculeProfile = Bioschemas + "MolecularEntity/0.5-RELEASE"
+	TaxonProfile    = Bioschemas + "Taxon/1.0-RELEASE"
+)
+
+// WoRMS LSID and record page of a taxon by AphiaID
+const (
+	WormsLSID   = "urn:lsid:marinespecies.org:taxname:%d"
+	WormsRecord = "https://www.marinespecies.org/aphia.php?p=taxdetails&id=%d"
+)
+
+// Predicates
+var (
+	rdfType     = IRI(RDF + "type")
+	conformsTo  = IRI(DCTerms + "conformsTo")
+	hasAttr     = IRI(SIO + "SIO_000008") // has attribute
+	hasValue    = IRI(SIO + "SIO_000300") // has value
+	foundInTaxa = IRI(Wikidata + "P703")  // found in taxon
+)
+
+// cheminf are the CHEMINF descriptor classes of molecule columns
+var cheminf = map[string]string{
+	"standard_inchi":     "CHEMINF_000113", // InChI descriptor
+	"standard_inchi_key": "CHEMINF_000059", // InChIKey
+	"canonical_smiles":   "CHEMINF_000018", // SMILES descriptor
+	"molecular_formula":  "CHEMINF_000042", // molecular formula
+	"iupac_name":         "CHEMINF_000107", // IUPAC name
+	"cas":                "CHEMINF_000446", // CAS registry number
+}
+
+// Resources names the MarineNP resources under the base IRI of the API,
+// such as https://marinenp.scicloud.eu/api/v1
+type Resources struct {
+	Base string
+}
+
+// Molecule returns the IRI of a molecule
+func (r Resources) Molecule(identifier string) Term {
+	return IRI(r.Base + "/molecules/" + identifier)
+}
+
+// Organism returns the IRI of an organism
+func (r Resources) Organism(id int64) Term {
+	return IRI(fmt.Sprintf("%s/organisms/%d", r.Base, id))
+}
+
+// Citation returns the IRI of a citation
+func (r Resources) Citation(id int64) Term {
+	return IRI(fmt.Sprintf("%s/citations/%d", r.Base, id))
+}
+
+// Location returns the IRI of a geographic location
+func (r Resources) Location(id int64) Term {
+	return IRI(fmt.Sprintf("%s/locations/%d", r.Base, id))
+}
+
+// Prefixes returns the prefixes of the vocabularies and of the resources
+func (r Resources) Prefixes() []Prefix {
+	return []Prefix{
+		{"schema", Schema},
+		{"dct", DCTerms},
+		{"obo", OBO},
+		{"sio", SIO},
+		{"wdt", Wikidata},
+		{"xsd", XSD},
+		{"molecule", r.Base + "/molecules/"},
+		{"organism", r.Base + "/organisms/"},
+		{"citation", r.Base + "/citations/"},
+		{"location", r.Base + "/locations/"},
+	}
+}
+
+// AddMolecule describes a molecule, its properties and its links to its
+// organisms, citations and locations, which are described by AddOrganism,
+// AddCitation and AddLocation
+func (r Resources) AddMolecule(g *Graph, m *models.Molecule, citations []models.Citation) {
+	s := r.Molecule(m.Identifier)
+	schema := func(name string) Term { return IRI(Schema + name) }
+
+	g.Add(s, rdfType, schema("MolecularEntity"))
+	g.Add(s, conformsTo, IRI(MoleculeProfile))
+	g.AddString(s, schema("identifier"), m.Identifier)
+	g.AddString(s, schema("name"), m.Name)
+	for _, synonym := range strings.Split(m.Synonyms, "|") {
+		g.AddString(s, schema("alternateName"), strings.TrimSpace(synonym))
+	}
+	g.AddString(s, schema("iupacName"), m.IupacName)
+	g.AddString(s, schema("inChI"), m.StandardInchi)
+	g.AddString(s, schema("inChIKey"), m.StandardInchiKey)
+	g.AddString(s, schema("smiles"), m.CanonicalSmiles)
+	g.AddString(s, schema("molecularFormula"), m.Properties.MolecularFormula)
+	if m.Properties.MolecularWeight > 0 {
+		g.Add(s, schema("molecularWeight"), Double(m.Properties.MolecularWeight))
+	}
+	if m.Properties.ExactMolecularWeight > 0 {
+		g.Add(s, schema("monoisotopicMolecularWeight"), Double(m.Properties.ExactMolecularWeight))
+	}
+	g.Add(s, schema("url"), s)
+
+	// Structure descriptors as CHEMINF attributes
+	for _, d := range []struct{ column, value string }{
+		{"standard_inchi", m.StandardInchi},
+		{"standard_inchi_key", m.StandardInchiKey},
+		{"canonical_smiles", m.CanonicalSmiles},
+		{"molecular_formula", m.Properties.MolecularFormula},
+		{"iupac_name", m.IupacName},
+		{"cas", m.Cas},
+	} {
+		if d.value == "" {
+			continue
+		}
+		attribute := g.Blank()
+		g.Add(s, hasAttr, attribute)
+		g.Add(attribute, rdfType, IRI(OBO+cheminf[d.column]))
+		g.Add(attribute, hasValue, String(d.value))
+	}
+
+	// Computed properties and classifications without a CHEMINF class
+	p := m.Properties
+	for _, v := range []struct {
+		name  string
+		value Term
+		ok    bool
+	}{
+		{"alogp", Double(p.Alogp), p.ID != 0},
+		{"topological_polar_surface_area", Double(p.TopologicalPolarSurfaceArea), p.ID != 0},
+		{"heavy_atom_count", Int(int64(p.HeavyAtomCount)), p.ID != 0},
+		{"rotatable_bond_count", Int(int64(p.RotatableBondCount)), p.ID != 0},
+		{"hydrogen_bond_acceptors", Int(int64(p.HydrogenBondAcceptors)), p.ID != 0},
+		{"hydrogen_bond_donors", Int(int64(p.HydrogenBondDonors)), p.ID != 0},
+		{"lipinski_rule_of_five_violations", Int(int64(p.LipinskiRuleOfFiveViolations)), p.ID != 0},
+		{"aromatic_rings_count", Int(int64(p.AromaticRingsCount)), p.ID != 0},
+		{"fractioncsp3", Double(p.FractionCSP3), p.ID != 0},
+		{"qed_drug_likeliness", Double(p.QEDDrugLikeliness), p.ID != 0},
+		{"np_likeness", Double(p.NPLikeness), p.ID != 0},
+		{"formal_charge", Int(int64(p.FormalCharge)), p.ID != 0},
+		{"chemical_super_class", String(p.ChemicalSuperClass), p.ChemicalSuperClass != ""},
+		{"chemical_class", String(p.ChemicalClass), p.ChemicalClass != ""},
+		{"chemical_sub_class", String(p.ChemicalSubClass), p.ChemicalSubClass != ""},
+		{"np_classifier_pathway", String(p.NPClassifierPathway), p.NPClassifierPathway != ""},
+		{"np_classifier_superclass", String(p.NPClassifierSuperclass), p.NPClassifierSuperclass != ""},
+		{"np_classifier_class", String(p.NPClassifierClass), p.NPClassifierClass != ""},
+	} {
+		if !v.ok {
+			continue
+		}
+		property := g.Blank()
+		g.Add(s, schema("additionalProperty"), property)
+		g.Add(property, rdfType, schema("PropertyValue"))
+		g.Add(property, schema("propertyID"), String(v.name))
+		g.Add(property, schema("value"), v.value)
+	}
+
+	// Wikidata identifier properties
+	g.AddString(s, IRI(Wikidata+"P235"), m.StandardInchiKey)
+	g.AddString(s, IRI(Wikidata+"P234"), m.StandardInchi)
+	g.AddString(s, IRI(Wikidata+"P233"), m.CanonicalSmiles)
+	g.AddString(s, IRI(Wikidata+"P231"), m.Cas)
+
+	for _, o := range m.Organisms {
+		g.Add(s, foundInTaxa, r.Organism(o.ID))
+	}
+	for _, c := range citations {
+		g.Add(s, schema("citation"), r.Citation(c.ID))
+	}
+	for _, l := range m.GeoLocations {
+		g.Add(s, schema("location"), r.Location(l.ID))
+	}
+}
+
+// AddOrganism describes an organism as a taxon, with its WoRMS LSID and
+// record when it has an AphiaID, and links its molecules if they are loaded
+func (r Resources) AddOrganism(g *Graph, o *models.Organism) {
+	s := r.Organism(o.ID)
+	schema := func(name string) Term { return IRI(Schema + name) }
+
+	g.Add(s, rdfType, schema("Taxon"))
+	g.Add(s, conformsTo, IRI(TaxonProfile))
+	g.AddString(s, schema("name"), o.Name)
+	if o.NameAphiaWorms != "" && o.NameAphiaWorms != o.Name {
+		g.AddString(s, schema("alternateName"), o.NameAphiaWorms)
+	}
+	g.AddString(s, schema("taxonRank"), strings.ToLower(o.Rank))
+	if strings.Contains(o.IRI, ":") && !strings.ContainsAny(o.IRI, " <>\"") {
+		g.Add(s, schema("sameAs"), IRI(o.IRI))
+	}
+	if o.AphiaIDWorms != nil && *o.AphiaIDWorms > 0 {
+		aphiaID := int64(*o.AphiaIDWorms)
+		g.Add(s, schema("sameAs"), IRI(fmt.Sprintf(WormsLSID, aphiaID)))
+		g.Add(s, schema("url"), IRI(fmt.Sprintf(WormsRecord, aphiaID)))
+		g.Add(s, schema("identifier"), String(fmt.Sprintf(WormsLSID, aphiaID)))
+		g.Add(s, IRI(Wikidata+"P850"), String(fmt.Sprint(aphiaID)))
+	}
+
+	for _, m := range o.Molecules {
+		molecule := r.Molecule(m.Identifier)
+		g.Add(molecule, rdfType, schema("MolecularEntity"))
+		g.AddString(molecule, schema("name"), m.Name)
+		g.Add(molecule, foundInTaxa, s)
+	}
+}
+
+// AddCitation describes a citation as a scholarly article
+func (r Resources) AddCitation(g *Graph, c *models.Citation) {
+	s := r.Citation(c.ID)
+	schema := func(name string) Term { return IRI(Schema + name) }
+
+	g.Add(s, rdfType, schema("ScholarlyArticle"))
+	g.AddString(s, schema("name"), c.Title)
+	g.AddString(s, schema("author"), c.Authors)
+	g.AddString(s, schema("description"), c.CitationText)
+	if doi := strings.TrimSpace(c.DOI); doi != "" {
+		doi = strings.TrimPrefix(strings.TrimPrefix(doi, "https://doi.org/"), "doi:")
+		g.Add(s, schema("identifier"), String("doi:"+doi))
+		g.Add(s, schema("sameAs"), IRI("https://doi.org/"+doi))
+		g.Add(s, IRI(Wikidata+"P356"), String(strings.ToUpper(doi)))
+	}
+}
+
+// AddLocation describes a geographic location as a place, and links its
+// molecules if they are loaded
+func (r Resources) AddLocation(g *Graph, l *models.GeoLocation) {
+	s := r.Location(l.ID)
+	schema := func(name string) Term { return IRI(Schema + name) }
+
+	g.Add(s, rdfType, schema("Place"))
+	g.AddString(s, schema("name"), l.Name)
+
+	for _, m := range l.Molecules {
+		molecule := r.Molecule(m.Identifier)
+		g.Add(molecule, rdfType, schema("MolecularEntity"))
+		g.AddString(molecule, schema("name"), m.Name)
+		g.Add(molecule, schema("location"), s)
+	}
+}
@@ -0,0 +1,414 @@
+/*
+ * MarineNP RDF
+ * Purpose: Build RDF graphs and write them as Turtle, N-Triples or JSON-LD
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file holds a minimal RDF model, triples of IRIs, blank nodes and
+ * literals, and its serializations. Turtle and JSON-LD documents group the
+ * triples by subject and abbreviate IRIs with the prefixes of the graph;
+ * N-Triples are written line by line for dumps of any size.
+ */
+
+package rdf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Serialization formats
+const (
+	Turtle   = "turtle"
+	NTriples = "ntriples"
+	JSONLD   = "jsonld"
+)
+
+// ContentTypes are the media types of the formats
+var ContentTypes = map[string]string{
+	Turtle:   "text/turtle; charset=utf-8",
+	NTriples: "application/n-triples; charset=utf-8",
+	JSONLD:   "application/ld+json; charset=utf-8",
+}
+
+// Common namespaces
+const (
+	RDF = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	XSD = "http://www.w3.org/2001/XMLSchema#"
+)
+
+// termKind is the kind of an RDF term
+type termKind int
+
+const (
+	iriTerm termKind = iota
+	blankTerm
+	literalTerm
+)
+
+// Term is an IRI, a blank node or a literal
+type Term struct {
+	kind     termKind
+	Value    string
+	Datatype string // Datatype IRI of typed literals, empty for strings
+}
+
+// IRI returns an IRI term
+func IRI(iri string) Term { return Term{kind: iriTerm, Value: iri} }
+
+// String returns a plain string literal
+func String(s string) Term { return Term{kind: literalTerm, Value: s} }
+
+// Int returns an xsd:integer literal
+func Int(n int64) Term {
+	return Term{kind: literalTerm, Value: strconv.FormatInt(n, 10), Datatype: XSD + "integer"}
+}
+
+// Double returns an xsd:double literal
+func Double(f float64) Term {
+	return Term{kind: literalTerm, Value: strconv.FormatFloat(f, 'g', -1, 64), Datatype: XSD + "double"}
+}
+
+// Bool returns an xsd:boolean literal
+func Bool(b bool) Term {
+	return Term{kind: literalTerm, Value: strconv.FormatBool(b), Datatype: XSD + "boolean"}
+}
+
+// Triple is a statement of a graph
+type Triple struct {
+	Subject, Predicate, Object Term
+}
+
+// Prefix abbreviates the IRIs of a namespace
+type Prefix struct {
+	Name, Namespace string
+}
+
+// Graph is an ordered set of triples
+type Graph struct {
+	Prefixes []Prefix
+	Triples  []Triple
+	seen     map[Triple]bool
+	blanks   int
+}
+
+// NewGraph returns an empty graph abbreviating IRIs with the prefixes
+func NewGraph(prefixes []Prefix) *Graph {
+	return &Graph{Prefixes: prefixes, seen: make(map[Triple]bool)}
+}
+
+// Add adds a triple unless the graph already has it
+func (g *Graph) Add(subject, predicate, object Term) {
+	t := Triple{subject, predicate, object}
+	if !g.seen[t] {
+		g.seen[t] = true
+		g.Triples = append(g.Triples, t)
+	}
+}
+
+// AddString adds a string literal triple unless the string is empty
+func (g *Graph) AddString(subject, predicate Term, s string) {
+	if s != "" {
+		g.Add(subject, predicate, String(s))
+	}
+}
+
+// Blank returns a new blank node
+func (g *Graph) Blank() Term {
+	g.blanks++
+	return Term{kind: blankTerm, Value: fmt.Sprintf("b%d", g.blanks)}
+}
+
+// Reset removes the triples, keeping the prefixes. Blank nodes stay unique
+// across resets, so that graphs written in parts do not merge them.
+func (g *Graph) Reset() {
+	g.Triples = g.Triples[:0]
+	g.seen = make(map[Triple]bool)
+}
+
+// Write writes the graph in a format
+func (g *Graph) Write(w io.Writer, format string) error {
+	switch format {
+	case Turtle:
+		return g.WriteTurtle(w)
+	case NTriples:
+		return g.WriteNTriples(w)
+	case JSONLD:
+		return g.WriteJSONLD(w)
+	}
+	return fmt.Errorf("unknown RDF format %s", format)
+}
+
+// WriteNTriples writes a triple per line
+func (g *Graph) WriteNTriples(w io.Writer) error {
+	var b strings.Builder
+	for _, t := range g.Triples {
+		b.Reset()
+		b.WriteString(ntriplesTerm(t.Subject))
+		b.WriteByte(' ')
+		b.WriteString(ntriplesTerm(t.Predicate))
+		b.WriteByte(' ')
+		b.WriteString(ntriplesTerm(t.Object))
+		b.WriteString(" .\n")
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTurtle writes the triples grouped by subject, with prefixed names
+func (g *Graph) WriteTurtle(w io.Writer) error {
+	var b strings.Builder
+	for _, p := range g.Prefixes {
+		fmt.Fprintf(&b, "@prefix %s: <%s> .\n", p.Name, escapeIRI(p.Namespace))
+	}
+	for _, subject := range g.subjects() {
+		b.WriteByte('\n')
+		b.WriteString(g.turtleTerm(subject.term))
+		for i, predicate := range subject.predicates {
+			if i > 0 {
+				b.WriteString(" ;")
+			}
+			b.WriteString("\n    ")
+			if predicate.term.Value == RDF+"type" {
+				b.WriteString("a")
+			} else {
+				b.WriteString(g.turtleTerm(predicate.term))
+			}
+			for j, object := range predicate.objects {
+				if j > 0 {
+					b.WriteString(" ,")
+				}
+				b.WriteByte(' ')
+				b.WriteString(g.turtleTerm(object))
+			}
+		}
+		b.WriteString(" .\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSONLD writes the triples as a JSON-LD document with a node object
+// per subject and the prefixes as its context
+func (g *Graph) WriteJSONLD(w io.Writer) error {
+	context := make(map[string]string, len(g.Prefixes))
+	for _, p := range g.Prefixes {
+		context[p.Name] = p.Namespace
+	}
+
+	nodes := []orderedNode{}
+	for _, subject := range g.subjects() {
+		node := orderedNode{{"@id", g.jsonldID(subject.term)}}
+		for _, predicate := range subject.predicates {
+			if predicate.term.Value == RDF+"type" {
+				types := make([]string, len(predicate.objects))
+				for i, object := range predicate.objects {
+					types[i] = g.compact(object.Value)
+				}
+				node = append(node, nodeField{"@type", types})
+				continue
+			}
+			values := make([]interface{}, len(predicate.objects))
+			for i, object := range predicate.objects {
+				values[i] = g.jsonldValue(object)
+			}
+			node = append(node, nodeField{g.compact(predicate.term.Value), values})
+		}
+		nodes = append(nodes, node)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(orderedNode{{"@context", context}, {"@graph", nodes}})
+}
+
+// subjectGroup holds the objects of the triples of a subject by predicate,
+// in the order the triples were added
+type subjectGroup struct {
+	term       Term
+	predicates []*predicateGroup
+}
+
+type predicateGroup struct {
+	term    Term
+	objects []Term
+}
+
+// subjects groups the triples by subject and predicate
+func (g *Graph) subjects() []*subjectGroup {
+	var subjects []*subjectGroup
+	bySubject := make(map[Term]*subjectGroup)
+	byPredicate := make(map[[2]Term]*predicateGroup)
+	for _, t := range g.Triples {
+		s, ok := bySubject[t.Subject]
+		if !ok {
+			s = &subjectGroup{term: t.Subject}
+			bySubject[t.Subject] = s
+			subjects = append(subjects, s)
+		}
+		key := [2]Term{t.Subject, t.Predicate}
+		p, ok := byPredicate[key]
+		if !ok {
+			p = &predicateGroup{term: t.Predicate}
+			byPredicate[key] = p
+			s.predicates = append(s.predicates, p)
+		}
+		p.objects = append(p.objects, t.Object)
+	}
+	return subjects
+}
+
+// localName matches the local names written as prefixed names
+var localName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_-]*$`)
+
+// prefixed returns the prefixed name of an IRI, or false if no prefix of the
+// graph abbreviates it. The longest matching namespace is used.
+func (g *Graph) prefixed(iri string) (string, bool) {
+	prefixes := append([]Prefix(nil), g.Prefixes...)
+	sort.SliceStable(prefixes, func(i, j int) bool { return len(prefixes[i].Namespace) > len(prefixes[j].Namespace) })
+	for _, p := range prefixes {
+		if strings.HasPrefix(iri, p.Namespace) && localName.MatchString(iri[len(p.Namespace):]) {
+			return p.Name + ":" + iri[len(p.Namespace):], true
+		}
+	}
+	return "", false
+}
+
+// compact returns the prefixed name of an IRI, or the IRI itself
+func (g *Graph) compact(iri string) string {
+	if name, ok := g.prefixed(iri); ok {
+		return name
+	}
+	return iri
+}
+
+func (g *Graph) turtleTerm(t Term) string {
+	switch t.kind {
+	case blankTerm:
+		return "_:" + t.Value
+	case literalTerm:
+		switch t.Datatype {
+		case "":
+			return quote(t.Value)
+		case XSD + "integer", XSD + "boolean":
+			return t.Value
+		}
+		return quote(t.Value) + "^^" + g.turtleTerm(IRI(t.Datatype))
+	}
+	if name, ok := g.prefixed(t.Value); ok {
+		return name
+	}
+	return "<" + escapeIRI(t.Value) + ">"
+}
+
+func (g *Graph) jsonldID(t Term) string {
+	if t.kind == blankTerm {
+		return "_:" + t.Value
+	}
+	return g.compact(t.Value)
+}
+
+func (g *Graph) jsonldValue(t Term) interface{} {
+	switch t.kind {
+	case literalTerm:
+		if t.Datatype == "" {
+			return map[string]string{"@value": t.Value}
+		}
+		return orderedNode{{"@value", t.Value}, {"@type", g.compact(t.Datatype)}}
+	}
+	return map[string]string{"@id": g.jsonldID(t)}
+}
+
+func ntriplesTerm(t Term) string {
+	switch t.kind {
+	case blankTerm:
+		return "_:" + t.Value
+	case literalTerm:
+		if t.Datatype == "" {
+			return quote(t.Value)
+		}
+		return quote(t.Value) + "^^<" + escapeIRI(t.Datatype) + ">"
+	}
+	return "<" + escapeIRI(t.Value) + ">"
+}
+
+// quote returns a string literal with the escapes shared by Turtle and
+// N-Triples
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// escapeIRI escapes the characters that IRI references may not contain
+func escapeIRI(iri string) string {
+	var b strings.Builder
+	for _, r := range iri {
+		if r <= 0x20 || strings.ContainsRune(`<>"{}|^`+"`\\", r) {
+			fmt.Fprintf(&b, `\u%04X`, r)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// orderedNode is a JSON object that keeps the order of its fields
+type orderedNode []nodeField
+
+type nodeField struct {
+	Key   string
+	Value interface{}
+}
+
+func (n orderedNode) MarshalJSON() ([]byte, error) {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, field := range n {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, err := json.Marshal(field.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(field.Value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return []byte(b.String()), nil
+}
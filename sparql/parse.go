@@ -0,0 +1,793 @@
+/*
+ * MarineNP SPARQL Parser
+ * Purpose: Parse the SPARQL subset answered by the graph query endpoint
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file parses SELECT queries with PREFIX and BASE declarations, basic
+ * graph patterns with the ; and , abbreviations, FILTER expressions, OPTIONAL
+ * groups, ORDER BY, LIMIT and OFFSET. Other query forms and graph patterns,
+ * such as CONSTRUCT, UNION or property paths, are rejected.
+ */
+
+package sparql
+
+import (
+	"fmt"
+	"marinenp/rdf"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Error is a query that cannot be parsed or is not in the supported subset
+type Error struct {
+	Offset *int   `json:"offset,omitempty"` // Byte offset in the query, for syntax errors
+	Reason string `json:"reason"`
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	if e.Offset == nil {
+		return "invalid query: " + e.Reason
+	}
+	return fmt.Sprintf("invalid query at offset %d: %s", *e.Offset, e.Reason)
+}
+
+// errorAt returns a syntax error at an offset
+func errorAt(offset int, reason string) *Error {
+	return &Error{Offset: &offset, Reason: reason}
+}
+
+// Query is a parsed SELECT query
+type Query struct {
+	Vars     []string // Projected variables, empty for SELECT *
+	Distinct bool
+	Where    *Group
+	Order    []OrderKey
+	Limit    int64 // -1 without LIMIT
+	Offset   int64
+}
+
+// Group is a group graph pattern. Its triple patterns are matched first,
+// then its optional groups are joined in order and its filters applied to
+// the result, wherever they appear in the group.
+type Group struct {
+	Triples   []Pattern
+	Optionals []*Group
+	Filters   []Expr
+}
+
+// Pattern is a triple pattern
+type Pattern struct {
+	Subject, Predicate, Object Node
+}
+
+// Node is a variable or an RDF term of a triple pattern
+type Node struct {
+	Var  string // Variable name, empty for terms
+	Term Term
+}
+
+// Term is an IRI or a literal
+type Term struct {
+	Literal  bool
+	Value    string
+	Datatype string // Datatype IRI of typed literals
+}
+
+// OrderKey is a sort key of ORDER BY
+type OrderKey struct {
+	Expr       Expr
+	Descending bool
+}
+
+// Expr is a FILTER or ORDER BY expression: a variable (VarExpr), a term
+// (Term), an operator (*UnaryExpr, *BinaryExpr) or a function call (*CallExpr)
+type Expr interface{}
+
+// VarExpr is a variable in an expression
+type VarExpr string
+
+// UnaryExpr is !x or -x
+type UnaryExpr struct {
+	Op  string
+	Arg Expr
+}
+
+// BinaryExpr is a logical, comparison or arithmetic operator
+type BinaryExpr struct {
+	Op          string
+	Left, Right Expr
+}
+
+// CallExpr is a call of a built-in function, named in upper case
+type CallExpr struct {
+	Name string
+	Args []Expr
+}
+
+// blankPrefix names the variables standing for blank nodes of the query,
+// which SELECT * does not project
+const blankPrefix = "_:"
+
+// Parse parses a query. The prefixes are declared before those of the query.
+func Parse(src string, prefixes []rdf.Prefix) (*Query, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, prefixes: make(map[string]string)}
+	for _, prefix := range prefixes {
+		p.prefixes[prefix.Name] = prefix.Namespace
+	}
+	return p.query()
+}
+
+type tokenKind int
+
+const (
+	tokEOF    tokenKind = iota
+	tokIRI              // <iri>, without the brackets
+	tokPName            // prefix:local
+	tokVar              // ?name or $name, without the sigil
+	tokBlank            // _:label, without _:
+	tokString           // unescaped string
+	tokNumber           // integer, decimal or double
+	tokLang             // @tag, without @
+	tokWord             // keyword, function name, a, true or false
+	tokPunct            // operator or delimiter
+)
+
+type token struct {
+	kind   tokenKind
+	value  string
+	offset int
+}
+
+// punctuation lists the operators and delimiters, longest first
+var punctuation = []string{"&&", "||", "!=", "<=", ">=", "^^", "{", "}", "(", ")", ".", ";", ",", "*", "=", "<", ">", "!", "+", "-", "/"}
+
+// lex splits a query into tokens
+func lex(src string) ([]token, error) {
+	var tokens []token
+	i, depth := 0, 0 // depth counts the open parentheses of expressions
+	emit := func(kind tokenKind, value string, end int) {
+		tokens = append(tokens, token{kind, value, i})
+		i = end
+	}
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '<' && !(depth > 0 && afterOperand(tokens)) && iriEnd(src, i) > 0:
+			end := iriEnd(src, i)
+			emit(tokIRI, src[i+1:end], end+1)
+		case c == '?' || c == '$':
+			j := scan(src, i+1, isVarChar)
+			if j == i+1 {
+				return nil, errorAt(i, "variable without a name")
+			}
+			emit(tokVar, src[i+1:j], j)
+		case c == '_' && strings.HasPrefix(src[i:], blankPrefix):
+			j := scan(src, i+2, isVarChar)
+			if j == i+2 {
+				return nil, errorAt(i, "blank node without a label")
+			}
+			emit(tokBlank, src[i+2:j], j)
+		case c == '"' || c == '\'':
+			s, end, err := lexString(src, i)
+			if err != nil {
+				return nil, err
+			}
+			emit(tokString, s, end)
+		case c == '@':
+			j := scan(src, i+1, func(c byte) bool { return isLetter(c) || isDigit(c) || c == '-' })
+			emit(tokLang, src[i+1:j], j)
+		case isDigit(c) || c == '.' && i+1 < len(src) && isDigit(src[i+1]):
+			emit(tokNumber, src[i:scanNumber(src, i)], scanNumber(src, i))
+		case isLetter(c) || c == '_' || c == ':' || c >= utf8.RuneSelf:
+			j := scan(src, i, isNameChar)
+			if j < len(src) && src[j] == ':' {
+				j = scan(src, j+1, func(c byte) bool { return isNameChar(c) || c == '.' || c == ':' || c == '%' })
+				for src[j-1] == '.' {
+					j--
+				}
+				emit(tokPName, src[i:j], j)
+			} else {
+				emit(tokWord, src[i:j], j)
+			}
+		default:
+			matched := false
+			for _, p := range punctuation {
+				if strings.HasPrefix(src[i:], p) {
+					if p == "(" {
+						depth++
+					} else if p == ")" {
+						depth--
+					}
+					emit(tokPunct, p, i+len(p))
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, errorAt(i, fmt.Sprintf("unexpected character %q", src[i]))
+			}
+		}
+	}
+	return append(tokens, token{tokEOF, "", len(src)}), nil
+}
+
+func isLetter(c byte) bool   { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+func isDigit(c byte) bool    { return c >= '0' && c <= '9' }
+func isVarChar(c byte) bool  { return isLetter(c) || isDigit(c) || c == '_' || c >= utf8.RuneSelf }
+func isNameChar(c byte) bool { return isVarChar(c) || c == '-' }
+
+// scan returns the end of the run of characters accepted from i
+func scan(src string, i int, accept func(byte) bool) int {
+	for i < len(src) && accept(src[i]) {
+		i++
+	}
+	return i
+}
+
+// scanNumber returns the end of the number starting at i
+func scanNumber(src string, i int) int {
+	j := scan(src, i, isDigit)
+	if j+1 < len(src) && src[j] == '.' && isDigit(src[j+1]) {
+		j = scan(src, j+1, isDigit)
+	}
+	if j < len(src) && (src[j] == 'e' || src[j] == 'E') {
+		k := j + 1
+		if k < len(src) && (src[k] == '+' || src[k] == '-') {
+			k++
+		}
+		if k < len(src) && isDigit(src[k]) {
+			j = scan(src, k, isDigit)
+		}
+	}
+	return j
+}
+
+// afterOperand reports whether the last token ends an operand, so that a <
+// following it in an expression is the less-than operator
+func afterOperand(tokens []token) bool {
+	if len(tokens) == 0 {
+		return false
+	}
+	switch t := tokens[len(tokens)-1]; t.kind {
+	case tokVar, tokNumber, tokString:
+		return true
+	case tokPunct:
+		return t.value == ")"
+	}
+	return false
+}
+
+// iriEnd returns the offset of the > closing the IRI reference opened at i,
+// or -1 if the < is an operator
+func iriEnd(src string, i int) int {
+	for j := i + 1; j < len(src); j++ {
+		switch c := src[j]; {
+		case c == '>':
+			return j
+		case c <= ' ' || strings.IndexByte(`<"{}|^`+"`\\", c) >= 0:
+			return -1
+		}
+	}
+	return -1
+}
+
+// lexString unescapes the short or long string literal starting at i and
+// returns it with its end
+func lexString(src string, i int) (string, int, error) {
+	quote := src[i : i+1]
+	long := strings.HasPrefix(src[i:], strings.Repeat(quote, 3))
+	if long {
+		quote = strings.Repeat(quote, 3)
+	}
+	var b strings.Builder
+	for j := i + len(quote); j < len(src); {
+		c := src[j]
+		switch {
+		case strings.HasPrefix(src[j:], quote):
+			return b.String(), j + len(quote), nil
+		case c == '\\' && j+1 < len(src):
+			escape := src[j+1]
+			j += 2
+			switch escape {
+			case 't':
+				b.WriteByte('\t')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case '"', '\'', '\\':
+				b.WriteByte(escape)
+			case 'u', 'U':
+				n := 4
+				if escape == 'U' {
+					n = 8
+				}
+				if j+n > len(src) {
+					return "", 0, errorAt(j-2, "invalid unicode escape")
+				}
+				r, err := strconv.ParseUint(src[j:j+n], 16, 32)
+				if err != nil {
+					return "", 0, errorAt(j-2, "invalid unicode escape")
+				}
+				b.WriteRune(rune(r))
+				j += n
+			default:
+				return "", 0, errorAt(j-2, fmt.Sprintf("invalid escape \\%c", escape))
+			}
+		case !long && (c == '\n' || c == '\r'):
+			return "", 0, errorAt(i, "unterminated string")
+		default:
+			b.WriteByte(c)
+			j++
+		}
+	}
+	return "", 0, errorAt(i, "unterminated string")
+}
+
+type parser struct {
+	tokens   []token
+	pos      int
+	prefixes map[string]string
+	base     string
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return errorAt(p.peek().offset, fmt.Sprintf(format, args...))
+}
+
+// isPunct reports whether the next token is the operator or delimiter v
+func (p *parser) isPunct(v string) bool {
+	t := p.peek()
+	return t.kind == tokPunct && t.value == v
+}
+
+// isWord reports whether the next token is the keyword v, in any case
+func (p *parser) isWord(v string) bool {
+	t := p.peek()
+	return t.kind == tokWord && strings.EqualFold(t.value, v)
+}
+
+func (p *parser) expect(v string) error {
+	if !p.isPunct(v) {
+		return p.errorf("expected %q, found %s", v, p.describe())
+	}
+	p.next()
+	return nil
+}
+
+// describe names the next token in errors
+func (p *parser) describe() string {
+	t := p.peek()
+	if t.kind == tokEOF {
+		return "end of query"
+	}
+	return fmt.Sprintf("%q", t.value)
+}
+
+func (p *parser) query() (*Query, error) {
+	for {
+		if p.isWord("PREFIX") {
+			p.next()
+			name := p.next()
+			if name.kind != tokPName || !strings.HasSuffix(name.value, ":") {
+				return nil, errorAt(name.offset, "expected a prefix name such as schema:")
+			}
+			iri, err := p.iri()
+			if err != nil {
+				return nil, err
+			}
+			p.prefixes[strings.TrimSuffix(name.value, ":")] = iri
+		} else if p.isWord("BASE") {
+			p.next()
+			iri, err := p.iri()
+			if err != nil {
+				return nil, err
+			}
+			p.base = iri
+		} else {
+			break
+		}
+	}
+
+	for _, form := range []string{"CONSTRUCT", "DESCRIBE", "ASK", "INSERT", "DELETE", "LOAD", "CLEAR", "DROP", "CREATE"} {
+		if p.isWord(form) {
+			return nil, p.errorf("only SELECT queries are supported")
+		}
+	}
+	if !p.isWord("SELECT") {
+		return nil, p.errorf("expected SELECT, found %s", p.describe())
+	}
+	p.next()
+
+	q := &Query{Limit: -1}
+	if p.isWord("DISTINCT") || p.isWord("REDUCED") {
+		q.Distinct = p.isWord("DISTINCT")
+		p.next()
+	}
+	if p.isPunct("*") {
+		p.next()
+	} else {
+		for p.peek().kind == tokVar {
+			q.Vars = append(q.Vars, p.next().value)
+		}
+		if len(q.Vars) == 0 {
+			return nil, p.errorf("expected variables or * after SELECT, found %s", p.describe())
+		}
+	}
+	if p.isWord("FROM") {
+		return nil, p.errorf("FROM is not supported, the query runs over the MarineNP graph")
+	}
+	if p.isWord("WHERE") {
+		p.next()
+	}
+	where, err := p.group()
+	if err != nil {
+		return nil, err
+	}
+	q.Where = where
+
+	for p.peek().kind != tokEOF {
+		switch {
+		case p.isWord("ORDER"):
+			p.next()
+			if !p.isWord("BY") {
+				return nil, p.errorf("expected BY after ORDER")
+			}
+			p.next()
+			for {
+				key, ok, err := p.orderKey()
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					break
+				}
+				q.Order = append(q.Order, key)
+			}
+			if len(q.Order) == 0 {
+				return nil, p.errorf("expected a sort key after ORDER BY")
+			}
+		case p.isWord("LIMIT"):
+			p.next()
+			if q.Limit, err = p.count(); err != nil {
+				return nil, err
+			}
+		case p.isWord("OFFSET"):
+			p.next()
+			if q.Offset, err = p.count(); err != nil {
+				return nil, err
+			}
+		case p.isWord("GROUP"), p.isWord("HAVING"), p.isWord("VALUES"):
+			return nil, p.errorf("%s is not supported", strings.ToUpper(p.peek().value))
+		default:
+			return nil, p.errorf("unexpected %s after the WHERE clause", p.describe())
+		}
+	}
+	return q, nil
+}
+
+// count parses the non-negative integer of LIMIT or OFFSET
+func (p *parser) count() (int64, error) {
+	t := p.next()
+	n, err := strconv.ParseInt(t.value, 10, 64)
+	if t.kind != tokNumber || err != nil || n < 0 {
+		return 0, errorAt(t.offset, "expected a non-negative integer")
+	}
+	return n, nil
+}
+
+// orderKey parses a sort key, returning false at the end of the keys
+func (p *parser) orderKey() (OrderKey, bool, error) {
+	switch {
+	case p.isWord("ASC"), p.isWord("DESC"):
+		descending := p.isWord("DESC")
+		p.next()
+		e, err := p.bracketted()
+		return OrderKey{e, descending}, true, err
+	case p.peek().kind == tokVar:
+		return OrderKey{Expr: VarExpr(p.next().value)}, true, nil
+	case p.isPunct("("):
+		e, err := p.bracketted()
+		return OrderKey{Expr: e}, true, err
+	}
+	return OrderKey{}, false, nil
+}
+
+// group parses a group graph pattern in braces
+func (p *parser) group() (*Group, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	g := &Group{}
+	for !p.isPunct("}") {
+		switch {
+		case p.peek().kind == tokEOF:
+			return nil, p.errorf("unterminated group, expected \"}\"")
+		case p.isWord("FILTER"):
+			p.next()
+			var e Expr
+			var err error
+			if p.isPunct("(") {
+				e, err = p.bracketted()
+			} else {
+				e, err = p.primary()
+			}
+			if err != nil {
+				return nil, err
+			}
+			g.Filters = append(g.Filters, e)
+		case p.isWord("OPTIONAL"):
+			p.next()
+			optional, err := p.group()
+			if err != nil {
+				return nil, err
+			}
+			g.Optionals = append(g.Optionals, optional)
+		case p.isPunct("."):
+			p.next()
+		case p.isPunct("{"):
+			return nil, p.errorf("nested groups and UNION are not supported")
+		case p.isWord("UNION"), p.isWord("MINUS"), p.isWord("GRAPH"), p.isWord("BIND"),
+			p.isWord("VALUES"), p.isWord("SERVICE"):
+			return nil, p.errorf("%s is not supported", strings.ToUpper(p.peek().value))
+		default:
+			if err := p.triples(g); err != nil {
+				return nil, err
+			}
+		}
+	}
+	p.next()
+	return g, nil
+}
+
+// triples parses the triple patterns of a subject, with ; separating the
+// predicates and , the objects of a predicate
+func (p *parser) triples(g *Group) error {
+	subject, err := p.node()
+	if err != nil {
+		return err
+	}
+	for {
+		var predicate Node
+		if t := p.peek(); t.kind == tokWord && t.value == "a" {
+			p.next()
+			predicate = Node{Term: Term{Value: rdf.RDF + "type"}}
+		} else if predicate, err = p.node(); err != nil {
+			return err
+		} else if predicate.Term.Literal {
+			return p.errorf("a predicate must be a variable or an IRI")
+		}
+		if p.isPunct("/") || p.isPunct("*") || p.isPunct("+") {
+			return p.errorf("property paths are not supported")
+		}
+		for {
+			object, err := p.node()
+			if err != nil {
+				return err
+			}
+			g.Triples = append(g.Triples, Pattern{subject, predicate, object})
+			if !p.isPunct(",") {
+				break
+			}
+			p.next()
+		}
+		if !p.isPunct(";") {
+			return nil
+		}
+		for p.isPunct(";") {
+			p.next()
+		}
+		if p.isPunct(".") || p.isPunct("}") {
+			return nil
+		}
+	}
+}
+
+// node parses a variable or a term of a triple pattern
+func (p *parser) node() (Node, error) {
+	switch t := p.peek(); t.kind {
+	case tokVar:
+		p.next()
+		return Node{Var: t.value}, nil
+	case tokBlank:
+		p.next()
+		return Node{Var: blankPrefix + t.value}, nil
+	}
+	if p.peek().kind == tokWord && !p.isWord("true") && !p.isWord("false") {
+		return Node{}, p.errorf("expected a variable or a term, found %s", p.describe())
+	}
+	term, err := p.term()
+	return Node{Term: term}, err
+}
+
+// term parses an IRI, a prefixed name or a literal
+func (p *parser) term() (Term, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokIRI, tokPName:
+		iri, err := p.iri()
+		return Term{Value: iri}, err
+	case tokString:
+		p.next()
+		term := Term{Literal: true, Value: t.value}
+		if p.peek().kind == tokLang {
+			p.next() // Language tags are ignored, as the graph has none
+		} else if p.isPunct("^^") {
+			p.next()
+			datatype, err := p.iri()
+			if err != nil {
+				return Term{}, err
+			}
+			if datatype != rdf.XSD+"string" {
+				term.Datatype = datatype
+			}
+		}
+		return term, nil
+	case tokNumber:
+		p.next()
+		datatype := rdf.XSD + "integer"
+		if strings.ContainsAny(t.value, "eE") {
+			datatype = rdf.XSD + "double"
+		} else if strings.Contains(t.value, ".") {
+			datatype = rdf.XSD + "decimal"
+		}
+		return Term{Literal: true, Value: t.value, Datatype: datatype}, nil
+	case tokWord:
+		if p.isWord("true") || p.isWord("false") {
+			p.next()
+			return Term{Literal: true, Value: strings.ToLower(t.value), Datatype: rdf.XSD + "boolean"}, nil
+		}
+	}
+	return Term{}, p.errorf("expected a term, found %s", p.describe())
+}
+
+// iri parses an IRI reference or a prefixed name and returns the IRI
+func (p *parser) iri() (string, error) {
+	t := p.next()
+	switch t.kind {
+	case tokIRI:
+		if p.base != "" && !strings.Contains(t.value, ":") {
+			return p.base + t.value, nil
+		}
+		return t.value, nil
+	case tokPName:
+		prefix, local, _ := strings.Cut(t.value, ":")
+		namespace, ok := p.prefixes[prefix]
+		if !ok {
+			return "", errorAt(t.offset, fmt.Sprintf("undeclared prefix %s:", prefix))
+		}
+		return namespace + local, nil
+	}
+	return "", errorAt(t.offset, fmt.Sprintf("expected an IRI, found %q", t.value))
+}
+
+// bracketted parses an expression in parentheses
+func (p *parser) bracketted() (Expr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	return e, p.expect(")")
+}
+
+// expr parses an expression, with the SPARQL operator precedence
+func (p *parser) expr() (Expr, error) {
+	return p.binary(0)
+}
+
+// precedence lists the binary operators by increasing precedence
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"=", "!=", "<", ">", "<=", ">="},
+	{"+", "-"},
+	{"*", "/"},
+}
+
+func (p *parser) binary(level int) (Expr, error) {
+	if level == len(precedence) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := ""
+		for _, o := range precedence[level] {
+			if p.isPunct(o) {
+				op = o
+			}
+		}
+		if op == "" {
+			return left, nil
+		}
+		p.next()
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{op, left, right}
+		// Comparisons do not chain
+		if level == 2 {
+			return left, nil
+		}
+	}
+}
+
+func (p *parser) unary() (Expr, error) {
+	for _, op := range []string{"!", "-", "+"} {
+		if p.isPunct(op) {
+			p.next()
+			arg, err := p.unary()
+			if err != nil || op == "+" {
+				return arg, err
+			}
+			return &UnaryExpr{op, arg}, nil
+		}
+	}
+	return p.primary()
+}
+
+// primary parses a bracketted expression, a variable, a term or a call
+func (p *parser) primary() (Expr, error) {
+	t := p.peek()
+	switch {
+	case p.isPunct("("):
+		return p.bracketted()
+	case t.kind == tokVar:
+		p.next()
+		return VarExpr(t.value), nil
+	case t.kind == tokWord && !p.isWord("true") && !p.isWord("false"):
+		p.next()
+		call := &CallExpr{Name: strings.ToUpper(t.value)}
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		for !p.isPunct(")") {
+			if len(call.Args) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			arg, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			call.Args = append(call.Args, arg)
+		}
+		p.next()
+		return call, nil
+	}
+	return p.term()
+}
@@ -0,0 +1,113 @@
+package sparql
+
+import (
+	"errors"
+	"testing"
+
+	"marinenp/rdf"
+)
+
+// testResources are the resources of the graph the tests query
+var testResources = rdf.Resources{Base: "https://marinenp.example"}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		query     string
+		vars      []string
+		distinct  bool
+		triples   int
+		optionals int
+		filters   int
+		order     int
+		limit     int64
+		offset    int64
+	}{
+		{`SELECT * WHERE { ?m schema:name ?name }`, nil, false, 1, 0, 0, 0, -1, 0},
+		{`SELECT DISTINCT ?m WHERE { ?m a schema:MolecularEntity ; schema:name ?name . }`, []string{"m"}, true, 2, 0, 0, 0, -1, 0},
+		{`SELECT ?m ?o { ?m wdt:P703 ?o, ?p }`, []string{"m", "o"}, false, 2, 0, 0, 0, -1, 0},
+		{`PREFIX ex: <http://example.org/> SELECT ?s WHERE { ?s ex:p ?o }`, []string{"s"}, false, 1, 0, 0, 0, -1, 0},
+		{`SELECT ?m ?doi WHERE { ?m schema:name ?n OPTIONAL { ?m schema:citation ?c . ?c schema:sameAs ?doi } }`, []string{"m", "doi"}, false, 1, 1, 0, 0, -1, 0},
+		{`SELECT ?m WHERE { ?m schema:name ?n FILTER(CONTAINS(?n, "toxin") && ?n != "x") FILTER BOUND(?n) }`, []string{"m"}, false, 1, 0, 2, 0, -1, 0},
+		{`SELECT ?m WHERE { ?m schema:name ?n } ORDER BY DESC(?n) ?m LIMIT 10 OFFSET 20`, []string{"m"}, false, 1, 0, 0, 2, 10, 20},
+		{`select ?m where { ?m schema:name ?n } limit 0`, []string{"m"}, false, 1, 0, 0, 0, 0, 0},
+		{`# comment
+		SELECT ?m WHERE { ?m schema:name "Tetrodotoxin"@en }`, []string{"m"}, false, 1, 0, 0, 0, -1, 0},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.query, Prefixes(testResources))
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.query, err)
+			continue
+		}
+		if len(q.Vars) != len(tt.vars) {
+			t.Errorf("Parse(%q): variables %v, want %v", tt.query, q.Vars, tt.vars)
+		} else {
+			for i := range q.Vars {
+				if q.Vars[i] != tt.vars[i] {
+					t.Errorf("Parse(%q): variables %v, want %v", tt.query, q.Vars, tt.vars)
+					break
+				}
+			}
+		}
+		if q.Distinct != tt.distinct || len(q.Where.Triples) != tt.triples || len(q.Where.Optionals) != tt.optionals ||
+			len(q.Where.Filters) != tt.filters || len(q.Order) != tt.order || q.Limit != tt.limit || q.Offset != tt.offset {
+			t.Errorf("Parse(%q): distinct %v, %d triples, %d optionals, %d filters, %d sort keys, limit %d, offset %d; "+
+				"want %v, %d, %d, %d, %d, %d, %d", tt.query, q.Distinct, len(q.Where.Triples), len(q.Where.Optionals),
+				len(q.Where.Filters), len(q.Order), q.Limit, q.Offset,
+				tt.distinct, tt.triples, tt.optionals, tt.filters, tt.order, tt.limit, tt.offset)
+		}
+	}
+}
+
+func TestParseTerms(t *testing.T) {
+	q, err := Parse(`SELECT ?m WHERE { ?m a schema:MolecularEntity ; schema:name "TTX" ; schema:weight "1.5"^^xsd:double }`, Prefixes(testResources))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Pattern{
+		{Node{Var: "m"}, Node{Term: Term{Value: rdf.RDF + "type"}}, Node{Term: Term{Value: rdf.Schema + "MolecularEntity"}}},
+		{Node{Var: "m"}, Node{Term: Term{Value: rdf.Schema + "name"}}, Node{Term: Term{Literal: true, Value: "TTX"}}},
+		{Node{Var: "m"}, Node{Term: Term{Value: rdf.Schema + "weight"}}, Node{Term: Term{Literal: true, Value: "1.5", Datatype: "http://www.w3.org/2001/XMLSchema#double"}}},
+	}
+	if len(q.Where.Triples) != len(want) {
+		t.Fatalf("got %d triples, want %d", len(q.Where.Triples), len(want))
+	}
+	for i, got := range q.Where.Triples {
+		if got != want[i] {
+			t.Errorf("triple %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		query  string
+		offset bool // Whether the error has an offset
+	}{
+		{`ASK { ?s ?p ?o }`, true},
+		{`CONSTRUCT { ?s ?p ?o } WHERE { ?s ?p ?o }`, true},
+		{`SELECT WHERE { ?s ?p ?o }`, true},
+		{`SELECT ?s FROM <http://example.org/> WHERE { ?s ?p ?o }`, true},
+		{`SELECT ?s WHERE { ?s ?p ?o `, true},
+		{`SELECT ?s WHERE { { ?s ?p ?o } UNION { ?s ?p ?o } }`, true},
+		{`SELECT ?s WHERE { ?s ?p ?o MINUS { ?s ?p ?o } }`, true},
+		{`SELECT ?s WHERE { BIND(1 AS ?s) }`, true},
+		{`SELECT ?s WHERE { ?s schema:name/schema:name ?o }`, true},
+		{`SELECT ?s WHERE { ?s "name" ?o }`, true},
+		{`SELECT ?s WHERE { ?s ?p ?o } GROUP BY ?s`, true},
+		{`SELECT ?s WHERE { ?s ?p ?o } LIMIT -1`, true},
+		{`SELECT ?s WHERE { ?s unknown:p ?o }`, true},
+		{`SELECT ?s WHERE { ?s ?p "unterminated }`, true},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.query, Prefixes(testResources))
+		var queryErr *Error
+		if !errors.As(err, &queryErr) {
+			t.Errorf("Parse(%q): error %v, want *Error", tt.query, err)
+			continue
+		}
+		if (queryErr.Offset != nil) != tt.offset {
+			t.Errorf("Parse(%q): %v, want an offset: %v", tt.query, err, tt.offset)
+		}
+	}
+}
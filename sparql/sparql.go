@@ -0,0 +1,646 @@
+/*
+ * MarineNP SPARQL
+ * Purpose: Answer read-only SPARQL queries over the MarineNP graph
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file translates a parsed query to one SQL SELECT over the triple view:
+ * each triple pattern joins the arms of the view it can match, OPTIONAL groups
+ * are left joined and FILTER expressions become SQL conditions. The query runs
+ * with a timeout and a limit on the number of results, and its results are
+ * returned in the SPARQL 1.1 JSON results format.
+ */
+
+package sparql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"marinenp/rdf"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ContentType is the media type of SPARQL JSON results
+const ContentType = "application/sparql-results+json"
+
+// maxPatterns limits the triple patterns of a query, as each is a join
+const maxPatterns = 32
+
+// ErrTimeout reports a query that did not finish within its timeout
+var ErrTimeout = errors.New("query timed out")
+
+// Options are the resources of the graph and the limits of a query
+type Options struct {
+	Resources rdf.Resources
+	Timeout   time.Duration
+	MaxRows   int64
+}
+
+// Results are the solutions of a query in the SPARQL JSON results format
+type Results struct {
+	Head struct {
+		Vars []string `json:"vars"`
+	} `json:"head"`
+	Results struct {
+		Bindings []map[string]Binding `json:"bindings"`
+	} `json:"results"`
+	Truncated bool `json:"-"` // Whether solutions beyond MaxRows were left out
+}
+
+// Binding is the value of a variable in a solution
+type Binding struct {
+	Type     string `json:"type"` // uri, bnode or literal
+	Value    string `json:"value"`
+	Datatype string `json:"datatype,omitempty"`
+}
+
+// Prefixes returns the prefixes declared for every query, those of the
+// graph plus rdf
+func Prefixes(r rdf.Resources) []rdf.Prefix {
+	return append(r.Prefixes(), rdf.Prefix{Name: "rdf", Namespace: rdf.RDF})
+}
+
+// Execute parses and runs a query
+func Execute(ctx context.Context, db *gorm.DB, src string, opts Options) (*Results, error) {
+	q, err := Parse(src, Prefixes(opts.Resources))
+	if err != nil {
+		return nil, err
+	}
+	c := &compiler{view: newView(opts.Resources), base: opts.Resources.Base}
+	stmt, vars, err := c.compile(q, opts.MaxRows)
+	if err != nil {
+		return nil, err
+	}
+
+	// The statement is run on the connection pool rather than through gorm,
+	// so that the prepared statement cache does not keep every query
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	results, err := run(ctx, sqlDB, stmt, vars, opts.MaxRows)
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%w after %s", ErrTimeout, opts.Timeout)
+	}
+	return results, err
+}
+
+// run runs the statement and reads the solutions, which have a value, kind
+// and datatype column per variable
+func run(ctx context.Context, db *sql.DB, stmt fragment, vars []string, maxRows int64) (*Results, error) {
+	rows, err := db.QueryContext(ctx, stmt.sql, stmt.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := &Results{}
+	results.Head.Vars = vars
+	results.Results.Bindings = []map[string]Binding{}
+	values := make([]interface{}, 3*len(vars))
+	dest := make([]interface{}, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if int64(len(results.Results.Bindings)) == maxRows {
+			results.Truncated = true
+			break
+		}
+		if len(vars) > 0 {
+			if err := rows.Scan(dest...); err != nil {
+				return nil, err
+			}
+		}
+		solution := make(map[string]Binding, len(vars))
+		for i, name := range vars {
+			if b, ok := binding(values[3*i], values[3*i+1], values[3*i+2]); ok {
+				solution[name] = b
+			}
+		}
+		results.Results.Bindings = append(results.Results.Bindings, solution)
+	}
+	return results, rows.Err()
+}
+
+// binding returns the binding of a variable from its columns, or false if it
+// is unbound
+func binding(value, kind, datatype interface{}) (Binding, bool) {
+	if value == nil {
+		return Binding{}, false
+	}
+	var s string
+	switch v := value.(type) {
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case float64:
+		s = strconv.FormatFloat(v, 'g', -1, 64)
+	case []byte:
+		s = string(v)
+	default:
+		s = fmt.Sprint(v)
+	}
+	switch text(kind) {
+	case kindIRI:
+		return Binding{Type: "uri", Value: s}, true
+	case kindBlank:
+		return Binding{Type: "bnode", Value: s}, true
+	}
+	b := Binding{Type: "literal", Value: s, Datatype: text(datatype)}
+	if b.Datatype == rdf.XSD+"boolean" {
+		b.Value = strconv.FormatBool(s != "0" && s != "false")
+	}
+	return b, true
+}
+
+// text returns a text column value
+func text(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
+
+// fragment is SQL with its arguments
+type fragment struct {
+	sql  string
+	args []interface{}
+}
+
+// sqlBuilder concatenates fragments
+type sqlBuilder struct {
+	strings.Builder
+	args []interface{}
+}
+
+func (b *sqlBuilder) add(f fragment) {
+	b.WriteString(f.sql)
+	b.args = append(b.args, f.args...)
+}
+
+func (b *sqlBuilder) fragment() fragment {
+	return fragment{b.String(), b.args}
+}
+
+// joinFragments joins fragments with a separator
+func joinFragments(fragments []fragment, sep string) fragment {
+	var b sqlBuilder
+	for i, f := range fragments {
+		if i > 0 {
+			b.WriteString(sep)
+		}
+		b.add(f)
+	}
+	return b.fragment()
+}
+
+// column is the SQL of the value, kind and datatype of a variable
+type column struct {
+	value, kind, datatype string
+}
+
+// scope maps variables to their columns, in order of appearance
+type scope struct {
+	columns map[string]column
+	names   []string
+}
+
+func newScope() *scope {
+	return &scope{columns: make(map[string]column)}
+}
+
+// bind binds a variable, returning the condition joining it to its earlier
+// binding if it has one
+func (s *scope) bind(name string, c column) (fragment, bool) {
+	if prev, ok := s.columns[name]; ok {
+		return fragment{sql: fmt.Sprintf("%s = %s AND %s = %s", prev.value, c.value, prev.kind, c.kind)}, true
+	}
+	s.columns[name] = c
+	s.names = append(s.names, name)
+	return fragment{}, false
+}
+
+// compiledGroup is the FROM clause of a group and the conditions of
+// variables repeated in its first triple pattern
+type compiledGroup struct {
+	from  sqlBuilder
+	where []fragment
+	vars  *scope
+}
+
+type compiler struct {
+	view     []arm
+	base     string
+	tables   int
+	patterns int
+}
+
+// alias returns a new table alias
+func (c *compiler) alias() string {
+	c.tables++
+	return fmt.Sprintf("t%d", c.tables)
+}
+
+// compile translates a query to SQL, returning the projected variables. One
+// row more than maxRows is selected to detect truncated results.
+func (c *compiler) compile(q *Query, maxRows int64) (fragment, []string, error) {
+	g, err := c.group(q.Where)
+	if err != nil {
+		return fragment{}, nil, err
+	}
+	where := g.where
+	for _, filter := range q.Where.Filters {
+		f, err := c.expr(filter, g.vars)
+		if err != nil {
+			return fragment{}, nil, err
+		}
+		where = append(where, f)
+	}
+
+	vars := q.Vars
+	if len(vars) == 0 {
+		for _, name := range g.vars.names {
+			if !strings.HasPrefix(name, blankPrefix) {
+				vars = append(vars, name)
+			}
+		}
+	}
+
+	var b sqlBuilder
+	b.WriteString("SELECT ")
+	if q.Distinct {
+		b.WriteString("DISTINCT ")
+	}
+	for i, name := range vars {
+		col, ok := g.vars.columns[name]
+		if !ok {
+			col = column{"NULL", "NULL", "NULL"}
+		}
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%s, %s, %s", col.value, col.kind, col.datatype)
+	}
+	if len(vars) == 0 {
+		b.WriteString("1")
+	}
+	b.WriteString(" FROM ")
+	b.add(g.from.fragment())
+	if len(where) > 0 {
+		b.WriteString(" WHERE ")
+		b.add(joinFragments(where, " AND "))
+	}
+	for i, key := range q.Order {
+		f, err := c.expr(key.Expr, g.vars)
+		if err != nil {
+			return fragment{}, nil, err
+		}
+		if i == 0 {
+			b.WriteString(" ORDER BY ")
+		} else {
+			b.WriteString(", ")
+		}
+		b.add(f)
+		if key.Descending {
+			b.WriteString(" DESC")
+		}
+	}
+	limit := maxRows + 1
+	if q.Limit >= 0 && q.Limit < limit {
+		limit = q.Limit
+	}
+	b.WriteString(" LIMIT ? OFFSET ?")
+	b.args = append(b.args, limit, q.Offset)
+	return b.fragment(), vars, nil
+}
+
+// group translates the triple patterns and optional groups of a group. The
+// filters of the group are left to the caller, which knows their scope.
+func (c *compiler) group(g *Group) (*compiledGroup, error) {
+	out := &compiledGroup{vars: newScope()}
+	if len(g.Triples) == 0 {
+		out.from.WriteString("(SELECT 1) AS " + c.alias())
+	}
+	for i, t := range g.Triples {
+		if c.patterns++; c.patterns > maxPatterns {
+			return nil, &Error{Reason: fmt.Sprintf("queries are limited to %d triple patterns", maxPatterns)}
+		}
+		table := c.alias()
+		if i > 0 {
+			out.from.WriteString(" JOIN ")
+		}
+		out.from.WriteString("(")
+		out.from.add(c.pattern(t))
+		out.from.WriteString(") AS " + table)
+
+		var on []fragment
+		for _, n := range []struct {
+			node Node
+			col  column
+		}{
+			{t.Subject, column{table + ".s", table + ".sk", "''"}},
+			{t.Predicate, column{table + ".p", sqlString(kindIRI), "''"}},
+			{t.Object, column{table + ".o", table + ".ok", table + ".od"}},
+		} {
+			if n.node.Var == "" {
+				continue
+			}
+			if f, joined := out.vars.bind(n.node.Var, n.col); joined {
+				on = append(on, f)
+			}
+		}
+		switch {
+		case i == 0:
+			out.where = append(out.where, on...)
+		case len(on) == 0:
+			out.from.WriteString(" ON 1")
+		default:
+			out.from.WriteString(" ON ")
+			out.from.add(joinFragments(on, " AND "))
+		}
+	}
+
+	for _, optional := range g.Optionals {
+		inner, err := c.group(optional)
+		if err != nil {
+			return nil, err
+		}
+		table := c.alias()
+
+		// The optional group is a subquery with a value, kind and datatype
+		// column per variable, joined on the variables bound before it
+		var sub sqlBuilder
+		sub.WriteString("SELECT ")
+		visible := &scope{columns: make(map[string]column, len(out.vars.columns))}
+		for name, col := range out.vars.columns {
+			visible.columns[name] = col
+		}
+		var on []fragment
+		for i, name := range inner.vars.names {
+			col := inner.vars.columns[name]
+			if i > 0 {
+				sub.WriteString(", ")
+			}
+			fmt.Fprintf(&sub, "%s AS v%d, %s AS k%d, %s AS d%d", col.value, i, col.kind, i, col.datatype, i)
+			joined := column{fmt.Sprintf("%s.v%d", table, i), fmt.Sprintf("%s.k%d", table, i), fmt.Sprintf("%s.d%d", table, i)}
+			if f, ok := out.vars.bind(name, joined); ok {
+				on = append(on, f)
+			} else {
+				visible.columns[name] = joined
+			}
+		}
+		if len(inner.vars.names) == 0 {
+			sub.WriteString("1")
+		}
+		sub.WriteString(" FROM ")
+		sub.add(inner.from.fragment())
+		if len(inner.where) > 0 {
+			sub.WriteString(" WHERE ")
+			sub.add(joinFragments(inner.where, " AND "))
+		}
+		for _, filter := range optional.Filters {
+			f, err := c.expr(filter, visible)
+			if err != nil {
+				return nil, err
+			}
+			on = append(on, f)
+		}
+
+		out.from.WriteString(" LEFT JOIN (")
+		out.from.add(sub.fragment())
+		out.from.WriteString(") AS " + table + " ON ")
+		if len(on) == 0 {
+			out.from.WriteString("1")
+		} else {
+			out.from.add(joinFragments(on, " AND "))
+		}
+	}
+	return out, nil
+}
+
+// pattern returns the union of the arms of the view that can match a triple
+// pattern, restricted to its subject, predicate and object terms
+func (c *compiler) pattern(t Pattern) fragment {
+	var arms []fragment
+	for _, a := range c.view {
+		if t.Predicate.Var == "" && t.Predicate.Term.Value != a.predicate {
+			continue
+		}
+		subject, ok := c.match(a.subject, t.Subject)
+		if !ok {
+			continue
+		}
+		object, ok := c.match(a.object, t.Object)
+		if !ok {
+			continue
+		}
+		var b sqlBuilder
+		fmt.Fprintf(&b, "SELECT %s AS s, %s AS sk, %s AS p, %s AS o, %s AS ok, %s AS od FROM %s WHERE %s",
+			a.subject.expr, sqlString(a.subject.kind), sqlString(a.predicate),
+			a.object.expr, sqlString(a.object.kind), sqlString(a.object.datatype), a.from, a.where)
+		for _, f := range append(subject, object...) {
+			b.WriteString(" AND ")
+			b.add(f)
+		}
+		arms = append(arms, b.fragment())
+	}
+	if len(arms) == 0 {
+		return fragment{sql: "SELECT NULL AS s, NULL AS sk, NULL AS p, NULL AS o, NULL AS ok, NULL AS od WHERE 0"}
+	}
+	return joinFragments(arms, " UNION ALL ")
+}
+
+// match returns the conditions for the term of an arm to match a node of a
+// pattern, or false if it cannot match
+func (c *compiler) match(a armTerm, n Node) ([]fragment, bool) {
+	if n.Var != "" {
+		return nil, true
+	}
+	t := n.Term
+	switch {
+	case t.Literal != (a.kind == kindLiteral) || a.kind == kindBlank:
+		return nil, false
+	case a.constant != "":
+		return nil, a.constant == t.Value
+	case a.resource != nil:
+		key, ok := c.key(a.resource, t.Value)
+		if !ok {
+			return nil, false
+		}
+		return []fragment{{a.key + " = ?", []interface{}{key}}}, true
+	}
+	return []fragment{{a.expr + " = ?", []interface{}{sqlValue(t)}}}, true
+}
+
+// key returns the key of the resource named by an IRI
+func (c *compiler) key(r *resource, iri string) (interface{}, bool) {
+	key, ok := strings.CutPrefix(iri, c.base+r.path)
+	if !ok || key == "" || strings.Contains(key, "/") {
+		return nil, false
+	}
+	if !r.numeric {
+		return key, true
+	}
+	id, err := strconv.ParseInt(key, 10, 64)
+	return id, err == nil
+}
+
+// sqlValue returns the SQL value of a term, numbers and booleans being
+// compared as numbers
+func sqlValue(t Term) interface{} {
+	switch t.Datatype {
+	case rdf.XSD + "integer", rdf.XSD + "int", rdf.XSD + "long":
+		if n, err := strconv.ParseInt(t.Value, 10, 64); err == nil {
+			return n
+		}
+	case rdf.XSD + "decimal", rdf.XSD + "double", rdf.XSD + "float":
+		if f, err := strconv.ParseFloat(t.Value, 64); err == nil {
+			return f
+		}
+	case rdf.XSD + "boolean":
+		if t.Value == "true" || t.Value == "1" {
+			return 1
+		}
+		return 0
+	}
+	return t.Value
+}
+
+// sqlOperators are the SQL forms of the binary operators
+var sqlOperators = map[string]string{
+	"||": "OR", "&&": "AND",
+	"=": "=", "!=": "<>", "<": "<", ">": ">", "<=": "<=", ">=": ">=",
+	"+": "+", "-": "-", "*": "*",
+}
+
+// expr translates an expression. Unbound variables are NULL, so that
+// filters on them are not satisfied, as errors are in SPARQL.
+func (c *compiler) expr(e Expr, s *scope) (fragment, error) {
+	switch e := e.(type) {
+	case VarExpr:
+		if col, ok := s.columns[string(e)]; ok {
+			return fragment{sql: col.value}, nil
+		}
+		return fragment{sql: "NULL"}, nil
+	case Term:
+		return fragment{"?", []interface{}{sqlValue(e)}}, nil
+	case *UnaryExpr:
+		arg, err := c.expr(e.Arg, s)
+		if err != nil {
+			return fragment{}, err
+		}
+		op := "-"
+		if e.Op == "!" {
+			op = "NOT "
+		}
+		return fragment{"(" + op + arg.sql + ")", arg.args}, nil
+	case *BinaryExpr:
+		left, err := c.expr(e.Left, s)
+		if err != nil {
+			return fragment{}, err
+		}
+		right, err := c.expr(e.Right, s)
+		if err != nil {
+			return fragment{}, err
+		}
+		if e.Op == "/" {
+			// Division is decimal, as in SPARQL
+			return joinFragments([]fragment{{sql: "(CAST("}, left, {sql: " AS REAL) / "}, right, {sql: ")"}}, ""), nil
+		}
+		return joinFragments([]fragment{{sql: "("}, left, {sql: " " + sqlOperators[e.Op] + " "}, right, {sql: ")"}}, ""), nil
+	case *CallExpr:
+		return c.call(e, s)
+	}
+	return fragment{}, &Error{Reason: fmt.Sprintf("unsupported expression %T", e)}
+}
+
+// functions are the SQL forms of the supported built-in functions, with
+// %[n]s standing for the nth argument
+var functions = map[string]struct {
+	arity int
+	sql   string
+}{
+	"STR":       {1, "CAST(%[1]s AS TEXT)"},
+	"LCASE":     {1, "lower(%[1]s)"},
+	"UCASE":     {1, "upper(%[1]s)"},
+	"STRLEN":    {1, "length(%[1]s)"},
+	"ABS":       {1, "abs(%[1]s)"},
+	"ROUND":     {1, "round(%[1]s)"},
+	"CONTAINS":  {2, "(instr(%[1]s, %[2]s) > 0)"},
+	"STRSTARTS": {2, "(substr(%[1]s, 1, length(%[2]s)) = %[2]s)"},
+	"STRENDS":   {2, "(length(%[2]s) = 0 OR substr(%[1]s, -length(%[2]s)) = %[2]s)"},
+}
+
+// kindTests are the functions testing the kind of a variable
+var kindTests = map[string]string{
+	"ISIRI":     kindIRI,
+	"ISURI":     kindIRI,
+	"ISBLANK":   kindBlank,
+	"ISLITERAL": kindLiteral,
+}
+
+func (c *compiler) call(e *CallExpr, s *scope) (fragment, error) {
+	if e.Name == "BOUND" || kindTests[e.Name] != "" {
+		var name VarExpr
+		ok := len(e.Args) == 1
+		if ok {
+			name, ok = e.Args[0].(VarExpr)
+		}
+		if !ok {
+			return fragment{}, &Error{Reason: e.Name + " expects a variable"}
+		}
+		col, ok := s.columns[string(name)]
+		if !ok {
+			return fragment{sql: "0"}, nil
+		}
+		if e.Name == "BOUND" {
+			return fragment{sql: "(" + col.value + " IS NOT NULL)"}, nil
+		}
+		return fragment{sql: "(" + col.kind + " = " + sqlString(kindTests[e.Name]) + ")"}, nil
+	}
+
+	if e.Name == "REGEX" {
+		return fragment{}, &Error{Reason: "REGEX is not supported, use CONTAINS, STRSTARTS or STRENDS"}
+	}
+	f, ok := functions[e.Name]
+	if !ok {
+		return fragment{}, &Error{Reason: "unsupported function " + e.Name}
+	}
+	if len(e.Args) != f.arity {
+		return fragment{}, &Error{Reason: fmt.Sprintf("%s expects %d arguments", e.Name, f.arity)}
+	}
+
+	// Arguments repeated in the SQL are repeated with their bound values
+	args := make([]fragment, len(e.Args))
+	for i, arg := range e.Args {
+		var err error
+		if args[i], err = c.expr(arg, s); err != nil {
+			return fragment{}, err
+		}
+	}
+	var b sqlBuilder
+	rest := f.sql
+	for {
+		start := strings.Index(rest, "%[")
+		if start < 0 {
+			b.WriteString(rest)
+			break
+		}
+		b.WriteString(rest[:start])
+		n := int(rest[start+2] - '1')
+		b.add(args[n])
+		rest = rest[start+len("%[1]s"):]
+	}
+	return b.fragment(), nil
+}
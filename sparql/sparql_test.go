@@ -0,0 +1,128 @@
+package sparql
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// compileQuery parses and compiles a query against the test resources
+func compileQuery(src string, maxRows int64) (fragment, []string, error) {
+	q, err := Parse(src, Prefixes(testResources))
+	if err != nil {
+		return fragment{}, nil, err
+	}
+	c := &compiler{view: newView(testResources), base: testResources.Base}
+	return c.compile(q, maxRows)
+}
+
+// hasArg reports whether a value is among the arguments of a statement
+func hasArg(stmt fragment, v interface{}) bool {
+	for _, arg := range stmt.args {
+		if arg == v {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		query    string
+		vars     []string
+		contains []string // Fragments the SQL must contain
+		limit    int64
+		offset   int64
+	}{
+		{`SELECT ?m ?name WHERE { ?m schema:name ?name }`, []string{"m", "name"}, nil, 101, 0},
+		{`SELECT DISTINCT ?m WHERE { ?m schema:name ?name }`, []string{"m"}, []string{"SELECT DISTINCT "}, 101, 0},
+		{`SELECT * WHERE { ?m schema:name ?name }`, []string{"m", "name"}, nil, 101, 0},
+		{`SELECT ?m ?o WHERE { ?m schema:name ?name OPTIONAL { ?m wdt:P703 ?o } }`, []string{"m", "o"}, []string{" LEFT JOIN "}, 101, 0},
+		{`SELECT ?m WHERE { ?m schema:name ?name FILTER(CONTAINS(?name, "toxin")) }`, []string{"m"}, []string{" WHERE ", "instr("}, 101, 0},
+		{`SELECT ?m WHERE { ?m schema:name ?name FILTER(STRSTARTS(?name, "tetro") || !BOUND(?name)) }`, []string{"m"}, []string{" WHERE ", " OR "}, 101, 0},
+		{`SELECT ?m WHERE { ?m schema:name ?name } ORDER BY DESC(?name) ?m LIMIT 5 OFFSET 2`, []string{"m"}, []string{" ORDER BY ", " DESC, "}, 5, 2},
+		{`SELECT ?m WHERE { ?m schema:name ?name } LIMIT 1000`, []string{"m"}, nil, 101, 0},
+	}
+	for _, tt := range tests {
+		stmt, vars, err := compileQuery(tt.query, 100)
+		if err != nil {
+			t.Errorf("compile(%q): %v", tt.query, err)
+			continue
+		}
+		if fmt.Sprint(vars) != fmt.Sprint(tt.vars) {
+			t.Errorf("compile(%q): variables %v, want %v", tt.query, vars, tt.vars)
+		}
+		for _, s := range tt.contains {
+			if !strings.Contains(stmt.sql, s) {
+				t.Errorf("compile(%q): %q does not contain %q", tt.query, stmt.sql, s)
+			}
+		}
+		if strings.Count(stmt.sql, "?") != len(stmt.args) {
+			t.Errorf("compile(%q): %d placeholders for %d arguments", tt.query, strings.Count(stmt.sql, "?"), len(stmt.args))
+		}
+		if !strings.HasSuffix(stmt.sql, " LIMIT ? OFFSET ?") || len(stmt.args) < 2 {
+			t.Errorf("compile(%q): %q does not end in LIMIT and OFFSET", tt.query, stmt.sql)
+			continue
+		}
+		if limit, offset := stmt.args[len(stmt.args)-2], stmt.args[len(stmt.args)-1]; limit != tt.limit || offset != tt.offset {
+			t.Errorf("compile(%q): limit %v, offset %v, want %d, %d", tt.query, limit, offset, tt.limit, tt.offset)
+		}
+	}
+}
+
+func TestCompileBindsLiterals(t *testing.T) {
+	tests := []struct {
+		query   string
+		literal string
+	}{
+		{`SELECT ?m WHERE { ?m schema:name "Tetrodotoxin" }`, "Tetrodotoxin"},
+		{`SELECT ?m WHERE { ?m schema:name "x' OR '1'='1" }`, "x' OR '1'='1"},
+		{`SELECT ?m WHERE { ?m schema:name ?name FILTER(?name = "it's") }`, "it's"},
+		{`SELECT ?m WHERE { ?m schema:name ?name FILTER(CONTAINS(?name, "a'); DROP TABLE molecules; --")) }`, "a'); DROP TABLE molecules; --"},
+	}
+	for _, tt := range tests {
+		stmt, _, err := compileQuery(tt.query, 100)
+		if err != nil {
+			t.Errorf("compile(%q): %v", tt.query, err)
+			continue
+		}
+		if !hasArg(stmt, tt.literal) {
+			t.Errorf("compile(%q): %q is not bound, arguments %v", tt.query, tt.literal, stmt.args)
+		}
+		if strings.Contains(stmt.sql, tt.literal) {
+			t.Errorf("compile(%q): %q is inlined in %q", tt.query, tt.literal, stmt.sql)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	var many strings.Builder
+	many.WriteString("SELECT ?m WHERE {")
+	for i := 0; i <= maxPatterns; i++ {
+		fmt.Fprintf(&many, " ?m schema:name ?n%d .", i)
+	}
+	many.WriteString(" }")
+
+	tests := []struct {
+		query  string
+		reason string // Part of the reason given
+	}{
+		{`SELECT ?m WHERE { ?m schema:name ?name FILTER(REGEX(?name, "^t")) }`, "REGEX is not supported"},
+		{`SELECT ?m WHERE { ?m schema:name ?name FILTER(SHA1(?name) = "x") }`, "unsupported function SHA1"},
+		{`SELECT ?m WHERE { ?m schema:name ?name FILTER(CONTAINS(?name)) }`, "CONTAINS"},
+		{`SELECT ?m WHERE { ?m schema:name ?name FILTER(BOUND("x")) }`, "expects a variable"},
+		{many.String(), "triple patterns"},
+	}
+	for _, tt := range tests {
+		_, _, err := compileQuery(tt.query, 100)
+		var queryErr *Error
+		if !errors.As(err, &queryErr) {
+			t.Errorf("compile(%q): error %v, want *Error", tt.query, err)
+			continue
+		}
+		if !strings.Contains(queryErr.Reason, tt.reason) {
+			t.Errorf("compile(%q): %q, want %q", tt.query, queryErr.Reason, tt.reason)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+/*
+ * MarineNP SPARQL Triple View
+ * Purpose: Map the SQLite tables to the triples of the MarineNP graph
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file describes the graph of the rdf package as a view over the tables:
+ * each arm of the view selects the triples of one predicate from one table,
+ * with SQL expressions for their subjects and objects. The CHEMINF attribute
+ * nodes are left out, as their values are also schema.org properties of the
+ * molecules.
+ */
+
+package sparql
+
+import (
+	"fmt"
+	"marinenp/rdf"
+	"strings"
+)
+
+// Term kinds, as stored in the kind columns of the view
+const (
+	kindIRI     = "i"
+	kindBlank   = "b"
+	kindLiteral = "l"
+)
+
+// resource is a kind of MarineNP resource, named by a key column under a
+// path of the API
+type resource struct {
+	path    string // such as /molecules/
+	numeric bool   // Whether the key is an integer id
+}
+
+var (
+	moleculeResource = &resource{path: "/molecules/"}
+	organismResource = &resource{path: "/organisms/", numeric: true}
+	citationResource = &resource{path: "/citations/", numeric: true}
+	locationResource = &resource{path: "/locations/", numeric: true}
+)
+
+// armTerm is the subject or object of the triples of an arm
+type armTerm struct {
+	kind     string
+	expr     string    // SQL value of the term
+	datatype string    // Datatype IRI of typed literals
+	resource *resource // Resource the term names, keyed by key
+	key      string
+	constant string // IRI of a constant term
+}
+
+// arm selects the triples of a predicate from a table
+type arm struct {
+	predicate string
+	from      string // Tables and joins
+	where     string // Rows having the triple
+	subject   armTerm
+	object    armTerm
+}
+
+// SQL forms of the joins and conditions shared by the arms
+const (
+	marineMolecules = "molecules.is_marine = TRUE"
+	marineOrganisms = "organisms.is_marine = TRUE"
+	moleculeCitable = `citables.citable_type = 'App\Models\Molecule'`
+	withProperties  = "molecules JOIN properties ON properties.molecule_id = molecules.id"
+	withOrganisms   = "molecules JOIN molecule_organism ON molecule_organism.molecule_id = molecules.id " +
+		"JOIN organisms ON organisms.id = molecule_organism.organism_id"
+	withCitations = "molecules JOIN citables ON citables.citable_id = molecules.id AND " + moleculeCitable +
+		" JOIN citations ON citations.id = citables.citation_id"
+	withLocations = "molecules JOIN geo_location_molecule ON geo_location_molecule.molecule_id = molecules.id " +
+		"JOIN geo_locations ON geo_locations.id = geo_location_molecule.geo_location_id"
+	citedCitations = "citations.id IN (SELECT citables.citation_id FROM citables " +
+		"JOIN molecules ON molecules.id = citables.citable_id WHERE " + moleculeCitable + " AND " + marineMolecules + ")"
+	locatedLocations = "geo_locations.id IN (SELECT geo_location_molecule.geo_location_id FROM geo_location_molecule " +
+		"JOIN molecules ON molecules.id = geo_location_molecule.molecule_id WHERE " + marineMolecules + ")"
+)
+
+// moleculeProperties are the properties described as schema:PropertyValue
+// nodes, as in rdf.Resources.AddMolecule
+var moleculeProperties = []struct {
+	column, datatype string
+}{
+	{"alogp", "double"},
+	{"topological_polar_surface_area", "double"},
+	{"heavy_atom_count", "integer"},
+	{"rotatable_bond_count", "integer"},
+	{"hydrogen_bond_acceptors", "integer"},
+	{"hydrogen_bond_donors", "integer"},
+	{"lipinski_rule_of_five_violations", "integer"},
+	{"aromatic_rings_count", "integer"},
+	{"fractioncsp3", "double"},
+	{"qed_drug_likeliness", "double"},
+	{"np_likeness", "double"},
+	{"formal_charge", "integer"},
+	{"chemical_super_class", ""},
+	{"chemical_class", ""},
+	{"chemical_sub_class", ""},
+	{"np_classifier_pathway", ""},
+	{"np_classifier_superclass", ""},
+	{"np_classifier_class", ""},
+}
+
+// view builds the arms of the triple view
+type view struct {
+	base string
+	arms []arm
+}
+
+// newView returns the arms of the graph of the resources
+func newView(r rdf.Resources) []arm {
+	v := &view{base: r.Base}
+	v.molecules()
+	v.organisms()
+	v.citations()
+	v.locations()
+	return v.arms
+}
+
+func (v *view) add(predicate, from, where string, subject, object armTerm) {
+	v.arms = append(v.arms, arm{predicate, from, where, subject, object})
+}
+
+// named returns the IRI of a resource by its key column
+func (v *view) named(r *resource, key string) armTerm {
+	return armTerm{kind: kindIRI, expr: sqlString(v.base+r.path) + " || " + key, resource: r, key: key}
+}
+
+// constant returns a constant IRI
+func constant(iri string) armTerm {
+	return armTerm{kind: kindIRI, expr: sqlString(iri), constant: iri}
+}
+
+// computed returns an IRI built by an SQL expression
+func computed(expr string) armTerm {
+	return armTerm{kind: kindIRI, expr: expr}
+}
+
+// literal returns a literal of an xsd datatype, or a string without one
+func literal(expr, datatype string) armTerm {
+	if datatype != "" {
+		datatype = rdf.XSD + datatype
+	}
+	return armTerm{kind: kindLiteral, expr: expr, datatype: datatype}
+}
+
+// filled is the condition of a string column being set
+func filled(column string) string {
+	return fmt.Sprintf("%s IS NOT NULL AND %s != ''", column, column)
+}
+
+func and(conditions ...string) string {
+	return strings.Join(conditions, " AND ")
+}
+
+func (v *view) molecules() {
+	m := v.named(moleculeResource, "molecules.identifier")
+	schema := func(name string) string { return rdf.Schema + name }
+
+	v.add(rdf.RDF+"type", "molecules", marineMolecules, m, constant(schema("MolecularEntity")))
+	v.add(rdf.DCTerms+"conformsTo", "molecules", marineMolecules, m, constant(rdf.MoleculeProfile))
+	for _, p := range []struct{ predicate, column string }{
+		{schema("identifier"), "molecules.identifier"},
+		{schema("name"), "molecules.name"},
+		{schema("iupacName"), "molecules.iupac_name"},
+		{schema("inChI"), "molecules.standard_inchi"},
+		{schema("inChIKey"), "molecules.standard_inchi_key"},
+		{schema("smiles"), "molecules.canonical_smiles"},
+		{rdf.Wikidata + "P235", "molecules.standard_inchi_key"},
+		{rdf.Wikidata + "P234", "molecules.standard_inchi"},
+		{rdf.Wikidata + "P233", "molecules.canonical_smiles"},
+		{rdf.Wikidata + "P231", "molecules.cas"},
+	} {
+		v.add(p.predicate, "molecules", and(marineMolecules, filled(p.column)), m, literal(p.column, ""))
+	}
+
+	// Synonyms are split on | by reading them as a JSON array
+	synonyms := `'["' || replace(replace(replace(molecules.synonyms, '\', '\\'), '"', '\"'), '|', '","') || '"]'`
+	v.add(schema("alternateName"),
+		fmt.Sprintf("molecules, json_each(CASE WHEN json_valid(%s) THEN %s ELSE '[]' END) AS synonym", synonyms, synonyms),
+		and(marineMolecules, filled("molecules.synonyms"), "trim(synonym.value) != ''"),
+		m, literal("trim(synonym.value)", ""))
+
+	v.add(schema("molecularFormula"), withProperties, and(marineMolecules, filled("properties.molecular_formula")),
+		m, literal("properties.molecular_formula", ""))
+	v.add(schema("molecularWeight"), withProperties, and(marineMolecules, "properties.molecular_weight > 0"),
+		m, literal("properties.molecular_weight", "double"))
+	v.add(schema("monoisotopicMolecularWeight"), withProperties, and(marineMolecules, "properties.exact_molecular_weight > 0"),
+		m, literal("properties.exact_molecular_weight", "double"))
+	v.add(schema("url"), "molecules", marineMolecules, m, m)
+
+	for _, p := range moleculeProperties {
+		column := "properties." + p.column
+		where := marineMolecules
+		if p.datatype == "" {
+			where = and(where, filled(column))
+		} else {
+			column = "coalesce(" + column + ", 0)" // Unset numbers are 0 in the models
+		}
+		node := armTerm{kind: kindBlank, expr: fmt.Sprintf("molecules.id || '-%s'", p.column)}
+		v.add(schema("additionalProperty"), withProperties, where, m, node)
+		v.add(rdf.RDF+"type", withProperties, where, node, constant(schema("PropertyValue")))
+		v.add(schema("propertyID"), withProperties, where, node, literal(sqlString(p.column), ""))
+		v.add(schema("value"), withProperties, where, node, literal(column, p.datatype))
+	}
+
+	v.add(rdf.Wikidata+"P703", withOrganisms, and(marineMolecules, marineOrganisms),
+		m, v.named(organismResource, "organisms.id"))
+	v.add(schema("citation"), withCitations, marineMolecules, m, v.named(citationResource, "citations.id"))
+	v.add(schema("location"), withLocations, marineMolecules, m, v.named(locationResource, "geo_locations.id"))
+}
+
+func (v *view) organisms() {
+	o := v.named(organismResource, "organisms.id")
+	schema := func(name string) string { return rdf.Schema + name }
+	aphia := "organisms.aphiaid_worms > 0"
+	lsid := sqlString(strings.TrimSuffix(rdf.WormsLSID, "%d")) + " || organisms.aphiaid_worms"
+
+	v.add(rdf.RDF+"type", "organisms", marineOrganisms, o, constant(schema("Taxon")))
+	v.add(rdf.DCTerms+"conformsTo", "organisms", marineOrganisms, o, constant(rdf.TaxonProfile))
+	v.add(schema("name"), "organisms", and(marineOrganisms, filled("organisms.name")), o, literal("organisms.name", ""))
+	v.add(schema("alternateName"), "organisms",
+		and(marineOrganisms, filled("organisms.name_aphia_worms"), "organisms.name_aphia_worms != organisms.name"),
+		o, literal("organisms.name_aphia_worms", ""))
+	v.add(schema("taxonRank"), "organisms", and(marineOrganisms, filled("organisms.rank")), o, literal("lower(organisms.rank)", ""))
+	v.add(schema("sameAs"), "organisms",
+		and(marineOrganisms, "instr(organisms.iri, ':') > 0",
+			`instr(organisms.iri, ' ') = 0 AND instr(organisms.iri, '<') = 0 AND instr(organisms.iri, '>') = 0 AND instr(organisms.iri, '"') = 0`,
+			"NOT ("+aphia+" AND organisms.iri = "+lsid+")"),
+		o, computed("organisms.iri"))
+	v.add(schema("sameAs"), "organisms", and(marineOrganisms, aphia), o, computed(lsid))
+	v.add(schema("url"), "organisms", and(marineOrganisms, aphia),
+		o, computed(sqlString(strings.TrimSuffix(rdf.WormsRecord, "%d"))+" || organisms.aphiaid_worms"))
+	v.add(schema("identifier"), "organisms", and(marineOrganisms, aphia), o, literal(lsid, ""))
+	v.add(rdf.Wikidata+"P850", "organisms", and(marineOrganisms, aphia), o, literal("CAST(organisms.aphiaid_worms AS TEXT)", ""))
+}
+
+func (v *view) citations() {
+	c := v.named(citationResource, "citations.id")
+	schema := func(name string) string { return rdf.Schema + name }
+
+	// The DOI without its resolver or doi: prefix, as in AddCitation
+	doi := "trim(citations.doi)"
+	doi = fmt.Sprintf("CASE WHEN substr(%s, 1, 16) = 'https://doi.org/' THEN substr(%s, 17) ELSE %s END", doi, doi, doi)
+	doi = fmt.Sprintf("CASE WHEN substr(%s, 1, 4) = 'doi:' THEN substr(%s, 5) ELSE %s END", doi, doi, doi)
+	hasDOI := and(citedCitations, "trim(citations.doi) != ''")
+
+	v.add(rdf.RDF+"type", "citations", citedCitations, c, constant(schema("ScholarlyArticle")))
+	for _, p := range []struct{ predicate, column string }{
+		{schema("name"), "citations.title"},
+		{schema("author"), "citations.authors"},
+		{schema("description"), "citations.citation_text"},
+	} {
+		v.add(p.predicate, "citations", and(citedCitations, filled(p.column)), c, literal(p.column, ""))
+	}
+	v.add(schema("identifier"), "citations", hasDOI, c, literal("'doi:' || "+doi, ""))
+	v.add(schema("sameAs"), "citations", hasDOI, c, computed("'https://doi.org/' || "+doi))
+	v.add(rdf.Wikidata+"P356", "citations", hasDOI, c, literal("upper("+doi+")", ""))
+}
+
+func (v *view) locations() {
+	l := v.named(locationResource, "geo_locations.id")
+
+	v.add(rdf.RDF+"type", "geo_locations", locatedLocations, l, constant(rdf.Schema+"Place"))
+	v.add(rdf.Schema+"name", "geo_locations", and(locatedLocations, filled("geo_locations.name")),
+		l, literal("geo_locations.name", ""))
+}
+
+// sqlString quotes a string as an SQL literal
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,102 @@
+/*
+ * MarineNP Fingerprint Build
+ * Purpose: Precompute structure search fingerprints
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file fills the fingerprints table from molecules.canonical_smiles. It
+ * is run with "marinenp fingerprints" after each data import so that the
+ * server does not have to compute fingerprints when it starts. Each row
+ * records the fingerprint version and a hash of the SMILES it was computed
+ * from, so that the server recomputes outdated fingerprints.
+ */
+
+package structure
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"time"
+
+	"marinenp/chem"
+	"marinenp/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// buildBatchSize is the number of molecules fingerprinted per transaction
+const buildBatchSize = 1000
+
+// BuildFingerprints computes and stores the fingerprints of all marine
+// molecules, returning the number of molecules stored
+func BuildFingerprints(db *gorm.DB) (int, error) {
+	if err := db.AutoMigrate(&models.Fingerprint{}); err != nil {
+		return 0, err
+	}
+
+	stored, failed := 0, 0
+	var lastID int64
+	for {
+		var molecules []models.Molecule
+		err := db.Select("id, canonical_smiles").
+			Where("is_marine = TRUE AND id > ?", lastID).
+			Order("id").
+			Limit(buildBatchSize).
+			Find(&molecules).Error
+		if err != nil {
+			return stored, err
+		}
+		if len(molecules) == 0 {
+			break
+		}
+		lastID = molecules[len(molecules)-1].ID
+
+		now := models.SQLiteTime(time.Now().UTC())
+		fingerprints := make([]models.Fingerprint, 0, len(molecules))
+		for _, molecule := range molecules {
+			mol, err := chem.ParseSMILES(molecule.CanonicalSmiles)
+			if err != nil {
+				log.Printf("Skipping molecule %d: %v", molecule.ID, err)
+				failed++
+				continue
+			}
+			fingerprints = append(fingerprints, models.Fingerprint{
+				MoleculeID:   molecule.ID,
+				Substructure: chem.PathFingerprint(mol).Bytes(),
+				Similarity:   chem.MorganFingerprint(mol).Bytes(),
+				Version:      chem.FingerprintVersion,
+				SmilesHash:   smilesHash(molecule.CanonicalSmiles),
+				CreatedAt:    now,
+				UpdatedAt:    now,
+			})
+		}
+		if len(fingerprints) == 0 {
+			continue
+		}
+
+		err = db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "molecule_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"substructure", "similarity", "version", "smiles_hash", "updated_at"}),
+		}).Create(&fingerprints).Error
+		if err != nil {
+			return stored, err
+		}
+		stored += len(fingerprints)
+		log.Printf("Stored fingerprints for %d molecules", stored)
+	}
+
+	if failed > 0 {
+		log.Printf("Skipped %d molecules with unparsable SMILES", failed)
+	}
+	return stored, nil
+}
+
+// smilesHash returns the hash of a canonical SMILES stored with its
+// fingerprints, which detects fingerprints of a structure changed since
+func smilesHash(smiles string) string {
+	h := fnv.New64a()
+	h.Write([]byte(smiles))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
@@ -0,0 +1,74 @@
+/*
+ * MarineNP Formula Search
+ * Purpose: Match molecular formula conditions against the stored formulas
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file answers formula and element range conditions from
+ * properties.molecular_formula, so that they do not wait for the structure
+ * index and find molecules whose SMILES cannot be parsed. Only molecules
+ * without a readable stored formula fall back to the formula of their
+ * structure.
+ */
+
+package structure
+
+import (
+	"marinenp/chem"
+
+	"gorm.io/gorm"
+)
+
+// MatchFormulas returns the ids, in ascending order, of the marine molecules
+// whose molecular formula satisfies match
+func MatchFormulas(db *gorm.DB, match func(chem.Formula) bool) ([]int64, error) {
+	rows, err := db.Table("molecules").
+		Select("molecules.id, properties.molecular_formula, molecules.canonical_smiles").
+		Joins("LEFT JOIN properties ON properties.molecule_id = molecules.id").
+		Where("molecules.is_marine = TRUE").
+		Order("molecules.id").
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	var lastID int64
+	for rows.Next() {
+		var id int64
+		var stored, smiles *string
+		if err := rows.Scan(&id, &stored, &smiles); err != nil {
+			return nil, err
+		}
+		// A molecule with several property rows is matched on the first
+		if id == lastID {
+			continue
+		}
+		lastID = id
+
+		formula, ok := moleculeFormula(stored, smiles)
+		if ok && match(formula) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, rows.Err()
+}
+
+// moleculeFormula returns the stored formula of a molecule, or the formula of
+// its structure when none is stored or it cannot be read
+func moleculeFormula(stored, smiles *string) (chem.Formula, bool) {
+	if stored != nil {
+		if formula, err := chem.ParseFormula(*stored); err == nil {
+			return formula, true
+		}
+	}
+	if smiles == nil {
+		return nil, false
+	}
+	mol, err := chem.ParseSMILES(*smiles)
+	if err != nil {
+		return nil, false
+	}
+	return mol.Formula(), true
+}
@@ -0,0 +1,185 @@
+/*
+ * MarineNP Structure Index
+ * Purpose: In-memory index of marine molecule structures
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file keeps the parsed structure and fingerprints of every marine
+ * molecule in memory so that structure and similarity searches do not have to
+ * parse SMILES per request. Fingerprints are read from the fingerprints table
+ * when they were stored by the current fingerprint version from the current
+ * SMILES, and computed on load otherwise. Formula searches are
+ * answered from the stored formulas, see formula.go.
+ */
+
+package structure
+
+import (
+	"log"
+	"runtime"
+	"sort"
+	"sync"
+
+	"marinenp/chem"
+	"marinenp/models"
+
+	"gorm.io/gorm"
+)
+
+// entry is one indexed molecule
+type entry struct {
+	id         int64
+	mol        *chem.Molecule
+	screen     chem.Fingerprint
+	similarity chem.Fingerprint
+}
+
+// Index holds the structures of all marine molecules
+type Index struct {
+	entries []entry
+}
+
+// Size returns the number of indexed molecules
+func (ix *Index) Size() int {
+	return len(ix.entries)
+}
+
+// indexRow is a molecule row read while loading the index
+type indexRow struct {
+	ID              int64
+	CanonicalSmiles string
+	Substructure    []byte
+	Similarity      []byte
+	Version         int
+	SmilesHash      string
+}
+
+// Load builds the index from the database
+func Load(db *gorm.DB) (*Index, error) {
+	columns := "molecules.id, molecules.canonical_smiles"
+	query := db.Table("molecules").
+		Where("molecules.is_marine = TRUE").
+		Order("molecules.id")
+	// Fingerprint tables built before fingerprints were versioned are ignored
+	if migrator := db.Migrator(); migrator.HasTable(&models.Fingerprint{}) && migrator.HasColumn(&models.Fingerprint{}, "Version") {
+		columns += ", fingerprints.substructure, fingerprints.similarity, fingerprints.version, fingerprints.smiles_hash"
+		query = query.Joins("LEFT JOIN fingerprints ON fingerprints.molecule_id = molecules.id")
+	}
+	query = query.Select(columns)
+
+	var rows []indexRow
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	ix := &Index{entries: make([]entry, 0, len(rows))}
+	computed, failed := 0, 0
+	for _, row := range rows {
+		mol, err := chem.ParseSMILES(row.CanonicalSmiles)
+		if err != nil {
+			failed++
+			continue
+		}
+		e := entry{id: row.ID, mol: mol}
+		// Fingerprints computed by another version or from another structure
+		// are outdated
+		current := row.Version == chem.FingerprintVersion && row.SmilesHash == smilesHash(row.CanonicalSmiles)
+		var screenErr, similarityErr error
+		if e.screen, screenErr = chem.FingerprintFromBytes(row.Substructure); screenErr != nil || !current {
+			e.screen = chem.PathFingerprint(mol)
+		}
+		if e.similarity, similarityErr = chem.FingerprintFromBytes(row.Similarity); similarityErr != nil || !current {
+			e.similarity = chem.MorganFingerprint(mol)
+		}
+		if screenErr != nil || similarityErr != nil || !current {
+			computed++
+		}
+		ix.entries = append(ix.entries, e)
+	}
+
+	if computed > 0 {
+		log.Printf("Structure index: computed missing or outdated fingerprints of %d molecules, run \"marinenp fingerprints\" to store them", computed)
+	}
+	if failed > 0 {
+		log.Printf("Structure index: skipped %d molecules with unparsable SMILES", failed)
+	}
+	return ix, nil
+}
+
+// Substructure returns the ids, in ascending order, of the molecules that
+// contain the query
+func (ix *Index) Substructure(q *chem.Query) []int64 {
+	screen := q.Screen()
+	return ix.search(func(i int, e *entry) bool {
+		return e.screen.Contains(screen) && q.Matches(e.mol)
+	})
+}
+
+// Hit is a molecule found by similarity search
+type Hit struct {
+	ID         int64
+	Similarity float64
+}
+
+// Similar returns the molecules whose Tanimoto similarity to the fingerprint
+// is at least threshold, most similar first
+func (ix *Index) Similar(fp chem.Fingerprint, threshold float64) []Hit {
+	scores := make([]float64, len(ix.entries))
+	ids := ix.search(func(i int, e *entry) bool {
+		scores[i] = chem.Tanimoto(fp, e.similarity)
+		return scores[i] >= threshold
+	})
+
+	hits := make([]Hit, 0, len(ids))
+	for i, e := range ix.entries {
+		if scores[i] >= threshold {
+			hits = append(hits, Hit{ID: e.id, Similarity: scores[i]})
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Similarity > hits[j].Similarity })
+	return hits
+}
+
+// Fingerprint returns the similarity fingerprint of an indexed molecule
+func (ix *Index) Fingerprint(id int64) (chem.Fingerprint, bool) {
+	i := sort.Search(len(ix.entries), func(i int) bool { return ix.entries[i].id >= id })
+	if i < len(ix.entries) && ix.entries[i].id == id {
+		return ix.entries[i].similarity, true
+	}
+	return nil, false
+}
+
+// search runs a predicate over all entries on every CPU
+func (ix *Index) search(match func(i int, e *entry) bool) []int64 {
+	workers := runtime.NumCPU()
+	chunk := (len(ix.entries) + workers - 1) / workers
+	results := make([][]int64, workers)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		start, end := w*chunk, (w+1)*chunk
+		if end > len(ix.entries) {
+			end = len(ix.entries)
+		}
+		if start >= end {
+			break
+		}
+		wg.Add(1)
+		go func(w, start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				if match(i, &ix.entries[i]) {
+					results[w] = append(results[w], ix.entries[i].id)
+				}
+			}
+		}(w, start, end)
+	}
+	wg.Wait()
+
+	var ids []int64
+	for _, r := range results {
+		ids = append(ids, r...)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
@@ -0,0 +1,87 @@
+/*
+ * MarineNP Organism Taxonomy
+ * Purpose: Store the WoRMS classification of the organisms
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file fills the organism_taxonomy table from a WoRMS source for the
+ * AphiaIDs of the marine organisms. It is run with "marinenp taxonomy" after
+ * each data import. AphiaIDs that have been looked up are skipped unless a
+ * refresh is asked for, so that an interrupted run can be resumed.
+ */
+
+package worms
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"marinenp/lookup"
+	"marinenp/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Classify looks up the AphiaIDs of the marine organisms in a source and
+// stores their classification. With refresh, AphiaIDs looked up before are
+// looked up again.
+func Classify(db *gorm.DB, source Source, refresh bool) (lookup.Counts, error) {
+	if err := db.AutoMigrate(&models.OrganismTaxonomy{}); err != nil {
+		return lookup.Counts{}, err
+	}
+
+	return lookup.Run(db, lookup.Task[int]{
+		Next: func(last *int) ([]int, error) {
+			lastID := 0
+			if last != nil {
+				lastID = *last
+			}
+			query := db.Model(&models.Organism{}).
+				Distinct("aphiaid_worms").
+				Where("is_marine = TRUE AND aphiaid_worms > ?", lastID)
+			if !refresh {
+				query = query.Where("aphiaid_worms NOT IN (SELECT aphiaid_worms FROM organism_taxonomy)")
+			}
+			var aphiaIDs []int
+			err := query.Order("aphiaid_worms").Limit(lookup.BatchSize).Pluck("aphiaid_worms", &aphiaIDs).Error
+			return aphiaIDs, err
+		},
+		Lookup: func(aphiaID int) (interface{}, bool, error) {
+			taxonomy := &models.OrganismTaxonomy{
+				AphiaIDWorms: aphiaID,
+				Source:       source.Name(),
+				Status:       models.MetadataNotFound,
+				UpdatedAt:    models.SQLiteTime(time.Now().UTC()),
+			}
+			record, err := source.Record(aphiaID)
+			if errors.Is(err, ErrNotFound) {
+				return taxonomy, false, nil
+			}
+			if err != nil {
+				return nil, false, err
+			}
+			taxonomy.Status = models.MetadataFound
+			taxonomy.Kingdom = strings.TrimSpace(record.Kingdom)
+			taxonomy.Phylum = strings.TrimSpace(record.Phylum)
+			taxonomy.Class = strings.TrimSpace(record.Class)
+			taxonomy.Order = strings.TrimSpace(record.Order)
+			taxonomy.Family = strings.TrimSpace(record.Family)
+			taxonomy.Genus = strings.TrimSpace(record.Genus)
+			taxonomy.Record = string(record.Raw)
+			return taxonomy, true, nil
+		},
+		Describe: func(aphiaID int) string {
+			return fmt.Sprintf("AphiaID %d", aphiaID)
+		},
+		Upsert: clause.OnConflict{
+			Columns: []clause.Column{{Name: "aphiaid_worms"}},
+			DoUpdates: clause.AssignmentColumns([]string{
+				"status", "kingdom", "phylum", "class", "order", "family", "genus",
+				"source", "record", "updated_at",
+			}),
+		},
+	})
+}
@@ -0,0 +1,186 @@
+/*
+ * MarineNP WoRMS
+ * Purpose: Read the classification of organisms from WoRMS
+ * Author: MarineNP Team
+ * Date: 2025-06-10
+ *
+ * This file looks up the AphiaRecords of the World Register of Marine Species
+ * by AphiaID, in the WoRMS REST API or a server answering
+ * /AphiaRecordByAphiaID/{id} like it, or in a local JSON dump of records as a
+ * JSON array or JSON Lines, optionally gzipped.
+ */
+
+package worms
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by a source that has no record for an AphiaID
+var ErrNotFound = errors.New("AphiaID not found")
+
+// Source looks up AphiaRecords by AphiaID
+type Source interface {
+	// Record returns the record of an AphiaID, or ErrNotFound
+	Record(aphiaID int) (*Record, error)
+	// Name identifies the source in the provenance of the classification
+	Name() string
+}
+
+// Record is an AphiaRecord with its classification. Raw holds the record as
+// read from the source.
+type Record struct {
+	AphiaID        int    `json:"AphiaID"`
+	ScientificName string `json:"scientificname"`
+	Status         string `json:"status"`
+	Rank           string `json:"rank"`
+	Kingdom        string `json:"kingdom"`
+	Phylum         string `json:"phylum"`
+	Class          string `json:"class"`
+	Order          string `json:"order"`
+	Family         string `json:"family"`
+	Genus          string `json:"genus"`
+
+	Raw json.RawMessage `json:"-"`
+}
+
+// NewSource returns the source at a location: an http(s) URL of a server
+// answering /AphiaRecordByAphiaID/{id} like the WoRMS REST API, or the path
+// of a dump
+func NewSource(location, userAgent string) (Source, error) {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		return &apiSource{
+			base:      strings.TrimSuffix(location, "/"),
+			userAgent: userAgent,
+			client:    &http.Client{Timeout: 30 * time.Second},
+		}, nil
+	}
+	return loadDump(location)
+}
+
+// apiSource reads records from the WoRMS REST API or a server like it
+type apiSource struct {
+	base      string
+	userAgent string
+	client    *http.Client
+}
+
+func (s *apiSource) Name() string { return s.base }
+
+func (s *apiSource) Record(aphiaID int) (*Record, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/AphiaRecordByAphiaID/%d", s.base, aphiaID), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if s.userAgent != "" {
+		req.Header.Set("User-Agent", s.userAgent)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// WoRMS answers unknown AphiaIDs with 204 No Content
+	switch {
+	case resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotFound:
+		return nil, ErrNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%s answered %s", s.base, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return parseRecord(data)
+}
+
+// parseRecord decodes a record, keeping its JSON
+func parseRecord(data json.RawMessage) (*Record, error) {
+	var r Record
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	if r.AphiaID == 0 {
+		return nil, errors.New("record without an AphiaID")
+	}
+	r.Raw = data
+	return &r, nil
+}
+
+// dumpSource holds the records of a dump by AphiaID
+type dumpSource struct {
+	path    string
+	records map[int]json.RawMessage
+}
+
+func (s *dumpSource) Name() string { return s.path }
+
+func (s *dumpSource) Record(aphiaID int) (*Record, error) {
+	data, ok := s.records[aphiaID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return parseRecord(data)
+}
+
+// loadDump reads a dump of records: JSON arrays of records, as returned by
+// the AphiaRecordsByAphiaIDs method, or a sequence of records such as JSON
+// Lines
+func loadDump(path string) (*dumpSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var r io.Reader = bufio.NewReader(file)
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	s := &dumpSource{path: path, records: map[int]json.RawMessage{}}
+	dec := json.NewDecoder(r)
+	for {
+		var doc json.RawMessage
+		if err := dec.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid dump %s: %w", path, err)
+		}
+		records := []json.RawMessage{doc}
+		if doc = bytes.TrimSpace(doc); len(doc) > 0 && doc[0] == '[' {
+			if err := json.Unmarshal(doc, &records); err != nil {
+				return nil, fmt.Errorf("invalid dump %s: %w", path, err)
+			}
+		}
+		for _, data := range records {
+			var id struct {
+				AphiaID int `json:"AphiaID"`
+			}
+			if err := json.Unmarshal(data, &id); err != nil {
+				return nil, fmt.Errorf("invalid dump %s: %w", path, err)
+			}
+			if id.AphiaID != 0 {
+				s.records[id.AphiaID] = data
+			}
+		}
+	}
+	return s, nil
+}